@@ -1,12 +1,16 @@
 package main
 
 import (
-	"strings"
+	"fmt"
 	"os"
+	"path"
+	"strings"
 
-	"github.com/docker/libkv/store"
 	renderizr "github.com/glerchundi/renderizr/pkg"
+	"github.com/glerchundi/renderizr/pkg/backends"
 	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
 	"github.com/glerchundi/renderizr/pkg/util"
 	"github.com/spf13/cobra"
 	flag "github.com/spf13/pflag"
@@ -18,46 +22,444 @@ const (
 )
 
 var (
-	globalCfg = config.NewGlobalConfig()
-	consulCfg = config.NewConsulBackendConfig()
-	etcdCfg = config.NewEtcdBackendConfig()
+	globalCfg    = config.NewGlobalConfig()
+	consulCfg    = config.NewConsulBackendConfig()
+	etcdCfg      = config.NewEtcdBackendConfig()
 	zookeeperCfg = config.NewZookeeperBackendConfig()
+	fixtureCfg   = config.NewFixtureBackendConfig()
+	pushCfg      = config.NewPushBackendConfig()
+	mqttCfg      = config.NewMQTTBackendConfig()
+	sqlCfg       = config.NewSQLBackendConfig()
+	httpCfg      = config.NewHTTPBackendConfig()
+	azureCfg     = config.NewAzureBackendConfig()
+	gcpCfg       = config.NewGCPBackendConfig()
+	vaultCfg     = config.NewVaultBackendConfig()
+	k8sCfg       = config.NewK8sBackendConfig()
+	envCfg       = config.NewEnvBackendConfig()
+	fsCfg        = config.NewFsBackendConfig()
 
 	backendCfgs = map[store.Backend]config.BackendConfig{
-		store.CONSUL: consulCfg,
-		store.ETCD:   etcdCfg,
-		store.ZK:     zookeeperCfg,
+		store.CONSUL:     consulCfg,
+		store.ETCD:       etcdCfg,
+		store.ZK:         zookeeperCfg,
+		backends.FIXTURE: fixtureCfg,
+		backends.PUSH:    pushCfg,
+		backends.MQTT:    mqttCfg,
+		backends.SQL:     sqlCfg,
+		backends.HTTP:    httpCfg,
+		backends.AZURE:   azureCfg,
+		backends.GCP:     gcpCfg,
+		backends.VAULT:   vaultCfg,
+		backends.K8S:     k8sCfg,
+		backends.ENV:     envCfg,
+		backends.FS:      fsCfg,
 	}
+
+	exportCfg   = config.NewExportConfig()
+	benchCfg    = config.NewBenchConfig()
+	generateCfg = config.NewGenerateConfig()
+	kvCfg       = config.NewKVConfig()
+	validateCfg = config.NewValidateConfig()
+	renderCfg   = config.NewRenderConfig()
+	whatIfCfg   = config.NewWhatIfConfig()
+	rollbackCfg = config.NewRollbackConfig()
 )
 
 func AddGlobalFlags(fs *flag.FlagSet, gc *config.GlobalConfig) {
 	fs.StringVar(&gc.Prefix, "prefix", gc.Prefix, "Key path prefix")
-	fs.StringSliceVar(&gc.Templates, "template", gc.Templates, "Template parameters like 'file.conf.tmpl;file.conf;0600;check;reload-cmd'")
+	fs.StringVar(&gc.LogFormat, "log-format", gc.LogFormat, "Log format: glog, text (logfmt) or json")
+	fs.StringVar(&gc.LogLevel, "log-level", gc.LogLevel, "Lowest level logged: debug, info, warning or error")
+	fs.StringSliceVar(&gc.Templates, "template", gc.Templates, "Template parameters like 'file.conf.tmpl;file.conf;0:0;0600;check;reload-cmd', optionally followed by name=value options (see docs/template-resources.md)")
+	fs.StringVar(&gc.ConfDir, "confdir", gc.ConfDir, "Directory with template resources in conf.d/*.toml and their templates in templates/")
 	fs.BoolVar(&gc.Onetime, "onetime", gc.Onetime, "Run once and exit")
+	fs.BoolVar(&gc.OnetimeRetry, "onetime-retry", gc.OnetimeRetry, "In onetime mode, keep rendering the templates, e.g. while the backend is starting, until they all succeed")
+	fs.DurationVar(&gc.OnetimeTimeout, "onetime-timeout", gc.OnetimeTimeout, "Time after which onetime retries give up and exit non-zero (0 means never)")
+	fs.IntVar(&gc.Workers, "workers", gc.Workers, "Number of templates rendered at once in onetime mode and on startup")
+	fs.IntVar(&gc.MaxParallel, "max-parallel-renders", gc.MaxParallel, "Maximum number of templates rendered at once, whether on startup, on resync or on changes, which is also the default number of workers (0 means unlimited)")
+	fs.BoolVar(&gc.SnapshotCycles, "snapshot-cycles", gc.SnapshotCycles, "Read every prefix once per startup or resync cycle and render all templates from that snapshot, so templates reading overlapping prefixes see the same data")
+	fs.BoolVar(&gc.RenderOnExit, "render-on-exit", gc.RenderOnExit, "Render every template a last time on shutdown, once watches stopped and renders in flight finished")
 	fs.BoolVar(&gc.Watch, "watch", gc.Watch, "Enable watch")
+	fs.DurationVar(&gc.RenderDebounce, "render-debounce", gc.RenderDebounce, "In watch mode, wait for the keys of a template to stop changing for this long before rendering it, so that bursts of updates trigger a single render and reload (templates may set their own)")
+	fs.DurationVar(&gc.MinInterval, "min-render-interval", gc.MinInterval, "In watch mode, minimum time between two renders of a template triggered by changes, those in between are coalesced (templates may set their own)")
+	fs.IntVar(&gc.MaxFailures, "max-consecutive-failures", gc.MaxFailures, "Exit non-zero once a template failed to render this many times in a row, for orchestrators to restart renderizr or page someone (0 means never, templates may set their own)")
+	fs.DurationVar(&gc.EphemeralCheck, "ephemeral-check-interval", gc.EphemeralCheck, "In watch mode, read the keys of templates holding ephemeral keys (consul sessions, zookeeper ephemeral nodes) again on this interval, as they may vanish without a delete event (0 to disable)")
+	fs.BoolVar(&gc.OnceOnChange, "once-on-change", gc.OnceOnChange, "Exit after the first render updating a destination")
+	fs.DurationVar(&gc.MaxRuntime, "max-runtime", gc.MaxRuntime, "Exit cleanly after running for this long, letting renders in flight finish (0 means forever)")
+	fs.StringVar(&gc.Exec, "exec", gc.Exec, "Command run and supervised once the templates are rendered, signaled or restarted when they change")
+	fs.StringVar(&gc.ExecSignal, "exec-reload-signal", gc.ExecSignal, "Signal sent to the exec command when the templates change, it is restarted if empty")
+	fs.StringVar(&gc.ExecKillSignal, "exec-kill-signal", gc.ExecKillSignal, "Signal sent to stop the exec command")
+	fs.DurationVar(&gc.ExecKillWait, "exec-kill-timeout", gc.ExecKillWait, "Time to wait for the exec command to stop before killing it")
 	fs.DurationVar(&gc.ResyncInterval, "resync-interval", gc.ResyncInterval, "Backend polling resync interval")
+	fs.DurationVar(&gc.ResyncSplay, "resync-splay", gc.ResyncSplay, "Randomly delay each resync up to this duration, so that fleets of instances do not hit the backend at the same time")
 	fs.BoolVar(&gc.NoOp, "noop", gc.NoOp, "Only show pending changes")
+	fs.BoolVar(&gc.Diff, "diff", gc.Diff, "Log the differences found in destinations before overwriting them, as noop mode does")
+	fs.BoolVar(&gc.DiffRedact, "diff-redact", gc.DiffRedact, "Hide the values of keys matching --redact-keys in logged diffs")
 	fs.BoolVar(&gc.KeepStageFile, "keep-stage-file", gc.KeepStageFile, "Keep staged files")
+	fs.BoolVar(&gc.Lock, "lock", gc.Lock, "Lock every destination with a file next to it, refusing to manage those already locked by another instance (not in noop mode)")
+	fs.Float64Var(&gc.RateLimit, "rate-limit", gc.RateLimit, "Maximum backend requests per second (0 means unlimited)")
+	fs.BoolVar(&gc.ShareRequests, "share-requests", gc.ShareRequests, "Share backend reads and watches between templates reading the same keys")
+	fs.IntVar(&gc.RateBurst, "rate-burst", gc.RateBurst, "Maximum burst of backend requests allowed over the rate limit")
+	fs.StringVar(&gc.SpiffeSocket, "spiffe-socket", gc.SpiffeSocket, "SPIFFE Workload API socket, like unix:///run/spire/agent.sock, providing rotated client certificates to the backends instead of cert/key files")
+	fs.StringVar(&gc.SpiffeServerID, "spiffe-server-id", gc.SpiffeServerID, "SPIFFE ID the backend servers must present, any from the trust bundle if empty")
+	fs.BoolVar(&gc.EnableSprig, "enable-sprig", gc.EnableSprig, "Make the sprig template functions available, those named like the built-in ones are left out")
+	fs.StringSliceVar(&gc.FileAllowList, "file-allow", gc.FileAllowList, "Directories the file template function may read from (none if empty)")
+	fs.StringSliceVar(&gc.FetchAllowList, "fetch-allow", gc.FetchAllowList, "Key prefixes the fetch template function may read from the backend at render time, values being cached for --datasource-ttl (none if empty)")
+	fs.StringSliceVar(&gc.FuncPlugins, "func-plugin", gc.FuncPlugins, "Go plugins exporting additional template functions as 'var Funcs map[string]interface{}'")
+	fs.StringVar(&gc.ShellFuncs, "shell-funcs", gc.ShellFuncs, "TOML file defining additional template functions as 'name = \"command\"', run by the template shell with the arguments as $1, $2...")
+	fs.BoolVar(&gc.FuncMetrics, "func-metrics", gc.FuncMetrics, "Count the calls and time spent in each template function, shown in the admin console and logged after onetime renders")
+	fs.DurationVar(&gc.StartupJitter, "startup-jitter", gc.StartupJitter, "Randomly delay the first backend access up to this duration")
+	fs.StringSliceVar(&gc.Datasources, "datasource", gc.Datasources, "Datasources available to templates like 'name=https://host/doc.json' (http, https, file, env and kv schemes)")
+	fs.DurationVar(&gc.DatasourceTTL, "datasource-ttl", gc.DatasourceTTL, "Time datasource contents are cached for")
+	fs.StringVar(&gc.AckPrefix, "ack-prefix", gc.AckPrefix, "Write render acknowledgments to the backend under this key path, which templates cannot watch")
+	fs.StringVar(&gc.NodeName, "node-name", gc.NodeName, "Name identifying this node in acknowledgments and template host selectors")
+	fs.StringVar(&gc.PublicKeyFile, "public-key-file", gc.PublicKeyFile, "Only render data matching a manifest signed with this PEM public key")
+	fs.StringVar(&gc.ManifestKey, "manifest-key", gc.ManifestKey, "Key, relative to the template prefix, holding the signed manifest")
+	fs.StringVar(&gc.ConfigKeyFile, "config-public-key-file", gc.ConfigKeyFile, "Only load template resources, templates, shell functions and function plugins with a valid <file>.sig signature made with this PEM public key")
+	fs.BoolVar(&gc.ChecksumFile, "checksum-file", gc.ChecksumFile, "Keep a <dest>.sha256 file with the checksum of each rendered file")
+	fs.StringVar(&gc.InventoryFile, "inventory-file", gc.InventoryFile, "JSON file listing every managed file with its mode, owner, hash and template, written after each startup and resync cycle (disabled if empty)")
+	fs.StringVar(&gc.AdminListen, "admin-listen", gc.AdminListen, "Address to serve the admin console on (disabled if empty)")
+	fs.StringVar(&gc.AdminTokenFile, "admin-token-file", gc.AdminTokenFile, "File containing the token, sent as bearer token or basic auth password, required by the admin console")
+	fs.StringVar(&gc.RedactKeys, "redact-keys", gc.RedactKeys, "Regular expression matching the keys whose values are hidden in diffs")
+	fs.IntVar(&gc.EventLogSize, "event-log-size", gc.EventLogSize, "Number of recent lifecycle events kept for the admin console")
+	fs.StringVar(&gc.OverridesFile, "overrides-file", gc.OverridesFile, "YAML/JSON document, like /etc/renderizr/overrides.yaml, whose keys take precedence over the backend ones, not allowed with --public-key-file")
+	fs.StringVar(&gc.Shell, "shell", gc.Shell, "Shell, with the argument preceding the command, running check and reload commands, e.g. '/bin/bash -c' or 'cmd /C', or 'none' to run them split into arguments without a shell")
+	fs.DurationVar(&gc.RenderTimeout, "render-timeout", gc.RenderTimeout, "Deadline of a render including its check and reload commands, which are killed when exceeded (0 means none)")
+	fs.DurationVar(&gc.CheckTimeout, "check-timeout", gc.CheckTimeout, "Time a check command may run before being killed along with its process group (0 means no limit, templates may set their own)")
+	fs.DurationVar(&gc.ReloadTimeout, "reload-timeout", gc.ReloadTimeout, "Time a reload command may run before being killed along with its process group (0 means no limit, templates may set their own)")
+	fs.IntVar(&gc.ReloadRetries, "reload-retries", gc.ReloadRetries, "Number of times a failing reload command is retried before giving up until the next sync")
+	fs.DurationVar(&gc.ReloadDelay, "reload-delay", gc.ReloadDelay, "Wait before retrying a failed reload command, doubled on each attempt")
+	fs.DurationVar(&gc.ReloadMaxDelay, "reload-max-delay", gc.ReloadMaxDelay, "Maximum wait between reload command retries")
+	fs.IntVar(&gc.CheckCacheSize, "check-cache-size", gc.CheckCacheSize, "Number of contents accepted by check commands that are not checked again, checks depending on external state like certificates or upstreams are skipped too (0 disables it)")
+	fs.StringVar(&gc.EmptyPrefix, "empty-prefix", gc.EmptyPrefix, "What to do when a template prefix holds no keys: 'fail', 'empty' to render without keys or 'retry'")
+	fs.IntVar(&gc.EmptyRetries, "empty-prefix-retries", gc.EmptyRetries, "Number of times an empty prefix is read again with the 'retry' policy before failing")
+	fs.StringSliceVar(&gc.Mounts, "mount", gc.Mounts, "Additional backend mounted under a key path like '/secrets=vault --address https://vault:8200', repeat list flags instead of separating values with commas")
 }
 
 func AddConsulFlags(fs *flag.FlagSet, cbc *config.ConsulBackendConfig) {
-	fs.StringSliceVar(&cbc.Endpoints, "endpoint", cbc.Endpoints, "List of consul endpoints")
+	fs.StringSliceVar(&cbc.Endpoints, "endpoint", cbc.Endpoints, "List of consul endpoints, like host:port, [::1]:8500 or unix:///var/run/consul.sock")
 	fs.StringVar(&cbc.CertFile, "cert-file", cbc.CertFile, "Identify HTTPS client using this SSL certificate file")
 	fs.StringVar(&cbc.KeyFile, "key-file", cbc.KeyFile, "Identify HTTPS client using this SSL key file")
 	fs.StringVar(&cbc.CAFile, "ca-file", cbc.CAFile, "Verify certificates of HTTPS-enabled servers using this CA bundle")
+	fs.StringVar(&cbc.Consistency, "consistency", cbc.Consistency, "Read consistency mode: 'consistent' reads from the leader after verifying it, 'default' from the leader, 'stale' from any server. Keys are read consistently and listed in default mode if empty")
 }
 
 func AddEtcdFlags(fs *flag.FlagSet, ebc *config.EtcdBackendConfig) {
-	fs.StringSliceVar(&ebc.Endpoints, "endpoint", ebc.Endpoints, "List of etcd endpoints")
+	fs.StringSliceVar(&ebc.Endpoints, "endpoint", ebc.Endpoints, "List of etcd endpoints, like host:port, [::1]:2379 or unix:///var/run/etcd.sock")
 	fs.StringVar(&ebc.CertFile, "cert-file", ebc.CertFile, "Identify HTTPS client using this SSL certificate file")
 	fs.StringVar(&ebc.KeyFile, "key-file", ebc.KeyFile, "Identify HTTPS client using this SSL key file")
 	fs.StringVar(&ebc.CAFile, "ca-file", ebc.CAFile, "Verify certificates of HTTPS-enabled servers using this CA bundle")
+	fs.StringVar(&ebc.Consistency, "consistency", ebc.Consistency, "Read consistency mode: 'quorum' for linearizable reads, 'serializable' for reads served by the member, possibly stale, with less load on the cluster")
 }
 
 func AddZookeeperFlags(fs *flag.FlagSet, zbc *config.ZookeeperBackendConfig) {
 	fs.StringSliceVar(&zbc.Endpoints, "endpoint", zbc.Endpoints, "List of zookeeper endpoints")
 }
 
+func AddFixtureFlags(fs *flag.FlagSet, fbc *config.FixtureBackendConfig) {
+	fs.StringSliceVar(&fbc.Files, "values", fbc.Files, "List of YAML/JSON files providing the keys")
+}
+
+func AddPushFlags(fs *flag.FlagSet, pbc *config.PushBackendConfig) {
+	fs.StringVar(&pbc.Listen, "listen", pbc.Listen, "Address to receive pushed snapshots on")
+	fs.StringVar(&pbc.SecretFile, "secret-file", pbc.SecretFile, "File containing the secret snapshots are signed with")
+	fs.StringVar(&pbc.CertFile, "cert-file", pbc.CertFile, "Receive snapshots over HTTPS with this SSL certificate file")
+	fs.StringVar(&pbc.KeyFile, "key-file", pbc.KeyFile, "Receive snapshots over HTTPS with this SSL key file")
+	fs.StringVar(&pbc.StateFile, "state-file", pbc.StateFile, "File keeping the index of the last snapshot accepted, refusing older ones after restarts")
+}
+
+func AddMQTTFlags(fs *flag.FlagSet, mbc *config.MQTTBackendConfig) {
+	fs.StringSliceVar(&mbc.Endpoints, "endpoint", mbc.Endpoints, "List of MQTT broker endpoints, like host:port, [::1]:1883 or unix:///var/run/mqtt.sock")
+	fs.StringVar(&mbc.Topic, "topic", mbc.Topic, "Topic filter whose retained messages provide the keys")
+	fs.StringVar(&mbc.ClientID, "client-id", mbc.ClientID, "MQTT client identifier (defaults to renderizr-<hostname>-<pid>)")
+	fs.StringVar(&mbc.Username, "username", mbc.Username, "MQTT username")
+	fs.StringVar(&mbc.PasswordFile, "password-file", mbc.PasswordFile, "File containing the MQTT password")
+	fs.StringVar(&mbc.CertFile, "cert-file", mbc.CertFile, "Identify TLS client using this SSL certificate file")
+	fs.StringVar(&mbc.KeyFile, "key-file", mbc.KeyFile, "Identify TLS client using this SSL key file")
+	fs.StringVar(&mbc.CAFile, "ca-file", mbc.CAFile, "Verify certificates of TLS-enabled brokers using this CA bundle")
+}
+
+func AddSQLFlags(fs *flag.FlagSet, sbc *config.SQLBackendConfig) {
+	fs.StringVar(&sbc.Driver, "driver", sbc.Driver, "Database driver: 'postgres' or 'mysql'")
+	fs.StringVar(&sbc.DSN, "dsn", sbc.DSN, "Database connection string (prefer the RENDERIZR_SQL_DSN environment variable)")
+	fs.StringVar(&sbc.Table, "table", sbc.Table, "Table holding the keys, identifiers are quoted and so case sensitive")
+	fs.StringVar(&sbc.KeyColumn, "key-column", sbc.KeyColumn, "Column holding the key paths")
+	fs.StringVar(&sbc.ValueColumn, "value-column", sbc.ValueColumn, "Column holding the values")
+	fs.DurationVar(&sbc.PollInterval, "poll-interval", sbc.PollInterval, "Interval between change checks in watch mode")
+	fs.StringVar(&sbc.NotifyChannel, "notify-channel", sbc.NotifyChannel, "Postgres channel to LISTEN on for change notifications")
+}
+
+func AddHTTPFlags(fs *flag.FlagSet, hbc *config.HTTPBackendConfig) {
+	fs.StringVar(&hbc.URL, "url", hbc.URL, "URL of the JSON/YAML document providing the keys")
+	fs.StringSliceVar(&hbc.Headers, "header", hbc.Headers, "Additional request headers like 'Authorization: Bearer xxx'")
+	fs.DurationVar(&hbc.Timeout, "timeout", hbc.Timeout, "Request timeout")
+	fs.StringVar(&hbc.CertFile, "cert-file", hbc.CertFile, "Identify HTTPS client using this SSL certificate file")
+	fs.StringVar(&hbc.KeyFile, "key-file", hbc.KeyFile, "Identify HTTPS client using this SSL key file")
+	fs.StringVar(&hbc.CAFile, "ca-file", hbc.CAFile, "Verify certificates of HTTPS-enabled servers using this CA bundle")
+}
+
+func AddAzureFlags(fs *flag.FlagSet, abc *config.AzureBackendConfig) {
+	fs.StringVar(&abc.AppConfigEndpoint, "app-config-endpoint", abc.AppConfigEndpoint, "App Configuration store URL, e.g. https://mystore.azconfig.io")
+	fs.StringVar(&abc.Label, "label", abc.Label, "Only read App Configuration settings with this label")
+	fs.StringVar(&abc.KeySeparator, "key-separator", abc.KeySeparator, "App Configuration key separator mapped to '/'")
+	fs.StringVar(&abc.KeyVault, "key-vault", abc.KeyVault, "Key Vault name or URL to read secrets from")
+	fs.StringVar(&abc.SecretsPrefix, "secrets-prefix", abc.SecretsPrefix, "Key path Key Vault secrets are mapped under")
+	fs.StringVar(&abc.ClientID, "client-id", abc.ClientID, "Client id of a user assigned managed identity")
+}
+
+func AddGCPFlags(fs *flag.FlagSet, gbc *config.GCPBackendConfig) {
+	fs.StringVar(&gbc.Project, "project", gbc.Project, "Project owning the secrets (defaults to the instance project)")
+	fs.StringSliceVar(&gbc.Secrets, "secret", gbc.Secrets, "Secrets to read (defaults to all of them)")
+	fs.StringSliceVar(&gbc.SecretVersions, "secret-version", gbc.SecretVersions, "Pin secrets to a version like 'name=3' (defaults to latest)")
+	fs.StringVar(&gbc.SecretsPrefix, "secrets-prefix", gbc.SecretsPrefix, "Key path secrets are mapped under")
+	fs.StringVar(&gbc.MetadataPrefix, "metadata-prefix", gbc.MetadataPrefix, "Key path instance and project attributes are mapped under (disabled if empty)")
+}
+
+func AddVaultFlags(fs *flag.FlagSet, vbc *config.VaultBackendConfig) {
+	fs.StringVar(&vbc.Address, "address", vbc.Address, "Vault server address")
+	fs.StringVar(&vbc.Namespace, "namespace", vbc.Namespace, "Vault namespace")
+	fs.StringVar(&vbc.TokenFile, "token-file", vbc.TokenFile, "File containing the Vault token (defaults to the VAULT_TOKEN environment variable)")
+	fs.StringVar(&vbc.RoleID, "role-id", vbc.RoleID, "AppRole role id, logs in with AppRole instead of using a token")
+	fs.StringVar(&vbc.SecretIDFile, "secret-id-file", vbc.SecretIDFile, "File containing the AppRole secret id")
+	fs.StringVar(&vbc.AuthMount, "auth-mount", vbc.AuthMount, "Mount path of the AppRole auth method")
+	fs.StringVar(&vbc.KVMount, "kv-mount", vbc.KVMount, "Mount path of the KV secrets engine mapped into the key space (disabled if empty)")
+	fs.IntVar(&vbc.KVVersion, "kv-version", vbc.KVVersion, "Version of the KV secrets engine: 1 or 2")
+	fs.StringSliceVar(&vbc.Paths, "path", vbc.Paths, "Other secret paths to read, like 'database/creds/app', their leases are renewed")
+	fs.DurationVar(&vbc.PollInterval, "poll-interval", vbc.PollInterval, "Interval between change checks in watch mode")
+	fs.StringVar(&vbc.CertFile, "cert-file", vbc.CertFile, "Identify HTTPS client using this SSL certificate file")
+	fs.StringVar(&vbc.KeyFile, "key-file", vbc.KeyFile, "Identify HTTPS client using this SSL key file")
+	fs.StringVar(&vbc.CAFile, "ca-file", vbc.CAFile, "Verify certificates of the Vault server using this CA bundle")
+}
+
+func AddK8sFlags(fs *flag.FlagSet, kbc *config.K8sBackendConfig) {
+	fs.StringVar(&kbc.Server, "server", kbc.Server, "Kubernetes API server address (defaults to the in-cluster one)")
+	fs.StringVar(&kbc.TokenFile, "token-file", kbc.TokenFile, "File containing the bearer token, read again on every request")
+	fs.StringSliceVar(&kbc.Namespaces, "namespace", kbc.Namespaces, "Namespaces to read objects from (all of them if empty)")
+	fs.StringVar(&kbc.LabelSelector, "selector", kbc.LabelSelector, "Only read objects matching this label selector")
+	fs.StringSliceVar(&kbc.Resources, "resource", kbc.Resources, "Kinds of objects to read: configmaps and/or secrets")
+	fs.StringVar(&kbc.CertFile, "cert-file", kbc.CertFile, "Identify HTTPS client using this SSL certificate file")
+	fs.StringVar(&kbc.KeyFile, "key-file", kbc.KeyFile, "Identify HTTPS client using this SSL key file")
+	fs.StringVar(&kbc.CAFile, "ca-file", kbc.CAFile, "Verify certificates of the API server using this CA bundle")
+}
+
+func AddEnvFlags(fs *flag.FlagSet, ebc *config.EnvBackendConfig) {
+	fs.StringVar(&ebc.Prefix, "env-prefix", ebc.Prefix, "Only expose the environment variables starting with this prefix, like 'MYAPP_'")
+	fs.BoolVar(&ebc.StripPrefix, "strip-prefix", ebc.StripPrefix, "Remove the environment prefix from the keys")
+	fs.StringVar(&ebc.Separator, "separator", ebc.Separator, "Separator of the variable name components mapped to key path components")
+}
+
+func AddFsFlags(fs *flag.FlagSet, fbc *config.FsBackendConfig) {
+	fs.StringVar(&fbc.RootPath, "root-path", fbc.RootPath, "Directory whose files are mapped into keys")
+	fs.Int64Var(&fbc.MaxFileSize, "max-file-size", fbc.MaxFileSize, "Size in bytes over which files are skipped (0 means unlimited)")
+}
+
+func AddExportFlags(fs *flag.FlagSet, ec *config.ExportConfig) {
+	fs.StringVar(&ec.Output, "output", ec.Output, "Archive to write rendered files to ('-' for stdout)")
+	fs.StringVar(&ec.Format, "format", ec.Format, "Archive format: 'tar' or 'oci-layer'")
+	fs.StringVar(&ec.Descriptor, "descriptor", ec.Descriptor, "Write the OCI layer descriptor (digest, size and diff id) to this file")
+}
+
+func AddBenchFlags(fs *flag.FlagSet, bc *config.BenchConfig) {
+	fs.IntVar(&bc.Iterations, "iterations", bc.Iterations, "Number of times each template is rendered")
+	fs.IntVar(&bc.TopFuncs, "top-funcs", bc.TopFuncs, "Number of most expensive template functions to report")
+}
+
+func AddGenerateFlags(fs *flag.FlagSet, gc *config.GenerateConfig) {
+	fs.StringVar(&gc.Output, "output", gc.Output, "Directory to write conf.d/<name>.toml and templates/<name>.tmpl to, to be used as --confdir")
+	fs.StringVar(&gc.Prefix, "prefix", gc.Prefix, "Prefix of the keys, laid out as <prefix>/<service>/<instance> = <host>:<port>")
+	fs.StringVar(&gc.Dest, "dest", gc.Dest, "Destination of the rendered file, instead of the conventional one")
+	fs.BoolVar(&gc.Force, "force", gc.Force, "Overwrite existing files")
+}
+
+func AddValidateFlags(fs *flag.FlagSet, vc *config.ValidateConfig) {
+	fs.StringSliceVar(&vc.Values, "values", vc.Values, "YAML/JSON files providing the keys to render the templates with, they are only parsed if empty")
+}
+
+func AddRenderFlags(fs *flag.FlagSet, rc *config.RenderConfig) {
+	fs.StringSliceVar(&rc.Values, "values", rc.Values, "YAML/JSON files providing the keys to render the templates with")
+	fs.BoolVar(&rc.Write, "write", rc.Write, "Sync the destinations, running the check and reload commands, instead of printing the outputs")
+}
+
+func AddWhatIfFlags(fs *flag.FlagSet, wc *config.WhatIfConfig) {
+	fs.StringSliceVar(&wc.Set, "set", wc.Set, "Key to render as if it was written, like '/key=value', repeat list flags instead of separating values with commas")
+	fs.StringSliceVar(&wc.Delete, "delete", wc.Delete, "Key to render as if it was deleted, along with the keys under it")
+}
+
+func AddRollbackFlags(fs *flag.FlagSet, rc *config.RollbackConfig) {
+	fs.StringSliceVar(&rc.Dests, "dest", rc.Dests, "Destinations to roll back (all of those keeping backups if empty)")
+}
+
+func AddKVImportFlags(fs *flag.FlagSet, kc *config.KVConfig) {
+	fs.StringVar(&kc.File, "file", kc.File, "YAML/JSON document to import the keys of ('-' for stdin)")
+}
+
+func AddKVExportFlags(fs *flag.FlagSet, kc *config.KVConfig) {
+	fs.StringVar(&kc.File, "file", kc.File, "File to export the keys to ('-' for stdout)")
+	fs.StringVar(&kc.Format, "format", kc.Format, "Document format: 'yaml' or 'json'")
+}
+
+func AddKVMigrateFlags(fs *flag.FlagSet, kc *config.KVConfig) {
+	fs.StringVar(&kc.From, "from", kc.From, "Backend to copy the keys from, along with its flags, like 'etcd --endpoint 127.0.0.1:2379'")
+	fs.StringVar(&kc.To, "to", kc.To, "Backend to copy the keys to, along with its flags, like 'consul --endpoint 127.0.0.1:8500'")
+	fs.StringSliceVar(&kc.Rewrites, "rewrite", kc.Rewrites, "Rename the keys matching a regular expression like '^/old/(.*)=/new/$1', the first matching rule applies and an empty replacement skips the key, repeat list flags instead of separating values with commas")
+	fs.StringVar(&kc.Exclude, "exclude", kc.Exclude, "Regular expression matching the keys not to copy")
+	fs.BoolVar(&kc.DryRun, "dry-run", kc.DryRun, "Only show the keys that would be copied")
+}
+
+// newBackendConfig returns a new configuration of the named backend and the
+// flags setting it.
+func newBackendConfig(backend store.Backend) (config.BackendConfig, *flag.FlagSet, error) {
+	fs := flag.NewFlagSet(string(backend), flag.ContinueOnError)
+	switch backend {
+	case store.CONSUL:
+		c := config.NewConsulBackendConfig()
+		AddConsulFlags(fs, c)
+		return c, fs, nil
+	case store.ETCD:
+		c := config.NewEtcdBackendConfig()
+		AddEtcdFlags(fs, c)
+		return c, fs, nil
+	case store.ZK:
+		c := config.NewZookeeperBackendConfig()
+		AddZookeeperFlags(fs, c)
+		return c, fs, nil
+	case backends.FIXTURE:
+		c := config.NewFixtureBackendConfig()
+		AddFixtureFlags(fs, c)
+		return c, fs, nil
+	case backends.PUSH:
+		c := config.NewPushBackendConfig()
+		AddPushFlags(fs, c)
+		return c, fs, nil
+	case backends.MQTT:
+		c := config.NewMQTTBackendConfig()
+		AddMQTTFlags(fs, c)
+		return c, fs, nil
+	case backends.SQL:
+		c := config.NewSQLBackendConfig()
+		AddSQLFlags(fs, c)
+		return c, fs, nil
+	case backends.HTTP:
+		c := config.NewHTTPBackendConfig()
+		AddHTTPFlags(fs, c)
+		return c, fs, nil
+	case backends.AZURE:
+		c := config.NewAzureBackendConfig()
+		AddAzureFlags(fs, c)
+		return c, fs, nil
+	case backends.GCP:
+		c := config.NewGCPBackendConfig()
+		AddGCPFlags(fs, c)
+		return c, fs, nil
+	case backends.VAULT:
+		c := config.NewVaultBackendConfig()
+		AddVaultFlags(fs, c)
+		return c, fs, nil
+	case backends.K8S:
+		c := config.NewK8sBackendConfig()
+		AddK8sFlags(fs, c)
+		return c, fs, nil
+	case backends.ENV:
+		c := config.NewEnvBackendConfig()
+		AddEnvFlags(fs, c)
+		return c, fs, nil
+	case backends.FS:
+		c := config.NewFsBackendConfig()
+		AddFsFlags(fs, c)
+		return c, fs, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown backend %q", backend)
+}
+
+// setBackendMounts parses the backends mounted with --mount, like
+// '/secrets=vault --address https://vault:8200'.
+func setBackendMounts(gc *config.GlobalConfig) {
+	gc.BackendMounts = make(map[string]config.BackendConfig)
+	for _, m := range gc.Mounts {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || path.Clean("/"+parts[0]) == "/" {
+			log.Fatalf("Mount should be provided as '/prefix=backend [flags]': %s", m)
+		}
+		prefix := path.Clean("/" + parts[0])
+		if _, ok := gc.BackendMounts[prefix]; ok {
+			log.Fatalf("Prefix %s is mounted more than once", prefix)
+		}
+
+		if strings.TrimSpace(parts[1]) == "" {
+			log.Fatalf("Mount should be provided as '/prefix=backend [flags]': %s", m)
+		}
+		bc, err := parseBackendSpec(parts[1])
+		if err != nil {
+			log.Fatalf("Unable to mount %s: %v", prefix, err)
+		}
+		gc.BackendMounts[prefix] = bc
+	}
+}
+
+// parseBackendSpec parses a backend along with its flags, like
+// 'vault --address https://vault:8200'.
+func parseBackendSpec(spec string) (config.BackendConfig, error) {
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("No backend given")
+	}
+	bc, fs, err := newBackendConfig(store.Backend(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// newBackendCommands creates a command per supported backend, all of them
+// running fn with the corresponding backend configuration.
+func newBackendCommands(fn func(cmd *cobra.Command, args []string)) []*cobra.Command {
+	consulCmd := &cobra.Command{Use: string(store.CONSUL), Run: fn}
+	AddConsulFlags(consulCmd.Flags(), consulCfg)
+
+	etcdCmd := &cobra.Command{Use: string(store.ETCD), Run: fn}
+	AddEtcdFlags(etcdCmd.Flags(), etcdCfg)
+
+	zookeeperCmd := &cobra.Command{Use: string(store.ZK), Run: fn}
+	AddZookeeperFlags(zookeeperCmd.Flags(), zookeeperCfg)
+
+	fixtureCmd := &cobra.Command{Use: string(backends.FIXTURE), Run: fn}
+	AddFixtureFlags(fixtureCmd.Flags(), fixtureCfg)
+
+	pushCmd := &cobra.Command{Use: string(backends.PUSH), Run: fn}
+	AddPushFlags(pushCmd.Flags(), pushCfg)
+
+	mqttCmd := &cobra.Command{Use: string(backends.MQTT), Run: fn}
+	AddMQTTFlags(mqttCmd.Flags(), mqttCfg)
+
+	sqlCmd := &cobra.Command{Use: string(backends.SQL), Run: fn}
+	AddSQLFlags(sqlCmd.Flags(), sqlCfg)
+
+	httpCmd := &cobra.Command{Use: string(backends.HTTP), Run: fn}
+	AddHTTPFlags(httpCmd.Flags(), httpCfg)
+
+	azureCmd := &cobra.Command{Use: string(backends.AZURE), Run: fn}
+	AddAzureFlags(azureCmd.Flags(), azureCfg)
+
+	gcpCmd := &cobra.Command{Use: string(backends.GCP), Run: fn}
+	AddGCPFlags(gcpCmd.Flags(), gcpCfg)
+
+	vaultCmd := &cobra.Command{Use: string(backends.VAULT), Run: fn}
+	AddVaultFlags(vaultCmd.Flags(), vaultCfg)
+
+	k8sCmd := &cobra.Command{Use: string(backends.K8S), Run: fn}
+	AddK8sFlags(k8sCmd.Flags(), k8sCfg)
+
+	envCmd := &cobra.Command{Use: string(backends.ENV), Run: fn}
+	AddEnvFlags(envCmd.Flags(), envCfg)
+
+	fsCmd := &cobra.Command{Use: string(backends.FS), Run: fn}
+	AddFsFlags(fsCmd.Flags(), fsCfg)
+
+	return []*cobra.Command{consulCmd, etcdCmd, zookeeperCmd, fixtureCmd, pushCmd, mqttCmd, sqlCmd, httpCmd, azureCmd, gcpCmd, vaultCmd, k8sCmd, envCmd, fsCmd}
+}
+
 func main() {
 	// initialize logs
 	util.InitLogs()
@@ -81,27 +483,153 @@ func main() {
 		},
 	)
 
-	consulCmd := &cobra.Command{Use: string(store.CONSUL), Run: run}
-	rootCmd.AddCommand(consulCmd)
+	for _, cmd := range newBackendCommands(run) {
+		rootCmd.AddCommand(cmd)
+	}
 
-	etcdCmd := &cobra.Command{Use: string(store.ETCD), Run: run}
-	rootCmd.AddCommand(etcdCmd)
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Render templates into a tar archive instead of their destinations",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	for _, cmd := range newBackendCommands(export) {
+		exportCmd.AddCommand(cmd)
+	}
+	rootCmd.AddCommand(exportCmd)
 
-	zookeeperCmd := &cobra.Command{Use: string(store.ZK), Run: run}
-	rootCmd.AddCommand(zookeeperCmd)
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Render templates repeatedly against a snapshot and report timings",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	for _, cmd := range newBackendCommands(bench) {
+		benchCmd.AddCommand(cmd)
+	}
+	rootCmd.AddCommand(benchCmd)
+
+	replCmd := &cobra.Command{
+		Use:   "repl",
+		Short: "Evaluate template expressions interactively against the backend data",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	for _, cmd := range newBackendCommands(repl) {
+		replCmd.AddCommand(cmd)
+	}
+	rootCmd.AddCommand(replCmd)
+
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Write a starter template resource for a common use case",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	for name, description := range renderizr.Generators() {
+		generateCmd.AddCommand(&cobra.Command{Use: name, Short: description, Run: generate})
+	}
+	rootCmd.AddCommand(generateCmd)
+
+	kvCmd := &cobra.Command{
+		Use:   "kv",
+		Short: "Import and export the backend keys under the prefix as YAML/JSON documents",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	kvImportCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Write the keys of a YAML/JSON document into the backend under the prefix",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	for _, cmd := range newBackendCommands(kvImport) {
+		kvImportCmd.AddCommand(cmd)
+	}
+	kvExportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the backend keys under the prefix as a YAML/JSON document",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	for _, cmd := range newBackendCommands(kvExport) {
+		kvExportCmd.AddCommand(cmd)
+	}
+	kvMigrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Copy the keys under the prefix from one backend to another",
+		Run:   kvMigrate,
+	}
+	kvCmd.AddCommand(kvImportCmd, kvExportCmd, kvMigrateCmd)
+	rootCmd.AddCommand(kvCmd)
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the templates parse and, given fixture values, render",
+		Run:   validate,
+	}
+	rootCmd.AddCommand(validateCmd)
+
+	renderCmd := &cobra.Command{
+		Use:   "render",
+		Short: "Render templates from local YAML/JSON values, without a backend",
+		Run:   render,
+	}
+	rootCmd.AddCommand(renderCmd)
+
+	whatIfCmd := &cobra.Command{
+		Use:   "what-if",
+		Short: "Show how the templates would change if keys were written or deleted",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	for _, cmd := range newBackendCommands(whatIf) {
+		whatIfCmd.AddCommand(cmd)
+	}
+	rootCmd.AddCommand(whatIfCmd)
+
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Restore the latest backup of destinations and reload them",
+		Run:   rollback,
+	}
+	rootCmd.AddCommand(rollbackCmd)
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "clean",
+		Short: "Remove stage files left behind by previous runs",
+		Run:   clean,
+	})
 
 	// flags
 	AddGlobalFlags(rootCmd.PersistentFlags(), globalCfg)
-	AddConsulFlags(consulCmd.Flags(), consulCfg)
-	AddEtcdFlags(etcdCmd.Flags(), etcdCfg)
-	AddZookeeperFlags(zookeeperCmd.Flags(), zookeeperCfg)
+	AddExportFlags(exportCmd.PersistentFlags(), exportCfg)
+	AddBenchFlags(benchCmd.PersistentFlags(), benchCfg)
+	AddGenerateFlags(generateCmd.PersistentFlags(), generateCfg)
+	AddKVImportFlags(kvImportCmd.PersistentFlags(), kvCfg)
+	AddKVExportFlags(kvExportCmd.PersistentFlags(), kvCfg)
+	AddKVMigrateFlags(kvMigrateCmd.Flags(), kvCfg)
+	AddValidateFlags(validateCmd.Flags(), validateCfg)
+	AddRenderFlags(renderCmd.Flags(), renderCfg)
+	AddWhatIfFlags(whatIfCmd.PersistentFlags(), whatIfCfg)
+	AddRollbackFlags(rollbackCmd.Flags(), rollbackCfg)
 
 	// execute!
 	rootCmd.Execute()
 }
 
-func run(cmd *cobra.Command, args []string) {
-	// Set flags form env's (if not set explicitly)
+// setFlagsFromEnv sets the flags of cmd and its parents from environment
+// variables (if not set explicitly), e.g. RENDERIZR_EXPORT_OUTPUT or
+// RENDERIZR_ETCD_ENDPOINT.
+func setFlagsFromEnv(cmd *cobra.Command) {
 	setFromEnvs := func(prefix string, flagSet *flag.FlagSet) {
 		flagSet.VisitAll(func(f *flag.Flag) {
 			if !f.Changed {
@@ -120,9 +648,107 @@ func run(cmd *cobra.Command, args []string) {
 		})
 	}
 
-	setFromEnvs(cliName, cmd.Parent().PersistentFlags())
+	setFromEnvs(cliName, cmd.Root().PersistentFlags())
+	if parent := cmd.Parent(); parent != cmd.Root() {
+		setFromEnvs(strings.Join([]string{cliName, parent.Name()}, "_"), parent.PersistentFlags())
+	}
 	setFromEnvs(strings.Join([]string{cliName, cmd.Name()}, "_"), cmd.Flags())
+}
+
+func run(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
 
 	// and then, run!
 	renderizr.Run(globalCfg, backendCfgs[store.Backend(cmd.Name())])
 }
+
+func export(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
+
+	renderizr.Export(globalCfg, backendCfgs[store.Backend(cmd.Name())], exportCfg)
+}
+
+func bench(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
+
+	renderizr.Bench(globalCfg, backendCfgs[store.Backend(cmd.Name())], benchCfg)
+}
+
+func clean(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+
+	renderizr.Clean(globalCfg)
+}
+
+func validate(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+
+	renderizr.Validate(globalCfg, validateCfg)
+}
+
+func render(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+
+	renderizr.Render(globalCfg, renderCfg)
+}
+
+func whatIf(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
+
+	renderizr.WhatIf(globalCfg, backendCfgs[store.Backend(cmd.Name())], whatIfCfg)
+}
+
+func rollback(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+
+	renderizr.Rollback(globalCfg, rollbackCfg)
+}
+
+func kvImport(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
+
+	renderizr.KVImport(globalCfg, backendCfgs[store.Backend(cmd.Name())], kvCfg)
+}
+
+func kvExport(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
+
+	renderizr.KVExport(globalCfg, backendCfgs[store.Backend(cmd.Name())], kvCfg)
+}
+
+func kvMigrate(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
+
+	if kvCfg.From == "" || kvCfg.To == "" {
+		log.Fatalf("Both --from and --to backends are required")
+	}
+	from, err := parseBackendSpec(kvCfg.From)
+	if err != nil {
+		log.Fatalf("Invalid --from backend: %v", err)
+	}
+	to, err := parseBackendSpec(kvCfg.To)
+	if err != nil {
+		log.Fatalf("Invalid --to backend: %v", err)
+	}
+	renderizr.KVMigrate(globalCfg, from, to, kvCfg)
+}
+
+func generate(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+
+	renderizr.Generate(generateCfg, cmd.Name())
+}
+
+func repl(cmd *cobra.Command, args []string) {
+	setFlagsFromEnv(cmd)
+	setBackendMounts(globalCfg)
+
+	renderizr.Repl(globalCfg, backendCfgs[store.Backend(cmd.Name())])
+}
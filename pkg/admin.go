@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// adminServer is the operational console served on the admin listener. It
+// shows the status of every template and allows re-rendering them.
+type adminServer struct {
+	templates  []*core.Template
+	processors []core.Processor
+	events     *core.EventLog
+	// token, if set, is required by every request
+	token   []byte
+	mux     *http.ServeMux
+	handler http.Handler
+}
+
+func newAdminServer(templates []*core.Template, processors []core.Processor, events *core.EventLog, token []byte) *adminServer {
+	s := &adminServer{
+		templates:  templates,
+		processors: processors,
+		events:     events,
+		token:      token,
+		mux:        http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/render", s.handleRender)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	// renders cannot be requested by other sites the browser visits
+	s.handler = util.RequireToken(s.token, "renderizr", http.NewCrossOriginProtection().Handler(s.mux))
+	return s
+}
+
+// ListenAndServe serves the console on addr, errors are logged.
+func (s *adminServer) ListenAndServe(addr string) {
+	log.Infof("Serving admin console on %s", addr)
+	if err := http.ListenAndServe(addr, s.handler); err != nil {
+		log.Errorf("Admin console stopped: %v", err)
+	}
+}
+
+func (s *adminServer) statuses() []core.Status {
+	statuses := make([]core.Status, len(s.templates))
+	for i, t := range s.templates {
+		statuses[i] = t.Status()
+	}
+	return statuses
+}
+
+func (s *adminServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := struct {
+		Statuses []core.Status
+		Events   []core.Event
+	}{s.statuses(), s.events.Events()}
+	if err := indexTemplate.Execute(w, data); err != nil {
+		log.Errorf("Unable to render admin console: %v", err)
+	}
+}
+
+func (s *adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.statuses())
+}
+
+func (s *adminServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.events.Events())
+}
+
+// handleRender re-renders the template given by its index in the id form
+// value, or all of them if absent.
+func (s *adminServer) handleRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	processors := s.processors
+	if id := r.FormValue("id"); id != "" {
+		i, err := strconv.Atoi(id)
+		if err != nil || i < 0 || i >= len(s.processors) {
+			http.Error(w, "unknown template "+id, http.StatusNotFound)
+			return
+		}
+		processors = processors[i : i+1]
+	}
+
+	for _, p := range processors {
+		go func(p core.Processor) {
+			if err := p.Run(); err != nil {
+				log.Error(err)
+			}
+		}(p)
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>renderizr</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: .4em; text-align: left; vertical-align: top; }
+pre { background: #f6f6f6; padding: .5em; max-height: 20em; overflow: auto; }
+.ok { color: #2a7d2a; }
+.ko { color: #b22222; }
+</style>
+</head>
+<body>
+<h1>renderizr</h1>
+<form method="post" action="/render"><button>Render all</button></form>
+<table>
+<tr><th>Template</th><th>Destination</th><th>Status</th><th>Renders</th><th>Changes</th><th>Errors</th><th>Last change</th><th></th></tr>
+{{range $i, $s := .Statuses}}
+<tr>
+<td>{{$s.Src}}</td>
+<td>{{$s.Dest}}{{if $s.Advisory}} (advisory){{end}}</td>
+<td>{{if $s.InSync}}<span class="ok">in sync</span>{{else}}<span class="ko">out of sync</span>{{end}}</td>
+<td>{{$s.Renders}}</td>
+<td>{{$s.Changes}}</td>
+<td>{{$s.Errors}}</td>
+<td>{{if not $s.LastChange.IsZero}}{{$s.LastChange.Format "2006-01-02 15:04:05"}}{{end}}</td>
+<td><form method="post" action="/render"><input type="hidden" name="id" value="{{$i}}"><button>Render</button></form></td>
+</tr>
+{{if $s.LastError}}<tr><td colspan="8" class="ko">{{$s.LastErrorTime.Format "2006-01-02 15:04:05"}}: {{$s.LastError}}</td></tr>{{end}}
+{{if $s.Funcs}}<tr><td colspan="8">Functions: {{range $j, $f := $s.Funcs}}{{if $j}}, {{end}}{{$f.Name}} {{$f.Calls}} calls {{$f.Duration}}{{end}}</td></tr>{{end}}
+{{if $s.LastDiff}}<tr><td colspan="8"><pre>{{$s.LastDiff}}</pre></td></tr>{{end}}
+{{end}}
+</table>
+<h2>Recent events</h2>
+<table>
+<tr><th>Time</th><th>Type</th><th>Template</th><th>Message</th></tr>
+{{range .Events}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Type}}</td><td>{{.Template}}</td><td>{{.Message}}</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
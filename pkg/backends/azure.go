@@ -0,0 +1,337 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// AZURE backend reads settings from Azure App Configuration and secrets
+// from Azure Key Vault, authenticating with a managed identity.
+const AZURE store.Backend = "azure"
+
+const (
+	azureIMDSEndpoint      = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureAppConfigVersion  = "1.0"
+	azureKeyVaultVersion   = "7.4"
+	azureKeyVaultResource  = "https://vault.azure.net"
+	azureRequestTimeout    = 30 * time.Second
+	azureDefaultSecretsDir = "/secrets"
+)
+
+// AzureOptions selects the services the keys are read from.
+type AzureOptions struct {
+	// AppConfigEndpoint is the App Configuration store URL, e.g.
+	// https://mystore.azconfig.io.
+	AppConfigEndpoint string
+	// Label filters App Configuration settings, empty means no label.
+	Label string
+	// KeySeparator is the hierarchy separator of App Configuration keys,
+	// it is replaced by "/".
+	KeySeparator string
+	// KeyVault is the vault name or URL, e.g. myvault or
+	// https://myvault.vault.azure.net.
+	KeyVault string
+	// SecretsPrefix is the directory secrets are mapped under.
+	SecretsPrefix string
+	// ClientID selects a user assigned managed identity.
+	ClientID string
+}
+
+// AzureStore is a read-only store merging App Configuration settings and
+// Key Vault secrets.
+type AzureStore struct {
+	options AzureOptions
+	client  *http.Client
+
+	mutex  sync.Mutex
+	tokens map[string]*tokenSource
+}
+
+// NewAzure returns a store reading from the services set in options.
+func NewAzure(options AzureOptions) (*AzureStore, error) {
+	if options.AppConfigEndpoint == "" && options.KeyVault == "" {
+		return nil, fmt.Errorf("Provide an App Configuration endpoint and/or a Key Vault")
+	}
+	if options.KeyVault != "" && !strings.HasPrefix(options.KeyVault, "https://") {
+		options.KeyVault = fmt.Sprintf("https://%s.vault.azure.net", options.KeyVault)
+	}
+	options.AppConfigEndpoint = strings.TrimSuffix(options.AppConfigEndpoint, "/")
+	options.KeyVault = strings.TrimSuffix(options.KeyVault, "/")
+	if options.SecretsPrefix == "" {
+		options.SecretsPrefix = azureDefaultSecretsDir
+	}
+
+	s := &AzureStore{
+		options: options,
+		client:  &http.Client{Timeout: azureRequestTimeout},
+		tokens:  make(map[string]*tokenSource),
+	}
+	return s, nil
+}
+
+// token returns a managed identity token for resource.
+func (s *AzureStore) token(resource string) (string, error) {
+	s.mutex.Lock()
+	ts, ok := s.tokens[resource]
+	if !ok {
+		ts = &tokenSource{fetch: func() (string, time.Time, error) {
+			return s.fetchToken(resource)
+		}}
+		s.tokens[resource] = ts
+	}
+	s.mutex.Unlock()
+
+	return ts.Token()
+}
+
+// fetchToken requests a token from the App Service identity endpoint if
+// available, or from the instance metadata service otherwise.
+func (s *AzureStore) fetchToken(resource string) (string, time.Time, error) {
+	q := url.Values{}
+	q.Set("resource", resource)
+	if s.options.ClientID != "" {
+		q.Set("client_id", s.options.ClientID)
+	}
+
+	var req *http.Request
+	var err error
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" {
+		q.Set("api-version", "2019-08-01")
+		req, err = http.NewRequest("GET", endpoint+"?"+q.Encode(), nil)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER"))
+	} else {
+		q.Set("api-version", "2018-02-01")
+		req, err = http.NewRequest("GET", azureIMDSEndpoint+"?"+q.Encode(), nil)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+
+	var resp struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	if err := s.do(req, &resp); err != nil {
+		return "", time.Time{}, fmt.Errorf("Unable to get managed identity token: %v", err)
+	}
+
+	expiresOn, err := strconv.ParseInt(resp.ExpiresOn.String(), 10, 64)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return resp.AccessToken, time.Unix(expiresOn, 0), nil
+}
+
+// get performs an authenticated GET request decoding the JSON response.
+func (s *AzureStore) get(resource, u string, v interface{}) error {
+	token, err := s.token(resource)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return s.do(req, v)
+}
+
+func (s *AzureStore) do(req *http.Request, v interface{}) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// settings returns every App Configuration setting matching the label.
+func (s *AzureStore) settings() (map[string]string, error) {
+	pairs := make(map[string]string)
+	if s.options.AppConfigEndpoint == "" {
+		return pairs, nil
+	}
+
+	q := url.Values{}
+	q.Set("api-version", azureAppConfigVersion)
+	if s.options.Label != "" {
+		q.Set("label", s.options.Label)
+	}
+	next := "/kv?" + q.Encode()
+
+	for next != "" {
+		var page struct {
+			Items []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"items"`
+			NextLink string `json:"@nextLink"`
+		}
+		if err := s.get(s.options.AppConfigEndpoint, s.options.AppConfigEndpoint+next, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			key := item.Key
+			if s.options.KeySeparator != "" {
+				key = strings.Replace(key, s.options.KeySeparator, "/", -1)
+			}
+			pairs[normalize(key)] = item.Value
+		}
+		next = page.NextLink
+	}
+
+	return pairs, nil
+}
+
+// secrets returns the current version of every enabled Key Vault secret.
+func (s *AzureStore) secrets() (map[string]string, error) {
+	pairs := make(map[string]string)
+	if s.options.KeyVault == "" {
+		return pairs, nil
+	}
+
+	next := s.options.KeyVault + "/secrets?api-version=" + azureKeyVaultVersion
+	for next != "" {
+		var page struct {
+			Value []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					Enabled bool `json:"enabled"`
+				} `json:"attributes"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+		if err := s.get(azureKeyVaultResource, next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Value {
+			if !item.Attributes.Enabled {
+				continue
+			}
+			var secret struct {
+				Value string `json:"value"`
+			}
+			if err := s.get(azureKeyVaultResource, item.ID+"?api-version="+azureKeyVaultVersion, &secret); err != nil {
+				return nil, err
+			}
+			pairs[path.Join(normalize(s.options.SecretsPrefix), path.Base(item.ID))] = secret.Value
+		}
+		next = page.NextLink
+	}
+
+	return pairs, nil
+}
+
+func (s *AzureStore) List(directory string) ([]*store.KVPair, error) {
+	settings, err := s.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	directory = normalize(directory)
+	secretsPrefix := normalize(s.options.SecretsPrefix)
+	if isChildKey(directory, secretsPrefix) || isChildKey(secretsPrefix, directory) {
+		secrets, err := s.secrets()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range secrets {
+			settings[k] = v
+		}
+	}
+
+	pairs := make([]*store.KVPair, 0)
+	for k, v := range settings {
+		if isChildKey(directory, k) {
+			pairs = append(pairs, &store.KVPair{Key: k, Value: []byte(v)})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+func (s *AzureStore) Get(key string) (*store.KVPair, error) {
+	key = normalize(key)
+	pairs, err := s.List(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		if pair.Key == key {
+			return pair, nil
+		}
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *AzureStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *AzureStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *AzureStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *AzureStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *AzureStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *AzureStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *AzureStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *AzureStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *AzureStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+func (s *AzureStore) Close() {
+}
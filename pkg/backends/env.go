@@ -0,0 +1,52 @@
+package backends
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// ENV backend exposes the process environment, e.g. MYAPP_DB_HOST becomes
+// /myapp/db/host.
+const ENV store.Backend = "env"
+
+// EnvOptions sets which variables are exposed and how their names map to
+// keys.
+type EnvOptions struct {
+	// Prefix restricts the variables to those whose name starts with it,
+	// all of them if empty. If StripPrefix is set it is not part of the key.
+	Prefix      string
+	StripPrefix bool
+	// Separator splits variable names into key components.
+	Separator string
+}
+
+// NewEnv returns a read-only store over a snapshot of the environment.
+// Variable names are lower cased, the environment of a running process does
+// not change, so the store supports neither watches nor reloads.
+func NewEnv(options EnvOptions) (*Fixture, error) {
+	if options.Separator == "" {
+		options.Separator = "_"
+	}
+
+	s := &Fixture{pairs: make(map[string]*store.KVPair)}
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], options.Prefix) {
+			continue
+		}
+
+		name := parts[0]
+		if options.StripPrefix {
+			name = strings.TrimPrefix(name, options.Prefix)
+		}
+		key := path.Join("/", strings.ToLower(strings.Replace(name, options.Separator, "/", -1)))
+		if key == "/" {
+			continue
+		}
+		s.pairs[key] = &store.KVPair{Key: key, Value: []byte(parts[1])}
+	}
+	return s, nil
+}
@@ -0,0 +1,166 @@
+package backends
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/libkv"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"gopkg.in/yaml.v2"
+)
+
+// FIXTURE backend reads keys from local YAML or JSON documents.
+const FIXTURE store.Backend = "fixture"
+
+func init() {
+	libkv.AddStore(FIXTURE, NewFixture)
+}
+
+// Fixture is a read-only store backed by a set of YAML/JSON documents which
+// are flattened into keys, nested maps and lists become path components.
+type Fixture struct {
+	pairs map[string]*store.KVPair
+}
+
+// NewFixture loads every document in files, later files override keys
+// defined by earlier ones.
+func NewFixture(files []string, options *store.Config) (store.Store, error) {
+	s := &Fixture{pairs: make(map[string]*store.KVPair)}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("Unable to parse %s: %v", file, err)
+		}
+
+		for k, v := range FlattenDocument(doc) {
+			s.pairs[k] = &store.KVPair{Key: k, Value: []byte(v)}
+		}
+	}
+	return s, nil
+}
+
+// FlattenDocument converts a decoded YAML/JSON document into a flat set of
+// keys, e.g. {"db": {"hosts": ["a"]}} becomes /db/hosts/0 = a.
+func FlattenDocument(doc interface{}) map[string]string {
+	kvs := make(map[string]string)
+	flatten("/", doc, kvs)
+	return kvs
+}
+
+func flatten(key string, v interface{}, kvs map[string]string) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		for k, child := range t {
+			flatten(path.Join(key, fmt.Sprint(k)), child, kvs)
+		}
+	case map[string]interface{}:
+		for k, child := range t {
+			flatten(path.Join(key, k), child, kvs)
+		}
+	case []interface{}:
+		for i, child := range t {
+			flatten(path.Join(key, strconv.Itoa(i)), child, kvs)
+		}
+	case nil:
+		kvs[key] = ""
+	default:
+		kvs[key] = fmt.Sprint(t)
+	}
+}
+
+func (s *Fixture) Get(key string) (*store.KVPair, error) {
+	pair, ok := s.pairs[normalize(key)]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return pair, nil
+}
+
+func (s *Fixture) Exists(key string) (bool, error) {
+	_, ok := s.pairs[normalize(key)]
+	return ok, nil
+}
+
+func (s *Fixture) List(directory string) ([]*store.KVPair, error) {
+	directory = normalize(directory)
+	pairs := make([]*store.KVPair, 0)
+	for k, pair := range s.pairs {
+		if isChildKey(directory, k) {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+func (s *Fixture) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *Fixture) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *Fixture) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *Fixture) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *Fixture) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *Fixture) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *Fixture) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *Fixture) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+func (s *Fixture) Close() {
+}
+
+// normalize returns key as an absolute, clean path.
+func normalize(key string) string {
+	return path.Join("/", key)
+}
+
+// StoreKey returns key as written to the libkv stores, without the leading
+// slash their drivers add, as some refuse keys starting with two of them.
+func StoreKey(key string) string {
+	return strings.TrimPrefix(normalize(key), "/")
+}
+
+// isChildKey reports whether key is directory itself or lives under it.
+func isChildKey(directory, key string) bool {
+	if directory == "/" || key == directory {
+		return true
+	}
+	return strings.HasPrefix(key, directory+"/")
+}
+
+type byKey []*store.KVPair
+
+func (p byKey) Len() int           { return len(p) }
+func (p byKey) Less(i, j int) bool { return p[i].Key < p[j].Key }
+func (p byKey) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,20 @@
+package backends
+
+import (
+	"testing"
+)
+
+func TestStoreKey(t *testing.T) {
+	tests := map[string]string{
+		"/a":      "a",
+		"a":       "a",
+		"//a/b/":  "a/b",
+		"/a/../b": "b",
+		"/":       "",
+	}
+	for key, expected := range tests {
+		if actual := StoreKey(key); actual != expected {
+			t.Errorf("Expected %q to be written as %q, got %q", key, expected, actual)
+		}
+	}
+}
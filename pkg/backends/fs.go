@@ -0,0 +1,284 @@
+package backends
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"gopkg.in/fsnotify.v1"
+)
+
+// FS backend maps a directory tree into keys, each file being a key holding
+// its contents.
+const FS store.Backend = "fs"
+
+// fsSettleTime is how long changes are collected before the tree is read
+// again, editors and volume updates usually touch several files at once.
+var fsSettleTime = 100 * time.Millisecond
+
+// FsOptions holds the directory mapped into the key space.
+type FsOptions struct {
+	RootPath string
+	// MaxFileSize is the size over which files are skipped, zero means no
+	// limit.
+	MaxFileSize int64
+}
+
+// FsStore is a read-only store over a directory tree. Hidden entries are
+// skipped, including the ..data directories of Kubernetes volumes whose
+// files are reached through the symlinks next to them. Symlinks to files
+// are followed, symlinks to directories are not.
+type FsStore struct {
+	options FsOptions
+	stop    chan struct{}
+}
+
+// NewFs returns a store reading the tree under options.RootPath.
+func NewFs(options FsOptions) (*FsStore, error) {
+	root, err := filepath.Abs(options.RootPath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: root, Err: os.ErrInvalid}
+	}
+	options.RootPath = root
+
+	return &FsStore{options: options, stop: make(chan struct{})}, nil
+}
+
+// file returns the file path of key.
+func (s *FsStore) file(key string) string {
+	return filepath.Join(s.options.RootPath, filepath.FromSlash(normalize(key)))
+}
+
+// read returns the contents of file, skipping it if it is not a regular
+// file or it is too big.
+func (s *FsStore) read(file string, fi os.FileInfo) ([]byte, bool) {
+	if !fi.Mode().IsRegular() {
+		return nil, false
+	}
+	if s.options.MaxFileSize > 0 && fi.Size() > s.options.MaxFileSize {
+		log.Warningf("Skipping %s, its size %d exceeds %d bytes", file, fi.Size(), s.options.MaxFileSize)
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Warningf("Unable to read %s: %v", file, err)
+		return nil, false
+	}
+	return data, true
+}
+
+// walk calls fn with every file under dir, along with its key, and every
+// directory reached.
+func (s *FsStore) walk(dir, key string, fn func(file, key string, fi os.FileInfo)) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		childKey := path.Join(key, entry.Name())
+
+		fi := entry
+		if entry.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(file); err != nil || fi.IsDir() {
+				continue
+			}
+		}
+
+		fn(file, childKey, fi)
+		if fi.IsDir() {
+			if err := s.walk(file, childKey, fn); err != nil {
+				log.Warningf("Unable to read %s: %v", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *FsStore) Get(key string) (*store.KVPair, error) {
+	if strings.Contains(normalize(key), "/.") {
+		return nil, store.ErrKeyNotFound
+	}
+	file := s.file(key)
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, store.ErrKeyNotFound
+	}
+	data, ok := s.read(file, fi)
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return &store.KVPair{Key: normalize(key), Value: data, LastIndex: uint64(fi.ModTime().UnixNano())}, nil
+}
+
+func (s *FsStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *FsStore) List(directory string) ([]*store.KVPair, error) {
+	directory = normalize(directory)
+	file := s.file(directory)
+	fi, err := os.Stat(file)
+	if err != nil || strings.Contains(directory, "/.") {
+		return nil, store.ErrKeyNotFound
+	}
+	if !fi.IsDir() {
+		pair, err := s.Get(directory)
+		if err != nil {
+			return nil, err
+		}
+		return []*store.KVPair{pair}, nil
+	}
+
+	pairs := make([]*store.KVPair, 0)
+	err = s.walk(file, directory, func(file, key string, fi os.FileInfo) {
+		if data, ok := s.read(file, fi); ok {
+			pairs = append(pairs, &store.KVPair{Key: key, Value: data, LastIndex: uint64(fi.ModTime().UnixNano())})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+// WatchTree sends the pairs under directory, starting with the current ones,
+// whenever they change. Every directory of the tree is watched with
+// fsnotify, along with the root to catch the swap of Kubernetes volumes.
+func (s *FsStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addWatches(watcher, directory); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer close(events)
+		defer watcher.Close()
+
+		var last []byte
+		settle := time.NewTimer(0)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-s.stop:
+				return
+			case event := <-watcher.Events:
+				log.Debugf("%s", event)
+				settle.Reset(fsSettleTime)
+			case err := <-watcher.Errors:
+				log.Warningf("Error watching %s: %v", s.options.RootPath, err)
+				settle.Reset(fsSettleTime)
+			case <-settle.C:
+				// New directories need their own watch.
+				if err := s.addWatches(watcher, directory); err != nil {
+					log.Warningf("Unable to watch %s: %v", s.options.RootPath, err)
+				}
+				pairs, err := s.List(directory)
+				if err != nil && err != store.ErrKeyNotFound {
+					return
+				}
+				sum := checksum(pairs)
+				if last != nil && string(sum) == string(last) {
+					continue
+				}
+				last = sum
+				select {
+				case events <- pairs:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// addWatches watches the root and every directory under directory. Adding
+// an already watched directory is a no-op.
+func (s *FsStore) addWatches(watcher *fsnotify.Watcher, directory string) error {
+	if err := watcher.Add(s.options.RootPath); err != nil {
+		return err
+	}
+	dir := s.file(directory)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil
+	}
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+	return s.walk(dir, directory, func(file, key string, fi os.FileInfo) {
+		if fi.IsDir() {
+			if err := watcher.Add(file); err != nil {
+				log.Warningf("Unable to watch %s: %v", file, err)
+			}
+		}
+	})
+}
+
+func (s *FsStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *FsStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *FsStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *FsStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *FsStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *FsStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *FsStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+// Close stops the watches.
+func (s *FsStore) Close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
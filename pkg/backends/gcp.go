@@ -0,0 +1,336 @@
+package backends
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// GCP backend reads secrets from Google Secret Manager and attributes from
+// the metadata server, authenticating with the instance (or workload
+// identity) service account.
+const GCP store.Backend = "gcp"
+
+const (
+	gcpMetadataEndpoint      = "http://metadata.google.internal/computeMetadata/v1"
+	gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com/v1"
+	gcpRequestTimeout        = 30 * time.Second
+)
+
+// GCPOptions selects what is mapped into the key space.
+type GCPOptions struct {
+	// Project owning the secrets, read from the metadata server if empty.
+	Project string
+	// Secrets restricts the secrets read, all of them if empty.
+	Secrets []string
+	// Versions pins secrets to a version, the latest is used otherwise.
+	Versions map[string]string
+	// SecretsPrefix is the directory secrets are mapped under.
+	SecretsPrefix string
+	// MetadataPrefix is the directory instance and project attributes are
+	// mapped under, attributes are not read if empty.
+	MetadataPrefix string
+}
+
+// GCPStore is a read-only store merging Secret Manager secrets and
+// metadata server attributes.
+type GCPStore struct {
+	options GCPOptions
+	client  *http.Client
+	token   *tokenSource
+}
+
+// NewGCP returns a store reading from the services set in options.
+func NewGCP(options GCPOptions) (*GCPStore, error) {
+	if options.SecretsPrefix == "" {
+		options.SecretsPrefix = "/secrets"
+	}
+
+	s := &GCPStore{
+		options: options,
+		client:  &http.Client{Timeout: gcpRequestTimeout},
+	}
+	s.token = &tokenSource{fetch: s.fetchToken}
+
+	if s.options.Project == "" {
+		project, err := s.metadata("/project/project-id")
+		if err != nil {
+			return nil, fmt.Errorf("Unable to discover the project: %v", err)
+		}
+		s.options.Project = string(project)
+	}
+
+	return s, nil
+}
+
+// metadata reads a path from the metadata server.
+func (s *GCPStore) metadata(p string) ([]byte, error) {
+	req, err := http.NewRequest("GET", gcpMetadataEndpoint+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return s.do(req)
+}
+
+// fetchToken gets an access token for the default service account.
+func (s *GCPStore) fetchToken() (string, time.Time, error) {
+	data, err := s.metadata("/instance/service-accounts/default/token")
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Unable to get service account token: %v", err)
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", time.Time{}, err
+	}
+	return resp.AccessToken, time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second), nil
+}
+
+// get performs an authenticated Secret Manager request.
+func (s *GCPStore) get(u string, v interface{}) error {
+	token, err := s.token.Token()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	data, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *GCPStore) do(req *http.Request) ([]byte, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return data, nil
+}
+
+// secretNames returns the names of the secrets to read.
+func (s *GCPStore) secretNames() ([]string, error) {
+	if len(s.options.Secrets) > 0 {
+		return s.options.Secrets, nil
+	}
+
+	names := make([]string, 0)
+	pageToken := ""
+	for {
+		q := url.Values{}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Secrets []struct {
+				Name string `json:"name"`
+			} `json:"secrets"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		u := fmt.Sprintf("%s/projects/%s/secrets?%s", gcpSecretManagerEndpoint, s.options.Project, q.Encode())
+		if err := s.get(u, &page); err != nil {
+			return nil, err
+		}
+		for _, secret := range page.Secrets {
+			names = append(names, path.Base(secret.Name))
+		}
+		if page.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// secrets returns the pinned (or latest) version of the secrets.
+func (s *GCPStore) secrets() (map[string]string, error) {
+	names, err := s.secretNames()
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make(map[string]string)
+	for _, name := range names {
+		version := s.options.Versions[name]
+		if version == "" {
+			version = "latest"
+		}
+
+		var resp struct {
+			Payload struct {
+				Data string `json:"data"`
+			} `json:"payload"`
+		}
+		u := fmt.Sprintf("%s/projects/%s/secrets/%s/versions/%s:access", gcpSecretManagerEndpoint, s.options.Project, name, version)
+		if err := s.get(u, &resp); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+		if err != nil {
+			return nil, err
+		}
+		pairs[path.Join(normalize(s.options.SecretsPrefix), name)] = string(data)
+	}
+	return pairs, nil
+}
+
+// attributes returns the custom instance and project metadata attributes.
+func (s *GCPStore) attributes() (map[string]string, error) {
+	pairs := make(map[string]string)
+	if s.options.MetadataPrefix == "" {
+		return pairs, nil
+	}
+
+	for _, scope := range []string{"instance", "project"} {
+		data, err := s.metadata("/" + scope + "/attributes/?recursive=true")
+		if err != nil {
+			return nil, err
+		}
+		var attributes map[string]string
+		if err := json.Unmarshal(data, &attributes); err != nil {
+			return nil, err
+		}
+		for k, v := range attributes {
+			pairs[path.Join(normalize(s.options.MetadataPrefix), scope, k)] = v
+		}
+	}
+	return pairs, nil
+}
+
+func (s *GCPStore) List(directory string) ([]*store.KVPair, error) {
+	directory = normalize(directory)
+	all := make(map[string]string)
+
+	sources := []struct {
+		prefix string
+		read   func() (map[string]string, error)
+	}{
+		{s.options.SecretsPrefix, s.secrets},
+		{s.options.MetadataPrefix, s.attributes},
+	}
+	for _, source := range sources {
+		if source.prefix == "" {
+			continue
+		}
+		prefix := normalize(source.prefix)
+		if !isChildKey(directory, prefix) && !isChildKey(prefix, directory) {
+			continue
+		}
+		pairs, err := source.read()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range pairs {
+			all[k] = v
+		}
+	}
+
+	pairs := make([]*store.KVPair, 0)
+	for k, v := range all {
+		if isChildKey(directory, k) {
+			pairs = append(pairs, &store.KVPair{Key: k, Value: []byte(v)})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+func (s *GCPStore) Get(key string) (*store.KVPair, error) {
+	key = normalize(key)
+	pairs, err := s.List(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		if pair.Key == key {
+			return pair, nil
+		}
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *GCPStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *GCPStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *GCPStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *GCPStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *GCPStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *GCPStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *GCPStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *GCPStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *GCPStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+func (s *GCPStore) Close() {
+}
+
+// ParseSecretVersions parses 'name=version' pins.
+func ParseSecretVersions(pins []string) (map[string]string, error) {
+	versions := make(map[string]string)
+	for _, pin := range pins {
+		parts := strings.SplitN(pin, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Secret version should be provided as name=version: %s", pin)
+		}
+		versions[parts[0]] = parts[1]
+	}
+	return versions, nil
+}
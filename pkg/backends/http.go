@@ -0,0 +1,186 @@
+package backends
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"gopkg.in/yaml.v2"
+)
+
+// HTTP backend reads keys from a JSON/YAML document served over HTTP(S).
+const HTTP store.Backend = "http"
+
+// HTTPOptions holds the parameters used to fetch the document.
+type HTTPOptions struct {
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+	TLS     *tls.Config
+}
+
+// HTTPStore is a read-only store built by flattening a remote document. It
+// is fetched on every List, but conditional requests (ETag and
+// Last-Modified) avoid transferring and parsing it when unchanged.
+type HTTPStore struct {
+	options HTTPOptions
+	client  *http.Client
+
+	mutex        sync.Mutex
+	etag         string
+	lastModified string
+	index        uint64
+	pairs        map[string]string
+}
+
+// NewHTTP returns a store reading the document at options.URL.
+func NewHTTP(options HTTPOptions) (*HTTPStore, error) {
+	if !strings.HasPrefix(options.URL, "http://") && !strings.HasPrefix(options.URL, "https://") {
+		return nil, fmt.Errorf("Invalid document URL %q", options.URL)
+	}
+
+	return &HTTPStore{
+		options: options,
+		client: &http.Client{
+			Timeout:   options.Timeout,
+			Transport: &http.Transport{TLSClientConfig: options.TLS, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+// fetch refreshes the document if it changed since the last request.
+func (s *HTTPStore) fetch() (map[string]string, uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	req, err := http.NewRequest("GET", s.options.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	for k, v := range s.options.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.pairs != nil {
+		if s.etag != "" {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if s.lastModified != "" {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		log.Debugf("%s not modified", s.options.URL)
+		return s.pairs, s.index, nil
+	case http.StatusOK:
+	default:
+		return nil, 0, fmt.Errorf("Unexpected status %s fetching %s", resp.Status, s.options.URL)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("Unable to parse %s: %v", s.options.URL, err)
+	}
+
+	s.pairs = FlattenDocument(doc)
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.index++
+
+	return s.pairs, s.index, nil
+}
+
+func (s *HTTPStore) Get(key string) (*store.KVPair, error) {
+	pairs, index, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+	key = normalize(key)
+	value, ok := pairs[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return &store.KVPair{Key: key, Value: []byte(value), LastIndex: index}, nil
+}
+
+func (s *HTTPStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *HTTPStore) List(directory string) ([]*store.KVPair, error) {
+	pairs, index, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	directory = normalize(directory)
+	list := make([]*store.KVPair, 0)
+	for k, v := range pairs {
+		if isChildKey(directory, k) {
+			list = append(list, &store.KVPair{Key: k, Value: []byte(v), LastIndex: index})
+		}
+	}
+	if len(list) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(list))
+	return list, nil
+}
+
+func (s *HTTPStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+func (s *HTTPStore) Close() {
+}
@@ -0,0 +1,488 @@
+package backends
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// K8S backend maps the ConfigMaps and Secrets of a Kubernetes cluster under
+// /configmaps/<namespace>/<name>/<key> and /secrets/<namespace>/<name>/<key>.
+const K8S store.Backend = "k8s"
+
+const (
+	k8sRequestTimeout = 30 * time.Second
+	// k8sWatchTimeout is how long the API server keeps a watch open before
+	// it is started again from the last seen resource version.
+	k8sWatchTimeout = 5 * time.Minute
+	k8sRetryDelay   = 2 * time.Second
+)
+
+// K8sOptions holds the API server address, credentials and which objects
+// are mapped into the key space.
+type K8sOptions struct {
+	Server string
+	// TokenFile is read before every request, as service account tokens are
+	// rotated by the kubelet.
+	TokenFile string
+	// Namespaces restricts the objects to these namespaces, all of them if
+	// empty.
+	Namespaces    []string
+	LabelSelector string
+	// Resources are the kinds of objects mapped: configmaps and/or secrets.
+	Resources []string
+	TLS       *tls.Config
+}
+
+// K8sStore is a read-only store kept in sync with the cluster by informers:
+// objects are listed once and then followed through the watch API, so
+// changes are seen as they happen instead of on the next resync.
+type K8sStore struct {
+	options     K8sOptions
+	client      *http.Client
+	watchClient *http.Client
+
+	mutex    sync.RWMutex
+	objects  map[string]map[string]string
+	index    uint64
+	watchers map[chan struct{}]bool
+	stop     chan struct{}
+}
+
+type k8sMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type k8sObject struct {
+	Metadata   k8sMetadata       `json:"metadata"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string]string `json:"binaryData"`
+}
+
+type k8sList struct {
+	Metadata k8sMetadata `json:"metadata"`
+	Items    []k8sObject `json:"items"`
+}
+
+type k8sEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// k8sInformer follows the objects of a resource in a namespace, or in every
+// namespace if empty.
+type k8sInformer struct {
+	resource        string
+	namespace       string
+	resourceVersion string
+}
+
+// NewK8s lists the configured objects and starts watching them.
+func NewK8s(options K8sOptions) (*K8sStore, error) {
+	if !strings.HasPrefix(options.Server, "http://") && !strings.HasPrefix(options.Server, "https://") {
+		return nil, fmt.Errorf("Invalid Kubernetes API server address %q", options.Server)
+	}
+	if len(options.Resources) == 0 {
+		options.Resources = []string{"configmaps", "secrets"}
+	}
+	for _, resource := range options.Resources {
+		if resource != "configmaps" && resource != "secrets" {
+			return nil, fmt.Errorf("Unsupported Kubernetes resource %q", resource)
+		}
+	}
+
+	transport := &http.Transport{TLSClientConfig: options.TLS, Proxy: http.ProxyFromEnvironment}
+	s := &K8sStore{
+		options:     options,
+		client:      &http.Client{Timeout: k8sRequestTimeout, Transport: transport},
+		watchClient: &http.Client{Transport: transport},
+		objects:     make(map[string]map[string]string),
+		watchers:    make(map[chan struct{}]bool),
+		stop:        make(chan struct{}),
+	}
+
+	namespaces := options.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	informers := make([]*k8sInformer, 0, len(options.Resources)*len(namespaces))
+	for _, resource := range options.Resources {
+		for _, namespace := range namespaces {
+			informer := &k8sInformer{resource: resource, namespace: namespace}
+			if err := s.list(informer); err != nil {
+				return nil, err
+			}
+			informers = append(informers, informer)
+		}
+	}
+	for _, informer := range informers {
+		go s.run(informer)
+	}
+
+	return s, nil
+}
+
+// url returns the API URL of the informer resource.
+func (s *K8sStore) url(informer *k8sInformer, query url.Values) string {
+	p := "/api/v1"
+	if informer.namespace != "" {
+		p = path.Join(p, "namespaces", informer.namespace)
+	}
+	p = path.Join(p, informer.resource)
+	if s.options.LabelSelector != "" {
+		query.Set("labelSelector", s.options.LabelSelector)
+	}
+	return strings.TrimSuffix(s.options.Server, "/") + p + "?" + query.Encode()
+}
+
+func (s *K8sStore) get(client *http.Client, u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.options.TokenFile != "" {
+		token, err := ReadSecret(s.options.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+string(token))
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("Kubernetes API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// list replaces the objects of the informer with the current ones.
+func (s *K8sStore) list(informer *k8sInformer) error {
+	resp, err := s.get(s.client, s.url(informer, url.Values{}))
+	if err != nil {
+		return fmt.Errorf("Unable to list %s: %v", informer.resource, err)
+	}
+	defer resp.Body.Close()
+
+	var list k8sList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("Unable to decode %s: %v", informer.resource, err)
+	}
+
+	objects := make(map[string]map[string]string, len(list.Items))
+	for _, item := range list.Items {
+		objects[k8sObjectKey(informer.resource, item.Metadata)] = k8sObjectData(informer.resource, item)
+	}
+
+	s.mutex.Lock()
+	for key := range s.objects {
+		if informer.owns(key) {
+			if _, ok := objects[key]; !ok {
+				delete(s.objects, key)
+			}
+		}
+	}
+	for key, data := range objects {
+		s.objects[key] = data
+	}
+	s.index++
+	s.notify()
+	s.mutex.Unlock()
+
+	informer.resourceVersion = list.Metadata.ResourceVersion
+	return nil
+}
+
+// run follows the changes of the informer objects until the store is closed,
+// listing them again whenever the watch cannot be resumed.
+func (s *K8sStore) run(informer *k8sInformer) {
+	for {
+		err := s.watch(informer)
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if err == nil {
+			continue
+		}
+		log.Warningf("Watch of %s interrupted: %v", informer.resource, err)
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(k8sRetryDelay):
+			}
+			if err = s.list(informer); err == nil {
+				break
+			}
+			log.Error(err)
+		}
+	}
+}
+
+// watch applies the events of a single watch request. It returns nil if the
+// server ended the watch and it can be resumed from the last resource
+// version.
+func (s *K8sStore) watch(informer *k8sInformer) error {
+	query := url.Values{}
+	query.Set("watch", "1")
+	query.Set("resourceVersion", informer.resourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", fmt.Sprint(int(k8sWatchTimeout/time.Second)))
+
+	resp, err := s.get(s.watchClient, s.url(informer, query))
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stop:
+		case <-done:
+		}
+		resp.Body.Close()
+	}()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event k8sEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if event.Type == "ERROR" {
+			// Mostly 410 Gone, the resource version is too old to resume.
+			return fmt.Errorf("%s", event.Object)
+		}
+
+		var object k8sObject
+		if err := json.Unmarshal(event.Object, &object); err != nil {
+			return err
+		}
+		informer.resourceVersion = object.Metadata.ResourceVersion
+
+		key := k8sObjectKey(informer.resource, object.Metadata)
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			s.update(key, k8sObjectData(informer.resource, object))
+		case "DELETED":
+			s.update(key, nil)
+		}
+	}
+}
+
+// update sets or, if data is nil, removes an object and notifies watchers.
+func (s *K8sStore) update(key string, data map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if data == nil {
+		delete(s.objects, key)
+	} else {
+		s.objects[key] = data
+	}
+	s.index++
+	s.notify()
+}
+
+// notify must be called with the mutex held.
+func (s *K8sStore) notify() {
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// owns reports whether the object at key is followed by the informer.
+func (informer *k8sInformer) owns(key string) bool {
+	dir := path.Join("/", informer.resource)
+	if informer.namespace != "" {
+		dir = path.Join(dir, informer.namespace)
+	}
+	return strings.HasPrefix(key, dir+"/")
+}
+
+func k8sObjectKey(resource string, metadata k8sMetadata) string {
+	return path.Join("/", resource, metadata.Namespace, metadata.Name)
+}
+
+// k8sObjectData returns the decoded values of an object. Secret values and
+// ConfigMap binary data are base64 encoded by the API.
+func k8sObjectData(resource string, object k8sObject) map[string]string {
+	data := make(map[string]string, len(object.Data)+len(object.BinaryData))
+	for k, v := range object.Data {
+		if resource == "secrets" {
+			decoded, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				log.Warningf("Unable to decode key %s of secret %s/%s: %v", k, object.Metadata.Namespace, object.Metadata.Name, err)
+				continue
+			}
+			v = string(decoded)
+		}
+		data[k] = v
+	}
+	for k, v := range object.BinaryData {
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			log.Warningf("Unable to decode key %s of configmap %s/%s: %v", k, object.Metadata.Namespace, object.Metadata.Name, err)
+			continue
+		}
+		data[k] = string(decoded)
+	}
+	return data
+}
+
+func (s *K8sStore) Get(key string) (*store.KVPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key = normalize(key)
+	if data, ok := s.objects[path.Dir(key)]; ok {
+		if value, ok := data[path.Base(key)]; ok {
+			return &store.KVPair{Key: key, Value: []byte(value), LastIndex: s.index}, nil
+		}
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *K8sStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *K8sStore) List(directory string) ([]*store.KVPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	directory = normalize(directory)
+	pairs := make([]*store.KVPair, 0)
+	for objectKey, data := range s.objects {
+		for k, v := range data {
+			key := objectKey + "/" + k
+			if isChildKey(directory, key) {
+				pairs = append(pairs, &store.KVPair{Key: key, Value: []byte(v), LastIndex: s.index})
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+// WatchTree sends the pairs under directory, starting with the current ones,
+// every time an object changes.
+func (s *K8sStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	notify := make(chan struct{}, 1)
+	notify <- struct{}{}
+	s.mutex.Lock()
+	s.watchers[notify] = true
+	s.mutex.Unlock()
+
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.watchers, notify)
+			s.mutex.Unlock()
+			close(events)
+		}()
+
+		var last []byte
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-s.stop:
+				return
+			case <-notify:
+				pairs, err := s.List(directory)
+				if err != nil && err != store.ErrKeyNotFound {
+					return
+				}
+				// Most events are about objects outside directory.
+				sum := checksum(pairs)
+				if last != nil && string(sum) == string(last) {
+					continue
+				}
+				last = sum
+				select {
+				case events <- pairs:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (s *K8sStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *K8sStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *K8sStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *K8sStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *K8sStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *K8sStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *K8sStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+// Close stops the informers.
+func (s *K8sStore) Close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
@@ -0,0 +1,240 @@
+package backends
+
+import (
+	"path"
+	"sort"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// MountStore combines several stores into a single key space: each mounted
+// store appears under its own prefix, shadowing the keys the root store
+// might have there.
+type MountStore struct {
+	root   store.Store
+	mounts []mount
+}
+
+type mount struct {
+	prefix string
+	store  store.Store
+}
+
+type byPrefixLength []mount
+
+func (m byPrefixLength) Len() int           { return len(m) }
+func (m byPrefixLength) Less(i, j int) bool { return len(m[i].prefix) > len(m[j].prefix) }
+func (m byPrefixLength) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// NewMountStore returns a store serving mounts, keyed by prefix, on top of
+// root.
+func NewMountStore(root store.Store, mounts map[string]store.Store) *MountStore {
+	s := &MountStore{root: root}
+	for prefix, m := range mounts {
+		s.mounts = append(s.mounts, mount{prefix: normalize(prefix), store: m})
+	}
+	// Nested mounts are routed to the innermost one.
+	sort.Sort(byPrefixLength(s.mounts))
+	return s
+}
+
+// route returns the store serving key, the key within that store and the
+// prefix the store is mounted under.
+func (s *MountStore) route(key string) (store.Store, string, string) {
+	key = normalize(key)
+	for _, m := range s.mounts {
+		if isChildKey(m.prefix, key) {
+			return m.store, normalize(key[len(m.prefix):]), m.prefix
+		}
+	}
+	return s.root, key, ""
+}
+
+// mounted returns the key space key of a pair read from a store mounted
+// under prefix.
+func mounted(prefix string, pair *store.KVPair) *store.KVPair {
+	if prefix == "" {
+		return pair
+	}
+	return &store.KVPair{Key: path.Join(prefix, normalize(pair.Key)), Value: pair.Value, LastIndex: pair.LastIndex}
+}
+
+// shadowed reports whether key of the root store is hidden by a mount.
+func (s *MountStore) shadowed(key string) bool {
+	key = normalize(key)
+	for _, m := range s.mounts {
+		if isChildKey(m.prefix, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MountStore) Get(key string) (*store.KVPair, error) {
+	st, rel, prefix := s.route(key)
+	pair, err := st.Get(rel)
+	if err != nil {
+		return nil, err
+	}
+	return mounted(prefix, pair), nil
+}
+
+func (s *MountStore) Exists(key string) (bool, error) {
+	st, rel, _ := s.route(key)
+	return st.Exists(rel)
+}
+
+func (s *MountStore) List(directory string) ([]*store.KVPair, error) {
+	directory = normalize(directory)
+	st, rel, prefix := s.route(directory)
+
+	pairs, err := st.List(rel)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	result := make([]*store.KVPair, 0, len(pairs))
+	for _, pair := range pairs {
+		if prefix == "" && s.shadowed(pair.Key) {
+			continue
+		}
+		result = append(result, mounted(prefix, pair))
+	}
+
+	// Mounts under a root store directory are part of its listing.
+	if prefix == "" {
+		for _, m := range s.mounts {
+			if m.prefix == directory || !isChildKey(directory, m.prefix) {
+				continue
+			}
+			pairs, err := m.store.List("/")
+			if err != nil && err != store.ErrKeyNotFound {
+				return nil, err
+			}
+			for _, pair := range pairs {
+				key := path.Join(m.prefix, normalize(pair.Key))
+				if inner, _, p := s.route(key); inner == m.store && p == m.prefix {
+					result = append(result, mounted(m.prefix, pair))
+				}
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(result))
+	return result, nil
+}
+
+// WatchTree watches directory in every store holding part of it, sending
+// the whole directory again whenever any of them changes.
+func (s *MountStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	directory = normalize(directory)
+	st, rel, prefix := s.route(directory)
+
+	watches := []<-chan []*store.KVPair{}
+	w, err := st.WatchTree(rel, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	watches = append(watches, w)
+	if prefix == "" {
+		for _, m := range s.mounts {
+			if m.prefix == directory || !isChildKey(directory, m.prefix) {
+				continue
+			}
+			w, err := m.store.WatchTree("/", stopCh)
+			if err != nil {
+				return nil, err
+			}
+			watches = append(watches, w)
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	closed := make(chan struct{}, len(watches))
+	for _, w := range watches {
+		go func(w <-chan []*store.KVPair) {
+			for range w {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+			closed <- struct{}{}
+		}(w)
+	}
+
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer close(events)
+		var last []byte
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-closed:
+				// A lost watch is reported by closing the whole one.
+				return
+			case <-changes:
+			}
+
+			pairs, err := s.List(directory)
+			if err != nil && err != store.ErrKeyNotFound {
+				return
+			}
+			sum := checksum(pairs)
+			if last != nil && string(sum) == string(last) {
+				continue
+			}
+			last = sum
+			select {
+			case events <- pairs:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (s *MountStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *MountStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	st, rel, _ := s.route(key)
+	return st.Put(StoreKey(rel), value, options)
+}
+
+func (s *MountStore) Delete(key string) error {
+	st, rel, _ := s.route(key)
+	return st.Delete(StoreKey(rel))
+}
+
+func (s *MountStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	st, rel, _ := s.route(key)
+	return st.NewLock(StoreKey(rel), options)
+}
+
+func (s *MountStore) DeleteTree(directory string) error {
+	st, rel, _ := s.route(directory)
+	return st.DeleteTree(StoreKey(rel))
+}
+
+func (s *MountStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *MountStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+// Close closes the root and every mounted store.
+func (s *MountStore) Close() {
+	s.root.Close()
+	for _, m := range s.mounts {
+		m.store.Close()
+	}
+}
@@ -0,0 +1,497 @@
+package backends
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// MQTT backend maps retained MQTT messages into keys, topic a/b/c becoming
+// key /a/b/c. An empty retained message deletes the key.
+const MQTT store.Backend = "mqtt"
+
+const (
+	mqttConnect     = 0x10
+	mqttConnack     = 0x20
+	mqttPublish     = 0x30
+	mqttSubscribe   = 0x82
+	mqttSuback      = 0x90
+	mqttPingreq     = 0xc0
+	mqttPingresp    = 0xd0
+	mqttDisconnect  = 0xe0
+	mqttKeepAlive   = 30 * time.Second
+	mqttDialTimeout = 10 * time.Second
+)
+
+// mqttSettleTime is how long the broker has to be quiet after subscribing
+// before the retained messages are considered fully delivered.
+var mqttSettleTime = 500 * time.Millisecond
+
+// MQTTOptions holds the connection parameters of the MQTT backend.
+type MQTTOptions struct {
+	Topic    string
+	ClientID string
+	Username string
+	Password string
+	TLS      *tls.Config
+}
+
+type mqttPacket struct {
+	header byte
+	body   []byte
+	err    error
+}
+
+// MQTTStore is a read-only store fed by the retained messages of a broker.
+type MQTTStore struct {
+	endpoints []string
+	options   MQTTOptions
+
+	mutex    sync.RWMutex
+	pairs    map[string]string
+	index    uint64
+	watchers map[chan struct{}]bool
+	ready    chan struct{}
+	stop     chan struct{}
+}
+
+// NewMQTT connects to the first reachable endpoint and waits until the
+// retained messages under the configured topic filter are received.
+func NewMQTT(endpoints []string, options MQTTOptions) (*MQTTStore, error) {
+	if options.Topic == "" {
+		options.Topic = "#"
+	}
+	if options.ClientID == "" {
+		hostname, _ := os.Hostname()
+		options.ClientID = fmt.Sprintf("renderizr-%s-%d", hostname, os.Getpid())
+	}
+
+	s := &MQTTStore{
+		endpoints: endpoints,
+		options:   options,
+		pairs:     make(map[string]string),
+		watchers:  make(map[chan struct{}]bool),
+		ready:     make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	go s.run(conn)
+
+	select {
+	case <-s.ready:
+	case <-time.After(mqttDialTimeout):
+		s.Close()
+		return nil, fmt.Errorf("Timed out waiting for retained messages on %s", options.Topic)
+	}
+	return s, nil
+}
+
+// connect dials the broker, sets up the session and subscribes to the
+// topic filter.
+func (s *MQTTStore) connect() (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	for _, endpoint := range s.endpoints {
+		if util.IsUnixEndpoint(endpoint) {
+			// local sockets are not worth encrypting
+			conn, err = util.DialEndpoint(&net.Dialer{Timeout: mqttDialTimeout}, endpoint)
+		} else if s.options.TLS != nil {
+			conn, err = tls.DialWithDialer(&net.Dialer{Timeout: mqttDialTimeout}, "tcp", endpoint, s.options.TLS)
+		} else {
+			conn, err = net.DialTimeout("tcp", endpoint, mqttDialTimeout)
+		}
+		if err == nil {
+			break
+		}
+		log.Warningf("Unable to connect to %s: %v", endpoint, err)
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("Unable to connect to any of %v", s.endpoints)
+	}
+
+	if err := s.handshake(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *MQTTStore) handshake(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// CONNECT with a clean session so that retained messages are resent.
+	var flags byte = 0x02
+	payload := mqttString(s.options.ClientID)
+	if s.options.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(s.options.Username)...)
+	}
+	if s.options.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(s.options.Password)...)
+	}
+	body := append(mqttString("MQTT"), 4, flags)
+	body = append(body, byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second&0xff))
+	body = append(body, payload...)
+	if err := writePacket(conn, mqttConnect, body); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	header, body, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if header&0xf0 != mqttConnack || len(body) != 2 {
+		return errors.New("Unexpected response to MQTT connect")
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("MQTT connection refused with code %d", body[1])
+	}
+
+	// SUBSCRIBE with QoS 0, packet identifier 1.
+	body = append([]byte{0, 1}, mqttString(s.options.Topic)...)
+	body = append(body, 0)
+	return writePacket(conn, mqttSubscribe, body)
+}
+
+// run reads messages until the store is closed, reconnecting on failures.
+func (s *MQTTStore) run(conn net.Conn) {
+	for {
+		err := s.read(conn)
+		conn.Close()
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		log.Errorf("MQTT connection lost: %v", err)
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(2 * time.Second):
+			}
+			if conn, err = s.connect(); err == nil {
+				break
+			}
+			log.Error(err)
+		}
+	}
+}
+
+// read consumes packets from conn. Retained messages received right after
+// subscribing are collected apart and swapped in at once when the broker
+// becomes quiet, so that reconnections never expose a partial key space.
+func (s *MQTTStore) read(conn net.Conn) error {
+	packets := make(chan mqttPacket)
+	go func() {
+		defer close(packets)
+		r := bufio.NewReader(conn)
+		for {
+			header, body, err := readPacket(r)
+			packets <- mqttPacket{header, body, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	defer func() {
+		conn.Close()
+		for range packets {
+		}
+	}()
+
+	pending := make(map[string]string)
+	settle := time.NewTimer(mqttSettleTime)
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			writePacket(conn, mqttDisconnect, nil)
+			return nil
+		case <-ping.C:
+			if err := writePacket(conn, mqttPingreq, nil); err != nil {
+				return err
+			}
+		case <-settle.C:
+			s.replace(pending)
+			pending = nil
+		case p := <-packets:
+			if p.err != nil {
+				return p.err
+			}
+			switch p.header & 0xf0 {
+			case mqttPublish:
+				key, value, err := parsePublish(p.header, p.body)
+				if err != nil {
+					return err
+				}
+				if pending != nil {
+					setOrDelete(pending, key, value)
+					settle.Reset(mqttSettleTime)
+				} else {
+					s.update(key, value)
+				}
+			case mqttSuback:
+				if len(p.body) < 3 || p.body[2] == 0x80 {
+					return fmt.Errorf("Subscription to %s refused", s.options.Topic)
+				}
+			case mqttPingresp:
+			}
+		}
+	}
+}
+
+// replace swaps the whole key space and notifies watchers.
+func (s *MQTTStore) replace(pairs map[string]string) {
+	s.mutex.Lock()
+	s.pairs = pairs
+	s.index++
+	s.notify()
+	s.mutex.Unlock()
+
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+}
+
+// update applies a single message and notifies watchers.
+func (s *MQTTStore) update(key, value string) {
+	s.mutex.Lock()
+	setOrDelete(s.pairs, key, value)
+	s.index++
+	s.notify()
+	s.mutex.Unlock()
+}
+
+// notify must be called with the mutex held.
+func (s *MQTTStore) notify() {
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *MQTTStore) Get(key string) (*store.KVPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key = normalize(key)
+	value, ok := s.pairs[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return &store.KVPair{Key: key, Value: []byte(value), LastIndex: s.index}, nil
+}
+
+func (s *MQTTStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *MQTTStore) List(directory string) ([]*store.KVPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	directory = normalize(directory)
+	pairs := make([]*store.KVPair, 0)
+	for k, v := range s.pairs {
+		if isChildKey(directory, k) {
+			pairs = append(pairs, &store.KVPair{Key: k, Value: []byte(v), LastIndex: s.index})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+// WatchTree sends the pairs under directory, starting with the current ones,
+// every time a message is received.
+func (s *MQTTStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	notify := make(chan struct{}, 1)
+	notify <- struct{}{}
+	s.mutex.Lock()
+	s.watchers[notify] = true
+	s.mutex.Unlock()
+
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.watchers, notify)
+			s.mutex.Unlock()
+			close(events)
+		}()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-s.stop:
+				return
+			case <-notify:
+				pairs, err := s.List(directory)
+				if err != nil && err != store.ErrKeyNotFound {
+					return
+				}
+				select {
+				case events <- pairs:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (s *MQTTStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *MQTTStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *MQTTStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *MQTTStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *MQTTStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *MQTTStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *MQTTStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+// Close disconnects from the broker.
+func (s *MQTTStore) Close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
+
+func setOrDelete(pairs map[string]string, key, value string) {
+	if value == "" {
+		delete(pairs, key)
+		return
+	}
+	pairs[key] = value
+}
+
+// parsePublish returns the key and value carried by a PUBLISH packet.
+func parsePublish(header byte, body []byte) (string, string, error) {
+	if len(body) < 2 {
+		return "", "", errors.New("Malformed MQTT publish")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return "", "", errors.New("Malformed MQTT publish")
+	}
+	topic := string(body[2 : 2+n])
+	payload := body[2+n:]
+	if qos := (header >> 1) & 0x03; qos > 0 {
+		// skip the packet identifier, only sent with QoS > 0
+		if len(payload) < 2 {
+			return "", "", errors.New("Malformed MQTT publish")
+		}
+		payload = payload[2:]
+	}
+	return normalize(strings.Trim(topic, "/")), string(payload), nil
+}
+
+func mqttString(s string) []byte {
+	b := []byte{byte(len(s) >> 8), byte(len(s) & 0xff)}
+	return append(b, s...)
+}
+
+func writePacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("Malformed MQTT remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,149 @@
+package backends
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+func publishPacket(topic, payload string) []byte {
+	var buf bytes.Buffer
+	writePacket(&buf, mqttPublish|0x01, append(mqttString(topic), payload...))
+	return buf.Bytes()
+}
+
+// serveMQTT accepts a single client on l, answers its handshake and sends
+// it retained, followed by every packet written to live.
+func serveMQTT(t *testing.T, l net.Listener, retained [][]byte, live <-chan []byte) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	if header, _, err := readPacket(r); err != nil || header != mqttConnect {
+		t.Errorf("Expected a connect, got %x: %v", header, err)
+		return
+	}
+	writePacket(conn, mqttConnack, []byte{0, 0})
+	header, body, err := readPacket(r)
+	if err != nil || header != mqttSubscribe {
+		t.Errorf("Expected a subscribe, got %x: %v", header, err)
+		return
+	}
+	if topic := string(body[4 : len(body)-1]); topic != "app/#" {
+		t.Errorf("Expected a subscription to app/#, got %s", topic)
+	}
+	writePacket(conn, mqttSuback, []byte{0, 1, 0})
+	for _, p := range retained {
+		conn.Write(p)
+	}
+	for p := range live {
+		conn.Write(p)
+	}
+}
+
+func TestMQTT(t *testing.T) {
+	defer func(d time.Duration) { mqttSettleTime = d }(mqttSettleTime)
+	mqttSettleTime = 50 * time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	live := make(chan []byte)
+	defer close(live)
+	go serveMQTT(t, l, [][]byte{
+		publishPacket("app/db/host", "db1"),
+		publishPacket("app/db/port", "5432"),
+		publishPacket("app/cache/host", "cache1"),
+		// empty retained messages delete their topic
+		publishPacket("app/cache/host", ""),
+	}, live)
+
+	s, err := NewMQTT([]string{l.Addr().String()}, MQTTOptions{Topic: "app/#"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	pairs, err := s.List("/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key+"="+string(pair.Value))
+	}
+	if strings.Join(keys, ",") != "/app/db/host=db1,/app/db/port=5432" {
+		t.Errorf("Unexpected retained pairs %v", keys)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	events, err := s.WatchTree("/app/db", stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-events
+	live <- publishPacket("app/db/host", "db2")
+	select {
+	case pairs := <-events:
+		if len(pairs) != 2 || string(pairs[0].Value) != "db2" {
+			t.Errorf("Expected the live message to be watched, got %v", pairs)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the live message to be watched")
+	}
+
+	if err := s.Put("/app/db/host", []byte("db3"), nil); err != store.ErrCallNotSupported {
+		t.Errorf("Expected the store to be read-only, got %v", err)
+	}
+}
+
+func TestMQTTPackets(t *testing.T) {
+	// remaining lengths over 127 bytes take several bytes
+	payload := strings.Repeat("x", 20000)
+	var buf bytes.Buffer
+	if err := writePacket(&buf, mqttPublish|0x02, append(mqttString("a/b/"), append([]byte{0, 7}, payload...)...)); err != nil {
+		t.Fatal(err)
+	}
+	header, body, err := readPacket(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, value, err := parsePublish(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the packet identifier of QoS 1 messages is not part of the value
+	if key != "/a/b" || value != payload {
+		t.Errorf("Unexpected message %s of %d bytes", key, len(value))
+	}
+
+	tests := []struct {
+		desc   string
+		header byte
+		body   []byte
+	}{
+		{"no topic", mqttPublish, []byte{0}},
+		{"truncated topic", mqttPublish, []byte{0, 5, 'a'}},
+		{"no packet identifier", mqttPublish | 0x02, mqttString("a")},
+	}
+	for _, tt := range tests {
+		if _, _, err := parsePublish(tt.header, tt.body); err == nil {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+	}
+
+	if _, _, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{mqttPublish, 0xff, 0xff, 0xff, 0xff, 0x01}))); err == nil {
+		t.Errorf("Expected remaining lengths over 4 bytes to be refused")
+	}
+}
@@ -0,0 +1,88 @@
+package backends
+
+import (
+	"sort"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// overlayStore reads the wrapped store as if some of its keys were set or
+// deleted, without writing anything to it.
+type overlayStore struct {
+	store.Store
+	set     map[string]string
+	deleted []string
+}
+
+// NewOverlayStore wraps s so that reads see the keys of set with their
+// values, and neither the deleted keys nor the keys under them.
+func NewOverlayStore(s store.Store, set map[string]string, deleted []string) store.Store {
+	o := &overlayStore{
+		Store: s,
+		set:   make(map[string]string, len(set)),
+	}
+	for k, v := range set {
+		o.set[normalize(k)] = v
+	}
+	for _, k := range deleted {
+		o.deleted = append(o.deleted, normalize(k))
+	}
+	return o
+}
+
+// isDeleted reports whether key is, or lives under, a deleted key.
+func (s *overlayStore) isDeleted(key string) bool {
+	for _, d := range s.deleted {
+		if isChildKey(d, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *overlayStore) List(directory string) ([]*store.KVPair, error) {
+	pairs, err := s.Store.List(directory)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+
+	dir := normalize(directory)
+	overlaid := make([]*store.KVPair, 0, len(pairs))
+	for _, pair := range pairs {
+		key := normalize(pair.Key)
+		if _, ok := s.set[key]; ok || s.isDeleted(key) {
+			continue
+		}
+		overlaid = append(overlaid, pair)
+	}
+	for k, v := range s.set {
+		if isChildKey(dir, k) {
+			overlaid = append(overlaid, &store.KVPair{Key: k, Value: []byte(v)})
+		}
+	}
+	if len(overlaid) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(overlaid))
+	return overlaid, nil
+}
+
+func (s *overlayStore) Get(key string) (*store.KVPair, error) {
+	if v, ok := s.set[normalize(key)]; ok {
+		return &store.KVPair{Key: normalize(key), Value: []byte(v)}, nil
+	}
+	if s.isDeleted(normalize(key)) {
+		return nil, store.ErrKeyNotFound
+	}
+	return s.Store.Get(key)
+}
+
+func (s *overlayStore) Exists(key string) (bool, error) {
+	if _, ok := s.set[normalize(key)]; ok {
+		return true, nil
+	}
+	if s.isDeleted(normalize(key)) {
+		return false, nil
+	}
+	return s.Store.Exists(key)
+}
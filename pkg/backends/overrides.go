@@ -0,0 +1,193 @@
+package backends
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"gopkg.in/yaml.v2"
+)
+
+// overridesPollInterval is how often the overrides file is checked for
+// changes while watching.
+var overridesPollInterval = 2 * time.Second
+
+// overrideStore merges the keys of a local YAML/JSON document over the
+// wrapped store, letting operators hotfix a single host.
+type overrideStore struct {
+	store.Store
+	file string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	pairs   map[string]string
+	// version counts the changes of pairs, so that every watcher can tell
+	// whether they changed since it last looked.
+	version uint64
+}
+
+// NewOverrideStore wraps s so that the keys defined in file take precedence
+// over the ones in s. The file is read again whenever it changes, and a
+// missing file defines no overrides.
+func NewOverrideStore(s store.Store, file string) store.Store {
+	return &overrideStore{
+		Store: s,
+		file:  file,
+	}
+}
+
+// overrides returns the keys of the overrides file and their version, which
+// changes along with them.
+func (s *overrideStore) overrides() (map[string]string, uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fi, err := os.Stat(s.file)
+	if os.IsNotExist(err) {
+		if s.pairs == nil || len(s.pairs) > 0 {
+			s.version++
+		}
+		s.pairs, s.modTime, s.size = map[string]string{}, time.Time{}, 0
+		return s.pairs, s.version, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.pairs != nil && fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return s.pairs, s.version, nil
+	}
+
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return nil, 0, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("Unable to parse %s: %v", s.file, err)
+	}
+
+	s.pairs, s.modTime, s.size = FlattenDocument(doc), fi.ModTime(), fi.Size()
+	s.version++
+	log.Infof("Loaded %d overrides from %s", len(s.pairs), s.file)
+	return s.pairs, s.version, nil
+}
+
+// merge returns pairs with the overrides under directory applied.
+func merge(directory string, pairs []*store.KVPair, overrides map[string]string) []*store.KVPair {
+	merged := make([]*store.KVPair, 0, len(pairs))
+	for _, pair := range pairs {
+		if _, ok := overrides[pair.Key]; !ok {
+			merged = append(merged, pair)
+		}
+	}
+	for k, v := range overrides {
+		if isChildKey(directory, k) {
+			merged = append(merged, &store.KVPair{Key: k, Value: []byte(v)})
+		}
+	}
+	sort.Sort(byKey(merged))
+	return merged
+}
+
+func (s *overrideStore) List(directory string) ([]*store.KVPair, error) {
+	overrides, _, err := s.overrides()
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err := s.Store.List(directory)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+
+	merged := merge(normalize(directory), pairs, overrides)
+	if len(merged) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return merged, nil
+}
+
+func (s *overrideStore) Get(key string) (*store.KVPair, error) {
+	overrides, _, err := s.overrides()
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := overrides[normalize(key)]; ok {
+		return &store.KVPair{Key: normalize(key), Value: []byte(v)}, nil
+	}
+	return s.Store.Get(key)
+}
+
+func (s *overrideStore) Exists(key string) (bool, error) {
+	overrides, _, err := s.overrides()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := overrides[normalize(key)]; ok {
+		return true, nil
+	}
+	return s.Store.Exists(key)
+}
+
+// WatchTree sends the merged pairs whenever the wrapped store reports a
+// change or the overrides file changes.
+func (s *overrideStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	backendEvents, err := s.Store.WatchTree(directory, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(overridesPollInterval)
+		defer ticker.Stop()
+
+		// every watcher tracks the overrides it last sent on its own
+		var last []*store.KVPair
+		var sent uint64
+		received := false
+		for {
+			select {
+			case pairs, ok := <-backendEvents:
+				if !ok {
+					return
+				}
+				last, received = pairs, true
+			case <-ticker.C:
+				if !received {
+					continue
+				}
+				if _, version, err := s.overrides(); err != nil {
+					log.Errorf("Unable to read overrides: %v", err)
+					continue
+				} else if version == sent {
+					continue
+				}
+			case <-stopCh:
+				return
+			}
+
+			overrides, version, err := s.overrides()
+			if err != nil {
+				log.Errorf("Unable to read overrides: %v", err)
+				continue
+			}
+			sent = version
+			select {
+			case events <- merge(normalize(directory), last, overrides):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
@@ -0,0 +1,86 @@
+package backends
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// treeStore is a wrapped store whose tree watches send their pairs once.
+type treeStore struct {
+	store.Store
+	pairs []*store.KVPair
+}
+
+func (s *treeStore) List(directory string) ([]*store.KVPair, error) {
+	return s.pairs, nil
+}
+
+func (s *treeStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	events := make(chan []*store.KVPair, 1)
+	events <- s.pairs
+	return events, nil
+}
+
+func receiveOverride(t *testing.T, events <-chan []*store.KVPair, key, value string) {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case pairs := <-events:
+			for _, pair := range pairs {
+				if pair.Key == key && string(pair.Value) == value {
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatalf("Expected %s to be overridden with %s", key, value)
+		}
+	}
+}
+
+func TestOverrideStoreWatchers(t *testing.T) {
+	defer func(interval time.Duration) { overridesPollInterval = interval }(overridesPollInterval)
+	overridesPollInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "renderizr-overrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "overrides.yaml")
+
+	backend := &treeStore{pairs: []*store.KVPair{{Key: "/app/host", Value: []byte("db1")}}}
+	s := NewOverrideStore(backend, file)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	first, err := s.WatchTree("/app", stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.WatchTree("/app", stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveOverride(t, first, "/app/host", "db1")
+	receiveOverride(t, second, "/app/host", "db1")
+
+	// every watcher is told about a change, not only the first to see it
+	if err := ioutil.WriteFile(file, []byte("app:\n  host: db2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	receiveOverride(t, first, "/app/host", "db2")
+	receiveOverride(t, second, "/app/host", "db2")
+
+	pair, err := s.Get("/app/host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pair.Value) != "db2" {
+		t.Errorf("Expected /app/host to be overridden with db2, got %s", pair.Value)
+	}
+}
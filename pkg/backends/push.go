@@ -0,0 +1,321 @@
+package backends
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// PUSH backend receives key/value snapshots pushed over HTTP.
+const PUSH store.Backend = "push"
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body.
+const SignatureHeader = "X-Renderizr-Signature"
+
+// maxSnapshotSize caps the size of a pushed snapshot.
+const maxSnapshotSize = 32 << 20
+
+// Snapshot is the document accepted by the push receiver. Index must grow
+// with every push, older or replayed snapshots are rejected.
+type Snapshot struct {
+	Index uint64            `json:"index"`
+	Pairs map[string]string `json:"pairs"`
+}
+
+// PushOptions describes where snapshots are received.
+type PushOptions struct {
+	Listen string
+	Secret []byte
+	// TLS, if set, serves the receiver over HTTPS.
+	TLS *tls.Config
+	// StateFile, if set, keeps the index of the last snapshot accepted so
+	// that snapshots pushed before a restart cannot be replayed.
+	StateFile string
+}
+
+// PushReceiver is a read-only store whose contents are replaced by signed
+// snapshots POSTed to /snapshot, for nodes that cannot reach the KV store
+// and get their configuration pushed by a controller instead.
+type PushReceiver struct {
+	secret    []byte
+	stateFile string
+	listener  net.Listener
+
+	mutex    sync.RWMutex
+	snapshot *Snapshot
+	// index is the index of the last snapshot accepted, if accepted is set,
+	// by this or a previous instance
+	index    uint64
+	accepted bool
+	watchers map[chan struct{}]bool
+}
+
+// NewPushReceiver starts listening on options.Listen, snapshots must be
+// signed with options.Secret.
+func NewPushReceiver(options PushOptions) (*PushReceiver, error) {
+	if len(options.Secret) == 0 {
+		return nil, fmt.Errorf("A secret is required to verify pushed snapshots")
+	}
+
+	s := &PushReceiver{
+		secret:    options.Secret,
+		stateFile: options.StateFile,
+		watchers:  make(map[chan struct{}]bool),
+	}
+	if s.stateFile != "" {
+		data, err := ioutil.ReadFile(s.stateFile)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			s.index, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid snapshot index in %s: %v", s.stateFile, err)
+			}
+			s.accepted = true
+		}
+	}
+
+	l, err := net.Listen("tcp", options.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if options.TLS != nil {
+		l = tls.NewListener(l, options.TLS)
+	}
+	s.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/snapshot", s.handleSnapshot)
+	go http.Serve(l, mux)
+
+	log.Infof("Waiting for snapshots on %s", l.Addr())
+	return s, nil
+}
+
+// Sign returns the signature expected for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *PushReceiver) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	signature := []byte(r.Header.Get(SignatureHeader))
+	if !hmac.Equal(signature, []byte(Sign(s.secret, body))) {
+		log.Warningf("Rejected snapshot from %s: invalid signature", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	if s.accepted && snapshot.Index <= s.index {
+		current := s.index
+		s.mutex.Unlock()
+		http.Error(w, fmt.Sprintf("snapshot index %d is not newer than %d", snapshot.Index, current), http.StatusConflict)
+		return
+	}
+	if err := s.saveIndex(snapshot.Index); err != nil {
+		s.mutex.Unlock()
+		log.Errorf("Unable to save the index of snapshot %d: %v", snapshot.Index, err)
+		http.Error(w, "unable to save the snapshot index", http.StatusInternalServerError)
+		return
+	}
+	s.snapshot, s.index, s.accepted = &snapshot, snapshot.Index, true
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	s.mutex.Unlock()
+
+	log.Infof("Received snapshot %d with %d keys from %s", snapshot.Index, len(snapshot.Pairs), r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveIndex writes index to the state file, if any, replacing it at once.
+func (s *PushReceiver) saveIndex(index uint64) error {
+	if s.stateFile == "" {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.stateFile), "."+filepath.Base(s.stateFile))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(strconv.FormatUint(index, 10) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.stateFile)
+}
+
+func (s *PushReceiver) Get(key string) (*store.KVPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.snapshot == nil {
+		return nil, store.ErrKeyNotFound
+	}
+	key = normalize(key)
+	value, ok := s.snapshot.Pairs[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return &store.KVPair{Key: key, Value: []byte(value), LastIndex: s.snapshot.Index}, nil
+}
+
+func (s *PushReceiver) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *PushReceiver) List(directory string) ([]*store.KVPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.snapshot == nil {
+		return nil, store.ErrKeyNotFound
+	}
+
+	directory = normalize(directory)
+	pairs := make([]*store.KVPair, 0)
+	for k, v := range s.snapshot.Pairs {
+		k = normalize(k)
+		if isChildKey(directory, k) {
+			pairs = append(pairs, &store.KVPair{Key: k, Value: []byte(v), LastIndex: s.snapshot.Index})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+// WatchTree sends the pairs under directory every time a snapshot arrives,
+// starting with the current one if any.
+func (s *PushReceiver) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	notify := make(chan struct{}, 1)
+	s.mutex.Lock()
+	s.watchers[notify] = true
+	if s.snapshot != nil {
+		notify <- struct{}{}
+	}
+	s.mutex.Unlock()
+
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.watchers, notify)
+			s.mutex.Unlock()
+			close(events)
+		}()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-notify:
+				pairs, err := s.List(directory)
+				if err != nil && err != store.ErrKeyNotFound {
+					return
+				}
+				select {
+				case events <- pairs:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (s *PushReceiver) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *PushReceiver) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *PushReceiver) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *PushReceiver) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *PushReceiver) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *PushReceiver) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *PushReceiver) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+// Close stops accepting snapshots.
+func (s *PushReceiver) Close() {
+	s.listener.Close()
+}
+
+// ReadSecret returns the trimmed contents of a secret file.
+func ReadSecret(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSpace(string(data))), nil
+}
@@ -0,0 +1,123 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var pushSecret = []byte("secret")
+
+func push(t *testing.T, client *http.Client, url string, snapshot Snapshot) int {
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", url+"/snapshot", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(SignatureHeader, Sign(pushSecret, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestPushReceiverReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	options := PushOptions{Listen: "127.0.0.1:0", Secret: pushSecret, StateFile: filepath.Join(dir, "push.state")}
+
+	s, err := NewPushReceiver(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + s.listener.Addr().String()
+	if status := push(t, http.DefaultClient, url, Snapshot{Index: 2, Pairs: map[string]string{"/a": "1"}}); status != http.StatusNoContent {
+		t.Errorf("Expected snapshot 2 to be accepted, got %d", status)
+	}
+	if status := push(t, http.DefaultClient, url, Snapshot{Index: 2, Pairs: map[string]string{"/a": "2"}}); status != http.StatusConflict {
+		t.Errorf("Expected snapshot 2 to be refused once replayed, got %d", status)
+	}
+	s.Close()
+
+	// snapshots accepted before a restart are refused after it
+	s, err = NewPushReceiver(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	url = "http://" + s.listener.Addr().String()
+	if status := push(t, http.DefaultClient, url, Snapshot{Index: 1, Pairs: map[string]string{"/a": "0"}}); status != http.StatusConflict {
+		t.Errorf("Expected snapshot 1 to be refused after a restart, got %d", status)
+	}
+	if status := push(t, http.DefaultClient, url, Snapshot{Index: 3, Pairs: map[string]string{"/a": "3"}}); status != http.StatusNoContent {
+		t.Errorf("Expected snapshot 3 to be accepted, got %d", status)
+	}
+	pair, err := s.Get("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pair.Value) != "3" {
+		t.Errorf("Expected /a to be 3, got %s", pair.Value)
+	}
+}
+
+func TestPushReceiverTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "renderizr"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewPushReceiver(PushOptions{
+		Listen: "127.0.0.1:0",
+		Secret: pushSecret,
+		TLS:    &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if status := push(t, client, "https://"+s.listener.Addr().String(), Snapshot{Index: 1}); status != http.StatusNoContent {
+		t.Errorf("Expected the snapshot to be accepted over HTTPS, got %d", status)
+	}
+}
@@ -0,0 +1,77 @@
+package backends
+
+import (
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// rateLimitedStore throttles every request issued to the wrapped store.
+type rateLimitedStore struct {
+	store.Store
+	limiter *util.RateLimiter
+}
+
+// NewRateLimitedStore wraps s so that no more than rate requests per second,
+// with bursts of up to burst requests, reach the backend. Watches only
+// consume a token when they are established.
+func NewRateLimitedStore(s store.Store, rate float64, burst int) store.Store {
+	return &rateLimitedStore{
+		Store:   s,
+		limiter: util.NewRateLimiter(rate, burst),
+	}
+}
+
+func (s *rateLimitedStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.limiter.Wait()
+	return s.Store.Put(key, value, options)
+}
+
+func (s *rateLimitedStore) Get(key string) (*store.KVPair, error) {
+	s.limiter.Wait()
+	return s.Store.Get(key)
+}
+
+func (s *rateLimitedStore) Delete(key string) error {
+	s.limiter.Wait()
+	return s.Store.Delete(key)
+}
+
+func (s *rateLimitedStore) Exists(key string) (bool, error) {
+	s.limiter.Wait()
+	return s.Store.Exists(key)
+}
+
+func (s *rateLimitedStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	s.limiter.Wait()
+	return s.Store.Watch(key, stopCh)
+}
+
+func (s *rateLimitedStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	s.limiter.Wait()
+	return s.Store.WatchTree(directory, stopCh)
+}
+
+func (s *rateLimitedStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	s.limiter.Wait()
+	return s.Store.NewLock(key, options)
+}
+
+func (s *rateLimitedStore) List(directory string) ([]*store.KVPair, error) {
+	s.limiter.Wait()
+	return s.Store.List(directory)
+}
+
+func (s *rateLimitedStore) DeleteTree(directory string) error {
+	s.limiter.Wait()
+	return s.Store.DeleteTree(directory)
+}
+
+func (s *rateLimitedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	s.limiter.Wait()
+	return s.Store.AtomicPut(key, value, previous, options)
+}
+
+func (s *rateLimitedStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	s.limiter.Wait()
+	return s.Store.AtomicDelete(key, previous)
+}
@@ -0,0 +1,36 @@
+package backends
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedStore(t *testing.T) {
+	backend := &countingStore{release: make(chan struct{})}
+	close(backend.release)
+	s := NewRateLimitedStore(backend, 20, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := s.List("/app"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst goes through, then requests are spaced by 50ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected 4 requests at 20/s with bursts of 2 to take 100ms, took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&backend.lists); n != 4 {
+		t.Errorf("Expected every request to reach the backend, got %d", n)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if _, err := s.WatchTree("/app", stop); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&backend.watches); n != 1 {
+		t.Errorf("Expected the watch to reach the backend, got %d", n)
+	}
+}
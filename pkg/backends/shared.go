@@ -0,0 +1,171 @@
+package backends
+
+import (
+	"sync"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// sharedStore lets the templates reading the same keys share the requests
+// to the wrapped store: concurrent Get and List calls for the same key are
+// served by a single request, and every WatchTree of a directory by a single
+// watch of it, whatever the number of templates.
+type sharedStore struct {
+	store.Store
+
+	mutex   sync.Mutex
+	gets    map[string]*sharedCall
+	lists   map[string]*sharedCall
+	watches map[string]*sharedWatch
+}
+
+// sharedCall is a request in flight, whose result is handed to every caller
+// waiting for it.
+type sharedCall struct {
+	done  chan struct{}
+	pair  *store.KVPair
+	pairs []*store.KVPair
+	err   error
+}
+
+// sharedWatch is a watch of a directory fanned out to its subscribers. Each
+// event holding the whole directory, slow subscribers only get the latest.
+type sharedWatch struct {
+	stop chan struct{}
+	// ended is closed once the watch ended
+	ended       chan struct{}
+	subscribers map[chan []*store.KVPair]struct{}
+	last        []*store.KVPair
+}
+
+// NewSharedStore wraps s so that the requests of the templates reading the
+// same keys are shared.
+func NewSharedStore(s store.Store) store.Store {
+	return &sharedStore{
+		Store:   s,
+		gets:    make(map[string]*sharedCall),
+		lists:   make(map[string]*sharedCall),
+		watches: make(map[string]*sharedWatch),
+	}
+}
+
+// share runs fn once for every caller of the same key at the same time.
+func (s *sharedStore) share(calls map[string]*sharedCall, key string, fn func(c *sharedCall)) *sharedCall {
+	s.mutex.Lock()
+	if c, ok := calls[key]; ok {
+		s.mutex.Unlock()
+		<-c.done
+		return c
+	}
+	c := &sharedCall{done: make(chan struct{})}
+	calls[key] = c
+	s.mutex.Unlock()
+
+	fn(c)
+
+	s.mutex.Lock()
+	delete(calls, key)
+	s.mutex.Unlock()
+	close(c.done)
+	return c
+}
+
+func (s *sharedStore) Get(key string) (*store.KVPair, error) {
+	c := s.share(s.gets, key, func(c *sharedCall) {
+		c.pair, c.err = s.Store.Get(key)
+	})
+	return c.pair, c.err
+}
+
+func (s *sharedStore) List(directory string) ([]*store.KVPair, error) {
+	c := s.share(s.lists, directory, func(c *sharedCall) {
+		c.pairs, c.err = s.Store.List(directory)
+	})
+	if c.err != nil {
+		return nil, c.err
+	}
+	// callers get their own slice of the shared pairs
+	return append([]*store.KVPair(nil), c.pairs...), nil
+}
+
+// WatchTree subscribes to the watch of directory, establishing it if there
+// is none yet. The channel is closed once stopCh is closed or the watch
+// ended.
+func (s *sharedStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	w, ok := s.watches[directory]
+	if !ok {
+		stop := make(chan struct{})
+		events, err := s.Store.WatchTree(directory, stop)
+		if err != nil {
+			return nil, err
+		}
+		w = &sharedWatch{stop: stop, ended: make(chan struct{}), subscribers: make(map[chan []*store.KVPair]struct{})}
+		s.watches[directory] = w
+		go s.fanOut(directory, w, events)
+	}
+
+	ch := make(chan []*store.KVPair, 1)
+	if w.last != nil {
+		ch <- w.last
+	}
+	w.subscribers[ch] = struct{}{}
+	go func() {
+		select {
+		case <-stopCh:
+			s.unsubscribe(directory, w, ch)
+		case <-w.ended:
+		}
+	}()
+	return ch, nil
+}
+
+// fanOut sends the events of the watch of directory to its subscribers
+// until it ends.
+func (s *sharedStore) fanOut(directory string, w *sharedWatch, events <-chan []*store.KVPair) {
+	for pairs := range events {
+		s.mutex.Lock()
+		w.last = pairs
+		for ch := range w.subscribers {
+			// replace the event not received yet, if any
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- pairs
+		}
+		s.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for ch := range w.subscribers {
+		close(ch)
+	}
+	w.subscribers = nil
+	close(w.ended)
+	if s.watches[directory] == w {
+		delete(s.watches, directory)
+	}
+}
+
+// unsubscribe removes the subscriber ch of the watch of directory, stopping
+// the watch once it has none left.
+func (s *sharedStore) unsubscribe(directory string, w *sharedWatch, ch chan []*store.KVPair) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := w.subscribers[ch]; !ok {
+		return
+	}
+	delete(w.subscribers, ch)
+	close(ch)
+	if len(w.subscribers) == 0 {
+		close(w.stop)
+		if s.watches[directory] == w {
+			delete(s.watches, directory)
+		}
+	}
+}
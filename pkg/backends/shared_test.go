@@ -0,0 +1,125 @@
+package backends
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// countingStore counts the requests reaching it. Its lists wait for release
+// and its tree watches send the events written to watch until it is closed.
+type countingStore struct {
+	store.Store
+
+	lists   int32
+	watches int32
+	release chan struct{}
+
+	mutex sync.Mutex
+	watch chan []*store.KVPair
+}
+
+func (s *countingStore) List(directory string) ([]*store.KVPair, error) {
+	atomic.AddInt32(&s.lists, 1)
+	<-s.release
+	return []*store.KVPair{{Key: directory + "/a", Value: []byte("1")}}, nil
+}
+
+func (s *countingStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	atomic.AddInt32(&s.watches, 1)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.watch = make(chan []*store.KVPair, 1)
+	return s.watch, nil
+}
+
+func TestSharedStoreList(t *testing.T) {
+	backend := &countingStore{release: make(chan struct{})}
+	s := NewSharedStore(backend)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pairs, err := s.List("/app")
+			if err != nil || len(pairs) != 1 {
+				t.Errorf("Expected the pairs of /app, got %v: %v", pairs, err)
+			}
+		}()
+	}
+	// let the callers wait for the request in flight
+	time.Sleep(100 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&backend.lists); n != 1 {
+		t.Errorf("Expected a single list request, got %d", n)
+	}
+}
+
+func TestSharedStoreWatchTree(t *testing.T) {
+	backend := &countingStore{}
+	s := NewSharedStore(backend)
+
+	stopFirst, stopSecond := make(chan struct{}), make(chan struct{})
+	first, err := s.WatchTree("/app", stopFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.WatchTree("/app", stopSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&backend.watches); n != 1 {
+		t.Errorf("Expected a single watch, got %d", n)
+	}
+
+	backend.watch <- []*store.KVPair{{Key: "/app/a", Value: []byte("1")}}
+	for _, events := range []<-chan []*store.KVPair{first, second} {
+		select {
+		case pairs := <-events:
+			if len(pairs) != 1 {
+				t.Errorf("Expected the pairs of /app, got %v", pairs)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected every subscriber to receive the event")
+		}
+	}
+
+	// unsubscribing closes the channel of the subscriber alone
+	close(stopFirst)
+	select {
+	case _, ok := <-first:
+		if ok {
+			t.Errorf("Expected the stopped subscriber to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the stopped subscriber to be closed")
+	}
+
+	// once the watch ends its subscribers are closed, and nothing is left
+	// waiting for them to stop
+	goroutines := runtime.NumGoroutine()
+	close(backend.watch)
+	select {
+	case _, ok := <-second:
+		if ok {
+			t.Errorf("Expected the subscribers of an ended watch to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the subscribers of an ended watch to be closed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines-2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines-2 {
+		t.Errorf("Expected the goroutines waiting for subscribers to stop to end, %d left of %d", n, goroutines)
+	}
+	close(stopSecond)
+}
@@ -0,0 +1,257 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// SQL backend maps the rows of a key/value table into keys.
+const SQL store.Backend = "sql"
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLOptions describes the table holding the keys.
+type SQLOptions struct {
+	Driver        string
+	DSN           string
+	Table         string
+	KeyColumn     string
+	ValueColumn   string
+	PollInterval  time.Duration
+	NotifyChannel string
+}
+
+// SQLStore is a read-only store backed by a database table. Changes are
+// detected by polling, and on PostgreSQL also through LISTEN/NOTIFY if a
+// notification channel is configured.
+type SQLStore struct {
+	db      *sql.DB
+	options SQLOptions
+	query   string
+}
+
+// NewSQL opens the database described by options.
+func NewSQL(options SQLOptions) (*SQLStore, error) {
+	if options.Driver != "postgres" && options.Driver != "mysql" {
+		return nil, fmt.Errorf("Unsupported SQL driver %q, use postgres or mysql", options.Driver)
+	}
+	for _, identifier := range []string{options.Table, options.KeyColumn, options.ValueColumn} {
+		if !identifierRegexp.MatchString(identifier) {
+			return nil, fmt.Errorf("Invalid SQL identifier %q", identifier)
+		}
+	}
+	if options.NotifyChannel != "" && options.Driver != "postgres" {
+		return nil, fmt.Errorf("Notifications are only supported by postgres")
+	}
+	if options.PollInterval <= 0 {
+		return nil, fmt.Errorf("Poll interval must be positive")
+	}
+
+	db, err := sql.Open(options.Driver, options.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	placeholder := "?"
+	if options.Driver == "postgres" {
+		placeholder = "$1"
+	}
+
+	key := quoteIdentifier(options.Driver, options.KeyColumn)
+	return &SQLStore{
+		db:      db,
+		options: options,
+		query: fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s LIKE %s ORDER BY %s",
+			key, quoteIdentifier(options.Driver, options.ValueColumn),
+			quoteIdentifier(options.Driver, options.Table),
+			key, placeholder, key),
+	}, nil
+}
+
+// quoteIdentifier quotes each part of a validated, possibly schema
+// qualified, identifier for driver, so that reserved words like key can be
+// used as column names.
+func quoteIdentifier(driver, identifier string) string {
+	quote := `"`
+	if driver == "mysql" {
+		quote = "`"
+	}
+	parts := strings.Split(identifier, ".")
+	for i, part := range parts {
+		parts[i] = quote + part + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+func (s *SQLStore) Get(key string) (*store.KVPair, error) {
+	key = normalize(key)
+	pairs, err := s.List(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		if pair.Key == key {
+			return pair, nil
+		}
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *SQLStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SQLStore) List(directory string) ([]*store.KVPair, error) {
+	directory = normalize(directory)
+	pattern := "%"
+	if directory != "/" {
+		pattern = escapeLike(directory) + "%"
+	}
+
+	rows, err := s.db.Query(s.query, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairs := make([]*store.KVPair, 0)
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		key = normalize(key)
+		if isChildKey(directory, key) {
+			pairs = append(pairs, &store.KVPair{Key: key, Value: []byte(value.String)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+// WatchTree sends the pairs under directory whenever they change. The table
+// is checked every poll interval and, if configured, when a notification is
+// received.
+func (s *SQLStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	var notifications <-chan *pq.Notification
+	var listener *pq.Listener
+	if s.options.NotifyChannel != "" {
+		listener = pq.NewListener(s.options.DSN, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Errorf("Postgres listener: %v", err)
+			}
+		})
+		if err := listener.Listen(s.options.NotifyChannel); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		notifications = listener.NotificationChannel()
+	}
+
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer close(events)
+		if listener != nil {
+			defer listener.Close()
+		}
+
+		ticker := time.NewTicker(s.options.PollInterval)
+		defer ticker.Stop()
+
+		var last []byte
+		for {
+			pairs, err := s.List(directory)
+			if err != nil && err != store.ErrKeyNotFound {
+				log.Errorf("Unable to poll %s: %v", s.options.Table, err)
+			} else if sum := checksum(pairs); !bytes.Equal(sum, last) {
+				last = sum
+				select {
+				case events <- pairs:
+				case <-stopCh:
+					return
+				}
+			}
+
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			case <-notifications:
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (s *SQLStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *SQLStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *SQLStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *SQLStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *SQLStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *SQLStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *SQLStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+func (s *SQLStore) Close() {
+	s.db.Close()
+}
+
+// escapeLike escapes the LIKE wildcards present in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// checksum returns a digest of pairs used to detect changes between polls.
+func checksum(pairs []*store.KVPair) []byte {
+	h := sha1.New()
+	for _, pair := range pairs {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(pair.Key), pair.Key, len(pair.Value), pair.Value)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,44 @@
+package backends
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		driver     string
+		identifier string
+		expected   string
+	}{
+		{"postgres", "key", `"key"`},
+		{"postgres", "config.renderizr", `"config"."renderizr"`},
+		{"mysql", "key", "`key`"},
+		{"mysql", "config.renderizr", "`config`.`renderizr`"},
+	}
+	for _, tt := range tests {
+		if actual := quoteIdentifier(tt.driver, tt.identifier); actual != tt.expected {
+			t.Errorf("Expected %s to be quoted as %s for %s, got %s", tt.identifier, tt.expected, tt.driver, actual)
+		}
+	}
+}
+
+func TestNewSQLOptions(t *testing.T) {
+	opts := SQLOptions{
+		Driver:       "mysql",
+		Table:        "renderizr",
+		KeyColumn:    "key",
+		ValueColumn:  "value",
+		PollInterval: 0,
+	}
+	if _, err := NewSQL(opts); err == nil {
+		t.Errorf("Expected a poll interval of 0 to be refused")
+	}
+
+	invalid := opts
+	invalid.PollInterval = time.Second
+	invalid.KeyColumn = "key`; DROP TABLE renderizr; --"
+	if _, err := NewSQL(invalid); err == nil {
+		t.Errorf("Expected the identifier %s to be refused", invalid.KeyColumn)
+	}
+}
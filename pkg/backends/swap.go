@@ -0,0 +1,84 @@
+package backends
+
+import (
+	"sync"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// SwappableStore forwards every request to a store that can be replaced
+// while in use, e.g. once the credentials it was created with changed.
+type SwappableStore struct {
+	mutex   sync.RWMutex
+	current store.Store
+}
+
+// NewSwappableStore returns a SwappableStore forwarding to s.
+func NewSwappableStore(s store.Store) *SwappableStore {
+	return &SwappableStore{current: s}
+}
+
+// Swap replaces the store requests are forwarded to and closes the previous
+// one, breaking its watches so that they are established again with s.
+func (s *SwappableStore) Swap(next store.Store) {
+	s.mutex.Lock()
+	previous := s.current
+	s.current = next
+	s.mutex.Unlock()
+
+	previous.Close()
+}
+
+func (s *SwappableStore) backend() store.Store {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.current
+}
+
+func (s *SwappableStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return s.backend().Put(key, value, options)
+}
+
+func (s *SwappableStore) Get(key string) (*store.KVPair, error) {
+	return s.backend().Get(key)
+}
+
+func (s *SwappableStore) Delete(key string) error {
+	return s.backend().Delete(key)
+}
+
+func (s *SwappableStore) Exists(key string) (bool, error) {
+	return s.backend().Exists(key)
+}
+
+func (s *SwappableStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return s.backend().Watch(key, stopCh)
+}
+
+func (s *SwappableStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return s.backend().WatchTree(directory, stopCh)
+}
+
+func (s *SwappableStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return s.backend().NewLock(key, options)
+}
+
+func (s *SwappableStore) List(directory string) ([]*store.KVPair, error) {
+	return s.backend().List(directory)
+}
+
+func (s *SwappableStore) DeleteTree(directory string) error {
+	return s.backend().DeleteTree(directory)
+}
+
+func (s *SwappableStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return s.backend().AtomicPut(key, value, previous, options)
+}
+
+func (s *SwappableStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return s.backend().AtomicDelete(key, previous)
+}
+
+func (s *SwappableStore) Close() {
+	s.backend().Close()
+}
@@ -0,0 +1,35 @@
+package backends
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before expiration a token is renewed.
+const tokenRefreshMargin = 5 * time.Minute
+
+// tokenSource caches a bearer token until it is about to expire.
+type tokenSource struct {
+	fetch func() (string, time.Time, error)
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Token returns a valid token, fetching a new one if needed.
+func (s *tokenSource) Token() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Add(tokenRefreshMargin).Before(s.expires) {
+		return s.token, nil
+	}
+
+	token, expires, err := s.fetch()
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = token, expires
+	return token, nil
+}
@@ -0,0 +1,568 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// VAULT backend reads secrets from HashiCorp Vault: a KV (v1 or v2) tree and
+// any other, possibly leased, secret paths like database credentials.
+const VAULT store.Backend = "vault"
+
+const vaultRequestTimeout = 30 * time.Second
+
+// VaultOptions holds the Vault address, credentials and what is read from
+// it. Keys are the Vault paths of the secrets, including their mount,
+// followed by the name of the field.
+type VaultOptions struct {
+	Address   string
+	Namespace string
+	// Token authenticates directly, unless RoleID is set in which case an
+	// AppRole login is performed with RoleID and SecretID.
+	Token     string
+	RoleID    string
+	SecretID  string
+	AuthMount string
+	// KVMount is the mount of the KV secrets engine mapped into the key
+	// space, KVVersion selects its API (1 or 2).
+	KVMount   string
+	KVVersion int
+	// Paths are other secret paths read as a whole, their leases are
+	// renewed while possible and the secret read again once they expire.
+	Paths        []string
+	PollInterval time.Duration
+	TLS          *tls.Config
+}
+
+// VaultStore is a read-only store over the Vault HTTP API.
+type VaultStore struct {
+	options VaultOptions
+	client  *http.Client
+	token   *tokenSource
+
+	authMutex sync.Mutex
+	current   string
+	renewable bool
+
+	leaseMutex sync.Mutex
+	leases     map[string]*vaultLease
+}
+
+// vaultLease is a leased secret along with when its lease must be renewed.
+type vaultLease struct {
+	id        string
+	renewable bool
+	duration  time.Duration
+	renewAt   time.Time
+	data      map[string]string
+}
+
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+type vaultResponse struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration int64           `json:"lease_duration"`
+	Renewable     bool            `json:"renewable"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *vaultAuth      `json:"auth"`
+	Errors        []string        `json:"errors"`
+}
+
+// NewVault returns a store reading from the Vault server at options.Address.
+func NewVault(options VaultOptions) (*VaultStore, error) {
+	if !strings.HasPrefix(options.Address, "http://") && !strings.HasPrefix(options.Address, "https://") {
+		return nil, fmt.Errorf("Invalid Vault address %q", options.Address)
+	}
+	if options.Token == "" && options.RoleID == "" {
+		return nil, fmt.Errorf("Either a Vault token or an AppRole role id is required")
+	}
+	if options.KVVersion != 1 && options.KVVersion != 2 {
+		return nil, fmt.Errorf("Unsupported KV secrets engine version %d", options.KVVersion)
+	}
+	if options.AuthMount == "" {
+		options.AuthMount = "approle"
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = time.Minute
+	}
+
+	s := &VaultStore{
+		options: options,
+		client: &http.Client{
+			Timeout:   vaultRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: options.TLS, Proxy: http.ProxyFromEnvironment},
+		},
+		current: options.Token,
+		leases:  make(map[string]*vaultLease),
+	}
+	s.token = &tokenSource{fetch: s.authenticate}
+
+	if _, err := s.token.Token(); err != nil {
+		return nil, fmt.Errorf("Unable to authenticate to Vault: %v", err)
+	}
+
+	return s, nil
+}
+
+// authenticate renews the current token if possible, and otherwise logs in
+// again with AppRole or looks up the lifetime of the static token.
+func (s *VaultStore) authenticate() (string, time.Time, error) {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+
+	if s.current != "" && s.renewable {
+		var resp vaultResponse
+		err := s.do("POST", "/v1/auth/token/renew-self", nil, s.current, &resp)
+		if err == nil && resp.Auth != nil {
+			return s.setAuth(resp.Auth)
+		}
+		log.Warningf("Unable to renew Vault token: %v", err)
+	}
+
+	if s.options.RoleID != "" {
+		body := map[string]string{"role_id": s.options.RoleID, "secret_id": s.options.SecretID}
+		var resp vaultResponse
+		if err := s.do("POST", "/v1/auth/"+strings.Trim(s.options.AuthMount, "/")+"/login", body, "", &resp); err != nil {
+			return "", time.Time{}, err
+		}
+		if resp.Auth == nil {
+			return "", time.Time{}, fmt.Errorf("AppRole login returned no token")
+		}
+		log.Debugf("Logged in to Vault with AppRole")
+		return s.setAuth(resp.Auth)
+	}
+
+	var resp vaultResponse
+	if err := s.do("GET", "/v1/auth/token/lookup-self", nil, s.current, &resp); err != nil {
+		return "", time.Time{}, err
+	}
+	var data struct {
+		TTL       int64 `json:"ttl"`
+		Renewable bool  `json:"renewable"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return "", time.Time{}, err
+	}
+	return s.setAuth(&vaultAuth{ClientToken: s.current, LeaseDuration: data.TTL, Renewable: data.Renewable})
+}
+
+func (s *VaultStore) setAuth(auth *vaultAuth) (string, time.Time, error) {
+	s.current = auth.ClientToken
+	s.renewable = auth.Renewable
+
+	// tokens without ttl never expire, check them again once a day
+	ttl := time.Duration(auth.LeaseDuration) * time.Second
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return s.current, time.Now().Add(ttl), nil
+}
+
+// request performs an authenticated request.
+func (s *VaultStore) request(method, p string, body interface{}, v interface{}) error {
+	token, err := s.token.Token()
+	if err != nil {
+		return err
+	}
+	return s.do(method, p, body, token, v)
+}
+
+func (s *VaultStore) do(method, p string, body interface{}, token string, v interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(s.options.Address, "/")+p, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if s.options.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.options.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return store.ErrKeyNotFound
+	case resp.StatusCode == http.StatusNoContent:
+		return nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		var e vaultResponse
+		if json.Unmarshal(data, &e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.Join(e.Errors, ", "))
+		}
+		return fmt.Errorf("%s %s: %s", method, req.URL.Path, resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// kvPath returns the API path of a KV secret or, if list is set, of the
+// keys under it.
+func (s *VaultStore) kvPath(rel string, list bool) string {
+	mount := strings.Trim(s.options.KVMount, "/")
+	rel = strings.Trim(rel, "/")
+	if s.options.KVVersion == 1 {
+		return "/v1/" + path.Join(mount, rel)
+	}
+	if list {
+		return "/v1/" + path.Join(mount, "metadata", rel)
+	}
+	return "/v1/" + path.Join(mount, "data", rel)
+}
+
+// readKV reads the fields of the KV secret at rel.
+func (s *VaultStore) readKV(rel string) (map[string]string, error) {
+	var resp vaultResponse
+	if err := s.request("GET", s.kvPath(rel, false), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	data := resp.Data
+	if s.options.KVVersion == 2 {
+		var v2 struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(resp.Data, &v2); err != nil {
+			return nil, err
+		}
+		data = v2.Data
+	}
+	return vaultFields(data)
+}
+
+// listKV returns the names under the KV directory at rel, directories end
+// with a slash.
+func (s *VaultStore) listKV(rel string) ([]string, error) {
+	var resp vaultResponse
+	if err := s.request("GET", s.kvPath(rel, true)+"?list=true", nil, &resp); err != nil {
+		return nil, err
+	}
+	var data struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, err
+	}
+	return data.Keys, nil
+}
+
+// walkKV adds to pairs the fields of every KV secret under rel. As rel may
+// name a secret, or one of its fields, those are read too.
+func (s *VaultStore) walkKV(rel string, pairs map[string]string) error {
+	rel = strings.Trim(rel, "/")
+	if rel != "" {
+		candidates := []string{rel}
+		if dir := path.Dir(rel); dir != "." {
+			candidates = append(candidates, dir)
+		}
+		for _, candidate := range candidates {
+			if err := s.addKV(candidate, pairs); err != nil && err != store.ErrKeyNotFound {
+				return err
+			}
+		}
+	}
+	return s.walkKVDir(rel, pairs)
+}
+
+func (s *VaultStore) walkKVDir(rel string, pairs map[string]string) error {
+	names, err := s.listKV(rel)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		child := path.Join(rel, name)
+		if strings.HasSuffix(name, "/") {
+			err = s.walkKVDir(child, pairs)
+		} else {
+			err = s.addKV(child, pairs)
+		}
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *VaultStore) addKV(rel string, pairs map[string]string) error {
+	fields, err := s.readKV(rel)
+	if err != nil {
+		return err
+	}
+	for field, value := range fields {
+		pairs[path.Join("/", s.options.KVMount, rel, field)] = value
+	}
+	return nil
+}
+
+// readLeased returns the fields of the secret at p. Leased secrets are
+// cached and their lease renewed until it cannot be extended any more, so
+// that dynamic secrets are only issued again when they expire.
+func (s *VaultStore) readLeased(p string) (map[string]string, error) {
+	s.leaseMutex.Lock()
+	defer s.leaseMutex.Unlock()
+
+	p = strings.Trim(p, "/")
+	if lease, ok := s.leases[p]; ok {
+		if time.Now().Before(lease.renewAt) {
+			return lease.data, nil
+		}
+		if lease.renewable && s.renewLease(lease) {
+			return lease.data, nil
+		}
+		log.Infof("Lease of Vault secret %s expired, reading it again", p)
+		delete(s.leases, p)
+	}
+
+	var resp vaultResponse
+	if err := s.request("GET", "/v1/"+p, nil, &resp); err != nil {
+		return nil, err
+	}
+	data, err := vaultFields(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.LeaseID != "" {
+		duration := time.Duration(resp.LeaseDuration) * time.Second
+		s.leases[p] = &vaultLease{
+			id:        resp.LeaseID,
+			renewable: resp.Renewable,
+			duration:  duration,
+			renewAt:   renewTime(duration),
+			data:      data,
+		}
+	}
+	return data, nil
+}
+
+// renewLease extends lease, it reports false if that was not possible or the
+// extension is too short to be worth keeping the secret.
+func (s *VaultStore) renewLease(lease *vaultLease) bool {
+	body := map[string]interface{}{
+		"lease_id":  lease.id,
+		"increment": int64(lease.duration / time.Second),
+	}
+	var resp vaultResponse
+	if err := s.request("PUT", "/v1/sys/leases/renew", body, &resp); err != nil {
+		log.Warningf("Unable to renew Vault lease %s: %v", lease.id, err)
+		return false
+	}
+
+	duration := time.Duration(resp.LeaseDuration) * time.Second
+	if duration < lease.duration/3 {
+		// close to the max ttl, get a fresh secret instead
+		return false
+	}
+	lease.renewable = resp.Renewable
+	lease.renewAt = renewTime(duration)
+	log.Debugf("Renewed Vault lease %s for %v", lease.id, duration)
+	return true
+}
+
+// renewTime returns when a lease of the given duration should be renewed.
+func renewTime(duration time.Duration) time.Time {
+	return time.Now().Add(duration * 2 / 3)
+}
+
+// vaultFields decodes the fields of a secret, values which are not strings
+// are kept in their JSON form.
+func vaultFields(data json.RawMessage) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		var str string
+		if err := json.Unmarshal(v, &str); err == nil {
+			fields[k] = str
+		} else {
+			fields[k] = string(v)
+		}
+	}
+	return fields, nil
+}
+
+func (s *VaultStore) List(directory string) ([]*store.KVPair, error) {
+	directory = normalize(directory)
+	all := make(map[string]string)
+
+	if s.options.KVMount != "" {
+		mount := normalize(s.options.KVMount)
+		if isChildKey(directory, mount) {
+			if err := s.walkKV("", all); err != nil {
+				return nil, err
+			}
+		} else if isChildKey(mount, directory) {
+			if err := s.walkKV(strings.TrimPrefix(directory, mount), all); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, p := range s.options.Paths {
+		prefix := normalize(p)
+		if !isChildKey(directory, prefix) && !isChildKey(prefix, directory) {
+			continue
+		}
+		fields, err := s.readLeased(p)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range fields {
+			all[path.Join(prefix, field)] = value
+		}
+	}
+
+	pairs := make([]*store.KVPair, 0)
+	for k, v := range all {
+		if isChildKey(directory, k) {
+			pairs = append(pairs, &store.KVPair{Key: k, Value: []byte(v)})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+func (s *VaultStore) Get(key string) (*store.KVPair, error) {
+	key = normalize(key)
+	pairs, err := s.List(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		if pair.Key == key {
+			return pair, nil
+		}
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *VaultStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// WatchTree sends the pairs under directory whenever they change, which
+// includes secrets rotated in Vault and dynamic secrets issued again after
+// their lease expired. Vault is polled every poll interval.
+func (s *VaultStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	events := make(chan []*store.KVPair)
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(s.options.PollInterval)
+		defer ticker.Stop()
+
+		var last []byte
+		for {
+			pairs, err := s.List(directory)
+			if err != nil && err != store.ErrKeyNotFound {
+				log.Errorf("Unable to poll Vault: %v", err)
+			} else if sum := checksum(pairs); !bytes.Equal(sum, last) {
+				last = sum
+				select {
+				case events <- pairs:
+				case <-stopCh:
+					return
+				}
+			}
+
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (s *VaultStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *VaultStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *VaultStore) Delete(key string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *VaultStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (s *VaultStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (s *VaultStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, store.ErrCallNotSupported
+}
+
+func (s *VaultStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, store.ErrCallNotSupported
+}
+
+func (s *VaultStore) Close() {
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// Bench renders every template repeatedly against a snapshot of the backend
+// data and reports render times, allocations and the most expensive
+// template functions.
+func Bench(gc *config.GlobalConfig, bc config.BackendConfig, bcfg *config.BenchConfig) {
+	configureLogging(gc)
+
+	tcs := getTemplateConfigs(gc)
+	client := newStoreClient(gc, bc)
+	defer client.Close()
+
+	datasources, err := newDatasources(gc, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verifier, err := newVerifier(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	custom, err := loadCustomFuncs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, tc := range tcs {
+		profiler := core.NewFuncProfiler()
+		template := core.NewTemplate(tc, false, false, true)
+		template.SetDatasources(datasources)
+		template.SetVerifier(verifier)
+		if err := setTemplateFuncs(template, gc, custom); err != nil {
+			log.Fatal(err)
+		}
+		template.Instrument(profiler)
+
+		result, err := core.Bench(template, client, bcfg.Iterations)
+		if err != nil {
+			log.Fatalf("Unable to bench %s: %v", tc.Src, err)
+		}
+
+		fmt.Fprintf(w, "%s\n", tc.Src)
+		fmt.Fprintf(w, "  iterations\tmean\tmin\tmax\tallocs/op\tbytes/op\n")
+		fmt.Fprintf(w, "  %d\t%v\t%v\t%v\t%d\t%d\n",
+			result.Iterations, result.Mean(), result.Min, result.Max, result.Allocs, result.Bytes)
+
+		stats := profiler.Stats()
+		if len(stats) > bcfg.TopFuncs {
+			stats = stats[:bcfg.TopFuncs]
+		}
+		if len(stats) > 0 {
+			fmt.Fprintf(w, "  function\tcalls\ttotal\tper call\n")
+			for _, s := range stats {
+				fmt.Fprintf(w, "  %s\t%d\t%v\t%v\n", s.Name, s.Calls, s.Duration, s.Duration/time.Duration(s.Calls))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// orphanGracePeriod protects the stage files of a render in flight, of an
+// instance not holding destination locks, from the cleanup at startup.
+const orphanGracePeriod = time.Minute
+
+// Clean removes every stage file left behind in the staging areas of the
+// configured templates.
+func Clean(gc *config.GlobalConfig) {
+	configureLogging(gc)
+
+	tcs := getTemplateConfigs(gc)
+	if err := cleanStageFiles(tcs, time.Now()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// cleanStageFiles removes the stage files of the given templates last
+// modified before olderThan.
+func cleanStageFiles(tcs []*config.TemplateConfig, olderThan time.Time) error {
+	for _, tc := range tcs {
+		if tc.IsStdout() {
+			continue
+		}
+		dest, err := filepath.Abs(tc.Dest)
+		if err != nil {
+			return err
+		}
+
+		removed, err := util.CleanStageDir(dest, olderThan)
+		for _, name := range removed {
+			log.Infof("Removed stage file %s", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+)
+
+// getTemplateConfigsFromConfDir loads the template resources defined in
+// confdir/conf.d/*.toml, like upstream confd does. Relative sources are
+// looked up in confdir/templates. Resources are verified first if verifier
+// isn't nil.
+func getTemplateConfigsFromConfDir(confdir string, verifier *core.FileVerifier) ([]*config.TemplateConfig, error) {
+	files, err := filepath.Glob(filepath.Join(confdir, "conf.d", "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	tcs := make([]*config.TemplateConfig, 0, len(files))
+	for _, file := range files {
+		tc, err := getTemplateConfigFromFile(confdir, file, verifier)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		tcs = append(tcs, tc)
+	}
+	return tcs, nil
+}
+
+func getTemplateConfigFromFile(confdir, file string, verifier *core.FileVerifier) (*config.TemplateConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if verifier != nil {
+		if err := verifier.Verify(file, data); err != nil {
+			return nil, err
+		}
+	}
+
+	tcf := &config.TemplateConfigFile{TemplateConfig: *config.NewTemplateConfig()}
+	md, err := toml.Decode(string(data), tcf)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("Unknown template option %q", undecoded[0].String())
+	}
+
+	tc := &tcf.TemplateConfig
+	if tc.Src != "" && !filepath.IsAbs(tc.Src) {
+		tc.Src = filepath.Join(confdir, "templates", tc.Src)
+	}
+	return tc, tc.Validate()
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
-	"github.com/docker/libkv/store"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/backends"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
 )
 
 type BackendConfig interface {
@@ -14,18 +17,20 @@ type BackendConfig interface {
 //
 
 type ConsulBackendConfig struct {
-	Endpoints []string
-	CAFile    string
-	CertFile  string
-	KeyFile   string
+	Endpoints   []string
+	CAFile      string
+	CertFile    string
+	KeyFile     string
+	Consistency string
 }
 
 func NewConsulBackendConfig() *ConsulBackendConfig {
 	return &ConsulBackendConfig{
-		Endpoints: []string{"127.0.0.1:8500"},
-		CAFile:    "",
-		CertFile:  "",
-		KeyFile:   "",
+		Endpoints:   []string{"127.0.0.1:8500"},
+		CAFile:      "",
+		CertFile:    "",
+		KeyFile:     "",
+		Consistency: "",
 	}
 }
 
@@ -42,18 +47,20 @@ func (*ConsulBackendConfig) IsWatchSupported() bool {
 //
 
 type EtcdBackendConfig struct {
-	Endpoints []string
-	CAFile    string
-	CertFile  string
-	KeyFile   string
+	Endpoints   []string
+	CAFile      string
+	CertFile    string
+	KeyFile     string
+	Consistency string
 }
 
 func NewEtcdBackendConfig() *EtcdBackendConfig {
 	return &EtcdBackendConfig{
-		Endpoints: []string{"127.0.0.1:2379"},
-		CAFile:    "",
-		CertFile:  "",
-		KeyFile:   "",
+		Endpoints:   []string{"127.0.0.1:2379"},
+		CAFile:      "",
+		CertFile:    "",
+		KeyFile:     "",
+		Consistency: "quorum",
 	}
 }
 
@@ -87,6 +94,354 @@ func (*ZookeeperBackendConfig) IsWatchSupported() bool {
 	return true
 }
 
+//
+// fixture
+//
+
+type FixtureBackendConfig struct {
+	Files []string
+}
+
+func NewFixtureBackendConfig() *FixtureBackendConfig {
+	return &FixtureBackendConfig{
+		Files: nil,
+	}
+}
+
+func (*FixtureBackendConfig) Type() store.Backend {
+	return backends.FIXTURE
+}
+
+func (*FixtureBackendConfig) IsWatchSupported() bool {
+	return false
+}
+
+//
+// push
+//
+
+type PushBackendConfig struct {
+	Listen     string
+	SecretFile string
+	CertFile   string
+	KeyFile    string
+	StateFile  string
+}
+
+func NewPushBackendConfig() *PushBackendConfig {
+	return &PushBackendConfig{
+		Listen:     ":8787",
+		SecretFile: "",
+		CertFile:   "",
+		KeyFile:    "",
+		StateFile:  "",
+	}
+}
+
+func (*PushBackendConfig) Type() store.Backend {
+	return backends.PUSH
+}
+
+func (*PushBackendConfig) IsWatchSupported() bool {
+	return true
+}
+
+//
+// mqtt
+//
+
+type MQTTBackendConfig struct {
+	Endpoints    []string
+	Topic        string
+	ClientID     string
+	Username     string
+	PasswordFile string
+	CAFile       string
+	CertFile     string
+	KeyFile      string
+}
+
+func NewMQTTBackendConfig() *MQTTBackendConfig {
+	return &MQTTBackendConfig{
+		Endpoints:    []string{"127.0.0.1:1883"},
+		Topic:        "#",
+		ClientID:     "",
+		Username:     "",
+		PasswordFile: "",
+		CAFile:       "",
+		CertFile:     "",
+		KeyFile:      "",
+	}
+}
+
+func (*MQTTBackendConfig) Type() store.Backend {
+	return backends.MQTT
+}
+
+func (*MQTTBackendConfig) IsWatchSupported() bool {
+	return true
+}
+
+//
+// sql
+//
+
+type SQLBackendConfig struct {
+	Driver        string
+	DSN           string
+	Table         string
+	KeyColumn     string
+	ValueColumn   string
+	PollInterval  time.Duration
+	NotifyChannel string
+}
+
+func NewSQLBackendConfig() *SQLBackendConfig {
+	return &SQLBackendConfig{
+		Driver:        "postgres",
+		DSN:           "",
+		Table:         "renderizr",
+		KeyColumn:     "key",
+		ValueColumn:   "value",
+		PollInterval:  10 * time.Second,
+		NotifyChannel: "",
+	}
+}
+
+func (*SQLBackendConfig) Type() store.Backend {
+	return backends.SQL
+}
+
+func (*SQLBackendConfig) IsWatchSupported() bool {
+	return true
+}
+
+//
+// http
+//
+
+type HTTPBackendConfig struct {
+	URL      string
+	Headers  []string
+	Timeout  time.Duration
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+func NewHTTPBackendConfig() *HTTPBackendConfig {
+	return &HTTPBackendConfig{
+		URL:      "",
+		Headers:  nil,
+		Timeout:  30 * time.Second,
+		CAFile:   "",
+		CertFile: "",
+		KeyFile:  "",
+	}
+}
+
+func (*HTTPBackendConfig) Type() store.Backend {
+	return backends.HTTP
+}
+
+func (*HTTPBackendConfig) IsWatchSupported() bool {
+	return false
+}
+
+//
+// azure
+//
+
+type AzureBackendConfig struct {
+	AppConfigEndpoint string
+	Label             string
+	KeySeparator      string
+	KeyVault          string
+	SecretsPrefix     string
+	ClientID          string
+}
+
+func NewAzureBackendConfig() *AzureBackendConfig {
+	return &AzureBackendConfig{
+		AppConfigEndpoint: "",
+		Label:             "",
+		KeySeparator:      ":",
+		KeyVault:          "",
+		SecretsPrefix:     "/secrets",
+		ClientID:          "",
+	}
+}
+
+func (*AzureBackendConfig) Type() store.Backend {
+	return backends.AZURE
+}
+
+func (*AzureBackendConfig) IsWatchSupported() bool {
+	return false
+}
+
+//
+// gcp
+//
+
+type GCPBackendConfig struct {
+	Project        string
+	Secrets        []string
+	SecretVersions []string
+	SecretsPrefix  string
+	MetadataPrefix string
+}
+
+func NewGCPBackendConfig() *GCPBackendConfig {
+	return &GCPBackendConfig{
+		Project:        "",
+		Secrets:        nil,
+		SecretVersions: nil,
+		SecretsPrefix:  "/secrets",
+		MetadataPrefix: "",
+	}
+}
+
+func (*GCPBackendConfig) Type() store.Backend {
+	return backends.GCP
+}
+
+func (*GCPBackendConfig) IsWatchSupported() bool {
+	return false
+}
+
+//
+// vault
+//
+
+type VaultBackendConfig struct {
+	Address      string
+	Namespace    string
+	TokenFile    string
+	RoleID       string
+	SecretIDFile string
+	AuthMount    string
+	KVMount      string
+	KVVersion    int
+	Paths        []string
+	PollInterval time.Duration
+	CAFile       string
+	CertFile     string
+	KeyFile      string
+}
+
+func NewVaultBackendConfig() *VaultBackendConfig {
+	return &VaultBackendConfig{
+		Address:      "https://127.0.0.1:8200",
+		Namespace:    "",
+		TokenFile:    "",
+		RoleID:       "",
+		SecretIDFile: "",
+		AuthMount:    "approle",
+		KVMount:      "secret",
+		KVVersion:    2,
+		Paths:        nil,
+		PollInterval: time.Minute,
+		CAFile:       "",
+		CertFile:     "",
+		KeyFile:      "",
+	}
+}
+
+func (*VaultBackendConfig) Type() store.Backend {
+	return backends.VAULT
+}
+
+func (*VaultBackendConfig) IsWatchSupported() bool {
+	return true
+}
+
+//
+// k8s
+//
+
+type K8sBackendConfig struct {
+	Server        string
+	TokenFile     string
+	Namespaces    []string
+	LabelSelector string
+	Resources     []string
+	CAFile        string
+	CertFile      string
+	KeyFile       string
+}
+
+func NewK8sBackendConfig() *K8sBackendConfig {
+	return &K8sBackendConfig{
+		Server:        "",
+		TokenFile:     "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		Namespaces:    nil,
+		LabelSelector: "",
+		Resources:     []string{"configmaps", "secrets"},
+		CAFile:        "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+		CertFile:      "",
+		KeyFile:       "",
+	}
+}
+
+func (*K8sBackendConfig) Type() store.Backend {
+	return backends.K8S
+}
+
+func (*K8sBackendConfig) IsWatchSupported() bool {
+	return true
+}
+
+//
+// env
+//
+
+type EnvBackendConfig struct {
+	Prefix      string
+	StripPrefix bool
+	Separator   string
+}
+
+func NewEnvBackendConfig() *EnvBackendConfig {
+	return &EnvBackendConfig{
+		Prefix:      "",
+		StripPrefix: false,
+		Separator:   "_",
+	}
+}
+
+func (*EnvBackendConfig) Type() store.Backend {
+	return backends.ENV
+}
+
+func (*EnvBackendConfig) IsWatchSupported() bool {
+	return false
+}
+
+//
+// fs
+//
+
+type FsBackendConfig struct {
+	RootPath    string
+	MaxFileSize int64
+}
+
+func NewFsBackendConfig() *FsBackendConfig {
+	return &FsBackendConfig{
+		RootPath:    "",
+		MaxFileSize: 1 << 20,
+	}
+}
+
+func (*FsBackendConfig) Type() store.Backend {
+	return backends.FS
+}
+
+func (*FsBackendConfig) IsWatchSupported() bool {
+	return true
+}
+
 /*
 //
 // boltdb
@@ -107,4 +462,4 @@ func (*BoltDBBackendConfig) Type() string {
 func (*BoltDBBackendConfig) IsWatchSupported() bool {
 	return false
 }
-*/
\ No newline at end of file
+*/
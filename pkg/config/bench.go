@@ -0,0 +1,13 @@
+package config
+
+type BenchConfig struct {
+	Iterations int
+	TopFuncs   int
+}
+
+func NewBenchConfig() *BenchConfig {
+	return &BenchConfig{
+		Iterations: 100,
+		TopFuncs:   10,
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	// EnvValuePrefix reads a value from an environment variable, e.g.
+	// env://CONSUL_HTTP_ADDR.
+	EnvValuePrefix = "env://"
+	// FileValuePrefix reads a value from a file, e.g.
+	// file:///run/secrets/vault-address.
+	FileValuePrefix = "file://"
+)
+
+// ExpandValue resolves value when it references an environment variable
+// (env://NAME) or a file (file:///path, trailing newlines are dropped), so
+// that secrets don't show up in the process arguments. Other values are
+// returned as they are.
+func ExpandValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, EnvValuePrefix):
+		name := strings.TrimPrefix(value, EnvValuePrefix)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("Environment variable %s referenced by %s is not set", name, value)
+		}
+		return v, nil
+	case strings.HasPrefix(value, FileValuePrefix):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(value, FileValuePrefix))
+		if err != nil {
+			return "", fmt.Errorf("Unable to read %s: %v", value, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// ExpandBackendConfig returns a copy of bc whose string values, single or in
+// lists, and HTTP header values are resolved by ExpandValue. bc itself is left untouched so that it
+// can be expanded again, e.g. once the referenced files were rotated.
+func ExpandBackendConfig(bc BackendConfig) (BackendConfig, error) {
+	src := reflect.ValueOf(bc)
+	if src.Kind() != reflect.Ptr || src.Elem().Kind() != reflect.Struct {
+		return bc, nil
+	}
+	dst := reflect.New(src.Elem().Type())
+	dst.Elem().Set(src.Elem())
+
+	fields := dst.Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		name := fields.Type().Field(i).Name
+		switch {
+		case field.Kind() == reflect.String:
+			v, err := ExpandValue(field.String())
+			if err != nil {
+				return nil, fmt.Errorf("%s backend %s: %v", bc.Type(), name, err)
+			}
+			field.SetString(v)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+			values := make([]string, field.Len())
+			for j := range values {
+				v, err := ExpandValue(field.Index(j).String())
+				if err != nil {
+					return nil, fmt.Errorf("%s backend %s: %v", bc.Type(), name, err)
+				}
+				values[j] = v
+			}
+			field.Set(reflect.ValueOf(values))
+		}
+	}
+
+	// header values are expanded on their own, e.g. 'Authorization: env://TOKEN'
+	if hbc, ok := dst.Interface().(*HTTPBackendConfig); ok {
+		for i, h := range hbc.Headers {
+			parts := strings.SplitN(h, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			v, err := ExpandValue(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, fmt.Errorf("%s backend header %s: %v", bc.Type(), parts[0], err)
+			}
+			hbc.Headers[i] = parts[0] + ": " + v
+		}
+	}
+	return dst.Interface().(BackendConfig), nil
+}
@@ -0,0 +1,22 @@
+package config
+
+const (
+	// ExportFormatTar writes a plain tar archive.
+	ExportFormatTar = "tar"
+	// ExportFormatOCILayer writes a gzipped, reproducible OCI image layer.
+	ExportFormatOCILayer = "oci-layer"
+)
+
+type ExportConfig struct {
+	Output     string
+	Format     string
+	Descriptor string
+}
+
+func NewExportConfig() *ExportConfig {
+	return &ExportConfig{
+		Output:     "-",
+		Format:     ExportFormatTar,
+		Descriptor: "",
+	}
+}
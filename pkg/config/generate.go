@@ -0,0 +1,17 @@
+package config
+
+type GenerateConfig struct {
+	Output string
+	Prefix string
+	Dest   string
+	Force  bool
+}
+
+func NewGenerateConfig() *GenerateConfig {
+	return &GenerateConfig{
+		Output: ".",
+		Prefix: "/services",
+		Dest:   "",
+		Force:  false,
+	}
+}
@@ -1,17 +1,88 @@
 package config
 
 import (
+	"os"
 	"time"
 )
 
+// Policies applied when a template prefix holds no keys.
+const (
+	EmptyPrefixFail  = "fail"
+	EmptyPrefixEmpty = "empty"
+	EmptyPrefixRetry = "retry"
+)
+
 type GlobalConfig struct {
 	Prefix         string
 	Templates      []string
 	Onetime        bool
+	OnetimeRetry   bool
+	OnetimeTimeout time.Duration
 	Watch          bool
 	ResyncInterval time.Duration
+	ResyncSplay    time.Duration
 	NoOp           bool
 	KeepStageFile  bool
+	Lock           bool
+	RateLimit      float64
+	RateBurst      int
+	ShareRequests  bool
+	StartupJitter  time.Duration
+	Datasources    []string
+	DatasourceTTL  time.Duration
+	AckPrefix      string
+	NodeName       string
+	PublicKeyFile  string
+	ManifestKey    string
+	ConfigKeyFile  string
+	ChecksumFile   bool
+	InventoryFile  string
+	AdminListen    string
+	AdminTokenFile string
+	RedactKeys     string
+	EventLogSize   int
+	OverridesFile  string
+	ConfDir        string
+	Shell          string
+	ReloadRetries  int
+	ReloadDelay    time.Duration
+	ReloadMaxDelay time.Duration
+	CheckCacheSize int
+	EmptyPrefix    string
+	EmptyRetries   int
+	Mounts         []string
+	Workers        int
+	MaxParallel    int
+	SnapshotCycles bool
+	RenderOnExit   bool
+	EphemeralCheck time.Duration
+	RenderDebounce time.Duration
+	MinInterval    time.Duration
+	MaxFailures    int
+	OnceOnChange   bool
+	Exec           string
+	ExecSignal     string
+	ExecKillSignal string
+	ExecKillWait   time.Duration
+	MaxRuntime     time.Duration
+	RenderTimeout  time.Duration
+	CheckTimeout   time.Duration
+	ReloadTimeout  time.Duration
+	LogFormat      string
+	LogLevel       string
+	Diff           bool
+	DiffRedact     bool
+	SpiffeSocket   string
+	SpiffeServerID string
+	EnableSprig    bool
+	FileAllowList  []string
+	FetchAllowList []string
+	FuncPlugins    []string
+	ShellFuncs     string
+	FuncMetrics    bool
+	// BackendMounts are the backends parsed from Mounts, keyed by the
+	// prefix they are mounted under.
+	BackendMounts map[string]BackendConfig
 }
 
 func NewGlobalConfig() *GlobalConfig {
@@ -19,9 +90,71 @@ func NewGlobalConfig() *GlobalConfig {
 		Prefix:         "/",
 		Templates:      nil,
 		Onetime:        false,
+		OnetimeRetry:   false,
+		OnetimeTimeout: 0,
 		Watch:          false,
 		ResyncInterval: 60 * time.Second,
+		ResyncSplay:    0,
 		NoOp:           false,
 		KeepStageFile:  false,
+		Lock:           false,
+		RateLimit:      0,
+		RateBurst:      1,
+		ShareRequests:  false,
+		StartupJitter:  0,
+		Datasources:    nil,
+		DatasourceTTL:  60 * time.Second,
+		AckPrefix:      "",
+		NodeName:       hostname(),
+		PublicKeyFile:  "",
+		ManifestKey:    ".manifest",
+		ConfigKeyFile:  "",
+		InventoryFile:  "",
+		RedactKeys:     "(?i)(password|passwd|secret|token|credential|private)",
+		EventLogSize:   100,
+		Shell:          "/bin/sh -c",
+		ReloadRetries:  0,
+		ReloadDelay:    time.Second,
+		ReloadMaxDelay: 30 * time.Second,
+		CheckCacheSize: 0,
+		EmptyPrefix:    EmptyPrefixFail,
+		EmptyRetries:   5,
+		Workers:        1,
+		MaxParallel:    0,
+		SnapshotCycles: false,
+		RenderOnExit:   false,
+		EphemeralCheck: 0,
+		RenderDebounce: 0,
+		MinInterval:    0,
+		MaxFailures:    0,
+		OnceOnChange:   false,
+		Exec:           "",
+		ExecSignal:     "",
+		ExecKillSignal: "SIGTERM",
+		ExecKillWait:   30 * time.Second,
+		MaxRuntime:     0,
+		RenderTimeout:  0,
+		CheckTimeout:   0,
+		ReloadTimeout:  0,
+		LogFormat:      "glog",
+		LogLevel:       "info",
+		Diff:           false,
+		DiffRedact:     true,
+		SpiffeSocket:   "",
+		SpiffeServerID: "",
+		EnableSprig:    false,
+		FileAllowList:  nil,
+		FetchAllowList: nil,
+		FuncPlugins:    nil,
+		ShellFuncs:     "",
+		FuncMetrics:    false,
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
 	}
+	return name
 }
@@ -0,0 +1,28 @@
+package config
+
+const (
+	KVFormatYAML = "yaml"
+	KVFormatJSON = "json"
+)
+
+type KVConfig struct {
+	File     string
+	Format   string
+	From     string
+	To       string
+	Rewrites []string
+	Exclude  string
+	DryRun   bool
+}
+
+func NewKVConfig() *KVConfig {
+	return &KVConfig{
+		File:     "-",
+		Format:   KVFormatYAML,
+		From:     "",
+		To:       "",
+		Rewrites: nil,
+		Exclude:  "",
+		DryRun:   false,
+	}
+}
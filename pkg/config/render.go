@@ -0,0 +1,13 @@
+package config
+
+type RenderConfig struct {
+	Values []string
+	Write  bool
+}
+
+func NewRenderConfig() *RenderConfig {
+	return &RenderConfig{
+		Values: nil,
+		Write:  false,
+	}
+}
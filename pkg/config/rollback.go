@@ -0,0 +1,11 @@
+package config
+
+type RollbackConfig struct {
+	Dests []string
+}
+
+func NewRollbackConfig() *RollbackConfig {
+	return &RollbackConfig{
+		Dests: nil,
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// HostSelector restricts a template resource to the hosts it applies to, so
+// that a single bundle of resources can be deployed to a whole fleet. Every
+// criterion given must hold, an empty selector selects every host.
+type HostSelector struct {
+	// Hosts are hostname globs, one of them must match.
+	Hosts []string `toml:"hosts"`
+	// Env are environment variables, like 'ROLE=front*' holding a value
+	// matching a glob or like 'ROLE' just being set. All of them must match.
+	Env []string `toml:"env"`
+	// Files are paths that must all exist.
+	Files []string `toml:"files"`
+}
+
+// Validate checks the globs of the selector are well formed.
+func (s *HostSelector) Validate() error {
+	for _, h := range s.Hosts {
+		if _, err := path.Match(h, ""); err != nil {
+			return fmt.Errorf("Invalid host pattern %q: %v", h, err)
+		}
+	}
+	for _, e := range s.Env {
+		parts := strings.SplitN(e, "=", 2)
+		if parts[0] == "" {
+			return fmt.Errorf("Invalid environment selector %q", e)
+		}
+		if len(parts) == 2 {
+			if _, err := path.Match(parts[1], ""); err != nil {
+				return fmt.Errorf("Invalid environment selector %q: %v", e, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the host named hostname is selected, along with
+// the reason when it is not.
+func (s *HostSelector) Matches(hostname string) (bool, string) {
+	if len(s.Hosts) > 0 {
+		matched := false
+		for _, h := range s.Hosts {
+			if ok, _ := path.Match(h, hostname); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, fmt.Sprintf("host %s does not match %s", hostname, strings.Join(s.Hosts, ", "))
+		}
+	}
+
+	for _, e := range s.Env {
+		parts := strings.SplitN(e, "=", 2)
+		value, ok := os.LookupEnv(parts[0])
+		if !ok {
+			return false, fmt.Sprintf("%s is not set", parts[0])
+		}
+		if len(parts) == 2 {
+			if ok, _ := path.Match(parts[1], value); !ok {
+				return false, fmt.Sprintf("%s does not match %s", parts[0], parts[1])
+			}
+		}
+	}
+
+	for _, f := range s.Files {
+		if _, err := os.Stat(f); err != nil {
+			return false, fmt.Sprintf("%s does not exist", f)
+		}
+	}
+
+	return true, ""
+}
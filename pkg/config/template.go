@@ -1,29 +1,255 @@
 package config
 
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+const (
+	// NormalizeTrim trims trailing whitespace of every line of a value and
+	// its trailing newlines.
+	NormalizeTrim = "trim"
+	// NormalizeCRLF converts CRLF line endings of a value into LF.
+	NormalizeCRLF = "crlf"
+	// NormalizeFinalNewline makes sure the rendered output ends with a
+	// newline.
+	NormalizeFinalNewline = "final-newline"
+)
+
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF8BOM = "utf-8-bom"
+	// EncodingUTF16 is little endian UTF-16 with a byte order mark, what
+	// Windows applications usually expect.
+	EncodingUTF16   = "utf-16"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingLatin1  = "latin-1"
+)
+
+const (
+	LineEndingsLF   = "lf"
+	LineEndingsCRLF = "crlf"
+)
+
+// Duration is a time.Duration read from strings like "2s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// StdoutDest is the destination of templates writing to stdout instead of
+// a file.
+const StdoutDest = "-"
+
+// ShellNone is the shell of templates running their commands without one,
+// split into arguments.
+const ShellNone = "none"
+
 type TemplateConfigFile struct {
 	TemplateConfig TemplateConfig `toml:"template"`
 }
 
 type TemplateConfig struct {
-	Src           string
-	Dest          string
-	Uid           int
-	Gid           int
-	Mode          string
-	Prefix        string
-	CheckCmd      string
-	ReloadCmd     string
+	Src           string   `toml:"src"`
+	Dest          string   `toml:"dest"`
+	Uid           int      `toml:"uid"`
+	Gid           int      `toml:"gid"`
+	Mode          string   `toml:"mode"`
+	Prefix        string   `toml:"prefix"`
+	Keys          []string `toml:"keys"`
+	CheckCmd      string   `toml:"check_cmd"`
+	ReloadCmd     string   `toml:"reload_cmd"`
+	Triggers      []string `toml:"triggers"`
+	Normalize     []string `toml:"normalize"`
+	Encoding      string   `toml:"encoding"`
+	LineEndings   string   `toml:"line_endings"`
+	Shell         string   `toml:"shell"`
+	Group         string   `toml:"group"`
+	Priority      int      `toml:"priority"`
+	OnSuccess     string   `toml:"on_success"`
+	OnFailure     string   `toml:"on_failure"`
+	ReloadSignal  string   `toml:"reload_signal"`
+	ReloadProcess string   `toml:"reload_process"`
+	// ReloadWindow is a cron expression of the minutes the destination may
+	// be reloaded in, reloads being deferred until it matches.
+	ReloadWindow  string   `toml:"reload_window"`
+	Debounce      Duration `toml:"render_debounce"`
+	MinInterval   Duration `toml:"min_render_interval"`
+	CheckTimeout  Duration `toml:"check_timeout"`
+	ReloadTimeout Duration `toml:"reload_timeout"`
+	MaxFailures   int      `toml:"max_consecutive_failures"`
+	// Advisory templates have their failures logged and counted, but never
+	// failing renderizr, its health or the group they belong to.
+	Advisory bool `toml:"advisory"`
+	// LeftDelim and RightDelim replace {{ and }} as the action delimiters
+	// of the source template.
+	LeftDelim  string `toml:"left_delim"`
+	RightDelim string `toml:"right_delim"`
+	// Backups is the number of previous destinations kept, named after it
+	// with BackupSuffix and their number, 1 being the latest.
+	Backups      int    `toml:"backups"`
+	BackupSuffix string `toml:"backup_suffix"`
+	// AutoRollback restores the previous destination, and reloads it again,
+	// when the reload of a new one fails.
+	AutoRollback bool `toml:"auto_rollback"`
+	// KeepXattrs gives new destinations the extended attributes of the
+	// previous one, SELinux context and ACLs included, and SELinux sets
+	// their SELinux context.
+	KeepXattrs bool   `toml:"keep_xattrs"`
+	SELinux    string `toml:"selinux_context"`
+	// AllowEmpty lets empty or whitespace-only output replace destinations
+	// with contents.
+	AllowEmpty bool         `toml:"allow_empty"`
+	Selector   HostSelector `toml:"selector"`
 }
 
 func NewTemplateConfig() *TemplateConfig {
 	return &TemplateConfig{
-		Src:           "",
-		Dest:          "",
-		Uid:           0,
-		Gid:           0,
-		Mode:          "0644",
-		Prefix:        "/",
-		CheckCmd:      "",
-		ReloadCmd:     "",
+		Src:          "",
+		Dest:         "",
+		Uid:          0,
+		Gid:          0,
+		Mode:         "0644",
+		Prefix:       "/",
+		CheckCmd:     "",
+		ReloadCmd:    "",
+		Encoding:     EncodingUTF8,
+		BackupSuffix: ".bak",
+	}
+}
+
+// IsStdout reports whether the template writes to stdout instead of a file.
+func (tc *TemplateConfig) IsStdout() bool {
+	return tc.Dest == StdoutDest
+}
+
+// Watches reports whether the template reads or watches keys under the
+// backend directory dir, e.g. because dir is one of its keys or holds them.
+func (tc *TemplateConfig) Watches(dir string) bool {
+	dir = path.Join("/", dir)
+	keys := tc.Keys
+	if len(keys) == 0 {
+		keys = []string{"/"}
+	}
+	for _, key := range keys {
+		key = path.Join("/", tc.Prefix, key)
+		if key == "/" || dir == "/" || key == dir ||
+			strings.HasPrefix(key, dir+"/") || strings.HasPrefix(dir, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCommands checks the check and reload commands can be run by the
+// template shell, once it defaults to the global one.
+func (tc *TemplateConfig) ValidateCommands() error {
+	if tc.Shell != ShellNone {
+		return nil
+	}
+	for _, cmd := range []string{tc.CheckCmd, tc.ReloadCmd} {
+		if _, err := util.SplitArgs(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the template configuration is complete and only uses
+// known options.
+func (tc *TemplateConfig) Validate() error {
+	if tc.Src == "" || tc.Dest == "" {
+		return fmt.Errorf("Template source and destination are required")
+	}
+	if tc.IsStdout() && (tc.CheckCmd != "" || tc.ReloadCmd != "" || tc.ReloadProcess != "" || tc.Group != "" || tc.KeepXattrs || tc.SELinux != "") {
+		return fmt.Errorf("Templates writing to stdout cannot have check or reload commands, extended attributes, nor belong to a group")
 	}
+
+	for _, n := range tc.Normalize {
+		switch n {
+		case NormalizeTrim, NormalizeCRLF, NormalizeFinalNewline:
+		default:
+			return fmt.Errorf("Unknown normalization %q", n)
+		}
+	}
+
+	tc.Encoding = strings.ToLower(tc.Encoding)
+	switch tc.Encoding {
+	case "", EncodingUTF8, EncodingUTF8BOM, EncodingUTF16, EncodingUTF16LE, EncodingUTF16BE, EncodingLatin1:
+	default:
+		return fmt.Errorf("Unknown encoding %q", tc.Encoding)
+	}
+
+	tc.LineEndings = strings.ToLower(tc.LineEndings)
+	switch tc.LineEndings {
+	case "", LineEndingsLF, LineEndingsCRLF:
+	default:
+		return fmt.Errorf("Unknown line endings %q", tc.LineEndings)
+	}
+
+	if (tc.LeftDelim == "") != (tc.RightDelim == "") {
+		return fmt.Errorf("Both the left and right delimiters are required")
+	}
+
+	if tc.Backups < 0 {
+		return fmt.Errorf("Number of backups cannot be negative")
+	}
+	if strings.Contains(tc.BackupSuffix, "/") {
+		return fmt.Errorf("Backup suffix %q cannot contain '/'", tc.BackupSuffix)
+	}
+
+	if tc.MaxFailures < 0 {
+		return fmt.Errorf("Maximum consecutive failures cannot be negative")
+	}
+
+	if tc.Debounce < 0 || tc.MinInterval < 0 {
+		return fmt.Errorf("Render debounce and minimum interval cannot be negative")
+	}
+
+	if tc.CheckTimeout < 0 || tc.ReloadTimeout < 0 {
+		return fmt.Errorf("Check and reload timeouts cannot be negative")
+	}
+
+	if err := tc.Selector.Validate(); err != nil {
+		return err
+	}
+
+	if tc.ReloadProcess != "" {
+		if tc.ReloadCmd != "" {
+			return fmt.Errorf("A reload command and a reload process cannot be combined")
+		}
+		if _, err := regexp.Compile(tc.ReloadProcess); err != nil {
+			return fmt.Errorf("Invalid reload process pattern %q: %v", tc.ReloadProcess, err)
+		}
+		if tc.ReloadSignal == "" {
+			tc.ReloadSignal = "SIGHUP"
+		}
+	}
+	if tc.ReloadSignal != "" {
+		if tc.ReloadProcess == "" {
+			return fmt.Errorf("A reload signal requires a reload process")
+		}
+		if _, err := util.ParseSignal(tc.ReloadSignal); err != nil {
+			return err
+		}
+	}
+	if tc.ReloadWindow != "" {
+		if _, err := util.ParseCron(tc.ReloadWindow); err != nil {
+			return fmt.Errorf("Invalid reload window: %v", err)
+		}
+	}
+
+	return nil
 }
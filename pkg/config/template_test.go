@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestTemplateConfigWatches(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		keys    []string
+		dir     string
+		watches bool
+	}{
+		{"/", nil, "/acks", true},
+		{"/app", nil, "/acks", false},
+		{"/app", nil, "/app/acks", true},
+		{"/app", nil, "/", true},
+		{"/app", []string{"/db"}, "/app/acks", false},
+		{"/app", []string{"/db"}, "/app/db/acks", true},
+		{"/app", []string{"/db/host"}, "/app/db", true},
+		{"/app", []string{"/"}, "/app/acks", true},
+		{"/app", []string{"/dbs"}, "/app/db", false},
+	}
+
+	for _, tt := range tests {
+		tc := NewTemplateConfig()
+		tc.Prefix = tt.prefix
+		tc.Keys = tt.keys
+		if watches := tc.Watches(tt.dir); watches != tt.watches {
+			t.Errorf("Prefix %s and keys %v watching %s: expected %v, got %v", tt.prefix, tt.keys, tt.dir, tt.watches, watches)
+		}
+	}
+}
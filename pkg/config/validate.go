@@ -0,0 +1,11 @@
+package config
+
+type ValidateConfig struct {
+	Values []string
+}
+
+func NewValidateConfig() *ValidateConfig {
+	return &ValidateConfig{
+		Values: nil,
+	}
+}
@@ -0,0 +1,13 @@
+package config
+
+type WhatIfConfig struct {
+	Set    []string
+	Delete []string
+}
+
+func NewWhatIfConfig() *WhatIfConfig {
+	return &WhatIfConfig{
+		Set:    nil,
+		Delete: nil,
+	}
+}
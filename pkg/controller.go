@@ -0,0 +1,450 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// eventBufferSize is how many render events are buffered for the receiver
+// of Controller.Events before they are dropped.
+const eventBufferSize = 100
+
+// Controller renders the template resources of a configuration. It is what
+// the renderizr command runs, for other programs to embed renderizr:
+//
+//	c, err := renderizr.New(gc, bc)
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	if err := c.RenderOnce(); err != nil {
+//		return err
+//	}
+//	return c.Start(ctx)
+type Controller struct {
+	gc *config.GlobalConfig
+	bc config.BackendConfig
+
+	client       store.Store
+	reloadClient func() error
+	locks        []*util.FileLock
+
+	templates  []*core.Template
+	processors []core.Processor
+	// units are what gets run: single templates and the transactions
+	// of groups, along with their names
+	units []core.Processor
+	names []string
+	// advisory tells which units are advisory templates, whose failures
+	// do not fail a cycle
+	advisory []bool
+
+	events    *core.EventLog
+	eventChan chan core.Event
+	changes   chan string
+	failures  chan error
+}
+
+// New loads the template resources of gc and connects to the backend of bc,
+// without rendering anything yet.
+func New(gc *config.GlobalConfig, bc config.BackendConfig) (*Controller, error) {
+	tcs, err := loadTemplateConfigs(gc)
+	if err != nil {
+		return nil, err
+	}
+	util.Dump(bc)
+
+	// Fail early if running as non-root without the capabilities needed
+	if err := core.CheckOwnership(tcs); err != nil {
+		return nil, err
+	}
+
+	// Exit if watch is requested and not supported by backend
+	if gc.Watch && !bc.IsWatchSupported() {
+		return nil, fmt.Errorf("Watch is not supported for backend %s", bc.Type())
+	}
+	for prefix, mbc := range gc.BackendMounts {
+		if gc.Watch && !mbc.IsWatchSupported() {
+			return nil, fmt.Errorf("Watch is not supported for backend %s mounted under %s", mbc.Type(), prefix)
+		}
+	}
+
+	redactKeys, err := regexp.Compile(gc.RedactKeys)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid redact keys expression: %v", err)
+	}
+
+	switch gc.EmptyPrefix {
+	case config.EmptyPrefixFail, config.EmptyPrefixEmpty, config.EmptyPrefixRetry:
+	default:
+		return nil, fmt.Errorf("Invalid empty prefix policy %q", gc.EmptyPrefix)
+	}
+
+	c := &Controller{
+		gc:        gc,
+		bc:        bc,
+		events:    core.NewEventLog(gc.EventLogSize),
+		eventChan: make(chan core.Event, eventBufferSize),
+		changes:   make(chan string, 1),
+		failures:  make(chan error, 1),
+	}
+	c.events.Notify(c.eventChan)
+
+	// Refuse to fight with other instances over the same destinations,
+	// noop runs leave no lock files behind
+	if gc.Lock && !gc.NoOp {
+		if c.locks, err = lockDestinations(tcs); err != nil {
+			return nil, err
+		}
+	}
+
+	// Remove stage files orphaned by previous runs
+	if err := cleanStageFiles(tcs, time.Now().Add(-orphanGracePeriod)); err != nil {
+		log.Warningf("Unable to clean stage files: %v", err)
+	}
+
+	if err := c.setup(tcs, redactKeys); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// setup connects to the backend and creates the templates and processors.
+func (c *Controller) setup(tcs []*config.TemplateConfig, redactKeys *regexp.Regexp) error {
+	gc, bc := c.gc, c.bc
+
+	var err error
+	c.client, c.reloadClient, err = newReloadableStoreClient(gc, bc)
+	if err != nil {
+		return err
+	}
+
+	datasources, err := newDatasources(gc, c.client)
+	if err != nil {
+		return err
+	}
+
+	var acknowledger *core.Acknowledger
+	if gc.AckPrefix != "" {
+		acknowledger = core.NewAcknowledger(c.client, gc.AckPrefix, gc.NodeName)
+	}
+
+	verifier, err := newVerifier(gc)
+	if err != nil {
+		return err
+	}
+	configVerifier, err := newConfigVerifier(gc)
+	if err != nil {
+		return err
+	}
+	custom, err := loadCustomFuncs(gc)
+	if err != nil {
+		return err
+	}
+
+	limiter := core.NewRenderLimiter(gc.MaxParallel)
+	stdouts := 0
+	for _, tc := range tcs {
+		if tc.IsStdout() {
+			stdouts++
+		}
+	}
+	c.templates = make([]*core.Template, 0, len(tcs))
+	groups := make(map[string][]*core.Template)
+	for _, tc := range tcs {
+		if tc.Advisory && tc.Group != "" {
+			log.Warningf("%s is advisory, rendering it on its own instead of in group %s", tc.Dest, tc.Group)
+			tc.Group = ""
+		}
+		template := core.NewTemplate(tc, gc.NoOp, gc.KeepStageFile, true)
+		template.SetDatasources(datasources)
+		template.SetAcknowledger(acknowledger)
+		template.SetVerifier(verifier)
+		if configVerifier != nil {
+			template.SetSourceVerifier(configVerifier)
+		}
+		if err := setTemplateFuncs(template, gc, custom); err != nil {
+			return err
+		}
+		if gc.FuncMetrics {
+			template.Instrument(core.NewFuncProfiler())
+		}
+		template.SetChecksumFile(gc.ChecksumFile)
+		template.SetRecordDiffs(gc.AdminListen != "", redactKeys)
+		template.SetLogDiffs(gc.Diff, gc.DiffRedact)
+		template.SetEventLog(c.events)
+		template.SetReloadRetry(gc.ReloadRetries, gc.ReloadDelay, gc.ReloadMaxDelay)
+		template.SetCheckCache(gc.CheckCacheSize)
+		template.SetRenderTimeout(gc.RenderTimeout)
+		template.SetLogFields(log.Fields{"backend": string(bc.Type())})
+		template.SetEmptyPrefixPolicy(gc.EmptyPrefix, gc.EmptyRetries, string(bc.Type()))
+		if !tc.Advisory {
+			template.SetFailureNotifier(c.failures)
+		}
+		template.SetRenderLimiter(limiter)
+		template.SetOutputHeader(stdouts > 1)
+		if gc.OnceOnChange || gc.Exec != "" {
+			template.SetChangeNotifier(c.changes)
+		}
+		c.templates = append(c.templates, template)
+		if tc.Group != "" {
+			groups[tc.Group] = append(groups[tc.Group], template)
+		}
+	}
+
+	// templates of a group are rendered together in a transaction
+	c.processors = make([]core.Processor, 0, len(c.templates))
+	transactions := make(map[string]*core.Transaction)
+	for _, template := range c.templates {
+		group := template.Config().Group
+		if group == "" {
+			processor := core.NewOnDemandProcessor(template, c.client)
+			c.processors = append(c.processors, processor)
+			c.units = append(c.units, processor)
+			c.names = append(c.names, template.Config().Dest)
+			c.advisory = append(c.advisory, template.Config().Advisory)
+			continue
+		}
+		tx, ok := transactions[group]
+		if !ok {
+			tx = core.NewTransaction(group, groups[group], c.client)
+			transactions[group] = tx
+			c.units = append(c.units, tx)
+			c.names = append(c.names, "group "+group)
+			c.advisory = append(c.advisory, false)
+		}
+		c.processors = append(c.processors, tx)
+	}
+	log.Debugf("Processing order: %s", strings.Join(c.names, ", "))
+	return nil
+}
+
+// RenderOnce renders every template once, in order, failing if any of them
+// but the advisory ones failed. The inventory is written afterwards, if
+// requested.
+func (c *Controller) RenderOnce() error {
+	failed := 0
+	for i, err := range c.runCycle() {
+		if err == nil {
+			continue
+		}
+		if c.advisory[i] {
+			log.Warningf("%s (advisory): %v", c.names[i], err)
+			continue
+		}
+		log.Errorf("%s: %v", c.names[i], err)
+		failed++
+	}
+	if c.gc.FuncMetrics {
+		c.logFuncStats()
+	}
+	if c.gc.InventoryFile != "" {
+		if err := c.writeInventory(); err != nil {
+			log.Error(err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d templates failed", failed, len(c.units))
+	}
+	return nil
+}
+
+// logFuncStats logs the template functions each template spent time in.
+func (c *Controller) logFuncStats() {
+	for _, template := range c.templates {
+		stats := template.Status().Funcs
+		if len(stats) == 0 {
+			continue
+		}
+		calls := make([]string, len(stats))
+		for i, s := range stats {
+			calls[i] = fmt.Sprintf("%s %d calls %v", s.Name, s.Calls, s.Duration)
+		}
+		log.Infof("Template functions of %s: %s", template.Config().Dest, strings.Join(calls, ", "))
+	}
+}
+
+// runCycle runs every unit once, from a snapshot of the data of all of the
+// templates if requested, and returns their errors in the same order.
+func (c *Controller) runCycle() []error {
+	if !c.gc.SnapshotCycles {
+		return runOnetime(c.units, c.workers())
+	}
+
+	cycle, err := core.NewCycle(c.client, c.templates)
+	if err != nil {
+		errs := make([]error, len(c.units))
+		for i := range errs {
+			errs[i] = fmt.Errorf("Unable to read the data of the cycle: %v", err)
+		}
+		return errs
+	}
+	units := make([]core.Processor, len(c.units))
+	for i, unit := range c.units {
+		units[i] = core.WithCycle(unit, cycle)
+	}
+	return runOnetime(units, c.workers())
+}
+
+// workers returns how many units of a cycle are run at once: the workers
+// requested, or as many as the renders allowed to run in parallel.
+func (c *Controller) workers() int {
+	if c.gc.MaxParallel > c.gc.Workers {
+		return c.gc.MaxParallel
+	}
+	return c.gc.Workers
+}
+
+// Start keeps the destinations in sync until ctx is done: templates are
+// rendered every resync interval and, in watch mode, whenever their keys
+// change. Call RenderOnce first for them to be rendered right away.
+//
+// Once ctx is done watches are stopped, the renders in flight are waited
+// for, every template is rendered a last time if requested, and new
+// renders are blocked before returning. The same happens, and an error is
+// returned, as soon as a template failed to render as many times in a row
+// as its maximum consecutive failures.
+func (c *Controller) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	resynced := c.units
+	if c.gc.SnapshotCycles {
+		// every unit is resynced at once from the same snapshot
+		resynced = []core.Processor{processorFunc(c.RenderOnce)}
+	}
+	for _, unit := range resynced {
+		processor := core.NewIntervalProcessor(c.gc.ResyncInterval, unit, logError)
+		processor.SetSkipFirstRun(true)
+		processor.SetSplay(c.gc.ResyncSplay)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor.Run(ctx)
+		}()
+	}
+	if c.gc.InventoryFile != "" && !c.gc.SnapshotCycles {
+		// the inventory is written along with snapshot cycles otherwise
+		processor := core.NewIntervalProcessor(c.gc.ResyncInterval, processorFunc(c.writeInventory), logError)
+		processor.SetSkipFirstRun(true)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor.Run(ctx)
+		}()
+	}
+	if c.gc.Watch {
+		for i, template := range c.templates {
+			var processor *core.WatchProcessor
+			if tx, ok := c.processors[i].(*core.Transaction); ok {
+				processor = core.NewGroupWatchProcessor(template, c.client, tx, logError)
+			} else {
+				processor = core.NewWatchProcessor(template, c.client, logError)
+			}
+			processor.SetEphemeralCheck(c.gc.EphemeralCheck)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				processor.Run(ctx)
+			}()
+		}
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-c.failures:
+		cancel()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	waitOrKill(stopped)
+
+	if c.gc.RenderOnExit {
+		log.Infof("Rendering every template a last time before exiting")
+		if err := c.RenderOnce(); err != nil {
+			log.Error(err)
+		}
+	}
+	c.drain()
+	return err
+}
+
+// logError reports the errors of the processors run on their own.
+func logError(err error) {
+	log.Error(err)
+}
+
+// processorFunc adapts a function to the Processor interface.
+type processorFunc func() error
+
+func (f processorFunc) Run() error {
+	return f()
+}
+
+// drain lets the renders in flight finish and blocks new ones.
+func (c *Controller) drain() {
+	drained := make(chan struct{})
+	go func() {
+		for _, template := range c.templates {
+			template.Drain()
+		}
+		close(drained)
+	}()
+	waitOrKill(drained)
+}
+
+// waitOrKill waits for done to be closed, killing the commands of the
+// renders in flight if that takes longer than the grace period.
+func waitOrKill(done <-chan struct{}) {
+	grace := time.NewTimer(shutdownGracePeriod)
+	defer grace.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-grace.C:
+			log.Warningf("Renders still running after %v, killing their commands", shutdownGracePeriod)
+			core.KillCommands()
+			grace.Reset(time.Second)
+		}
+	}
+}
+
+// Events returns the channel the render, reload and error events of the
+// templates are sent to. Events are dropped while it is full.
+func (c *Controller) Events() <-chan core.Event {
+	return c.eventChan
+}
+
+// Reload connects to the backends again if their configuration, once
+// env:// and file:// values are resolved anew, changed.
+func (c *Controller) Reload() error {
+	return c.reloadClient()
+}
+
+// Close releases the destination locks and closes the backend client.
+func (c *Controller) Close() {
+	for _, l := range c.locks {
+		l.Unlock()
+	}
+	c.locks = nil
+	if c.client != nil {
+		c.client.Close()
+	}
+}
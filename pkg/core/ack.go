@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/json"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/backends"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// Ack is the document written back to the backend after a render.
+type Ack struct {
+	Node      string    `json:"node"`
+	Template  string    `json:"template"`
+	Dest      string    `json:"dest"`
+	Index     uint64    `json:"index"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Acknowledger writes render acknowledgments under
+// <prefix>/<node>/<dest> so that publishers can verify that a change
+// propagated across the fleet. A template is only acknowledged again when
+// the index of the data it was rendered from changes.
+type Acknowledger struct {
+	client store.Store
+	prefix string
+	node   string
+
+	mutex sync.Mutex
+	acked map[string]uint64
+}
+
+func NewAcknowledger(client store.Store, prefix, node string) *Acknowledger {
+	return &Acknowledger{
+		client: client,
+		prefix: prefix,
+		node:   node,
+		acked:  make(map[string]uint64),
+	}
+}
+
+// Ack acknowledges that t has been rendered from data at index.
+func (a *Acknowledger) Ack(t *Template, index uint64) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if last, ok := a.acked[t.config.Dest]; ok && last == index {
+		return nil
+	}
+
+	value, err := json.Marshal(&Ack{
+		Node:      a.node,
+		Template:  t.config.Src,
+		Dest:      t.config.Dest,
+		Index:     index,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	key := path.Join("/", a.prefix, a.node, t.config.Dest)
+	if err := a.client.Put(backends.StoreKey(key), value, nil); err != nil {
+		return err
+	}
+
+	log.Debugf("Acknowledged %s at index %d in %s", t.config.Dest, index, key)
+	a.acked[t.config.Dest] = index
+	return nil
+}
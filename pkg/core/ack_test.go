@@ -0,0 +1,139 @@
+package core
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// watchStore is an in-memory store whose tree watches fire on every write
+// under their directory, like those of the real backends.
+type watchStore struct {
+	store.Store
+
+	mutex    sync.Mutex
+	pairs    map[string]*store.KVPair
+	index    uint64
+	watchers map[string][]chan []*store.KVPair
+}
+
+func newWatchStore() *watchStore {
+	return &watchStore{
+		pairs:    make(map[string]*store.KVPair),
+		watchers: make(map[string][]chan []*store.KVPair),
+	}
+}
+
+func isUnder(dir, key string) bool {
+	return dir == "/" || key == dir || strings.HasPrefix(key, dir+"/")
+}
+
+func (s *watchStore) list(dir string) []*store.KVPair {
+	pairs := make([]*store.KVPair, 0)
+	for key, pair := range s.pairs {
+		if isUnder(dir, key) {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func (s *watchStore) Get(key string) (*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pair, ok := s.pairs[path.Join("/", key)]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return pair, nil
+}
+
+func (s *watchStore) List(dir string) ([]*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pairs := s.list(path.Join("/", dir))
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return pairs, nil
+}
+
+func (s *watchStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key = path.Join("/", key)
+	s.index++
+	s.pairs[key] = &store.KVPair{Key: key, Value: value, LastIndex: s.index}
+	for dir, watchers := range s.watchers {
+		if !isUnder(dir, key) {
+			continue
+		}
+		for _, watcher := range watchers {
+			select {
+			case watcher <- s.list(dir):
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+func (s *watchStore) WatchTree(dir string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dir = path.Join("/", dir)
+	watcher := make(chan []*store.KVPair, 1)
+	s.watchers[dir] = append(s.watchers[dir], watcher)
+	return watcher, nil
+}
+
+func TestAckDoesNotRenderAgain(t *testing.T) {
+	client := newWatchStore()
+	client.Put("/app/db/host", []byte("db1"), nil)
+
+	tc := config.NewTemplateConfig()
+	tc.Src = "test.conf.tmpl"
+	tc.Dest = "/etc/app.conf"
+	tc.Prefix = "/app"
+	tc.Keys = []string{"/db"}
+	template := NewTemplate(tc, false, false, false)
+
+	const ackPrefix = "/app/acks"
+	if tc.Watches(ackPrefix) {
+		t.Fatalf("Expected %s not to be watched by %s", ackPrefix, tc.Dest)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	changes, err := template.watchPairs(client, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acknowledger := NewAcknowledger(client, ackPrefix, "node1")
+	if err := acknowledger.Ack(template, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get("/app/acks/node1/etc/app.conf"); err != nil {
+		t.Fatalf("Expected the acknowledgment to be written: %v", err)
+	}
+
+	select {
+	case <-changes:
+		t.Errorf("Expected the acknowledgment not to render %s again", tc.Dest)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a change of the template keys still renders it
+	client.Put("/app/db/host", []byte("db2"), nil)
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Errorf("Expected a change of %s to render it again", tc.Dest)
+	}
+}
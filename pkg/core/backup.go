@@ -0,0 +1,94 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// backup keeps a copy of the destination about to be overwritten, if the
+// template keeps backups and it exists.
+func (t *Template) backup() error {
+	if t.config.Backups <= 0 || !util.IsFileExist(t.config.Dest) {
+		return nil
+	}
+	t.logger.Debugf("Backing %s up", t.config.Dest)
+	if err := util.BackupFile(t.config.Dest, t.config.BackupSuffix, t.config.Backups); err != nil {
+		return fmt.Errorf("Unable to back %s up: %v", t.config.Dest, err)
+	}
+	return nil
+}
+
+// snapshot keeps a copy of the destination about to be overwritten, for it
+// to be restored if its reload fails, if the template rolls back
+// automatically. It returns "" if there is nothing to keep.
+func (t *Template) snapshot() (string, error) {
+	if !t.config.AutoRollback || !t.hasReload() || !util.IsFileExist(t.config.Dest) {
+		return "", nil
+	}
+	snapshot, err := util.SnapshotFile(t.config.Dest)
+	if err != nil {
+		return "", fmt.Errorf("Unable to keep a copy of %s: %v", t.config.Dest, err)
+	}
+	return snapshot, nil
+}
+
+// rollbackReload restores the destination kept in snapshot after its reload
+// failed with reloadErr, and reloads it again. It returns the error to
+// report.
+func (t *Template) rollbackReload(snapshot string, reloadErr error) error {
+	t.logger.Warningf("Reload of %s failed, rolling it back: %v", t.config.Dest, reloadErr)
+	if err := util.RestoreSnapshot(snapshot, t.config.Dest); err != nil {
+		return fmt.Errorf("Reload of %s failed: %v, and so did rolling it back: %v", t.config.Dest, reloadErr, err)
+	}
+	util.RecordConfig(t.config.Dest, "")
+	t.events.Add(EventRender, t.config.Dest, "rolled back")
+	if t.checksumFile {
+		data, err := ioutil.ReadFile(t.config.Dest)
+		if err != nil {
+			return err
+		}
+		if err := util.WriteChecksumFile(t.config.Dest, fmt.Sprintf("%x", sha256.Sum256(data))); err != nil {
+			return fmt.Errorf("Unable to write checksum of %s: %v", t.config.Dest, err)
+		}
+	}
+
+	if err := t.reload(); err != nil {
+		return fmt.Errorf("Reload of %s failed: %v, and failed again once rolled back: %v", t.config.Dest, reloadErr, err)
+	}
+	return fmt.Errorf("Reload of %s failed, rolled back to its previous contents: %v", t.config.Dest, reloadErr)
+}
+
+// Rollback restores the latest backup of the destination and reloads it.
+// Rolling back again restores the backup before it.
+func (t *Template) Rollback() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.config.Backups <= 0 {
+		return fmt.Errorf("%s keeps no backups", t.config.Dest)
+	}
+	if !util.IsFileExist(util.BackupFileName(t.config.Dest, t.config.BackupSuffix, 1)) {
+		return fmt.Errorf("%s has no backup left", t.config.Dest)
+	}
+	if t.doNoOp {
+		t.logger.Warningf("Noop mode enabled. %s would be restored from %s", t.config.Dest,
+			util.BackupFileName(t.config.Dest, t.config.BackupSuffix, 1))
+		return nil
+	}
+
+	t.startWatchdog()
+	restored, err := util.RestoreBackup(t.config.Dest, t.config.BackupSuffix)
+	if err != nil {
+		return fmt.Errorf("Unable to restore %s: %v", t.config.Dest, err)
+	}
+	t.logger.Infof("Restored %s from %s", t.config.Dest, restored)
+	t.events.Add(EventRender, t.config.Dest, "rolled back")
+
+	if t.hasReload() {
+		return t.reload()
+	}
+	return nil
+}
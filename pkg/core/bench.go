@@ -0,0 +1,67 @@
+package core
+
+import (
+	"io/ioutil"
+	"runtime"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// BenchResult summarizes repeated renders of a template.
+type BenchResult struct {
+	Iterations int
+	Total      time.Duration
+	Min        time.Duration
+	Max        time.Duration
+	Allocs     uint64
+	Bytes      uint64
+}
+
+// Mean returns the average render time.
+func (r *BenchResult) Mean() time.Duration {
+	if r.Iterations == 0 {
+		return 0
+	}
+	return r.Total / time.Duration(r.Iterations)
+}
+
+// Bench renders t n times against a single snapshot of the data under its
+// prefix, without touching the destination.
+func Bench(t *Template, client store.Store, n int) (*BenchResult, error) {
+	pairs, err := t.listPairs(client)
+	if err != nil {
+		return nil, err
+	}
+	kvs, meta := mapKVPairs(pairs)
+
+	result := &BenchResult{Iterations: n}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		if err := t.Execute(ioutil.Discard, kvs, meta); err != nil {
+			return nil, err
+		}
+		elapsed := time.Since(start)
+
+		result.Total += elapsed
+		if i == 0 || elapsed < result.Min {
+			result.Min = elapsed
+		}
+		if elapsed > result.Max {
+			result.Max = elapsed
+		}
+	}
+
+	runtime.ReadMemStats(&after)
+	if n > 0 {
+		result.Allocs = (after.Mallocs - before.Mallocs) / uint64(n)
+		result.Bytes = (after.TotalAlloc - before.TotalAlloc) / uint64(n)
+	}
+
+	return result, nil
+}
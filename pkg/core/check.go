@@ -0,0 +1,57 @@
+package core
+
+import (
+	"sync"
+)
+
+// checkCache remembers the digests of the most recent contents accepted by
+// the check command, so identical candidates are not validated again.
+type checkCache struct {
+	mutex   sync.Mutex
+	size    int
+	digests map[string]bool
+	order   []string
+}
+
+func newCheckCache(size int) *checkCache {
+	if size <= 0 {
+		return nil
+	}
+	return &checkCache{size: size, digests: make(map[string]bool, size)}
+}
+
+// SetCheckCache sets how many contents accepted by the check command are
+// remembered. Zero disables the cache.
+func (t *Template) SetCheckCache(size int) {
+	t.checkCache = newCheckCache(size)
+}
+
+// has reports whether the content with digest was accepted before by cmd.
+func (c *checkCache) has(cmd, digest string) bool {
+	if c == nil || digest == "" {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.digests[cmd+"\x00"+digest]
+}
+
+// add records the content with digest as accepted by cmd, evicting the
+// oldest entry if the cache is full.
+func (c *checkCache) add(cmd, digest string) {
+	if c == nil || digest == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := cmd + "\x00" + digest
+	if c.digests[key] {
+		return
+	}
+	if len(c.order) >= c.size {
+		delete(c.digests, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.digests[key] = true
+	c.order = append(c.order, key)
+}
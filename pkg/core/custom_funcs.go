@@ -0,0 +1,222 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// shellFuncTimeout bounds how long the command of a shell function may take.
+const shellFuncTimeout = 10 * time.Second
+
+// LoadFuncPlugin opens the Go plugin at path, built with
+// 'go build -buildmode=plugin', and returns the template functions of its
+// exported variable:
+//
+//	var Funcs = map[string]interface{}{...}
+//
+// A non-nil verifier refuses plugins without a valid signature. The plugin
+// is then read once and opened from a private copy of what was verified, so
+// that replacing it in between doesn't get unsigned code loaded.
+func LoadFuncPlugin(path string, verifier *FileVerifier) (map[string]interface{}, error) {
+	if verifier == nil {
+		return openFuncPlugin(path, path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifier.Verify(path, data); err != nil {
+		return nil, err
+	}
+
+	// the Go runtime loads a plugin only once per process, copies of those
+	// already loaded are reused instead of failing to open them again
+	digest := sha256.Sum256(data)
+	signedPluginsMutex.Lock()
+	defer signedPluginsMutex.Unlock()
+	if funcs, ok := signedPlugins[digest]; ok {
+		return funcs, nil
+	}
+
+	dir, err := ioutil.TempDir("", "renderizr-plugin")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	copied := filepath.Join(dir, filepath.Base(path))
+	if err := ioutil.WriteFile(copied, data, 0500); err != nil {
+		return nil, err
+	}
+	funcs, err := openFuncPlugin(path, copied)
+	if err != nil {
+		return nil, err
+	}
+	signedPlugins[digest] = funcs
+	return funcs, nil
+}
+
+var (
+	signedPluginsMutex sync.Mutex
+	signedPlugins      = make(map[[sha256.Size]byte]map[string]interface{})
+)
+
+// openFuncPlugin opens the plugin file, a copy of name or name itself.
+func openFuncPlugin(name, file string) (map[string]interface{}, error) {
+	p, err := plugin.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("Plugin %s: %v", name, err)
+	}
+	sym, err := p.Lookup("Funcs")
+	if err != nil {
+		return nil, fmt.Errorf("Plugin %s: %v", name, err)
+	}
+	funcs, ok := sym.(*map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Plugin %s: Funcs is a %T instead of a map[string]interface{}", name, sym)
+	}
+	if err := checkFuncs(*funcs); err != nil {
+		return nil, fmt.Errorf("Plugin %s: %v", name, err)
+	}
+	return *funcs, nil
+}
+
+// LoadShellFuncs reads the TOML file at path mapping function names to the
+// commands they run, for SetShellFuncs:
+//
+//	vaultRead = "vault read -field=value \"$1\""
+//
+// A non-nil verifier refuses files without a valid signature.
+func LoadShellFuncs(path string, verifier *FileVerifier) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if verifier != nil {
+		if err := verifier.Verify(path, data); err != nil {
+			return nil, err
+		}
+	}
+	var commands map[string]string
+	if _, err := toml.Decode(string(data), &commands); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return commands, nil
+}
+
+// SetShellFuncs makes functions running commands available, those named
+// like the built-in ones are left out. Commands are run through the template
+// shell with the function arguments as positional parameters, or without a
+// shell with them appended to their own arguments, and their output without
+// the trailing newline is returned.
+func (t *Template) SetShellFuncs(commands map[string]string) error {
+	funcs := make(map[string]interface{}, len(commands))
+	for name, cmd := range commands {
+		argv, err := shellFuncArgs(t.config.Shell, name, cmd)
+		if err != nil {
+			return fmt.Errorf("Shell function %s: %v", name, err)
+		}
+		funcs[name] = shellFunc(name, argv)
+	}
+	t.SetCustomFuncs(funcs)
+	return nil
+}
+
+// shellFuncArgs returns the arguments running cmd, the command of the
+// function name, through shell, before those of the function.
+func shellFuncArgs(shell, name, cmd string) ([]string, error) {
+	if shell == config.ShellNone {
+		args, err := util.SplitArgs(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, errors.New("Empty command")
+		}
+		return args, nil
+	}
+	argv := strings.Fields(shell)
+	if len(argv) == 0 {
+		argv = []string{"/bin/sh", "-c"}
+	}
+	// the function name is $0 for POSIX shells
+	return append(argv, cmd, name), nil
+}
+
+// shellFunc returns the template function named name running argv followed
+// by its arguments.
+func shellFunc(name string, argv []string) func(...interface{}) (string, error) {
+	return func(args ...interface{}) (string, error) {
+		argv := append([]string(nil), argv...)
+		for _, arg := range args {
+			argv = append(argv, fmt.Sprint(arg))
+		}
+		c := exec.Command(argv[0], argv[1:]...)
+		var stdout, stderr bytes.Buffer
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+
+		if err := startCommand(c); err != nil {
+			return "", err
+		}
+		defer finishCommand(c)
+		done := make(chan error, 1)
+		go func() {
+			done <- waitCommand(c)
+		}()
+
+		timer := time.NewTimer(shellFuncTimeout)
+		defer timer.Stop()
+		select {
+		case err := <-done:
+			if err != nil {
+				return "", fmt.Errorf("%s: %v: %q", name, err, stderr.String())
+			}
+			return strings.TrimSuffix(stdout.String(), "\n"), nil
+		case <-timer.C:
+			killProcessGroup(c)
+			<-done
+			return "", fmt.Errorf("%s: killed after %v", name, shellFuncTimeout)
+		}
+	}
+}
+
+// checkFuncs returns an error if any of funcs is not usable by templates,
+// instead of letting text/template panic on it.
+func checkFuncs(funcs map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	template.New("").Funcs(funcs)
+	return nil
+}
+
+// SetCustomFuncs makes the user-defined functions available, those named
+// like the built-in ones are left out.
+func (t *Template) SetCustomFuncs(funcs map[string]interface{}) {
+	for name, fn := range funcs {
+		if _, ok := t.funcMap[name]; ok {
+			t.logger.Warningf("Custom function %s is named like a built-in one, ignoring it", name)
+			continue
+		}
+		t.funcMap[name] = fn
+	}
+	t.compiled = nil
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// Cycle is a snapshot of the data of a set of templates, for them to render
+// the same version of it. Every directory is listed from the backend once,
+// those under another one are served from the listing of the latter, so
+// templates reading overlapping prefixes cannot see different versions in
+// the middle of an update. Reads outside of the snapshot go to the backend.
+type Cycle struct {
+	store.Store
+	// lists are the pairs under each directory listed
+	lists map[string][]*store.KVPair
+}
+
+// NewCycle lists the data of templates from client.
+func NewCycle(client store.Store, templates []*Template) (*Cycle, error) {
+	dirs := make([]string, 0, len(templates))
+	for _, t := range templates {
+		for _, dir := range t.keyDirectories() {
+			dirs = append(dirs, path.Join("/", dir))
+		}
+	}
+	sort.Strings(dirs)
+
+	c := &Cycle{Store: client, lists: make(map[string][]*store.KVPair)}
+	for _, dir := range dirs {
+		if _, ok := c.covering(dir); ok {
+			continue
+		}
+		pairs, err := listKey(client, dir)
+		if err != nil {
+			return nil, err
+		}
+		c.lists[dir] = pairs
+	}
+	return c, nil
+}
+
+// covering returns the directory listed holding dir, if any.
+func (c *Cycle) covering(dir string) (string, bool) {
+	for d := dir; ; d = path.Dir(d) {
+		if _, ok := c.lists[d]; ok {
+			return d, true
+		}
+		if d == "/" {
+			return "", false
+		}
+	}
+}
+
+// under returns the pairs listed at or under dir.
+func (c *Cycle) under(listed, dir string) []*store.KVPair {
+	pairs := make([]*store.KVPair, 0)
+	for _, pair := range c.lists[listed] {
+		key := path.Join("/", pair.Key)
+		if key == dir || dir == "/" || strings.HasPrefix(key, dir+"/") {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// List returns the pairs under dir from the snapshot if it holds them.
+func (c *Cycle) List(dir string) ([]*store.KVPair, error) {
+	dir = path.Join("/", dir)
+	listed, ok := c.covering(dir)
+	if !ok {
+		return c.Store.List(dir)
+	}
+	pairs := c.under(listed, dir)
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return pairs, nil
+}
+
+// Get returns the pair of key from the snapshot if it holds it.
+func (c *Cycle) Get(key string) (*store.KVPair, error) {
+	key = path.Join("/", key)
+	listed, ok := c.covering(key)
+	if !ok {
+		return c.Store.Get(key)
+	}
+	for _, pair := range c.lists[listed] {
+		if path.Join("/", pair.Key) == key {
+			return pair, nil
+		}
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+// WithCycle returns a processor running processor against the data of
+// cycle instead of fetching its own.
+func WithCycle(processor Processor, cycle *Cycle) Processor {
+	return cycleProcessor{processor: processor, cycle: cycle}
+}
+
+type cycleProcessor struct {
+	processor Processor
+	cycle     *Cycle
+}
+
+func (p cycleProcessor) Run() error {
+	switch processor := p.processor.(type) {
+	case *OnDemandProcessor:
+		return processor.run(p.cycle)
+	case *Transaction:
+		return processor.run(p.cycle)
+	}
+	return p.processor.Run()
+}
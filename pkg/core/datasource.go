@@ -0,0 +1,329 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/kelseyhightower/memkv"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// datasourceTimeout bounds the HTTP requests reading datasources.
+	datasourceTimeout = 30 * time.Second
+	// maxFetchedKeys bounds the number of keys read by Fetch kept cached.
+	maxFetchedKeys = 1024
+)
+
+// Datasources resolves named data sources declared by the user at render
+// time. Supported URIs are http(s)://, file://, env:NAME and kv:/key, the
+// latter being read from the configured backend. Results are cached for
+// ttl and shared by all templates.
+type Datasources struct {
+	sources    map[string]*url.URL
+	client     store.Store
+	httpClient *http.Client
+	ttl        time.Duration
+
+	// fetchAllowList are the key prefixes Fetch may read from
+	fetchAllowList []string
+
+	cache   *datasourceCache
+	fetched *datasourceCache
+}
+
+type cachedDatasource struct {
+	data    []byte
+	expires time.Time
+	// missing records keys found not to exist
+	missing bool
+}
+
+// datasourceCache caches datasources for a while, reading each of them
+// once at a time however many templates ask for it. Reads of different
+// datasources do not wait for each other.
+type datasourceCache struct {
+	// size bounds the number of entries, zero not to
+	size int
+
+	mutex   sync.Mutex
+	entries map[string]cachedDatasource
+	loading map[string]*datasourceLoad
+}
+
+// datasourceLoad is a read in progress, done once it is closed.
+type datasourceLoad struct {
+	done chan struct{}
+	c    cachedDatasource
+	err  error
+}
+
+func newDatasourceCache(size int) *datasourceCache {
+	return &datasourceCache{
+		size:    size,
+		entries: make(map[string]cachedDatasource),
+		loading: make(map[string]*datasourceLoad),
+	}
+}
+
+// get returns the cached entry of key, calling load to read it if it is
+// missing or expired, or waiting for the read in progress if any.
+func (dc *datasourceCache) get(key string, load func() (cachedDatasource, error)) (cachedDatasource, error) {
+	dc.mutex.Lock()
+	if c, ok := dc.entries[key]; ok && time.Now().Before(c.expires) {
+		dc.mutex.Unlock()
+		return c, nil
+	}
+	if l, ok := dc.loading[key]; ok {
+		dc.mutex.Unlock()
+		<-l.done
+		return l.c, l.err
+	}
+	l := &datasourceLoad{done: make(chan struct{})}
+	dc.loading[key] = l
+	dc.mutex.Unlock()
+
+	l.c, l.err = load()
+
+	dc.mutex.Lock()
+	delete(dc.loading, key)
+	if l.err == nil {
+		dc.evict()
+		dc.entries[key] = l.c
+	}
+	dc.mutex.Unlock()
+	close(l.done)
+	return l.c, l.err
+}
+
+// evict makes room for a new entry, dropping the expired ones and then
+// those expiring first.
+func (dc *datasourceCache) evict() {
+	if dc.size <= 0 || len(dc.entries) < dc.size {
+		return
+	}
+	now := time.Now()
+	for key, c := range dc.entries {
+		if !now.Before(c.expires) {
+			delete(dc.entries, key)
+		}
+	}
+	for len(dc.entries) >= dc.size {
+		var first string
+		var expires time.Time
+		for key, c := range dc.entries {
+			if expires.IsZero() || c.expires.Before(expires) {
+				first, expires = key, c.expires
+			}
+		}
+		delete(dc.entries, first)
+	}
+}
+
+// NewDatasources validates the name to URI definitions in defs.
+func NewDatasources(defs map[string]string, client store.Store, ttl time.Duration) (*Datasources, error) {
+	sources := make(map[string]*url.URL, len(defs))
+	for name, uri := range defs {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid datasource %s: %v", name, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "file", "env", "kv":
+		default:
+			return nil, fmt.Errorf("Unsupported scheme %q for datasource %s", u.Scheme, name)
+		}
+		sources[name] = u
+	}
+
+	return &Datasources{
+		sources:    sources,
+		client:     client,
+		httpClient: &http.Client{Timeout: datasourceTimeout},
+		ttl:        ttl,
+		cache:      newDatasourceCache(0),
+		fetched:    newDatasourceCache(maxFetchedKeys),
+	}, nil
+}
+
+// SetFetchAllowList sets the key prefixes Fetch may read from, none by
+// default.
+func (d *Datasources) SetFetchAllowList(prefixes []string) {
+	d.fetchAllowList = make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		d.fetchAllowList = append(d.fetchAllowList, path.Join("/", prefix))
+	}
+}
+
+// Fetch reads key from the backend on demand, along with whether it exists,
+// caching the result for the datasource ttl, up to maxFetchedKeys of them.
+// The key must be under one of the prefixes allowed with SetFetchAllowList.
+func (d *Datasources) Fetch(key string) ([]byte, bool, error) {
+	key = path.Join("/", key)
+	allowed := false
+	for _, prefix := range d.fetchAllowList {
+		if prefix == "/" || key == prefix || strings.HasPrefix(key, prefix+"/") {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, false, fmt.Errorf("Fetching %s is not allowed, see --fetch-allow", key)
+	}
+	if d.client == nil {
+		return nil, false, fmt.Errorf("Unable to fetch %s: no backend available", key)
+	}
+
+	c, err := d.fetched.get(key, func() (cachedDatasource, error) {
+		pair, err := d.client.Get(key)
+		c := cachedDatasource{expires: time.Now().Add(d.ttl)}
+		switch {
+		case err == store.ErrKeyNotFound:
+			c.missing = true
+		case err != nil:
+			return c, fmt.Errorf("Unable to fetch %s: %v", key, err)
+		default:
+			c.data = pair.Value
+		}
+		return c, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return c.data, !c.missing, nil
+}
+
+// Read returns the raw contents of the named datasource.
+func (d *Datasources) Read(name string) ([]byte, error) {
+	u, ok := d.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("Undefined datasource %s", name)
+	}
+
+	c, err := d.cache.get(name, func() (cachedDatasource, error) {
+		data, err := d.fetch(u)
+		if err != nil {
+			return cachedDatasource{}, fmt.Errorf("Unable to read datasource %s: %v", name, err)
+		}
+		return cachedDatasource{data: data, expires: time.Now().Add(d.ttl)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.data, nil
+}
+
+// Parse returns the named datasource decoded as YAML (or JSON).
+func (d *Datasources) Parse(name string) (interface{}, error) {
+	data, err := d.Read(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("Unable to parse datasource %s: %v", name, err)
+	}
+	return stringifyKeys(v), nil
+}
+
+func (d *Datasources) fetch(u *url.URL) ([]byte, error) {
+	switch u.Scheme {
+	case "http", "https":
+		resp, err := d.httpClient.Get(u.String())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	case "file":
+		return ioutil.ReadFile(u.Host + u.Path)
+	case "env":
+		name := u.Opaque
+		if name == "" {
+			name = strings.TrimPrefix(u.Path, "/")
+		}
+		return []byte(os.Getenv(name)), nil
+	case "kv":
+		if d.client == nil {
+			return nil, fmt.Errorf("no backend available")
+		}
+		key := u.Opaque
+		if key == "" {
+			key = u.Path
+		}
+		pair, err := d.client.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		return pair.Value, nil
+	}
+	return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+}
+
+// stringifyKeys converts the map[interface{}]interface{} values produced by
+// the YAML decoder into map[string]interface{}.
+func stringifyKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			m[fmt.Sprint(k)] = stringifyKeys(child)
+		}
+		return m
+	case []interface{}:
+		for i, child := range t {
+			t[i] = stringifyKeys(child)
+		}
+		return t
+	}
+	return v
+}
+
+// getDatasource returns the named datasource decoded as YAML (or JSON).
+func (t *Template) getDatasource(name string) (interface{}, error) {
+	if t.datasources == nil {
+		return nil, fmt.Errorf("Undefined datasource %s", name)
+	}
+	return t.datasources.Parse(name)
+}
+
+// fetchKey returns the value of key read from the backend at render time,
+// outside of the template data, or the optional default value if it does
+// not exist.
+func (t *Template) fetchKey(key string, v ...string) (string, error) {
+	if t.datasources == nil {
+		return "", fmt.Errorf("Fetching %s is not allowed, see --fetch-allow", key)
+	}
+	data, ok, err := t.datasources.Fetch(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		if len(v) > 0 {
+			return v[0], nil
+		}
+		return "", fmt.Errorf("%v: %s", memkv.ErrNotExist, key)
+	}
+	return string(data), nil
+}
+
+// includeDatasource returns the raw contents of the named datasource.
+func (t *Template) includeDatasource(name string) (string, error) {
+	if t.datasources == nil {
+		return "", fmt.Errorf("Undefined datasource %s", name)
+	}
+	data, err := t.datasources.Read(name)
+	return string(data), err
+}
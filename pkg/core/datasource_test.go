@@ -0,0 +1,92 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDatasourcesReadOnce(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			atomic.AddInt32(&requests, 1)
+			<-release
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	d, err := NewDatasources(map[string]string{
+		"slow": server.URL + "/slow",
+		"fast": server.URL + "/fast",
+	}, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := d.Read("slow"); err != nil || string(data) != "/slow" {
+				t.Errorf("Expected /slow, got %q: %v", data, err)
+			}
+		}()
+	}
+
+	// other datasources are read while one is slow
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if data, err := d.Read("fast"); err != nil || string(data) != "/fast" {
+			t.Errorf("Expected /fast, got %q: %v", data, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected fast to be read while slow is being read")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected slow to be requested once, got %d requests", n)
+	}
+}
+
+func TestDatasourcesFetchCacheSize(t *testing.T) {
+	client := newWatchStore()
+	d, err := NewDatasources(nil, client, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetFetchAllowList([]string{"/app"})
+
+	client.Put("/app/key", []byte("value"), nil)
+	if data, ok, err := d.Fetch("/app/key"); err != nil || !ok || string(data) != "value" {
+		t.Errorf("Expected value, got %q (%v): %v", data, ok, err)
+	}
+	if _, ok, err := d.Fetch("/app/missing"); err != nil || ok {
+		t.Errorf("Expected /app/missing not to exist: %v", err)
+	}
+	if _, _, err := d.Fetch("/other/key"); err == nil {
+		t.Errorf("Expected fetching /other/key not to be allowed")
+	}
+
+	for i := 0; i < 2*maxFetchedKeys; i++ {
+		if _, _, err := d.Fetch(fmt.Sprintf("/app/keys/%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(d.fetched.entries); n > maxFetchedKeys {
+		t.Errorf("Expected at most %d fetched keys cached, got %d", maxFetchedKeys, n)
+	}
+}
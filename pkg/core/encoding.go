@@ -0,0 +1,89 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+)
+
+// executeTemplate executes tmpl into w, normalized and converted to the
+// line endings and output encoding of the template.
+func (t *Template) executeTemplate(tmpl executor, w io.Writer) error {
+	plain := t.config.Encoding == "" || t.config.Encoding == config.EncodingUTF8
+	if plain && t.config.LineEndings == "" {
+		return t.executeNormalized(tmpl, w)
+	}
+
+	var buf bytes.Buffer
+	if err := t.executeNormalized(tmpl, &buf); err != nil {
+		return err
+	}
+
+	data := convertLineEndings(t.config.LineEndings, buf.Bytes())
+	data, err := encode(t.config.Encoding, data)
+	if err != nil {
+		return fmt.Errorf("Unable to encode %s as %s: %v", t.config.Dest, t.config.Encoding, err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// convertLineEndings makes every line of data end as requested, leaving it
+// untouched if no line endings are given.
+func convertLineEndings(lineEndings string, data []byte) []byte {
+	switch lineEndings {
+	case config.LineEndingsLF:
+		return bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	case config.LineEndingsCRLF:
+		data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+		return bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+	}
+	return data
+}
+
+// encode converts UTF-8 data into the given encoding.
+func encode(encoding string, data []byte) ([]byte, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("output is not valid UTF-8")
+	}
+
+	switch encoding {
+	case config.EncodingUTF8:
+		return data, nil
+	case config.EncodingUTF8BOM:
+		return append([]byte{0xEF, 0xBB, 0xBF}, data...), nil
+	case config.EncodingUTF16:
+		return append([]byte{0xFF, 0xFE}, encodeUTF16(data, false)...), nil
+	case config.EncodingUTF16LE:
+		return encodeUTF16(data, false), nil
+	case config.EncodingUTF16BE:
+		return encodeUTF16(data, true), nil
+	case config.EncodingLatin1:
+		out := make([]byte, 0, len(data))
+		for offset, r := range string(data) {
+			if r > 0xFF {
+				return nil, fmt.Errorf("character %q at offset %d has no latin-1 representation", r, offset)
+			}
+			out = append(out, byte(r))
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+func encodeUTF16(data []byte, bigEndian bool) []byte {
+	units := utf16.Encode(bytes.Runes(data))
+	out := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		if bigEndian {
+			out = append(out, byte(u>>8), byte(u))
+		} else {
+			out = append(out, byte(u), byte(u>>8))
+		}
+	}
+	return out
+}
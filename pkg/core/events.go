@@ -0,0 +1,102 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Lifecycle event types.
+const (
+	EventRender    = "render"
+	EventReload    = "reload"
+	EventError     = "error"
+	EventReconnect = "reconnect"
+)
+
+// Event is a lifecycle event of a template.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Template string    `json:"template"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// EventLog keeps the most recent events in a fixed size ring buffer, one of
+// size 0 keeps none but still notifies them. A nil EventLog discards every
+// event.
+type EventLog struct {
+	mutex     sync.Mutex
+	events    []Event
+	next      int
+	full      bool
+	listeners []chan<- Event
+}
+
+func NewEventLog(size int) *EventLog {
+	if size < 0 {
+		size = 0
+	}
+	return &EventLog{events: make([]Event, size)}
+}
+
+// Notify sends every event added from now on to c as well. Events c is not
+// ready to receive are dropped instead of blocking the templates.
+func (l *EventLog) Notify(c chan<- Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.listeners = append(l.listeners, c)
+}
+
+// Add records an event, overwriting the oldest one if the log is full.
+func (l *EventLog) Add(typ, template, message string) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	event := Event{
+		Time:     time.Now(),
+		Type:     typ,
+		Template: template,
+		Message:  message,
+	}
+	for _, c := range l.listeners {
+		select {
+		case c <- event:
+		default:
+		}
+	}
+
+	if len(l.events) == 0 {
+		return
+	}
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Events returns the recorded events, oldest first.
+func (l *EventLog) Events() []Event {
+	if l == nil {
+		return []Event{}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.full {
+		return append([]Event{}, l.events[:l.next]...)
+	}
+	events := make([]Event, 0, len(l.events))
+	events = append(events, l.events[l.next:]...)
+	return append(events, l.events[:l.next]...)
+}
+
+// SetEventLog sets where the lifecycle events of the template are recorded.
+func (t *Template) SetEventLog(events *EventLog) {
+	t.events = events
+}
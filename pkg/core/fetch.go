@@ -0,0 +1,200 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// emptyPrefixRetryDelay is the wait before reading an empty prefix again.
+var emptyPrefixRetryDelay = 2 * time.Second
+
+type emptyPrefixPolicy struct {
+	action  string
+	retries int
+	backend string
+}
+
+// SetEmptyPrefixPolicy sets what happens when the template prefix holds no
+// keys: failing, rendering without keys or reading it again up to retries
+// times. backend names the backend in the messages.
+func (t *Template) SetEmptyPrefixPolicy(action string, retries int, backend string) {
+	t.emptyPrefix = emptyPrefixPolicy{action: action, retries: retries, backend: backend}
+}
+
+// keyDirectories returns the backend directories holding the template data:
+// its prefix or, if the template lists its keys, each of them.
+func (t *Template) keyDirectories() []string {
+	if len(t.config.Keys) == 0 {
+		return []string{t.config.Prefix}
+	}
+
+	dirs := make([]string, 0, len(t.config.Keys))
+	for _, key := range t.config.Keys {
+		dirs = append(dirs, path.Join("/", t.config.Prefix, key))
+	}
+	return dirs
+}
+
+// listPairs fetches the template data, applying the empty prefix policy when
+// there is none.
+func (t *Template) listPairs(client store.Store) ([]*store.KVPair, error) {
+	for attempt := 0; ; attempt++ {
+		// retries read the backend again instead of the same snapshot
+		if cycle, ok := client.(*Cycle); ok && attempt > 0 {
+			client = cycle.Store
+		}
+		pairs, err := t.fetchPairs(client)
+		if err != store.ErrKeyNotFound {
+			return pairs, err
+		}
+
+		switch t.emptyPrefix.action {
+		case config.EmptyPrefixEmpty:
+			t.logger.Debugf("Prefix %s holds no keys in %s, rendering %s without them", t.config.Prefix, t.backendName(), t.config.Dest)
+			return []*store.KVPair{}, nil
+		case config.EmptyPrefixRetry:
+			if attempt < t.emptyPrefix.retries {
+				t.logger.Warningf("Prefix %s holds no keys in %s, reading it again in %s", t.config.Prefix, t.backendName(), emptyPrefixRetryDelay)
+				time.Sleep(emptyPrefixRetryDelay)
+				continue
+			}
+		}
+		return nil, fmt.Errorf("Prefix %s holds no keys in %s, it may be missing, empty or compacted (see --empty-prefix): %v", t.config.Prefix, t.backendName(), err)
+	}
+}
+
+func (t *Template) backendName() string {
+	if t.emptyPrefix.backend == "" {
+		return "the backend"
+	}
+	return t.emptyPrefix.backend
+}
+
+// fetchPairs fetches the template data. Templates listing their keys only
+// fetch those, instead of everything under their prefix.
+func (t *Template) fetchPairs(client store.Store) ([]*store.KVPair, error) {
+	if len(t.config.Keys) == 0 {
+		return client.List(t.config.Prefix)
+	}
+
+	seen := make(map[string]bool)
+	pairs := make([]*store.KVPair, 0)
+	for _, dir := range t.keyDirectories() {
+		found, err := listKey(client, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range found {
+			if !seen[pair.Key] {
+				seen[pair.Key] = true
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+// listKey returns the pairs under dir, or dir itself if it is a leaf. Some
+// backends match directories as plain prefixes, so only the pairs actually
+// under dir are kept.
+func listKey(client store.Store, dir string) ([]*store.KVPair, error) {
+	dir = path.Join("/", dir)
+
+	found, err := client.List(dir)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+
+	pairs := make([]*store.KVPair, 0, len(found))
+	for _, pair := range found {
+		key := path.Join("/", pair.Key)
+		if key == dir || dir == "/" || len(key) > len(dir) && key[:len(dir)+1] == dir+"/" {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) > 0 {
+		return pairs, nil
+	}
+
+	pair, err := client.Get(dir)
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []*store.KVPair{pair}, nil
+}
+
+// watchPairs sends the template data whenever it changes. Templates listing
+// their keys watch each of them and fetch all of them again on changes. The
+// channel is closed once stopChan is or if a watch ends, e.g. when the
+// backend closes the connection.
+func (t *Template) watchPairs(client store.Store, stopChan <-chan struct{}) (<-chan []*store.KVPair, error) {
+	if len(t.config.Keys) == 0 {
+		return client.WatchTree(t.config.Prefix, stopChan)
+	}
+
+	// stop ends the watches of every key once one of them ends
+	stop := make(chan struct{})
+	dirs := t.keyDirectories()
+	changes := make(chan struct{}, 1)
+	ended := make(chan struct{}, len(dirs))
+	for _, dir := range dirs {
+		events, err := client.WatchTree(dir, stop)
+		if err != nil {
+			close(stop)
+			return nil, err
+		}
+		go func(events <-chan []*store.KVPair) {
+			for range events {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+			ended <- struct{}{}
+		}(events)
+	}
+
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		defer close(stop)
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ended:
+				return
+			case <-changes:
+			}
+
+			pairs, err := t.fetchPairs(client)
+			if err != nil && err != store.ErrKeyNotFound {
+				continue
+			}
+			select {
+			case out <- pairs:
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+type byKey []*store.KVPair
+
+func (p byKey) Len() int           { return len(p) }
+func (p byKey) Less(i, j int) bool { return p[i].Key < p[j].Key }
+func (p byKey) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// hookTimeout bounds how long a hook command or webhook may take.
+const hookTimeout = 30 * time.Second
+
+// HookEvent is the context a hook is run with. Commands get it as
+// RENDERIZR_* environment variables, webhooks as a JSON body.
+type HookEvent struct {
+	Status string    `json:"status"`
+	Src    string    `json:"src"`
+	Dest   string    `json:"dest"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// runHooks runs the on_success hook if the last render updated the
+// destination, or the on_failure one if it failed. Hooks failing are only
+// logged.
+func (t *Template) runHooks(err error) {
+	hook, event := t.config.OnSuccess, HookEvent{Status: "success"}
+	if err != nil {
+		hook, event = t.config.OnFailure, HookEvent{Status: "failure", Error: err.Error()}
+	} else if !t.updated {
+		return
+	}
+	if hook == "" {
+		return
+	}
+	event.Src = t.config.Src
+	event.Dest = t.config.Dest
+	event.Time = time.Now()
+
+	if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+		err = postHook(hook, event)
+	} else {
+		err = t.execHook(hook, event)
+	}
+	if err != nil {
+		t.logger.Errorf("The %s hook of %s failed: %v", event.Status, t.config.Dest, err)
+	}
+}
+
+// execHook runs cmd through the template shell. The render deadline does
+// not apply, hooks must run after a render timed out too.
+func (t *Template) execHook(cmd string, event HookEvent) error {
+	t.logger.Debugf("Running %s hook %s", event.Status, cmd)
+
+	c, err := t.command(cmd)
+	if err != nil {
+		return err
+	}
+	c.Env = append(os.Environ(),
+		"RENDERIZR_STATUS="+event.Status,
+		"RENDERIZR_SRC="+event.Src,
+		"RENDERIZR_DEST="+event.Dest,
+		"RENDERIZR_ERROR="+event.Error,
+	)
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+
+	if err := startCommand(c); err != nil {
+		return err
+	}
+	defer finishCommand(c)
+	done := make(chan error, 1)
+	go func() {
+		done <- waitCommand(c)
+	}()
+
+	timer := time.NewTimer(hookTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%v: %q", err, output.String())
+		}
+		t.logger.Debugf("%q", output.String())
+		return nil
+	case <-timer.C:
+		killProcessGroup(c)
+		<-done
+		return fmt.Errorf("Killed after %v", hookTimeout)
+	}
+}
+
+// postHook posts event as JSON to url.
+func postHook(url string, event HookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: hookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected response %s", resp.Status)
+	}
+	return nil
+}
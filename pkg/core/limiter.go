@@ -0,0 +1,30 @@
+package core
+
+// RenderLimiter bounds the number of renders running at once, whatever
+// triggered them, across the templates sharing it.
+type RenderLimiter struct {
+	slots chan struct{}
+}
+
+// NewRenderLimiter returns a limiter letting n renders run at once, or nil,
+// which does not limit them, if n is not positive.
+func NewRenderLimiter(n int) *RenderLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &RenderLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a render slot to be available.
+func (l *RenderLimiter) acquire() {
+	if l != nil {
+		l.slots <- struct{}{}
+	}
+}
+
+// release frees the slot taken by acquire.
+func (l *RenderLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
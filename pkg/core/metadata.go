@@ -0,0 +1,36 @@
+package core
+
+import (
+	"errors"
+)
+
+var errNoMetadata = errors.New("no metadata available for key")
+
+// KeyMetadata describes the backend state of a key at the time it was read.
+// Only the fields exposed by the backend client are populated, for the
+// libkv stores that is the last modification index.
+type KeyMetadata struct {
+	Key         string
+	ModifyIndex uint64
+}
+
+// getMetadata returns the metadata for key, as seen in the last render.
+func (t *Template) getMetadata(key string) (KeyMetadata, error) {
+	m, ok := t.meta[key]
+	if !ok {
+		return KeyMetadata{}, errNoMetadata
+	}
+	return m, nil
+}
+
+// getLastIndex returns the highest modification index among all the keys,
+// it can be used as a version of the whole data set.
+func (t *Template) getLastIndex() uint64 {
+	var index uint64
+	for _, m := range t.meta {
+		if m.ModifyIndex > index {
+			index = m.ModifyIndex
+		}
+	}
+	return index
+}
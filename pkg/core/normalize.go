@@ -0,0 +1,72 @@
+package core
+
+import (
+	"io"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+)
+
+// normalizes reports whether the template applies the given normalization.
+func (t *Template) normalizes(normalization string) bool {
+	for _, n := range t.config.Normalize {
+		if n == normalization {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeValue applies the value normalizations of the template, so that
+// cosmetic differences between publishers do not cause changes.
+func (t *Template) normalizeValue(v string) string {
+	if t.normalizes(config.NormalizeCRLF) {
+		v = strings.Replace(v, "\r\n", "\n", -1)
+	}
+	if t.normalizes(config.NormalizeTrim) {
+		lines := strings.Split(v, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " \t\r")
+		}
+		v = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	}
+	return v
+}
+
+// executeNormalized executes tmpl into w applying the output normalizations
+// of the template.
+func (t *Template) executeNormalized(tmpl executor, w io.Writer) error {
+	if !t.normalizes(config.NormalizeFinalNewline) {
+		return tmpl.Execute(w, nil)
+	}
+
+	lw := &lastByteWriter{w: w}
+	if err := tmpl.Execute(lw, nil); err != nil {
+		return err
+	}
+	if lw.written && lw.last != '\n' {
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	return nil
+}
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// lastByteWriter remembers the last byte written through it.
+type lastByteWriter struct {
+	w       io.Writer
+	last    byte
+	written bool
+}
+
+func (w *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.last = p[n-1]
+		w.written = true
+	}
+	return n, err
+}
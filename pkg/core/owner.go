@@ -0,0 +1,44 @@
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// chown gives the file name to the configured owner. Running as non-root
+// that requires CAP_CHOWN unless the owner is the user itself, a missing
+// capability is reported instead of a bare permission error.
+func (t *Template) chown(name string) error {
+	err := os.Chown(name, t.config.Uid, t.config.Gid)
+	if err != nil && os.IsPermission(err) && !util.HasCapability(util.CapChown) {
+		return fmt.Errorf("Unable to give %s to %d:%d, running as uid %d requires %s: %v",
+			name, t.config.Uid, t.config.Gid, os.Geteuid(), util.CapabilityName(util.CapChown), err)
+	}
+	return err
+}
+
+// chmod sets the mode of the file name, which requires CAP_FOWNER if it is
+// owned by another user.
+func (t *Template) chmod(name string, mode os.FileMode) error {
+	err := os.Chmod(name, mode)
+	if err != nil && os.IsPermission(err) && !util.HasCapability(util.CapFowner) {
+		return fmt.Errorf("Unable to set the mode of %s, running as uid %d requires %s: %v",
+			name, os.Geteuid(), util.CapabilityName(util.CapFowner), err)
+	}
+	return err
+}
+
+// CheckOwnership returns an error if the files of the template resources
+// cannot be given to their owners, before anything is rendered.
+func CheckOwnership(tcs []*config.TemplateConfig) error {
+	for _, tc := range tcs {
+		if !tc.IsStdout() && !util.CanChown(tc.Uid, tc.Gid) {
+			return fmt.Errorf("%s should be owned by %d:%d, which requires running as root or with %s as uid %d",
+				tc.Dest, tc.Uid, tc.Gid, util.CapabilityName(util.CapChown), os.Geteuid())
+		}
+	}
+	return nil
+}
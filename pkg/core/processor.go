@@ -1,10 +1,13 @@
 package core
 
 import (
-	"time"
+	"context"
+	"io"
 	"sync"
+	"time"
 
-	"github.com/docker/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/util"
 )
 
 type Processor interface {
@@ -18,6 +21,7 @@ type Processor interface {
 type OnDemandProcessor struct {
 	template *Template
 	client   store.Store
+	mapper   kvMapper
 }
 
 func NewOnDemandProcessor(template *Template, client store.Store) *OnDemandProcessor {
@@ -28,109 +32,389 @@ func NewOnDemandProcessor(template *Template, client store.Store) *OnDemandProce
 }
 
 func (p *OnDemandProcessor) Run() error {
-	pairs, err := p.client.List(p.template.config.Prefix)
+	return p.run(p.client)
+}
+
+// run renders the template with the data read from client.
+func (p *OnDemandProcessor) run(client store.Store) error {
+	pairs, err := p.template.listPairs(client)
+	if err != nil {
+		return err
+	}
+
+	p.mapper.mutex.Lock()
+	defer p.mapper.mutex.Unlock()
+	return p.template.Render(p.mapper.mapKVPairs(pairs))
+}
+
+// Execute renders the template with the current backend data into w
+// instead of syncing its destination.
+func (p *OnDemandProcessor) Execute(w io.Writer) error {
+	pairs, err := p.template.listPairs(p.client)
 	if err != nil {
 		return err
 	}
 
-	return p.template.Render(mapKVPairs(pairs))
+	p.mapper.mutex.Lock()
+	defer p.mapper.mutex.Unlock()
+	kvs, meta := p.mapper.mapKVPairs(pairs)
+	return p.template.Execute(w, kvs, meta)
 }
 
 //
 // Interval Processor
 //
 
+// IntervalProcessor runs a processor every interval until its context is
+// done, reporting its errors to onError.
 type IntervalProcessor struct {
 	interval  time.Duration
 	processor Processor
+	// skipFirstRun waits for the first interval instead of running the
+	// processor right away, e.g. when it was just run.
+	skipFirstRun bool
+	// splay is the window over which each run is randomly delayed.
+	splay time.Duration
 
-	stopChan  <-chan struct{}
-	doneChan  chan bool
-	errChan   chan error
+	onError func(error)
 }
 
-func NewIntervalProcessor(interval time.Duration, processor Processor,
-                          stopChan <-chan struct{}, doneChan chan bool, errChan chan error) *IntervalProcessor {
+func NewIntervalProcessor(interval time.Duration, processor Processor, onError func(error)) *IntervalProcessor {
 	return &IntervalProcessor{
-		interval, processor,
-		stopChan, doneChan, errChan,
+		interval: interval, processor: processor, onError: onError,
 	}
 }
 
-func (p *IntervalProcessor) Run() error {
-	defer close(p.doneChan)
+// SetSkipFirstRun makes the processor wait for an interval before its
+// first run.
+func (p *IntervalProcessor) SetSkipFirstRun(skip bool) {
+	p.skipFirstRun = skip
+}
+
+// SetSplay delays each run by a random duration up to splay, so that many
+// instances sharing an interval do not hit the backend at the same time.
+func (p *IntervalProcessor) SetSplay(splay time.Duration) {
+	p.splay = splay
+}
+
+// Run runs the processor until ctx is done, a run in progress is finished
+// first.
+func (p *IntervalProcessor) Run(ctx context.Context) {
+	skip := p.skipFirstRun
 	for {
-		if err := p.processor.Run(); err != nil {
-			p.errChan <- err
+		if !skip {
+			if err := p.processor.Run(); err != nil {
+				p.onError(err)
+			}
 		}
+		skip = false
 
+		timer := time.NewTimer(p.interval + util.Jitter(p.splay))
 		select {
-		case <-p.stopChan:
-			break
-		case <-time.After(p.interval):
-			continue
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
 		}
 	}
-	return nil
 }
 
 //
 // Watch Processor
 //
 
+// The wait before watching the template data again after a watch failed or
+// ended doubles from minWatchDelay up to maxWatchDelay, plus a random jitter
+// of up to half of it so that fleets do not reconnect in lockstep.
+const (
+	minWatchDelay = time.Second
+	maxWatchDelay = time.Minute
+)
+
+// expiryMargin is how long after the expiration of a key it is read again,
+// for the backend to have removed it.
+const expiryMargin = time.Second
+
+// maxDebounceFactor bounds the wait for the keys of a template to settle
+// down, to that many times the debounce window, under constant updates.
+const maxDebounceFactor = 10
+
+// WatchProcessor renders a template whenever its data changes until its
+// context is done, reporting errors to onError.
 type WatchProcessor struct {
-	template  *Template
-	client    store.Store
+	template *Template
+	client   store.Store
 
-	stopChan  <-chan struct{}
-	doneChan  chan bool
-	errChan   chan error
+	onError func(error)
+
+	mapper kvMapper
+	// processor, if set, is run on changes instead of rendering the
+	// template alone.
+	processor Processor
+	// ephemeralCheck is how often the data is read again while it holds
+	// ephemeral keys, zero not to.
+	ephemeralCheck time.Duration
+	// lastRender is when changes were last rendered, for the minimum
+	// render interval of the template.
+	lastRender time.Time
 }
 
-func NewWatchProcessor(template *Template, client store.Store,
-                       stopChan <-chan struct{}, doneChan chan bool, errChan chan error) *WatchProcessor {
+func NewWatchProcessor(template *Template, client store.Store, onError func(error)) *WatchProcessor {
 	return &WatchProcessor{
-		template, client,
-		stopChan, doneChan, errChan,
+		template: template, client: client, onError: onError,
 	}
 }
 
-func (p *WatchProcessor) Run() error {
-	defer close(p.doneChan)
+// NewGroupWatchProcessor watches the template data like a WatchProcessor
+// but runs processor, rendering the template along with others, on changes.
+func NewGroupWatchProcessor(template *Template, client store.Store, processor Processor, onError func(error)) *WatchProcessor {
+	p := NewWatchProcessor(template, client, onError)
+	p.processor = processor
+	return p
+}
+
+// SetEphemeralCheck makes the processor read the template data again every
+// interval while it holds ephemeral keys, which may vanish without the
+// watch telling when their owner goes away.
+func (p *WatchProcessor) SetEphemeralCheck(interval time.Duration) {
+	p.ephemeralCheck = interval
+}
+
+// Run watches the template data until ctx is done, a render in progress is
+// finished first. Watches failing or ending are established again, waiting
+// longer after each consecutive failure.
+func (p *WatchProcessor) Run(ctx context.Context) {
+	failed := false
+	delay := minWatchDelay
+	for {
+		events, err := p.template.watchPairs(p.client, ctx.Done())
+		if err != nil {
+			p.onError(err)
+		} else {
+			if failed {
+				p.template.events.Add(EventReconnect, p.template.config.Dest, "watching "+p.template.config.Prefix+" again")
+			}
+			established := time.Now()
+			if !p.consume(ctx, events) {
+				return
+			}
+			// watches lasting long enough were healthy, unlike those
+			// ending right away
+			if time.Since(established) > maxWatchDelay {
+				delay = minWatchDelay
+			}
+			p.template.logger.Warningf("Watch of %s ended, watching it again in %v", p.template.config.Prefix, delay)
+		}
+		failed = true
+
+		// Prevent backend errors from consuming all resources.
+		timer := time.NewTimer(delay + util.Jitter(delay/2))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay *= 2
+		if delay > maxWatchDelay {
+			delay = maxWatchDelay
+		}
+	}
+}
 
-	var wg sync.WaitGroup
+// consume renders the template on every change sent to events until the
+// channel is closed, returning false if ctx was done instead. The data is
+// also read again and rendered when keys expire, as not every backend
+// sends an event for them. Changes arriving within the debounce window or
+// the minimum render interval of the template are rendered together.
+func (p *WatchProcessor) consume(ctx context.Context, events <-chan []*store.KVPair) bool {
+	expiry := newStoppedTimer()
+	defer expiry.Stop()
+	due := newStoppedTimer()
+	defer due.Stop()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			events, err := p.client.WatchTree(p.template.config.Prefix, p.stopChan)
+	// pending are the latest pairs not rendered yet, since the time the
+	// first of the changes they hold arrived
+	var pending []*store.KVPair
+	var since time.Time
+	// lastExpiry is the expiration the data was last read again for, and
+	// expiryBackoff the least wait before reading it again
+	var lastExpiry time.Time
+	expiryBackoff := minWatchDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-expiry.C:
+			pairs, err := p.template.listPairs(p.client)
 			if err != nil {
-				p.errChan <- err
-				// Prevent backend errors from consuming all resources.
-				time.Sleep(time.Second * 2)
+				p.onError(err)
+				expiry.Reset(minWatchDelay)
 				continue
 			}
+			pending = pairs
+		case changed, ok := <-events:
+			if !ok {
+				return true
+			}
+			pending = changed
+			stopTimer(expiry)
+		case <-due.C:
+			p.lastRender = time.Now()
+			p.render(pending)
+			if at, ok := nextExpiry(pending, p.ephemeralCheck); ok {
+				var wait time.Duration
+				wait, expiryBackoff = expiryDelay(at, lastExpiry, expiryBackoff)
+				lastExpiry = at
+				p.template.logger.Debugf("Reading %s again in %v for its keys to expire", p.template.config.Prefix, wait)
+				expiry.Reset(wait)
+			}
+			pending, since = nil, time.Time{}
+			continue
+		}
+
+		if since.IsZero() {
+			since = time.Now()
+		}
+		stopTimer(due)
+		due.Reset(time.Until(p.renderAt(since)))
+	}
+}
+
+// renderAt returns when changes pending since the given time should be
+// rendered: once the keys were quiet for the debounce window, but not
+// before the minimum render interval elapsed since the last render.
+func (p *WatchProcessor) renderAt(since time.Time) time.Time {
+	debounce := time.Duration(p.template.config.Debounce)
+	at := time.Now().Add(debounce)
+	if limit := since.Add(maxDebounceFactor * debounce); at.After(limit) {
+		at = limit
+	}
+	if next := p.lastRender.Add(time.Duration(p.template.config.MinInterval)); at.Before(next) {
+		at = next
+	}
+	return at
+}
 
-			for {
-				select {
-				case pairs := <-events:
-					if err := p.template.Render(mapKVPairs(pairs)); err != nil {
-						p.errChan <- err
-					}
-				}
+// newStoppedTimer returns a timer that is not running, to be reset.
+func newStoppedTimer() *time.Timer {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return t
+}
+
+// stopTimer stops t, draining its channel if it fired already.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// render renders pairs, or runs the processor set in their place.
+func (p *WatchProcessor) render(pairs []*store.KVPair) {
+	if p.processor != nil {
+		if err := p.processor.Run(); err != nil {
+			p.onError(err)
+		}
+		return
+	}
+	if err := p.template.Render(p.mapper.mapKVPairs(pairs)); err != nil {
+		p.onError(err)
+	}
+}
+
+// nextExpiry returns when pairs should be read again for their expired keys
+// to be removed: shortly after the earliest expiration, or after
+// ephemeralCheck if any of them is ephemeral and it is not zero.
+func nextExpiry(pairs []*store.KVPair, ephemeralCheck time.Duration) (time.Time, bool) {
+	var next time.Time
+	for _, pair := range pairs {
+		at := pair.Expiration
+		if at.IsZero() {
+			if !pair.Ephemeral || ephemeralCheck <= 0 {
+				continue
 			}
+			at = time.Now().Add(ephemeralCheck)
+		} else {
+			at = at.Add(expiryMargin)
 		}
-	}()
-	wg.Wait()
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
 
-	return nil
+// expiryDelay returns how long to wait before reading the data again for
+// keys expiring at, along with the backoff for the next expiration. The
+// wait is never shorter than backoff, which doubles up to maxWatchDelay
+// while the same expiration comes back, as backends may keep expired keys
+// for a while.
+func expiryDelay(at, last time.Time, backoff time.Duration) (time.Duration, time.Duration) {
+	if at.Equal(last) {
+		backoff *= 2
+		if backoff > maxWatchDelay {
+			backoff = maxWatchDelay
+		}
+	} else {
+		backoff = minWatchDelay
+	}
+	wait := time.Until(at)
+	if wait < backoff {
+		wait = backoff
+	}
+	return wait, backoff
 }
 
-func mapKVPairs(pairs []*store.KVPair) map[string]string {
-	kvs := make(map[string]string)
+func mapKVPairs(pairs []*store.KVPair) (map[string]string, map[string]KeyMetadata) {
+	kvs := make(map[string]string, len(pairs))
+	meta := make(map[string]KeyMetadata, len(pairs))
 	for _, kv := range pairs {
 		kvs[kv.Key] = string(kv.Value)
+		meta[kv.Key] = KeyMetadata{Key: kv.Key, ModifyIndex: kv.LastIndex}
+	}
+	return kvs, meta
+}
+
+// kvMapper maps pairs like mapKVPairs but reuses its maps between renders,
+// along with the strings of the values that did not change, so that large
+// prefixes do not allocate a copy of every value on each event. The maps
+// are only valid until the next call.
+type kvMapper struct {
+	mutex sync.Mutex
+	kvs   map[string]string
+	meta  map[string]KeyMetadata
+}
+
+func (m *kvMapper) mapKVPairs(pairs []*store.KVPair) (map[string]string, map[string]KeyMetadata) {
+	if m.kvs == nil {
+		m.kvs = make(map[string]string, len(pairs))
+		m.meta = make(map[string]KeyMetadata, len(pairs))
 	}
-	return kvs
-}
\ No newline at end of file
+
+	for _, kv := range pairs {
+		// Comparing against the converted bytes does not allocate.
+		if v, ok := m.kvs[kv.Key]; !ok || v != string(kv.Value) {
+			m.kvs[kv.Key] = string(kv.Value)
+		}
+		m.meta[kv.Key] = KeyMetadata{Key: kv.Key, ModifyIndex: kv.LastIndex}
+	}
+
+	if len(m.kvs) != len(pairs) {
+		present := make(map[string]bool, len(pairs))
+		for _, kv := range pairs {
+			present[kv.Key] = true
+		}
+		for k := range m.kvs {
+			if !present[k] {
+				delete(m.kvs, k)
+				delete(m.meta, k)
+			}
+		}
+	}
+
+	return m.kvs, m.meta
+}
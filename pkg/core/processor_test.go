@@ -0,0 +1,72 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+func TestNextExpiry(t *testing.T) {
+	now := time.Now()
+	soon, later := now.Add(time.Minute), now.Add(time.Hour)
+
+	tests := []struct {
+		desc           string
+		pairs          []*store.KVPair
+		ephemeralCheck time.Duration
+		expected       time.Time
+		ok             bool
+	}{
+		{"no expirations", []*store.KVPair{{Key: "/a"}}, 0, time.Time{}, false},
+		{"earliest expiration", []*store.KVPair{{Key: "/a", Expiration: later}, {Key: "/b", Expiration: soon}}, 0, soon.Add(expiryMargin), true},
+		{"ephemeral without checks", []*store.KVPair{{Key: "/a", Ephemeral: true}}, 0, time.Time{}, false},
+		{"ephemeral check", []*store.KVPair{{Key: "/a", Ephemeral: true}, {Key: "/b", Expiration: later}}, time.Minute, now.Add(time.Minute), true},
+	}
+
+	for _, tt := range tests {
+		at, ok := nextExpiry(tt.pairs, tt.ephemeralCheck)
+		if ok != tt.ok {
+			t.Errorf("%s: expected %v, got %v", tt.desc, tt.ok, ok)
+			continue
+		}
+		// ephemeral checks are relative to the current time
+		if diff := at.Sub(tt.expected); diff < 0 || diff > time.Second {
+			t.Errorf("%s: expected %v, got %v", tt.desc, tt.expected, at)
+		}
+	}
+}
+
+func TestExpiryDelay(t *testing.T) {
+	// expirations in the future are waited for
+	at := time.Now().Add(time.Hour)
+	wait, backoff := expiryDelay(at, time.Time{}, minWatchDelay)
+	if wait < 59*time.Minute || backoff != minWatchDelay {
+		t.Errorf("Expected to wait for the expiration, got %v and a backoff of %v", wait, backoff)
+	}
+
+	// expirations in the past, which the backend did not remove yet, wait
+	// longer each time they come back
+	past := time.Now().Add(-time.Minute)
+	wait, backoff = expiryDelay(past, time.Time{}, minWatchDelay)
+	if wait != minWatchDelay {
+		t.Errorf("Expected to wait %v for a past expiration, got %v", minWatchDelay, wait)
+	}
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		wait, backoff = expiryDelay(past, past, backoff)
+		if wait != expected {
+			t.Errorf("Expected to wait %v for a past expiration coming back, got %v", expected, wait)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		wait, backoff = expiryDelay(past, past, backoff)
+	}
+	if wait != maxWatchDelay {
+		t.Errorf("Expected to wait at most %v, got %v", maxWatchDelay, wait)
+	}
+
+	// a new expiration starts over
+	if wait, _ = expiryDelay(past.Add(time.Second), past, backoff); wait != minWatchDelay {
+		t.Errorf("Expected to wait %v for a new expiration, got %v", minWatchDelay, wait)
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// running holds the check and reload commands being run, to kill them on
+// shutdown.
+var running = struct {
+	sync.Mutex
+	commands map[*exec.Cmd]struct{}
+}{commands: make(map[*exec.Cmd]struct{})}
+
+// outputWaitDelay bounds the time the output of a command is still read
+// once it exited or was killed, processes that left its group, like
+// daemons, possibly holding it open forever.
+const outputWaitDelay = 5 * time.Second
+
+// startCommand starts c in its own process group, so that it can be killed
+// along with every process it spawns, and tracks it until finishCommand.
+func startCommand(c *exec.Cmd) error {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.WaitDelay = outputWaitDelay
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	running.Lock()
+	running.commands[c] = struct{}{}
+	running.Unlock()
+	return nil
+}
+
+// waitCommand waits for the started command c to exit. Output still held
+// open by processes it left behind is not waited for past outputWaitDelay,
+// which is no failure of the command itself.
+func waitCommand(c *exec.Cmd) error {
+	err := c.Wait()
+	if err == exec.ErrWaitDelay {
+		return nil
+	}
+	return err
+}
+
+// finishCommand stops tracking the command c once it exited.
+func finishCommand(c *exec.Cmd) {
+	running.Lock()
+	delete(running.commands, c)
+	running.Unlock()
+}
+
+// killProcessGroup kills the process group of the started command c.
+func killProcessGroup(c *exec.Cmd) error {
+	return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
+
+// KillCommands kills the process groups of every check and reload command
+// being run. Background processes they spawned are killed too, instead of
+// being left behind holding ports or files once renderizr exits.
+func KillCommands() {
+	running.Lock()
+	defer running.Unlock()
+
+	for c := range running.commands {
+		log.Warningf("Killing %q", c.Args[len(c.Args)-1])
+		if err := killProcessGroup(c); err != nil {
+			log.Errorf("Unable to kill %q: %v", c.Args[len(c.Args)-1], err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FuncStats holds the number of calls and time spent in a template function.
+type FuncStats struct {
+	Name     string        `json:"name"`
+	Calls    int64         `json:"calls"`
+	Duration time.Duration `json:"duration"`
+}
+
+// FuncProfiler measures the template functions it wraps.
+type FuncProfiler struct {
+	mutex sync.Mutex
+	stats map[string]*FuncStats
+}
+
+func NewFuncProfiler() *FuncProfiler {
+	return &FuncProfiler{stats: make(map[string]*FuncStats)}
+}
+
+// Wrap returns a copy of funcMap whose functions record their calls.
+func (p *FuncProfiler) Wrap(funcMap map[string]interface{}) map[string]interface{} {
+	wrapped := make(map[string]interface{}, len(funcMap))
+	for name, fn := range funcMap {
+		wrapped[name] = p.wrap(name, fn)
+	}
+	return wrapped
+}
+
+func (p *FuncProfiler) wrap(name string, fn interface{}) interface{} {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fn
+	}
+
+	return reflect.MakeFunc(v.Type(), func(args []reflect.Value) []reflect.Value {
+		start := time.Now()
+		var results []reflect.Value
+		if v.Type().IsVariadic() {
+			results = v.CallSlice(args)
+		} else {
+			results = v.Call(args)
+		}
+		p.record(name, time.Since(start))
+		return results
+	}).Interface()
+}
+
+func (p *FuncProfiler) record(name string, d time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.stats[name]
+	if !ok {
+		s = &FuncStats{Name: name}
+		p.stats[name] = s
+	}
+	s.Calls++
+	s.Duration += d
+}
+
+// Stats returns the recorded statistics, most expensive functions first.
+func (p *FuncProfiler) Stats() []FuncStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stats := make([]FuncStats, 0, len(p.stats))
+	for _, s := range p.stats {
+		stats = append(stats, *s)
+	}
+	sort.Sort(byDuration(stats))
+	return stats
+}
+
+// Reset discards the recorded statistics.
+func (p *FuncProfiler) Reset() {
+	p.mutex.Lock()
+	p.stats = make(map[string]*FuncStats)
+	p.mutex.Unlock()
+}
+
+type byDuration []FuncStats
+
+func (s byDuration) Len() int           { return len(s) }
+func (s byDuration) Less(i, j int) bool { return s[i].Duration > s[j].Duration }
+func (s byDuration) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Instrument makes the template functions report their calls to p.
+func (t *Template) Instrument(p *FuncProfiler) {
+	t.profiler = p
+	t.funcMap = p.Wrap(t.funcMap)
+	t.compiled = nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// expandRawBlocks replaces the raw blocks of text, what lies between
+// {{raw}} and {{endraw}} or the given delimiters, with actions printing
+// their contents verbatim, so that the engine never interprets them. Trim
+// markers work like on any action.
+func expandRawBlocks(text, left, right string) (string, error) {
+	if left == "" {
+		left, right = "{{", "}}"
+	}
+	start := regexp.MustCompile(regexp.QuoteMeta(left) + `(- )?\s*raw\s*( -)?` + regexp.QuoteMeta(right))
+	end := regexp.MustCompile(regexp.QuoteMeta(left) + `(- )?\s*endraw\s*( -)?` + regexp.QuoteMeta(right))
+
+	var out strings.Builder
+	for {
+		loc := start.FindStringSubmatchIndex(text)
+		if loc == nil {
+			out.WriteString(text)
+			return out.String(), nil
+		}
+		out.WriteString(text[:loc[0]])
+		trimBefore, trimStart := loc[2] >= 0, loc[4] >= 0
+		text = text[loc[1]:]
+
+		endLoc := end.FindStringSubmatchIndex(text)
+		if endLoc == nil {
+			return "", fmt.Errorf("Unterminated raw block, %sendraw%s is missing", left, right)
+		}
+		trimEnd, trimAfter := endLoc[2] >= 0, endLoc[4] >= 0
+
+		content := text[:endLoc[0]]
+		if trimStart {
+			content = strings.TrimLeft(content, " \t\r\n")
+		}
+		if trimEnd {
+			content = strings.TrimRight(content, " \t\r\n")
+		}
+
+		out.WriteString(left)
+		if trimBefore {
+			out.WriteString("- ")
+		}
+		// a quoted string keeps carriage returns, which raw strings drop,
+		// and the newlines after it the line numbers of later errors
+		out.WriteString("print " + strconv.Quote(content) + strings.Repeat("\n", strings.Count(content, "\n")))
+		if trimAfter {
+			out.WriteString(" -")
+		}
+		out.WriteString(right)
+		text = text[endLoc[1]:]
+	}
+}
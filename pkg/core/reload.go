@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+type reloadRetry struct {
+	retries  int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+// SetReloadRetry sets how many times a failing reload command is retried,
+// doubling the wait between attempts from delay up to maxDelay.
+func (t *Template) SetReloadRetry(retries int, delay, maxDelay time.Duration) {
+	t.reloadRetry = reloadRetry{retries: retries, delay: delay, maxDelay: maxDelay}
+}
+
+// hasReload reports whether the destination is reloaded once updated, by a
+// command or by signaling a process.
+func (t *Template) hasReload() bool {
+	return t.config.ReloadCmd != "" || t.config.ReloadProcess != ""
+}
+
+// reloadAction describes how the destination is reloaded.
+func (t *Template) reloadAction() string {
+	if t.config.ReloadProcess != "" {
+		return fmt.Sprintf("%s to processes matching %q", t.config.ReloadSignal, t.config.ReloadProcess)
+	}
+	return t.config.ReloadCmd
+}
+
+// reloadOnce runs the reload command, or signals the processes matching
+// the reload process pattern, e.g. those of the main container when running
+// as a sidecar sharing its PID namespace.
+func (t *Template) reloadOnce() error {
+	if t.config.ReloadProcess == "" {
+		return t.exec(t.config.ReloadCmd, time.Duration(t.config.ReloadTimeout))
+	}
+
+	sig, err := util.ParseSignal(t.config.ReloadSignal)
+	if err != nil {
+		return err
+	}
+	pattern, err := regexp.Compile("^(?:" + t.config.ReloadProcess + ")$")
+	if err != nil {
+		return err
+	}
+	pids, err := util.FindProcesses(pattern)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("No process matching %q", t.config.ReloadProcess)
+	}
+	for _, pid := range pids {
+		t.logger.Debugf("Sending %s to process %d", t.config.ReloadSignal, pid)
+		if err := syscall.Kill(pid, sig); err != nil {
+			return fmt.Errorf("Unable to signal process %d: %v", pid, err)
+		}
+	}
+	return nil
+}
+
+// reload executes the reload command, retrying it as configured. If every
+// attempt fails the reload is kept pending and tried again on the next sync,
+// even if the destination is already in sync by then.
+// It returns nil if the reload command returns 0.
+func (t *Template) reload() error {
+	delay := t.reloadRetry.delay
+	for attempt := 0; ; attempt++ {
+		err := t.reloadOnce()
+		if err == nil {
+			t.reloadPending = false
+			t.events.Add(EventReload, t.config.Dest, t.reloadAction())
+			return nil
+		}
+		t.events.Add(EventReload, t.config.Dest, "failed: "+err.Error())
+
+		if attempt >= t.reloadRetry.retries {
+			t.reloadPending = true
+			if attempt > 0 {
+				err = fmt.Errorf("Reload of %s failed after %d attempts: %v", t.config.Dest, attempt+1, err)
+				t.logger.Error(err)
+				t.events.Add(EventError, t.config.Dest, err.Error())
+			}
+			return err
+		}
+
+		t.logger.Warningf("Reload of %s failed, retrying in %s: %v", t.config.Dest, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		if t.reloadRetry.maxDelay > 0 && delay > t.reloadRetry.maxDelay {
+			delay = t.reloadRetry.maxDelay
+		}
+	}
+}
+
+// reloadInWindow reloads the destination if its reload window, if any, is
+// open. Otherwise the reload is kept pending and run once the window opens,
+// or on a sync within it.
+func (t *Template) reloadInWindow() error {
+	if t.config.ReloadWindow == "" {
+		return t.reload()
+	}
+	window, err := util.ParseCron(t.config.ReloadWindow)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if window.Matches(now) {
+		return t.reload()
+	}
+
+	t.reloadPending = true
+	at := window.Next(now)
+	if at.IsZero() {
+		return fmt.Errorf("Reload window %q of %s never opens", t.config.ReloadWindow, t.config.Dest)
+	}
+	t.logger.Infof("Reload of %s deferred until its reload window opens at %s", t.config.Dest, at.Format(time.RFC3339))
+	t.events.Add(EventReload, t.config.Dest, "deferred until "+at.Format(time.RFC3339))
+	if t.reloadTimer != nil {
+		t.reloadTimer.Stop()
+	}
+	t.reloadTimer = time.AfterFunc(time.Until(at), t.reloadDeferred)
+	return nil
+}
+
+// reloadDeferred runs the reload deferred until the reload window opened,
+// unless a sync did already.
+func (t *Template) reloadDeferred() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.reloadPending {
+		return
+	}
+	if err := t.reloadInWindow(); err != nil {
+		t.logger.Errorf("Deferred reload of %s failed: %v", t.config.Dest, err)
+	}
+}
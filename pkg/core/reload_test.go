@@ -0,0 +1,31 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+)
+
+func TestDrainStopsDeferredReloads(t *testing.T) {
+	tc := config.NewTemplateConfig()
+	tc.Src = "test.conf.tmpl"
+	tc.Dest = "/etc/app.conf"
+	tc.ReloadCmd = "true"
+	// a window opening in half an hour
+	tc.ReloadWindow = fmt.Sprintf("%d * * * *", (time.Now().Minute()+30)%60)
+	template := NewTemplate(tc, false, false, false)
+
+	if err := template.reloadInWindow(); err != nil {
+		t.Fatal(err)
+	}
+	if !template.reloadPending || template.reloadTimer == nil {
+		t.Fatalf("Expected the reload to be deferred")
+	}
+
+	template.Drain()
+	if template.reloadTimer.Stop() {
+		t.Errorf("Expected the deferred reload to be stopped once drained")
+	}
+}
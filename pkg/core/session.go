@@ -0,0 +1,77 @@
+package core
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// Session evaluates template snippets against a snapshot of the data under
+// the template prefix, with the same functions available to templates.
+type Session struct {
+	template *Template
+	client   store.Store
+	keys     []string
+}
+
+func NewSession(template *Template, client store.Store) *Session {
+	return &Session{
+		template: template,
+		client:   client,
+	}
+}
+
+// Load takes a new snapshot of the backend data.
+func (s *Session) Load() error {
+	pairs, err := s.template.listPairs(s.client)
+	if err != nil {
+		return err
+	}
+	kvs, meta := mapKVPairs(pairs)
+
+	s.template.mutex.Lock()
+	defer s.template.mutex.Unlock()
+	if err := s.template.setKVs(kvs, meta); err != nil {
+		return err
+	}
+
+	s.keys = make([]string, 0, len(kvs))
+	for k := range kvs {
+		if key := s.template.normalizeKey(k); s.template.store.Exists(key) {
+			s.keys = append(s.keys, key)
+		}
+	}
+	sort.Strings(s.keys)
+	return nil
+}
+
+// Keys returns the keys of the snapshot starting with prefix, sorted.
+func (s *Session) Keys(prefix string) []string {
+	keys := make([]string, 0)
+	for _, key := range s.keys {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Eval executes text as a template into w. A bare expression, without
+// actions, is evaluated as if enclosed in {{ }}.
+func (s *Session) Eval(w io.Writer, text string) error {
+	if !strings.Contains(text, "{{") {
+		text = "{{" + text + "}}"
+	}
+
+	s.template.mutex.Lock()
+	defer s.template.mutex.Unlock()
+
+	tmpl, err := template.New("repl").Funcs(s.template.funcMap).Parse(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, nil)
+}
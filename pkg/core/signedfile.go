@@ -0,0 +1,57 @@
+package core
+
+import (
+	"crypto"
+	"fmt"
+	"io/ioutil"
+)
+
+// SignatureSuffix is appended to the name of a file to get the name of its
+// detached signature.
+const SignatureSuffix = ".sig"
+
+// FileVerifier refuses configuration files without a valid detached
+// signature, so that only signed configuration drives what renderizr writes.
+// Signatures are base64 encoded, made like those of manifests, and can be
+// created with `cosign sign-blob` or `openssl dgst -sha256 -sign`.
+type FileVerifier struct {
+	publicKey crypto.PublicKey
+}
+
+// NewFileVerifier loads the PEM encoded public key of the signer.
+func NewFileVerifier(publicKeyFile string) (*FileVerifier, error) {
+	publicKey, err := loadPublicKey(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &FileVerifier{publicKey: publicKey}, nil
+}
+
+// Verify checks that data, the contents of the file name, matches the
+// signature next to it.
+func (v *FileVerifier) Verify(name string, data []byte) error {
+	signature, err := ioutil.ReadFile(name + SignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("Unable to read the signature of %s: %v", name, err)
+	}
+	if err := verifySignature(v.publicKey, data, string(signature)); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// VerifyFile reads the file name and checks it matches its signature.
+func (v *FileVerifier) VerifyFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return v.Verify(name, data)
+}
+
+// SetSourceVerifier makes the template refuse sources without a valid
+// signature, checked whenever they change.
+func (t *Template) SetSourceVerifier(v *FileVerifier) {
+	t.srcVerifier = v
+	t.compiled = nil
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestFileVerifier(t *testing.T, dir string) (*FileVerifier, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pub")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewFileVerifier(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier, key
+}
+
+func writeSignedFile(t *testing.T, key *ecdsa.PrivateKey, name string, data []byte) {
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name+SignatureSuffix, []byte(base64.StdEncoding.EncodeToString(signature)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileVerifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-signedfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	verifier, key := newTestFileVerifier(t, dir)
+
+	good := filepath.Join(dir, "good.toml")
+	writeSignedFile(t, key, good, []byte("[template]\n"))
+	if err := verifier.VerifyFile(good); err != nil {
+		t.Errorf("Expected a valid signature for %s, got: %v", good, err)
+	}
+
+	bad := filepath.Join(dir, "bad.toml")
+	writeSignedFile(t, key, bad, []byte("[template]\n"))
+	if err := ioutil.WriteFile(bad, []byte("[template]\nmode = \"0777\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyFile(bad); err == nil {
+		t.Errorf("Expected the signature of the modified %s to be refused", bad)
+	}
+
+	missing := filepath.Join(dir, "missing.toml")
+	if err := ioutil.WriteFile(missing, []byte("[template]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyFile(missing); err == nil {
+		t.Errorf("Expected %s without a signature to be refused", missing)
+	}
+}
+
+func TestLoadSignedFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-signedfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	verifier, key := newTestFileVerifier(t, dir)
+
+	shellFuncs := filepath.Join(dir, "funcs.toml")
+	writeSignedFile(t, key, shellFuncs, []byte("upper = \"tr a-z A-Z\"\n"))
+	commands, err := LoadShellFuncs(shellFuncs, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands["upper"] != "tr a-z A-Z" {
+		t.Errorf("Unexpected shell functions %v", commands)
+	}
+
+	if err := ioutil.WriteFile(shellFuncs, []byte("upper = \"rm -rf /\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadShellFuncs(shellFuncs, verifier); err == nil {
+		t.Errorf("Expected the modified %s to be refused", shellFuncs)
+	}
+	if _, err := LoadShellFuncs(shellFuncs, nil); err != nil {
+		t.Errorf("Expected %s to be loaded without a verifier, got: %v", shellFuncs, err)
+	}
+
+	// plugins are refused before being opened
+	plugin := filepath.Join(dir, "funcs.so")
+	writeSignedFile(t, key, plugin, []byte("not a plugin"))
+	if err := ioutil.WriteFile(plugin, []byte("not a plugin either"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFuncPlugin(plugin, verifier); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("Expected the modified %s to be refused for its signature, got: %v", plugin, err)
+	}
+}
+
+func TestLoadSignedFuncPlugin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building a plugin is slow")
+	}
+	dir, err := ioutil.TempDir("", "renderizr-signedfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "shout.go")
+	if err := ioutil.WriteFile(src, []byte(`package main
+
+var Funcs = map[string]interface{}{"shout": func(s string) string { return s + "!" }}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	built := filepath.Join(dir, "built.so")
+	if out, err := exec.Command("go", "build", "-buildmode=plugin", "-o", built, src).CombinedOutput(); err != nil {
+		t.Skipf("Unable to build a plugin: %v: %s", err, out)
+	}
+	data, err := ioutil.ReadFile(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, key := newTestFileVerifier(t, dir)
+	// the same plugin, signed at two paths, is loaded from private copies
+	for _, name := range []string{"shout.so", "copy.so"} {
+		plugin := filepath.Join(dir, name)
+		writeSignedFile(t, key, plugin, data)
+		funcs, err := LoadFuncPlugin(plugin, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shout, ok := funcs["shout"].(func(string) string)
+		if !ok || shout("hey") != "hey!" {
+			t.Errorf("Unexpected functions %v loaded from %s", funcs, plugin)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// maxSnippetDepth bounds how deeply snippets can include other snippets, to
+// stop a snippet including itself.
+const maxSnippetDepth = 10
+
+// snippet is a template stored as a KV value, parsed once per value.
+type snippet struct {
+	value string
+	tmpl  *template.Template
+}
+
+// kvTemplate parses and executes the template stored in key, with data as
+// its dot if given. It lets shared snippets be managed in the backend.
+func (t *Template) kvTemplate(key string, data ...interface{}) (string, error) {
+	if len(data) > 1 {
+		return "", fmt.Errorf("kvTemplate accepts a single data argument")
+	}
+
+	value, err := t.store.GetValue(key)
+	if err != nil {
+		return "", err
+	}
+
+	if t.snippetDepth >= maxSnippetDepth {
+		return "", fmt.Errorf("Snippet %s nested more than %d levels", key, maxSnippetDepth)
+	}
+
+	s, ok := t.snippets[key]
+	if !ok || s.value != value {
+		text, err := expandRawBlocks(value, "", "")
+		if err != nil {
+			return "", fmt.Errorf("Unable to parse snippet %s: %v", key, err)
+		}
+		tmpl, err := template.New(key).Funcs(t.funcMap).Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("Unable to parse snippet %s: %v", key, err)
+		}
+		s = &snippet{value: value, tmpl: tmpl}
+		t.snippets[key] = s
+	}
+
+	var dot interface{}
+	if len(data) == 1 {
+		dot = data[0]
+	}
+
+	t.snippetDepth++
+	defer func() { t.snippetDepth-- }()
+
+	var buf bytes.Buffer
+	if err := s.tmpl.Execute(&buf, dot); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// maxDiffSize is the largest destination diffs are recorded for.
+const maxDiffSize = 1 << 20
+
+// redactedValue replaces the values of sensitive keys in recorded diffs.
+const redactedValue = "<redacted>"
+
+// Status describes the outcome of the recent renders of a template.
+type Status struct {
+	Src     string `json:"src"`
+	Dest    string `json:"dest"`
+	InSync  bool   `json:"in_sync"`
+	Renders int64  `json:"renders"`
+	Changes int64  `json:"changes"`
+	Errors  int64  `json:"errors"`
+	// ConsecutiveErrors are the renders failed since the last successful
+	// one.
+	ConsecutiveErrors int64     `json:"consecutive_errors"`
+	LastRender        time.Time `json:"last_render"`
+	LastChange        time.Time `json:"last_change"`
+	LastDiff          string    `json:"last_diff,omitempty"`
+	LastError         string    `json:"last_error,omitempty"`
+	LastErrorTime     time.Time `json:"last_error_time"`
+	// Unhealthy is set when a render exceeded its deadline, until the next
+	// successful one. Advisory templates are never unhealthy.
+	Unhealthy bool `json:"unhealthy"`
+	Advisory  bool `json:"advisory"`
+	// Funcs are the statistics of the template functions, most expensive
+	// first, if they are instrumented.
+	Funcs []FuncStats `json:"funcs,omitempty"`
+}
+
+// Status returns the status of the template.
+func (t *Template) Status() Status {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+
+	status := t.status
+	status.Src = t.config.Src
+	status.Dest = t.config.Dest
+	status.Advisory = t.config.Advisory
+	if t.profiler != nil {
+		status.Funcs = t.profiler.Stats()
+	}
+	return status
+}
+
+// SetRecordDiffs sets whether the differences found in the destination are
+// kept in the template status, with the values of keys matching redactKeys
+// hidden.
+func (t *Template) SetRecordDiffs(enabled bool, redactKeys *regexp.Regexp) {
+	t.recordDiffs = enabled
+	t.redactKeys = redactKeys
+}
+
+// recordRender updates the status with the outcome of a render.
+func (t *Template) recordRender(err error) {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+
+	t.status.Renders++
+	t.status.LastRender = time.Now()
+	if err != nil {
+		t.status.InSync = false
+		t.status.Errors++
+		t.status.LastError = err.Error()
+		t.status.LastErrorTime = t.status.LastRender
+		t.status.ConsecutiveErrors++
+		t.events.Add(EventError, t.config.Dest, t.status.LastError)
+		if limit := int64(t.config.MaxFailures); limit > 0 && t.status.ConsecutiveErrors == limit && t.failures != nil {
+			select {
+			case t.failures <- fmt.Errorf("%s failed to render %d times in a row: %v", t.config.Dest, limit, err):
+			default:
+			}
+		}
+	} else {
+		t.status.Unhealthy = false
+		t.status.ConsecutiveErrors = 0
+	}
+}
+
+// recordSync updates the status once the destination has been compared and
+// possibly overwritten.
+func (t *Template) recordSync(inSync, changed bool) {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+
+	t.status.InSync = inSync
+	if changed {
+		t.status.Changes++
+		t.status.LastChange = time.Now()
+		t.events.Add(EventRender, t.config.Dest, "updated")
+	}
+}
+
+// recordDiff keeps the redacted difference between the destination and the
+// stage file.
+func (t *Template) recordDiff(stageFileName string) {
+	if !t.recordDiffs {
+		return
+	}
+
+	diff := t.diff(stageFileName, true)
+
+	t.statusMutex.Lock()
+	t.status.LastDiff = diff
+	t.statusMutex.Unlock()
+}
+
+// SetLogDiffs sets whether the differences found in the destination are
+// logged before overwriting it, and whether the values of keys matching the
+// redact keys are hidden in them. Noop mode always logs them.
+func (t *Template) SetLogDiffs(enabled, redacted bool) {
+	t.logDiffs = enabled
+	t.redactDiffs = redacted
+}
+
+// diff returns the unified diff between the destination and the stage file,
+// with the sensitive values hidden if redacted.
+func (t *Template) diff(stageFileName string, redacted bool) string {
+	var current []byte
+	if fi, err := os.Stat(t.config.Dest); err == nil {
+		if fi.Size() > maxDiffSize {
+			return "(destination too large to diff)"
+		}
+		if current, err = ioutil.ReadFile(t.config.Dest); err != nil {
+			return "(unable to read destination: " + err.Error() + ")"
+		}
+	}
+
+	staged, err := ioutil.ReadFile(stageFileName)
+	if err != nil {
+		return "(unable to read stage file: " + err.Error() + ")"
+	}
+	if len(staged) > maxDiffSize {
+		return "(candidate too large to diff)"
+	}
+
+	diff := util.Diff(t.config.Dest, t.config.Dest+" (candidate)", string(current), string(staged), 3)
+	if !redacted {
+		return diff
+	}
+	return redact(diff, t.sensitive)
+}
+
+// redact replaces every occurrence of values in text, longest first so that
+// a value containing another is not partially revealed.
+func redact(text string, values []string) string {
+	sort.Sort(byLengthDesc(values))
+	for _, v := range values {
+		text = strings.Replace(text, v, redactedValue, -1)
+	}
+	return text
+}
+
+type byLengthDesc []string
+
+func (s byLengthDesc) Len() int           { return len(s) }
+func (s byLengthDesc) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+func (s byLengthDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
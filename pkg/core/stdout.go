@@ -0,0 +1,65 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// stdoutMutex keeps the outputs of the templates writing to stdout from
+// being interleaved.
+var stdoutMutex sync.Mutex
+
+// WriteOutputHeader separates the outputs of several templates written to
+// the same stream, naming the template of the following one.
+func WriteOutputHeader(w io.Writer, name string) {
+	fmt.Fprintf(w, "==> %s <==\n", name)
+}
+
+// SetOutputHeader sets whether the output of a template writing to stdout
+// is preceded by a header naming its source, to tell it apart from those of
+// other templates.
+func (t *Template) SetOutputHeader(enabled bool) {
+	t.outputHeader = enabled
+}
+
+// renderStdout writes the template output to stdout, unless it did not
+// change since it was last written.
+func (t *Template) renderStdout() error {
+	tmpl, err := t.compile()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := t.executeTemplate(tmpl, &buf); err != nil {
+		return err
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256(buf.Bytes()))
+	if digest == t.stageDigest {
+		t.logger.Debugf("Output of %s unchanged", t.config.Src)
+		t.recordSync(true, false)
+		return nil
+	}
+	if t.doNoOp {
+		t.logger.Warningf("Noop mode enabled. The output of %s will not be written to stdout", t.config.Src)
+		t.recordSync(false, false)
+		return nil
+	}
+
+	stdoutMutex.Lock()
+	defer stdoutMutex.Unlock()
+	if t.outputHeader {
+		WriteOutputHeader(os.Stdout, t.config.Src)
+	}
+	if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	t.stageDigest = digest
+	t.recordSync(true, true)
+	t.updated = true
+	return nil
+}
@@ -2,20 +2,24 @@ package core
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
-	"os/exec"
+	"time"
 
 	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/log"
 	"github.com/glerchundi/renderizr/pkg/util"
-	"github.com/golang/glog"
 	"github.com/kelseyhightower/memkv"
 
 	"sync"
@@ -26,44 +30,144 @@ type Template struct {
 	config        *config.TemplateConfig
 	funcMap       map[string]interface{}
 	store         memkv.Store
+	meta          map[string]KeyMetadata
+	datasources   *Datasources
+	acknowledger  *Acknowledger
+	verifier      *SnapshotVerifier
+	fileAllowList []string
+	stageDigest   string
+	checksumFile  bool
+	recordDiffs   bool
+	logDiffs      bool
+	redactDiffs   bool
+	redactKeys    *regexp.Regexp
+	sensitive     []string
+	status        Status
+	events        *EventLog
+	triggerDigest string
+	snippets      map[string]*snippet
+	snippetDepth  int
+	reloadRetry   reloadRetry
+	reloadPending bool
+	reloadTimer   *time.Timer
+	checkCache    *checkCache
+	emptyPrefix   emptyPrefixPolicy
+	changes       chan<- string
+	failures      chan<- error
+	limiter       *RenderLimiter
+	updated       bool
+	watchdog      watchdog
+	logger        *log.Entry
+	compiled      *template.Template
+	compiledSize  int64
+	compiledTime  time.Time
+	compiledSum   [sha256.Size]byte
+	profiler      *FuncProfiler
+	srcVerifier   *FileVerifier
+	statusMutex   sync.Mutex
 	doNoOp        bool
 	keepStageFile bool
 	useMutex      bool
+	outputHeader  bool
 	mutex         *sync.Mutex
 }
 
 func NewTemplate(config *config.TemplateConfig, doNoOp, keepStageFile, useMutex bool) *Template {
-	store := memkv.New()
 	funcMap := newFuncMap()
-	for name, fn := range store.FuncMap {
-		funcMap[name] = fn
-	}
 
-	return &Template{
-		config: config,
-		funcMap: funcMap,
-		store: store,
-		doNoOp: doNoOp,
+	t := &Template{
+		config:        config,
+		funcMap:       funcMap,
+		meta:          make(map[string]KeyMetadata),
+		doNoOp:        doNoOp,
+		redactDiffs:   true,
 		keepStageFile: keepStageFile,
-		useMutex: useMutex,
-		mutex: &sync.Mutex{},
+		useMutex:      useMutex,
+		mutex:         &sync.Mutex{},
+		logger:        log.WithFields(log.Fields{"src": config.Src, "dest": config.Dest}),
+		snippets:      make(map[string]*snippet),
 	}
+	t.store = memkv.New()
+	addStoreFuncs(funcMap, &t.store)
+	funcMap["meta"] = t.getMetadata
+	funcMap["lastIndex"] = t.getLastIndex
+	funcMap["datasource"] = t.getDatasource
+	funcMap["include"] = t.includeDatasource
+	funcMap["kvTemplate"] = t.kvTemplate
+	funcMap["file"] = t.readFile
+	funcMap["fetch"] = t.fetchKey
+
+	return t
+}
+
+// SetLogFields adds fields to the context of the messages logged about the
+// template, which already includes its src and dest.
+func (t *Template) SetLogFields(fields log.Fields) {
+	t.logger = t.logger.WithFields(fields)
 }
 
 // Render is a convenience function that wraps calls to the three main
 // tasks required to keep local configuration files in sync. First we
 // stage a candidate configuration file, and finally sync things up.
 // It returns an error if any fails.
-func (t *Template) Render(kvs map[string]string) error {
+func (t *Template) Render(kvs map[string]string, meta map[string]KeyMetadata) error {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	fileMode, err := t.getExpectedFileMode()
-	if err != nil {
+	changed, digest := t.triggersChanged(kvs)
+	if !changed {
+		return nil
+	}
+
+	t.limiter.acquire()
+	defer t.limiter.release()
+
+	t.updated = false
+	t.startWatchdog()
+	err := t.render(kvs, meta)
+	t.recordRender(err)
+	t.runHooks(err)
+	if err == nil {
+		t.triggerDigest = digest
+		t.notifyChange()
+	}
+	return err
+}
+
+// notifyChange notifies the destination was updated by the last render, if
+// a change notifier is set.
+func (t *Template) notifyChange() {
+	if t.changes == nil || !t.updated {
+		return
+	}
+	select {
+	case t.changes <- t.config.Dest:
+	default:
+	}
+}
+
+// Drain waits for the render in flight, if any, and blocks every render
+// after it, along with the reloads deferred until their window opens. It is
+// meant to be called on shutdown, so that the process never exits in the
+// middle of writing a destination or reloading its service.
+func (t *Template) Drain() {
+	t.mutex.Lock()
+	if t.reloadTimer != nil && t.reloadTimer.Stop() {
+		t.logger.Warningf("Reload of %s deferred until its reload window opens is dropped on shutdown", t.config.Dest)
+	}
+}
+
+func (t *Template) render(kvs map[string]string, meta map[string]KeyMetadata) error {
+	if err := t.setKVs(kvs, meta); err != nil {
 		return err
 	}
 
-	if err := t.setKVs(kvs); err != nil {
+	if t.config.IsStdout() {
+		return t.renderStdout()
+	}
+
+	fileMode, err := t.getExpectedFileMode()
+	if err != nil {
 		return err
 	}
 
@@ -76,9 +180,104 @@ func (t *Template) Render(kvs map[string]string) error {
 		return err
 	}
 
+	if t.acknowledger != nil && !t.doNoOp {
+		if err := t.acknowledger.Ack(t, t.getLastIndex()); err != nil {
+			t.logger.Errorf("Unable to acknowledge %s: %v", t.config.Dest, err)
+		}
+	}
+
 	return nil
 }
 
+// Execute renders the template with the given data into w, leaving the
+// destination untouched.
+func (t *Template) Execute(w io.Writer, kvs map[string]string, meta map[string]KeyMetadata) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := t.setKVs(kvs, meta); err != nil {
+		return err
+	}
+
+	tmpl, err := t.compile()
+	if err != nil {
+		return err
+	}
+
+	return t.executeTemplate(tmpl, w)
+}
+
+// SetDatasources sets the datasources available to the template functions.
+func (t *Template) SetDatasources(datasources *Datasources) {
+	t.datasources = datasources
+}
+
+// SetAcknowledger sets where successful renders are acknowledged.
+func (t *Template) SetAcknowledger(acknowledger *Acknowledger) {
+	t.acknowledger = acknowledger
+}
+
+// SetVerifier sets the verifier data must pass before being rendered.
+func (t *Template) SetVerifier(verifier *SnapshotVerifier) {
+	t.verifier = verifier
+}
+
+// SetSprig sets whether the functions of the sprig library are available,
+// see addSprigFuncs.
+func (t *Template) SetSprig(enabled bool) {
+	if enabled {
+		addSprigFuncs(t.funcMap)
+		t.compiled = nil
+	}
+}
+
+// SetFileAllowList sets the directories the file function may read from,
+// none by default.
+func (t *Template) SetFileAllowList(dirs []string) {
+	t.fileAllowList = make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		t.fileAllowList = append(t.fileAllowList, dir)
+	}
+}
+
+// SetChecksumFile sets whether a sidecar file with the sha256 of the
+// destination is kept up to date.
+func (t *Template) SetChecksumFile(enabled bool) {
+	t.checksumFile = enabled
+}
+
+// SetChangeNotifier sets a channel receiving the destination every time it
+// is updated. Notifications are dropped if the channel is not ready.
+func (t *Template) SetChangeNotifier(changes chan<- string) {
+	t.changes = changes
+}
+
+// SetFailureNotifier sets a channel receiving an error once the template
+// failed to render as many times in a row as its maximum consecutive
+// failures, if set. It is dropped if the channel is not ready.
+func (t *Template) SetFailureNotifier(failures chan<- error) {
+	t.failures = failures
+}
+
+// SetRenderLimiter sets the limiter bounding the renders running at once
+// along with other templates.
+func (t *Template) SetRenderLimiter(limiter *RenderLimiter) {
+	t.limiter = limiter
+}
+
+// Config returns the configuration the template was created with.
+func (t *Template) Config() *config.TemplateConfig {
+	return t.config
+}
+
+// ExpectedFileMode returns the mode the destination file should have.
+func (t *Template) ExpectedFileMode() (os.FileMode, error) {
+	return t.getExpectedFileMode()
+}
+
 // setFileMode sets the FileMode.
 func (t *Template) getExpectedFileMode() (os.FileMode, error) {
 	var fileMode os.FileMode = 0644
@@ -100,35 +299,130 @@ func (t *Template) getExpectedFileMode() (os.FileMode, error) {
 	return fileMode, nil
 }
 
-// setKVs sets the Vars for template resource.
-func (t *Template) setKVs(kvs map[string]string) error {
+// setKVs sets the Vars and their metadata for template resource.
+func (t *Template) setKVs(kvs map[string]string, meta map[string]KeyMetadata) error {
+	normalized := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		normalized[t.normalizeKey(k)] = v
+	}
+
+	if t.verifier != nil {
+		if err := t.verifier.Verify(t.config.Prefix, normalized); err != nil {
+			return fmt.Errorf("Refusing to render %s: %v", t.config.Dest, err)
+		}
+	}
+
 	t.store.Purge()
+	t.meta = make(map[string]KeyMetadata, len(meta))
+	t.sensitive = nil
 	for k, v := range kvs {
-		t.store.Set(filepath.Join("/", strings.TrimPrefix(k, t.config.Prefix)), v)
+		key := t.normalizeKey(k)
+		if _, ok := normalized[key]; !ok || !t.isSelected(key) {
+			continue
+		}
+		v = t.normalizeValue(v)
+		t.store.Set(key, v)
+		if t.redactKeys != nil && v != "" && t.redactKeys.MatchString(key) {
+			t.sensitive = append(t.sensitive, v)
+		}
+		if m, ok := meta[k]; ok {
+			m.Key = key
+			t.meta[key] = m
+		}
 	}
 	return nil
 }
 
+// isSelected reports whether key, relative to the template prefix, is one of
+// the template keys or lives under them. Every key is selected if the
+// template does not list its keys.
+func (t *Template) isSelected(key string) bool {
+	if len(t.config.Keys) == 0 {
+		return true
+	}
+	for _, k := range t.config.Keys {
+		k = filepath.Join("/", k)
+		if k == "/" || key == k || strings.HasPrefix(key, k+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeKey strips the template prefix from a backend key.
+func (t *Template) normalizeKey(key string) string {
+	return filepath.Join("/", strings.TrimPrefix(key, t.config.Prefix))
+}
+
+// Compile parses the source template, failing on syntax errors and on
+// calls to unknown functions.
+func (t *Template) Compile() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, err := t.compile()
+	return err
+}
+
+// compile parses the source template. The parsed template is cached and
+// only parsed again once the source changes.
+func (t *Template) compile() (*template.Template, error) {
+	fi, err := os.Stat(t.config.Src)
+	if err != nil {
+		t.compiled = nil
+		return nil, errors.New("Missing template: " + t.config.Src)
+	}
+	if t.compiled != nil && fi.Size() == t.compiledSize && fi.ModTime().Equal(t.compiledTime) {
+		return t.compiled, nil
+	}
+
+	data, err := ioutil.ReadFile(t.config.Src)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	if t.compiled == nil || digest != t.compiledSum {
+		if t.srcVerifier != nil {
+			if err := t.srcVerifier.Verify(t.config.Src, data); err != nil {
+				t.compiled = nil
+				return nil, err
+			}
+		}
+		t.logger.Debugf("Compiling source template %s", t.config.Src)
+		text, err := expandRawBlocks(string(data), t.config.LeftDelim, t.config.RightDelim)
+		if err != nil {
+			t.compiled = nil
+			return nil, fmt.Errorf("Unable to process template %s, %s", t.config.Src, err)
+		}
+		tmpl, err := template.New(path.Base(t.config.Src)).Delims(t.config.LeftDelim, t.config.RightDelim).Funcs(t.funcMap).Parse(text)
+		if err != nil {
+			t.compiled = nil
+			return nil, fmt.Errorf("Unable to process template %s, %s", t.config.Src, err)
+		}
+		t.compiled = tmpl
+		t.compiledSum = digest
+	}
+	t.compiledSize = fi.Size()
+	t.compiledTime = fi.ModTime()
+	return t.compiled, nil
+}
+
 // createStageFile stages the src configuration file by processing the src
 // template and setting the desired owner, group, and mode. It also sets the
 // StageFile for the template resource.
 // It returns an error if any.
 func (t *Template) createStageFile(fileMode os.FileMode) (*os.File, error) {
-	glog.V(1).Infof("Using source template %s", t.config.Src)
+	t.logger.Debugf("Using source template %s", t.config.Src)
 
-	if !util.IsFileExist(t.config.Src) {
-		return nil, errors.New("Missing template: " + t.config.Src)
-	}
-
-	glog.V(1).Infof("Compiling source template %s", t.config.Src)
-	tmpl, err := template.New(path.Base(t.config.Src)).Funcs(t.funcMap).ParseFiles(t.config.Src)
+	tmpl, err := t.compile()
 	if err != nil {
-		return nil, fmt.Errorf("Unable to process template %s, %s", t.config.Src, err)
+		return nil, err
 	}
 
-	// create TempFile in Dest directory to avoid cross-filesystem issues
+	// create the stage file in the staging area next to Dest to avoid
+	// cross-filesystem issues
 	errorOcurred := true
-	tempFile, err := ioutil.TempFile(filepath.Dir(t.config.Dest), "."+filepath.Base(t.config.Dest))
+	tempFile, err := util.CreateStageFile(t.config.Dest)
 	if err != nil {
 		return nil, err
 	}
@@ -139,9 +433,13 @@ func (t *Template) createStageFile(fileMode os.FileMode) (*os.File, error) {
 		}
 	}()
 
-	if err = tmpl.Execute(tempFile, nil); err != nil {
+	// hash the contents while writing them, this lets sync skip reading the
+	// destination when it was not touched since it was last written.
+	h := sha256.New()
+	if err = t.executeTemplate(tmpl, io.MultiWriter(tempFile, h)); err != nil {
 		return nil, err
 	}
+	t.stageDigest = fmt.Sprintf("%x", h.Sum(nil))
 
 	// Set the owner, group, and mode on the stage file now to make it easier to
 	// compare against the destination configuration file later.
@@ -150,11 +448,15 @@ func (t *Template) createStageFile(fileMode os.FileMode) (*os.File, error) {
 		return nil, err
 	}
 
-	err = os.Chown(tempFile.Name(), t.config.Uid, t.config.Gid)
+	err = t.chown(tempFile.Name())
 	if err != nil {
 		return nil, err
 	}
 
+	if err := t.setXattrs(tempFile.Name()); err != nil {
+		return nil, err
+	}
+
 	errorOcurred = false
 	return tempFile, nil
 }
@@ -170,33 +472,96 @@ func (t *Template) sync(stageFile *os.File, fileMode os.FileMode, doNoOp bool) e
 		defer os.Remove(stageFileName)
 	}
 
-	glog.V(1).Infof("Comparing candidate config to %s", t.config.Dest)
-	ok, err := util.IsSameConfig(stageFileName, t.config.Dest)
-	if err != nil {
-		glog.Error(err)
+	inSync, err := t.prepare(stageFileName, doNoOp)
+	if err != nil || doNoOp {
 		return err
 	}
+	return t.apply(stageFileName, fileMode, inSync)
+}
+
+// prepare compares the staged config file to the destination and, if they
+// differ, runs the config check command on it. It reports whether they are
+// in sync already.
+func (t *Template) prepare(stageFileName string, doNoOp bool) (bool, error) {
+	t.logger.Debugf("Comparing candidate config to %s", t.config.Dest)
+	ok, err := util.IsSameConfig(stageFileName, t.config.Dest, t.stageDigest)
+	if err != nil {
+		t.logger.Error(err)
+		return false, err
+	}
+
+	if !ok {
+		t.recordDiff(stageFileName)
+		if err := t.guardBlank(stageFileName); err != nil {
+			return false, err
+		}
+	}
 
 	if doNoOp {
-		glog.Warningf("Noop mode enabled. %s will not be modified", t.config.Dest)
-		return nil
+		if ok {
+			t.logger.Warningf("Noop mode enabled. %s will not be modified", t.config.Dest)
+		} else {
+			t.logger.Warningf("Noop mode enabled. %s would be modified as follows:\n%s", t.config.Dest, t.diff(stageFileName, t.redactDiffs))
+		}
+		t.recordSync(ok, false)
+		return ok, nil
 	}
 
 	if !ok {
-		glog.Infof("Target config %s out of sync", t.config.Dest)
+		t.logger.Infof("Target config %s out of sync", t.config.Dest)
+		if t.logDiffs {
+			t.logger.Infof("Differences in %s:\n%s", t.config.Dest, t.diff(stageFileName, t.redactDiffs))
+		}
 
 		if t.config.CheckCmd != "" {
 			if err := t.check(stageFileName); err != nil {
-				return errors.New("Config check failed: " + err.Error())
+				return false, errors.New("Config check failed: " + err.Error())
 			}
 		}
+	}
+
+	return ok, nil
+}
+
+// guardBlank refuses to replace a destination with contents by the blank
+// staged file, unless the template allows empty output: a template bug is
+// far more likely than an intended wipe of a critical file.
+func (t *Template) guardBlank(stageFileName string) error {
+	if t.config.AllowEmpty || !util.IsFileExist(t.config.Dest) {
+		return nil
+	}
+	blank, err := util.IsBlankFile(stageFileName)
+	if err != nil || !blank {
+		return err
+	}
+	destBlank, err := util.IsBlankFile(t.config.Dest)
+	if err != nil || destBlank {
+		return err
+	}
+	return fmt.Errorf("Refusing to replace %s with empty output, set allow_empty if intended", t.config.Dest)
+}
+
+// apply overwrites the destination with the prepared stage file unless they
+// are in sync, and runs the reload command.
+func (t *Template) apply(stageFileName string, fileMode os.FileMode, inSync bool) error {
+	if !inSync {
+		if err := t.backup(); err != nil {
+			return err
+		}
+		snapshot, err := t.snapshot()
+		if err != nil {
+			return err
+		}
+		if snapshot != "" {
+			defer os.Remove(snapshot)
+		}
 
-		glog.V(1).Infof("Overwriting target config %s", t.config.Dest)
+		t.logger.Debugf("Overwriting target config %s", t.config.Dest)
 
-		err := os.Rename(stageFileName, t.config.Dest)
+		err = os.Rename(stageFileName, t.config.Dest)
 		if err != nil {
 			if strings.Contains(err.Error(), "device or resource busy") {
-				glog.V(1).Infof("Rename failed - target is likely a mount.config. Trying to write instead")
+				t.logger.Debugf("Rename failed - target is likely a mount.config. Trying to write instead")
 				// try to open the file and write to it
 				var contents []byte
 				var rerr error
@@ -205,67 +570,184 @@ func (t *Template) sync(stageFile *os.File, fileMode os.FileMode, doNoOp bool) e
 					return rerr
 				}
 				err := ioutil.WriteFile(t.config.Dest, contents, fileMode)
-				// make sure owner and group match the temp file, in case the file was created with WriteFile
-				os.Chown(t.config.Dest, t.config.Uid, t.config.Gid)
 				if err != nil {
 					return err
 				}
+				// make sure owner, group and mode match the temp file, WriteFile
+				// leaves those of existing files as they were
+				if err := t.chown(t.config.Dest); err != nil {
+					return err
+				}
+				if err := t.chmod(t.config.Dest, fileMode); err != nil {
+					return err
+				}
+				if err := t.fixSELinuxContext(); err != nil {
+					return err
+				}
 			} else {
 				return err
 			}
 		}
 
-		if t.config.ReloadCmd != "" {
-			if err := t.reload(); err != nil {
+		util.RecordConfig(t.config.Dest, t.stageDigest)
+		t.recordSync(true, true)
+		if err := t.writeChecksumFile(); err != nil {
+			return err
+		}
+
+		if t.hasReload() {
+			if err := t.reloadInWindow(); err != nil {
+				if snapshot != "" {
+					return t.rollbackReload(snapshot, err)
+				}
 				return err
 			}
 		}
 
-		glog.Infof("Target config %s has been updated", t.config.Dest)
+		t.logger.Infof("Target config %s has been updated", t.config.Dest)
+		t.updated = true
 	} else {
-		glog.V(1).Infof("Target config %s in sync", t.config.Dest)
+		t.logger.Debugf("Target config %s in sync", t.config.Dest)
+		t.recordSync(true, false)
+		if err := t.fixSELinuxContext(); err != nil {
+			return err
+		}
+		if t.reloadPending {
+			if err := t.reloadInWindow(); err != nil {
+				return err
+			}
+		}
+		if t.checksumFile && !util.IsFileExist(util.ChecksumFileName(t.config.Dest)) {
+			if err := t.writeChecksumFile(); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// writeChecksumFile writes the sidecar checksum of the destination, if
+// enabled.
+func (t *Template) writeChecksumFile() error {
+	if !t.checksumFile {
+		return nil
+	}
+	t.logger.Debugf("Writing checksum of %s", t.config.Dest)
+	if err := util.WriteChecksumFile(t.config.Dest, t.stageDigest); err != nil {
+		return fmt.Errorf("Unable to write checksum of %s: %v", t.config.Dest, err)
+	}
+	return nil
+}
+
 // check executes the check command to validate the staged config file. The
 // command is modified so that any references to src template are substituted
 // with a string representing the full path of the staged file. This allows the
 // check to be run on the staged file before overwriting the destination config
 // file.
 // It returns nil if the check command returns 0 and there are no other errors.
+// Contents already accepted are not checked again if the check cache is
+// enabled.
 func (t *Template) check(stageFileName string) error {
-	tmpl, err := template.New("checkcmd").Parse(t.config.CheckCmd)
-	if err != nil {
+	if t.checkCache.has(t.config.CheckCmd, t.stageDigest) {
+		t.logger.Debugf("Candidate config for %s already checked", t.config.Dest)
+		return nil
+	}
+
+	var c *exec.Cmd
+	if t.config.Shell == config.ShellNone {
+		// the command is split before the staged file is substituted, for
+		// its name to remain a single argument
+		args, err := util.SplitArgs(t.config.CheckCmd)
+		if err != nil {
+			return err
+		}
+		for i, arg := range args {
+			if args[i], err = expandCheckCmd(arg, stageFileName); err != nil {
+				return err
+			}
+		}
+		t.logger.Debugf("Running %s", strings.Join(args, " "))
+		if c, err = commandFromArgs(args); err != nil {
+			return err
+		}
+	} else {
+		cmd, err := expandCheckCmd(t.config.CheckCmd, stageFileName)
+		if err != nil {
+			return err
+		}
+		t.logger.Debugf("Running %s", cmd)
+		if c, err = t.command(cmd); err != nil {
+			return err
+		}
+	}
+
+	if err := t.run(c, time.Duration(t.config.CheckTimeout)); err != nil {
 		return err
 	}
+	t.checkCache.add(t.config.CheckCmd, t.stageDigest)
+	return nil
+}
+
+// expandCheckCmd substitutes the staged file name in the check command cmd.
+func expandCheckCmd(cmd, stageFileName string) (string, error) {
+	tmpl, err := template.New("checkcmd").Parse(cmd)
+	if err != nil {
+		return "", err
+	}
 
 	var cmdBuffer bytes.Buffer
 	if err := tmpl.Execute(&cmdBuffer, stageFileName); err != nil {
-		return err
+		return "", err
 	}
+	return cmdBuffer.String(), nil
+}
 
-	return t.exec(cmdBuffer.String())
+// command returns the command running cmd through the template shell, or
+// on its own split into arguments if the template has none.
+func (t *Template) command(cmd string) (*exec.Cmd, error) {
+	if t.config.Shell == config.ShellNone {
+		args, err := util.SplitArgs(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return commandFromArgs(args)
+	}
+	shell := strings.Fields(t.config.Shell)
+	if len(shell) == 0 {
+		shell = []string{"/bin/sh", "-c"}
+	}
+	return exec.Command(shell[0], append(shell[1:], cmd)...), nil
 }
 
-// reload executes the reload command.
-// It returns nil if the reload command returns 0.
-func (t *Template) reload() error {
-	return t.exec(t.config.ReloadCmd)
+// commandFromArgs returns the command running the program of args[0] with
+// the rest of them.
+func commandFromArgs(args []string) (*exec.Cmd, error) {
+	if len(args) == 0 {
+		return nil, errors.New("Empty command")
+	}
+	return exec.Command(args[0], args[1:]...), nil
 }
 
-func (t *Template) exec(cmd string) error {
-	glog.V(1).Infof("Running %s", cmd)
+func (t *Template) exec(cmd string, timeout time.Duration) error {
+	t.logger.Debugf("Running %s", cmd)
 
-	c := exec.Command("/bin/sh", "-c", cmd)
-	output, err := c.CombinedOutput()
+	c, err := t.command(cmd)
 	if err != nil {
-		glog.Errorf("%q", string(output))
+		return err
+	}
+	return t.run(c, timeout)
+}
+
+// run runs c, logging its output.
+func (t *Template) run(c *exec.Cmd, timeout time.Duration) error {
+	output, err := t.runCommand(c, timeout)
+	if err != nil {
+		t.logger.Errorf("%q", string(output))
 		return err
 	}
 
-	glog.V(1).Infof("%q", string(output))
+	t.logger.Debugf("%q", string(output))
 
 	return nil
-}
\ No newline at end of file
+}
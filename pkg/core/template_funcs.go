@@ -1,11 +1,25 @@
 package core
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Masterminds/sprig"
+	"github.com/glerchundi/renderizr/pkg/util"
+	"github.com/kelseyhightower/memkv"
+	"gopkg.in/yaml.v2"
 )
 
 func newFuncMap() map[string]interface{} {
@@ -14,17 +28,213 @@ func newFuncMap() map[string]interface{} {
 	m["split"] = strings.Split
 	m["json"] = UnmarshalJsonObject
 	m["jsonArray"] = UnmarshalJsonArray
+	m["yaml"] = UnmarshalYamlObject
+	m["yamlArray"] = UnmarshalYamlArray
 	m["dir"] = path.Dir
-	m["getenv"] = os.Getenv
+	m["map"] = CreateMap
+	m["getenv"] = Getenv
 	m["join"] = strings.Join
 	m["datetime"] = time.Now
 	m["toUpper"] = strings.ToUpper
 	m["toLower"] = strings.ToLower
 	m["contains"] = strings.Contains
 	m["replace"] = strings.Replace
+	m["trimSuffix"] = strings.TrimSuffix
+	m["fileExists"] = util.IsFileExist
+	m["parseBool"] = strconv.ParseBool
+	m["reverse"] = Reverse
+	m["sortByLength"] = SortByLength
+	m["sortKVByLength"] = SortKVByLength
+	m["seq"] = Seq
+	m["base64Encode"] = Base64Encode
+	m["base64Decode"] = Base64Decode
+	m["md5sum"] = Md5sum
+	m["sha1sum"] = Sha1sum
+	m["sha256sum"] = Sha256sum
+	m["lookupIP"] = LookupIP
+	m["lookupIPV4"] = LookupIPV4
+	m["lookupIPV6"] = LookupIPV6
+	m["lookupSRV"] = LookupSRV
+	m["cidrContains"] = CIDRContains
+	m["splitHostPort"] = SplitHostPort
+	m["joinHostPort"] = JoinHostPort
+	m["sortIPs"] = SortIPs
+	m["atoi"] = strconv.Atoi
+	m["add"] = func(a, b int) int { return a + b }
+	m["sub"] = func(a, b int) int { return a - b }
+	m["div"] = func(a, b int) int { return a / b }
+	m["mod"] = func(a, b int) int { return a % b }
+	m["mul"] = func(a, b int) int { return a * b }
 	return m
 }
 
+// addStoreFuncs registers the functions reading the keys of store, the same
+// ones confd provides: exists, get, gets, getv, getvs, ls and lsdir.
+func addStoreFuncs(funcMap map[string]interface{}, store *memkv.Store) {
+	for name, fn := range store.FuncMap {
+		funcMap[name] = fn
+	}
+	// getv accepts a default value returned when the key does not exist
+	funcMap["getv"] = func(key string, v ...string) (string, error) {
+		value, err := store.GetValue(key)
+		if err == memkv.ErrNotExist && len(v) > 0 {
+			return v[0], nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("%v: %s", err, key)
+		}
+		return value, nil
+	}
+}
+
+// addSprigFuncs registers the functions of the sprig library whose names
+// are not taken, those keep their confd semantics, e.g. 'split' and
+// 'contains' whose arguments are reversed in sprig.
+func addSprigFuncs(funcMap map[string]interface{}) {
+	for name, fn := range sprig.TxtFuncMap() {
+		if _, ok := funcMap[name]; !ok {
+			funcMap[name] = fn
+		}
+	}
+}
+
+// readFile returns the contents of the local file path, which must be
+// under one of the directories allowed with SetFileAllowList. Symbolic
+// links are resolved first, so they cannot point outside of them.
+func (t *Template) readFile(path string) (string, error) {
+	resolved, err := filepath.Abs(path)
+	if err == nil {
+		resolved, err = filepath.EvalSymlinks(resolved)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range t.fileAllowList {
+		if d, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = d
+		}
+		if rel, err := filepath.Rel(dir, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			data, err := ioutil.ReadFile(resolved)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		}
+	}
+	return "", fmt.Errorf("Reading %s is not allowed, see --file-allow", path)
+}
+
+// Getenv retrieves the value of the environment variable named by the key,
+// or the optional default value when it is empty.
+func Getenv(key string, v ...string) string {
+	value := os.Getenv(key)
+	if value == "" && len(v) > 0 {
+		return v[0]
+	}
+	return value
+}
+
+// CreateMap builds a map from a list of key and value pairs, so several
+// values can be handed to a nested template.
+func CreateMap(values ...interface{}) (map[string]interface{}, error) {
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("Invalid map call, expected key and value pairs")
+	}
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, ok := values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("Map keys must be strings")
+		}
+		m[key] = values[i+1]
+	}
+	return m, nil
+}
+
+// Reverse returns a reversed copy of a list of strings or KVPairs, other
+// values are returned untouched.
+func Reverse(values interface{}) interface{} {
+	switch v := values.(type) {
+	case []string:
+		r := make([]string, len(v))
+		for i, s := range v {
+			r[len(v)-1-i] = s
+		}
+		return r
+	case memkv.KVPairs:
+		r := make(memkv.KVPairs, len(v))
+		for i, kv := range v {
+			r[len(v)-1-i] = kv
+		}
+		return r
+	case []memkv.KVPair:
+		r := make([]memkv.KVPair, len(v))
+		for i, kv := range v {
+			r[len(v)-1-i] = kv
+		}
+		return r
+	}
+	return values
+}
+
+// SortByLength returns a copy of values sorted from the shortest to the
+// longest.
+func SortByLength(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i]) < len(sorted[j])
+	})
+	return sorted
+}
+
+// SortKVByLength returns a copy of values sorted from the shortest key to
+// the longest.
+func SortKVByLength(values []memkv.KVPair) []memkv.KVPair {
+	sorted := append([]memkv.KVPair(nil), values...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Key) < len(sorted[j].Key)
+	})
+	return sorted
+}
+
+// Seq returns the integers from first to last, both included.
+func Seq(first, last int) []int {
+	var seq []int
+	for i := first; i <= last; i++ {
+		seq = append(seq, i)
+	}
+	return seq
+}
+
+// Base64Encode returns the standard base64 encoding of data.
+func Base64Encode(data string) string {
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
+
+// Base64Decode decodes standard base64 encoded data.
+func Base64Decode(data string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// Md5sum returns the hex encoded MD5 digest of data.
+func Md5sum(data string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+}
+
+// Sha1sum returns the hex encoded SHA-1 digest of data.
+func Sha1sum(data string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(data)))
+}
+
+// Sha256sum returns the hex encoded SHA-256 digest of data.
+func Sha256sum(data string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
+
 func UnmarshalJsonObject(data string) (map[string]interface{}, error) {
 	var ret map[string]interface{}
 	err := json.Unmarshal([]byte(data), &ret)
@@ -36,3 +246,21 @@ func UnmarshalJsonArray(data string) ([]interface{}, error) {
 	err := json.Unmarshal([]byte(data), &ret)
 	return ret, err
 }
+
+func UnmarshalYamlObject(data string) (map[string]interface{}, error) {
+	var ret map[interface{}]interface{}
+	if err := yaml.Unmarshal([]byte(data), &ret); err != nil {
+		return nil, err
+	}
+	// nested maps get string keys, like json ones, to be indexable
+	m, _ := stringifyKeys(ret).(map[string]interface{})
+	return m, nil
+}
+
+func UnmarshalYamlArray(data string) ([]interface{}, error) {
+	var ret []interface{}
+	if err := yaml.Unmarshal([]byte(data), &ret); err != nil {
+		return nil, err
+	}
+	return stringifyKeys(ret).([]interface{}), nil
+}
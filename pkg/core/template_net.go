@@ -0,0 +1,116 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// LookupIP returns the addresses of host, sorted.
+func LookupIP(host string) ([]string, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return SortIPs(addrs), nil
+}
+
+// LookupIPV4 returns the IPv4 addresses of host, sorted.
+func LookupIPV4(host string) ([]string, error) {
+	return lookupIPFamily(host, true)
+}
+
+// LookupIPV6 returns the IPv6 addresses of host, sorted.
+func LookupIPV6(host string) ([]string, error) {
+	return lookupIPFamily(host, false)
+}
+
+func lookupIPFamily(host string, v4 bool) ([]string, error) {
+	addrs, err := LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	family := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if (net.ParseIP(addr).To4() != nil) == v4 {
+			family = append(family, addr)
+		}
+	}
+	return family, nil
+}
+
+// LookupSRV returns the SRV records of service, proto and name, sorted by
+// target and port so that the output is stable, unlike the weighted order
+// the resolver uses.
+func LookupSRV(service, proto, name string) ([]*net.SRV, error) {
+	_, addrs, err := net.LookupSRV(service, proto, name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].Target != addrs[j].Target {
+			return addrs[i].Target < addrs[j].Target
+		}
+		return addrs[i].Port < addrs[j].Port
+	})
+	return addrs, nil
+}
+
+// CIDRContains reports whether ip belongs to the network cidr, like
+// 10.0.0.0/8.
+func CIDRContains(cidr, ip string) (bool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, fmt.Errorf("Invalid IP address %q", ip)
+	}
+	return network.Contains(parsed), nil
+}
+
+// SplitHostPort splits an address like 'host:port' or '[::1]:port' into
+// its host and port.
+func SplitHostPort(hostport string) ([]string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	return []string{host, port}, nil
+}
+
+// JoinHostPort builds an address from a host and a port, given as a
+// string or a number, with IPv6 addresses in brackets.
+func JoinHostPort(host string, port interface{}) string {
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
+
+// SortIPs returns a copy of ips sorted by address, IPv4 ones first. Values
+// which are not IP addresses go last, sorted as strings.
+func SortIPs(ips []string) []string {
+	sorted := append([]string(nil), ips...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := net.ParseIP(sorted[i]), net.ParseIP(sorted[j])
+		switch {
+		case a == nil && b == nil:
+			return sorted[i] < sorted[j]
+		case a == nil || b == nil:
+			return b == nil
+		}
+		a4, b4 := a.To4(), b.To4()
+		if (a4 == nil) != (b4 == nil) {
+			return a4 != nil
+		}
+		if a4 != nil {
+			return bytes.Compare(a4, b4) < 0
+		}
+		return bytes.Compare(a.To16(), b.To16()) < 0
+	})
+	return sorted
+}
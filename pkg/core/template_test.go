@@ -1,11 +1,12 @@
 package core
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/glerchundi/renderizr/pkg/backends"
 	"github.com/glerchundi/renderizr/pkg/config"
 )
 
@@ -15,11 +16,11 @@ const (
 )
 
 type templateTest struct {
-	desc        string                  // description of the test (for helpful errors)
-	toml        string                  // toml file contents
-	tmpl        string                  // template file contents
-	expected    string                  // expected generated file contents
-	updateStore func(*TemplateResource) // function for setting values in store
+	desc        string          // description of the test (for helpful errors)
+	toml        string          // toml file contents
+	tmpl        string          // template file contents
+	expected    string          // expected generated file contents
+	updateStore func(*Template) // function for setting values in store
 }
 
 // templateTests is an array of templateTest structs, each representing a test of
@@ -49,7 +50,7 @@ key: key
 val: abc
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/key", "abc")
 		},
 	},
@@ -81,7 +82,7 @@ key: /test/user
 val: mary
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/user", "mary")
 			tr.store.Set("/test/pass", "abc")
 			tr.store.Set("/nada/url", "url")
@@ -107,7 +108,7 @@ user = {{getv "/test/user"}}
 url = http://www.abc.com
 user = bob
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/url", "http://www.abc.com")
 			tr.store.Set("/test/user", "bob")
 		},
@@ -137,7 +138,7 @@ val: abc
 val: mary
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/user", "mary")
 			tr.store.Set("/test/pass", "abc")
 			tr.store.Set("/nada/url", "url")
@@ -166,7 +167,7 @@ f: foo
 br: bar
 bz: baz
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data", "foo:bar:baz")
 		},
 	},
@@ -189,7 +190,7 @@ key: {{$data}}
 
 key: VALUE
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data", `Value`)
 		},
 	},
@@ -212,7 +213,7 @@ key: {{$data}}
 
 key: value
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data", `Value`)
 		},
 	},
@@ -245,7 +246,7 @@ id: host2
 ip: 192.168.10.12
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data/1", `{"Id":"host1", "IP":"192.168.10.11"}`)
 			tr.store.Set("/test/data/2", `{"Id":"host2", "IP":"192.168.10.12"}`)
 		},
@@ -275,11 +276,129 @@ num: 2
 num: 3
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data/", `["1", "2", "3"]`)
 		},
 	},
 
+	templateTest{
+		desc: "yaml test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/data/",
+]
+`,
+		tmpl: `
+{{range getvs "/test/data/*"}}
+{{$data := yaml .}}
+id: {{$data.id}}
+port: {{$data.ports.http}}
+{{end}}
+`,
+		expected: `
+
+
+id: host1
+port: 80
+
+
+id: host2
+port: 8080
+
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/data/1", "id: host1\nports:\n  http: 80\n")
+			tr.store.Set("/test/data/2", "{id: host2, ports: {http: 8080}}")
+		},
+	},
+
+	templateTest{
+		desc: "yamlArray test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/data/",
+]
+`,
+		tmpl: `
+{{range yamlArray (getv "/test/data/")}}
+num: {{.}}
+{{end}}
+`,
+		expected: `
+
+num: 1
+
+num: 2
+
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/data/", "- 1\n- 2\n")
+		},
+	},
+
+	templateTest{
+		desc: "base64, hash test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/secret",
+]
+`,
+		tmpl: `
+encoded: {{base64Encode (getv "/test/secret")}}
+decoded: {{base64Decode "aHVudGVyMg=="}}
+md5: {{md5sum (getv "/test/secret")}}
+sha1: {{sha1sum (getv "/test/secret")}}
+sha256: {{sha256sum (getv "/test/secret")}}
+`,
+		expected: `
+encoded: aHVudGVyMg==
+decoded: hunter2
+md5: 2ab96390c7dbe3439de74d0c9b0b1767
+sha1: f3bbbd66a63d4bf1747940578ec3d0103530e21d
+sha256: f52fbd32b2b3b86ff88ef6c490628285f482af15ddcb29541f94bcf526a3f6c7
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/secret", "hunter2")
+		},
+	},
+
+	templateTest{
+		desc: "network test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/upstreams/",
+]
+`,
+		tmpl: `
+{{range sortIPs (getvs "/test/upstreams/*")}}
+{{- if cidrContains "10.0.0.0/8" .}}server {{joinHostPort . 8080}};
+{{end}}{{end}}
+{{- $hp := splitHostPort "[::1]:443"}}host: {{index $hp 0}} port: {{index $hp 1}}
+`,
+		expected: `
+server 10.0.0.2:8080;
+server 10.0.0.10:8080;
+host: ::1 port: 443
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/upstreams/a", "10.0.0.10")
+			tr.store.Set("/test/upstreams/b", "192.168.0.1")
+			tr.store.Set("/test/upstreams/c", "10.0.0.2")
+		},
+	},
+
 	templateTest{
 		desc: "ls test",
 		toml: `
@@ -306,7 +425,7 @@ value: def
 value: ghi
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data/abc", "123")
 			tr.store.Set("/test/data/def", "456")
 			tr.store.Set("/test/data/ghi", "789")
@@ -337,7 +456,7 @@ value: def
 value: jkl
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data/abc", "123")
 			tr.store.Set("/test/data/def/ghi", "456")
 			tr.store.Set("/test/data/jkl/mno", "789")
@@ -364,11 +483,247 @@ dir: {{.}}
 dir: /test/data
 
 `,
-		updateStore: func(tr *TemplateResource) {
+		updateStore: func(tr *Template) {
 			tr.store.Set("/test/data", "parent")
 			tr.store.Set("/test/data/def", "child")
 		},
 	},
+
+	templateTest{
+		desc: "getv default test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/url",
+]
+`,
+		tmpl: `
+url = {{getv "/test/url" "http://localhost"}}
+user = {{getv "/test/user" "nobody"}}
+`,
+		expected: `
+url = http://www.abc.com
+user = nobody
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/url", "http://www.abc.com")
+		},
+	},
+
+	templateTest{
+		desc: "exists test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/key",
+]
+`,
+		tmpl: `
+{{if exists "/test/key"}}key: {{getv "/test/key"}}{{end}}
+{{if exists "/test/nada"}}nada: {{getv "/test/nada"}}{{end}}
+`,
+		expected: `
+key: abc
+
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/key", "abc")
+		},
+	},
+
+	templateTest{
+		desc: "reverse, sortByLength test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/data/",
+]
+`,
+		tmpl: `
+{{range reverse (sortByLength (getvs "/test/data/*"))}}
+value: {{.}}
+{{end}}
+`,
+		expected: `
+
+value: ccc
+
+value: bb
+
+value: a
+
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/data/1", "bb")
+			tr.store.Set("/test/data/2", "ccc")
+			tr.store.Set("/test/data/3", "a")
+		},
+	},
+
+	templateTest{
+		desc: "seq, atoi, add test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/workers",
+]
+`,
+		tmpl: `
+{{range seq 1 (atoi (getv "/test/workers"))}}worker{{add . 10}}
+{{end}}`,
+		expected: `
+worker11
+worker12
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/workers", "2")
+		},
+	},
+
+	templateTest{
+		desc: "custom shell function test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/name",
+]
+`,
+		tmpl: `
+{{greet (getv "/test/name") 2}}
+`,
+		expected: `
+hello world x2
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/name", "world")
+			tr.SetShellFuncs(map[string]string{
+				"greet": `echo "hello $1 x$2"`,
+			})
+		},
+	},
+
+	templateTest{
+		desc: "shell funcs without shell test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+`,
+		tmpl: `
+{{greet (getv "/test/name") 2}}
+`,
+		expected: `
+'hello' world 2
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/name", "world")
+			tr.config.Shell = config.ShellNone
+			tr.SetShellFuncs(map[string]string{
+				"greet": `echo "'hello'"`,
+			})
+		},
+	},
+
+	templateTest{
+		desc: "fetch test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/name",
+]
+`,
+		tmpl: `
+{{getv "/test/name"}} {{fetch "/global/level"}} {{fetch "/global/missing" "none"}}
+`,
+		expected: `
+app debug none
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/name", "app")
+			os.Setenv("RENDERIZRTEST_GLOBAL_LEVEL", "debug")
+			client, _ := backends.NewEnv(backends.EnvOptions{Prefix: "RENDERIZRTEST_", StripPrefix: true})
+			datasources, _ := NewDatasources(nil, client, time.Minute)
+			datasources.SetFetchAllowList([]string{"/global"})
+			tr.SetDatasources(datasources)
+		},
+	},
+
+	templateTest{
+		desc: "delimiters test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+left_delim = "[["
+right_delim = "]]"
+keys = [
+    "/test/name",
+]
+`,
+		tmpl: `
+- alert: [[getv "/test/name"]]Down
+  summary: "{{ $labels.instance }} is down"
+`,
+		expected: `
+- alert: appDown
+  summary: "{{ $labels.instance }} is down"
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/name", "app")
+			tr.config.LeftDelim, tr.config.RightDelim = "[[", "]]"
+		},
+	},
+
+	templateTest{
+		desc: "raw blocks test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/name",
+]
+`,
+		tmpl: `
+name: {{getv "/test/name"}}
+{{raw}}chart: {{ .Values.name | default "` + "`x`" + `" }}
+{{- end }}{{endraw}}
+{{- raw }} {{ {{- endraw}}
+`,
+		expected: `
+name: app
+chart: {{ .Values.name | default "` + "`x`" + `" }}
+{{- end }} {{
+`,
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/name", "app")
+		},
+	},
+	templateTest{
+		desc: "raw blocks crlf test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+`,
+		tmpl:     "{{raw}}a\r\n{{b}}\r\n{{endraw -}}\n{{getv \"/test/name\"}}\n",
+		expected: "a\r\n{{b}}\r\napp\n",
+		updateStore: func(tr *Template) {
+			tr.store.Set("/test/name", "app")
+		},
+	},
 }
 
 // TestTemplates runs all tests in templateTests
@@ -378,30 +733,29 @@ func TestTemplates(t *testing.T) {
 	}
 }
 
-// ExectureTestTemplate builds a TemplateResource based on the toml and tmpl files described
+// ExectureTestTemplate builds a Template based on the toml and tmpl files described
 // in the templateTest, writes a config file, and compares the result against the expectation
 // in the templateTest.
 func ExecuteTestTemplate(tt templateTest, t *testing.T) {
 	setupDirectoriesAndFiles(tt, t)
 	defer os.RemoveAll("test")
 
-	tr, err := templateResource()
-	if err != nil {
-		t.Errorf(tt.desc + ": failed to create TemplateResource: " + err.Error())
-	}
+	tr := newTestTemplate()
 
 	tt.updateStore(tr)
 
-	if err := tr.createStageFile(); err != nil {
-		t.Errorf(tt.desc + ": failed createStageFile: " + err.Error())
+	stageFile, err := tr.createStageFile(0666)
+	if err != nil {
+		t.Fatal(tt.desc + ": failed createStageFile: " + err.Error())
 	}
+	defer os.Remove(stageFile.Name())
 
-	actual, err := ioutil.ReadFile(tr.stageFile.Name())
+	actual, err := ioutil.ReadFile(stageFile.Name())
 	if err != nil {
-		t.Errorf(tt.desc + ": failed to read StageFile: " + err.Error())
+		t.Error(tt.desc + ": failed to read StageFile: " + err.Error())
 	}
 	if string(actual) != tt.expected {
-		t.Errorf(fmt.Sprintf("%v: invalid StageFile. Expected %v, actual %v", tt.desc, tt.expected, string(actual)))
+		t.Errorf("%v: invalid StageFile. Expected %v, actual %v", tt.desc, tt.expected, string(actual))
 	}
 }
 
@@ -410,37 +764,29 @@ func ExecuteTestTemplate(tt templateTest, t *testing.T) {
 func setupDirectoriesAndFiles(tt templateTest, t *testing.T) {
 	// create renderizr directory and toml file
 	if err := os.MkdirAll("./test/renderizr", os.ModePerm); err != nil {
-		t.Errorf(tt.desc + ": failed to created renderizr directory: " + err.Error())
+		t.Error(tt.desc + ": failed to created renderizr directory: " + err.Error())
 	}
 	if err := ioutil.WriteFile(tomlFilePath, []byte(tt.toml), os.ModePerm); err != nil {
-		t.Errorf(tt.desc + ": failed to write toml file: " + err.Error())
+		t.Error(tt.desc + ": failed to write toml file: " + err.Error())
 	}
 	// create templates directory and tmpl file
 	if err := os.MkdirAll("./test/templates", os.ModePerm); err != nil {
-		t.Errorf(tt.desc + ": failed to create template directory: " + err.Error())
+		t.Error(tt.desc + ": failed to create template directory: " + err.Error())
 	}
 	if err := ioutil.WriteFile(tmplFilePath, []byte(tt.tmpl), os.ModePerm); err != nil {
-		t.Errorf(tt.desc + ": failed to write toml file: " + err.Error())
+		t.Error(tt.desc + ": failed to write toml file: " + err.Error())
 	}
 	// create tmp directory for output
 	if err := os.MkdirAll("./test/tmp", os.ModePerm); err != nil {
-		t.Errorf(tt.desc + ": failed to create tmp directory: " + err.Error())
+		t.Error(tt.desc + ": failed to create tmp directory: " + err.Error())
 	}
 }
 
-// templateResource creates a templateResource for creating a config file
-func templateResource() (*TemplateResource, error) {
-	client, err := env.NewEnvClient(config.NewEnvBackendConfig())
-	if err != nil {
-		return nil, err
-	}
-
+// newTestTemplate creates a Template for creating a config file
+func newTestTemplate() *Template {
 	tc := config.NewTemplateConfig()
 	tc.Src = "./test/templates/test.conf.tmpl"
 	tc.Dest = "./test/tmp/test.conf"
 
-	tr := NewTemplateResource(tc, client)
-	tr.fileMode = 0666
-
-	return tr, nil
+	return NewTemplate(tc, false, false, false)
 }
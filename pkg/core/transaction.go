@@ -0,0 +1,171 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// Transaction renders a group of templates together: all of them are
+// rendered from the same snapshot of the backend data, and destinations
+// are only written once every template rendered and passed its check.
+type Transaction struct {
+	name      string
+	templates []*Template
+	client    store.Store
+}
+
+// stagedTemplate is a template of a transaction ready to be applied.
+type stagedTemplate struct {
+	template      *Template
+	stageFileName string
+	fileMode      os.FileMode
+	inSync        bool
+	digest        string
+}
+
+func NewTransaction(name string, templates []*Template, client store.Store) *Transaction {
+	return &Transaction{
+		name:      name,
+		templates: templates,
+		client:    client,
+	}
+}
+
+// Templates returns the templates of the transaction.
+func (tx *Transaction) Templates() []*Template {
+	return tx.templates
+}
+
+// Run renders every template of the transaction whose data changed and
+// applies them all, or none if any of them fails before being applied.
+func (tx *Transaction) Run() error {
+	return tx.run(tx.client)
+}
+
+// run runs the transaction with the data read from client.
+func (tx *Transaction) run(client store.Store) error {
+	for _, t := range tx.templates {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		t.startWatchdog()
+	}
+
+	// Templates sharing their keys share the snapshot of them too.
+	snapshots := make(map[string][]*store.KVPair)
+	kvs := make([]map[string]string, len(tx.templates))
+	meta := make([]map[string]KeyMetadata, len(tx.templates))
+	for i, t := range tx.templates {
+		id := t.config.Prefix + "\x00" + strings.Join(t.config.Keys, "\x00")
+		pairs, ok := snapshots[id]
+		if !ok {
+			var err error
+			if pairs, err = t.listPairs(client); err != nil {
+				return fmt.Errorf("Transaction %s aborted: %v", tx.name, err)
+			}
+			snapshots[id] = pairs
+		}
+		kvs[i], meta[i] = mapKVPairs(pairs)
+	}
+
+	// the whole transaction takes a single render slot
+	limiter := tx.templates[0].limiter
+	limiter.acquire()
+	defer limiter.release()
+
+	staged := make([]*stagedTemplate, 0, len(tx.templates))
+	defer func() {
+		for _, st := range staged {
+			if !st.template.keepStageFile {
+				os.Remove(st.stageFileName)
+			}
+		}
+	}()
+	for i, t := range tx.templates {
+		changed, digest := t.triggersChanged(kvs[i])
+		if !changed {
+			continue
+		}
+		t.updated = false
+		st, err := t.stage(kvs[i], meta[i])
+		if err != nil {
+			t.recordRender(err)
+			t.runHooks(err)
+			return fmt.Errorf("Transaction %s aborted, %s failed: %v", tx.name, t.config.Dest, err)
+		}
+		st.digest = digest
+		staged = append(staged, st)
+	}
+
+	if len(staged) == 0 {
+		return nil
+	}
+	if tx.templates[0].doNoOp {
+		for _, st := range staged {
+			st.template.recordRender(nil)
+		}
+		return nil
+	}
+
+	log.Debugf("Applying transaction %s", tx.name)
+	var errs []string
+	for _, st := range staged {
+		t := st.template
+		err := t.apply(st.stageFileName, st.fileMode, st.inSync)
+		t.recordRender(err)
+		t.runHooks(err)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", t.config.Dest, err))
+			continue
+		}
+		t.triggerDigest = st.digest
+		if t.acknowledger != nil {
+			if err := t.acknowledger.Ack(t, t.getLastIndex()); err != nil {
+				log.Errorf("Unable to acknowledge %s: %v", t.config.Dest, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Transaction %s partially applied: %s", tx.name, strings.Join(errs, "; "))
+	}
+	for _, st := range staged {
+		st.template.notifyChange()
+	}
+	return nil
+}
+
+// stage renders the template into a stage file and checks it, without
+// touching the destination.
+func (t *Template) stage(kvs map[string]string, meta map[string]KeyMetadata) (*stagedTemplate, error) {
+	fileMode, err := t.getExpectedFileMode()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.setKVs(kvs, meta); err != nil {
+		return nil, err
+	}
+
+	stageFile, err := t.createStageFile(fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	inSync, err := t.prepare(stageFile.Name(), t.doNoOp)
+	if err != nil {
+		if !t.keepStageFile {
+			os.Remove(stageFile.Name())
+		}
+		return nil, err
+	}
+
+	return &stagedTemplate{
+		template:      t,
+		stageFileName: stageFile.Name(),
+		fileMode:      fileMode,
+		inSync:        inSync,
+	}, nil
+}
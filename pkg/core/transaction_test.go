@@ -0,0 +1,81 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+)
+
+func newGroupTemplate(t *testing.T, dir, name, tmpl string) *Template {
+	tc := config.NewTemplateConfig()
+	tc.Src = filepath.Join(dir, name+".tmpl")
+	tc.Dest = filepath.Join(dir, name)
+	tc.Uid = os.Getuid()
+	tc.Gid = os.Getgid()
+	tc.Group = "app"
+	if err := ioutil.WriteFile(tc.Src, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return NewTemplate(tc, false, false, false)
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-transaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := newWatchStore()
+	client.Put("/app/port", []byte("8080"), nil)
+
+	server := newGroupTemplate(t, dir, "server.conf", "listen {{getv \"/app/port\"}}\n")
+	proxy := newGroupTemplate(t, dir, "proxy.conf", "upstream 127.0.0.1:{{getv \"/app/port\"}}\n")
+	// the proxy refuses to run against privileged ports
+	proxy.config.CheckCmd = "! grep -q ':[0-9][0-9]\\?$' {{.}}"
+	tx := NewTransaction("app", []*Template{server, proxy}, client)
+
+	if err := tx.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, server.config.Dest); s != "listen 8080\n" {
+		t.Errorf("Unexpected %s %q", server.config.Dest, s)
+	}
+	if s := readFile(t, proxy.config.Dest); s != "upstream 127.0.0.1:8080\n" {
+		t.Errorf("Unexpected %s %q", proxy.config.Dest, s)
+	}
+
+	// a failed check of any template applies none of them
+	client.Put("/app/port", []byte("80"), nil)
+	if err := tx.Run(); err == nil {
+		t.Errorf("Expected the failed check to abort the transaction")
+	}
+	if s := readFile(t, server.config.Dest); s != "listen 8080\n" {
+		t.Errorf("Expected %s to be left untouched, got %q", server.config.Dest, s)
+	}
+	if s := readFile(t, proxy.config.Dest); s != "upstream 127.0.0.1:8080\n" {
+		t.Errorf("Expected %s to be left untouched, got %q", proxy.config.Dest, s)
+	}
+
+	client.Put("/app/port", []byte("8081"), nil)
+	if err := tx.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, server.config.Dest); s != "listen 8081\n" {
+		t.Errorf("Unexpected %s %q", server.config.Dest, s)
+	}
+	if s := readFile(t, proxy.config.Dest); s != "upstream 127.0.0.1:8081\n" {
+		t.Errorf("Unexpected %s %q", proxy.config.Dest, s)
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// triggersChanged reports whether the keys matching the template trigger
+// patterns changed since the last successful render, along with the digest
+// identifying their current values. Without triggers every change counts.
+func (t *Template) triggersChanged(kvs map[string]string) (bool, string) {
+	if len(t.config.Triggers) == 0 {
+		return true, ""
+	}
+
+	keys := make([]string, 0)
+	for k := range kvs {
+		if key := t.normalizeKey(k); t.isTrigger(key) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", t.normalizeKey(k), t.normalizeValue(kvs[k]))
+	}
+	digest := fmt.Sprintf("%x", h.Sum(nil))
+
+	if digest == t.triggerDigest {
+		t.logger.Debugf("No trigger key of %s changed, skipping render", t.config.Dest)
+		return false, digest
+	}
+	return true, digest
+}
+
+// isTrigger reports whether key, relative to the template prefix, matches
+// any of the trigger patterns. Patterns follow path.Match, a pattern ending
+// in a slash matches the whole subtree.
+func (t *Template) isTrigger(key string) bool {
+	for _, pattern := range t.config.Triggers {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(key, pattern) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
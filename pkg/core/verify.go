@@ -0,0 +1,188 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignedManifest is the value stored at the manifest key of a prefix.
+// Manifest is the JSON encoded Manifest, Signature is the base64 encoded
+// signature of the Manifest bytes made with the publisher private key
+// (RSA PKCS#1 v1.5 or ASN.1 ECDSA over SHA-256).
+type SignedManifest struct {
+	Manifest  string `json:"manifest"`
+	Signature string `json:"signature"`
+}
+
+// Manifest binds the data of a prefix to the signature of its publisher.
+// Keys maps every key, relative to Prefix, to the hex encoded SHA-256 of its
+// value. Serial is increased by the publisher on every new manifest of the
+// prefix and Expires, if set, is when it stops being valid, so that older
+// manifests, and their data, cannot be replayed.
+type Manifest struct {
+	Prefix  string            `json:"prefix"`
+	Serial  uint64            `json:"serial"`
+	Expires time.Time         `json:"expires,omitempty"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// SnapshotVerifier refuses data that doesn't match the manifest signed by
+// a trusted publisher, protecting against a compromised writer poisoning
+// the configuration of a whole fleet.
+type SnapshotVerifier struct {
+	publicKey   crypto.PublicKey
+	manifestKey string
+
+	mutex   sync.Mutex
+	serials map[string]uint64
+}
+
+// NewSnapshotVerifier loads the PEM encoded publisher public key.
+func NewSnapshotVerifier(publicKeyFile, manifestKey string) (*SnapshotVerifier, error) {
+	publicKey, err := loadPublicKey(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotVerifier{
+		publicKey:   publicKey,
+		manifestKey: path.Join("/", manifestKey),
+		serials:     make(map[string]uint64),
+	}, nil
+}
+
+// Verify checks that kvs, whose keys are relative to prefix, match the
+// signed manifest of prefix found among them, and that the manifest is not
+// older than the last one accepted for prefix. The manifest key is removed
+// from kvs.
+func (v *SnapshotVerifier) Verify(prefix string, kvs map[string]string) error {
+	prefix = path.Join("/", prefix)
+	value, ok := kvs[v.manifestKey]
+	if !ok {
+		return fmt.Errorf("manifest %s not found", v.manifestKey)
+	}
+	delete(kvs, v.manifestKey)
+
+	var signed SignedManifest
+	if err := json.Unmarshal([]byte(value), &signed); err != nil {
+		return fmt.Errorf("invalid manifest: %v", err)
+	}
+	if err := verifySignature(v.publicKey, []byte(signed.Manifest), signed.Signature); err != nil {
+		if err == errInvalidSignature {
+			return errors.New("invalid manifest signature")
+		}
+		return err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(signed.Manifest), &manifest); err != nil {
+		return fmt.Errorf("invalid manifest: %v", err)
+	}
+	if path.Join("/", manifest.Prefix) != prefix {
+		return fmt.Errorf("manifest signed for prefix %s instead of %s", manifest.Prefix, prefix)
+	}
+	if manifest.Serial == 0 {
+		return errors.New("manifest has no serial")
+	}
+	if !manifest.Expires.IsZero() && time.Now().After(manifest.Expires) {
+		return fmt.Errorf("manifest %d expired at %v", manifest.Serial, manifest.Expires)
+	}
+
+	signedKeys := make(map[string]string, len(manifest.Keys))
+	for k, h := range manifest.Keys {
+		signedKeys[path.Join("/", k)] = strings.ToLower(h)
+	}
+
+	for k, value := range kvs {
+		h, ok := signedKeys[k]
+		if !ok {
+			return fmt.Errorf("key %s is not in the manifest", k)
+		}
+		sum := sha256.Sum256([]byte(value))
+		if hex.EncodeToString(sum[:]) != h {
+			return fmt.Errorf("key %s doesn't match the manifest", k)
+		}
+	}
+	for k := range signedKeys {
+		if _, ok := kvs[k]; !ok {
+			return fmt.Errorf("key %s from the manifest is missing", k)
+		}
+	}
+
+	// the same manifest is verified again on every render
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if last := v.serials[prefix]; manifest.Serial < last {
+		return fmt.Errorf("manifest %d is older than manifest %d already accepted", manifest.Serial, last)
+	}
+	v.serials[prefix] = manifest.Serial
+	return nil
+}
+
+// errInvalidSignature is returned for signatures not matching the data.
+var errInvalidSignature = errors.New("invalid signature")
+
+// loadPublicKey loads the PEM encoded RSA or ECDSA public key of
+// publicKeyFile.
+func loadPublicKey(publicKeyFile string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in %s", publicKeyFile)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("Unsupported public key type in %s", publicKeyFile)
+	}
+	return publicKey, nil
+}
+
+// verifySignature checks the base64 encoded signature of data made with the
+// private key of publicKey, RSA PKCS#1 v1.5 or ASN.1 ECDSA over SHA-256.
+func verifySignature(publicKey crypto.PublicKey, data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	digest := sha256.Sum256(data)
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return errInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		var esig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(key, digest[:], esig.R, esig.S) {
+			return errInvalidSignature
+		}
+	}
+	return nil
+}
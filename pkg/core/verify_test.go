@@ -0,0 +1,109 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signedManifest returns the manifest of kvs signed with key.
+func signedManifest(t *testing.T, key *ecdsa.PrivateKey, manifest Manifest, kvs map[string]string) string {
+	manifest.Keys = make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		sum := sha256.Sum256([]byte(v))
+		manifest.Keys[k] = hex.EncodeToString(sum[:])
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := json.Marshal(SignedManifest{
+		Manifest:  string(data),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestSnapshotVerifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, key := newTestFileVerifier(t, dir)
+	verifier, err := NewSnapshotVerifier(filepath.Join(dir, "key.pub"), ".manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]string{"/db/host": "db1", "/db/port": "5432"}
+	snapshot := func(manifest Manifest, kvs map[string]string) map[string]string {
+		snapshot := map[string]string{"/.manifest": signedManifest(t, key, manifest, data)}
+		for k, v := range kvs {
+			snapshot[k] = v
+		}
+		return snapshot
+	}
+
+	tests := []struct {
+		desc     string
+		prefix   string
+		manifest Manifest
+		kvs      map[string]string
+		valid    bool
+	}{
+		{"valid", "/app", Manifest{Prefix: "/app", Serial: 2}, data, true},
+		{"same serial", "/app/", Manifest{Prefix: "app", Serial: 2}, data, true},
+		{"older serial", "/app", Manifest{Prefix: "/app", Serial: 1}, data, false},
+		{"newer serial", "/app", Manifest{Prefix: "/app", Serial: 3, Expires: time.Now().Add(time.Hour)}, data, true},
+		{"serials are per prefix", "/other", Manifest{Prefix: "/other", Serial: 1}, data, true},
+		{"another prefix", "/other", Manifest{Prefix: "/app", Serial: 4}, data, false},
+		{"no serial", "/new", Manifest{Prefix: "/new"}, data, false},
+		{"expired", "/app", Manifest{Prefix: "/app", Serial: 5, Expires: time.Now().Add(-time.Minute)}, data, false},
+		{"modified key", "/app", Manifest{Prefix: "/app", Serial: 6}, map[string]string{"/db/host": "evil", "/db/port": "5432"}, false},
+		{"unsigned key", "/app", Manifest{Prefix: "/app", Serial: 6}, map[string]string{"/db/host": "db1", "/db/port": "5432", "/db/user": "evil"}, false},
+		{"missing key", "/app", Manifest{Prefix: "/app", Serial: 6}, map[string]string{"/db/host": "db1"}, false},
+	}
+
+	for _, tt := range tests {
+		err := verifier.Verify(tt.prefix, snapshot(tt.manifest, tt.kvs))
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected the data to be accepted, got: %v", tt.desc, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected the data to be refused", tt.desc)
+		}
+	}
+
+	// refused manifests don't move the last accepted serial
+	if err := verifier.Verify("/app", snapshot(Manifest{Prefix: "/app", Serial: 3}, data)); err != nil {
+		t.Errorf("Expected manifest 3 to still be accepted, got: %v", err)
+	}
+
+	kvs := snapshot(Manifest{Prefix: "/app", Serial: 3}, data)
+	kvs["/.manifest"] = `{"manifest": "{}", "signature": "c2lnbmF0dXJl"}`
+	if err := verifier.Verify("/app", kvs); err == nil {
+		t.Errorf("Expected an invalid signature to be refused")
+	}
+	delete(kvs, "/.manifest")
+	if err := verifier.Verify("/app", kvs); err == nil {
+		t.Errorf("Expected data without a manifest to be refused")
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// watchdog bounds the time a render, including its check and reload
+// commands, may take.
+type watchdog struct {
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// SetRenderTimeout sets the deadline of every render. Commands still running
+// when it expires are killed, along with their process group, and the
+// template is marked unhealthy until it renders again. Zero disables it.
+func (t *Template) SetRenderTimeout(timeout time.Duration) {
+	t.watchdog.timeout = timeout
+}
+
+// startWatchdog sets the deadline of the render about to start.
+func (t *Template) startWatchdog() {
+	if t.watchdog.timeout > 0 {
+		t.watchdog.deadline = time.Now().Add(t.watchdog.timeout)
+	} else {
+		t.watchdog.deadline = time.Time{}
+	}
+}
+
+// runCommand runs c in its own process group and returns its combined
+// output. If the command runs for longer than timeout, unless zero, or the
+// render deadline expires first the whole group is killed.
+func (t *Template) runCommand(c *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+
+	var expired, timedOut <-chan time.Time
+	if !t.watchdog.deadline.IsZero() {
+		remaining := t.watchdog.deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return nil, t.stuck(c)
+		}
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timedOut = timer.C
+	}
+
+	if err := startCommand(c); err != nil {
+		return nil, err
+	}
+	defer finishCommand(c)
+	done := make(chan error, 1)
+	go func() {
+		done <- waitCommand(c)
+	}()
+
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-expired:
+		killProcessGroup(c)
+		<-done
+		return output.Bytes(), t.stuck(c)
+	case <-timedOut:
+		killProcessGroup(c)
+		<-done
+		return output.Bytes(), fmt.Errorf("%q timed out after %v", c.Args[len(c.Args)-1], timeout)
+	}
+}
+
+// stuck marks the template unhealthy, unless advisory, and returns the
+// error of a render that exceeded its deadline running c.
+func (t *Template) stuck(c *exec.Cmd) error {
+	err := fmt.Errorf("Render of %s exceeded its deadline of %v running %q", t.config.Dest, t.watchdog.timeout, c.Args[len(c.Args)-1])
+	t.logger.Error(err)
+
+	if !t.config.Advisory {
+		t.statusMutex.Lock()
+		t.status.Unhealthy = true
+		t.statusMutex.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+)
+
+func TestRunCommandTimeoutWithLeftoverProcess(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid not available")
+	}
+	tr := NewTemplate(config.NewTemplateConfig(), false, false, false)
+
+	// the process started by setsid leaves the group, keeping the output
+	// open after the group was killed
+	c := exec.Command("/bin/sh", "-c", "setsid sleep 10 & sleep 10")
+	start := time.Now()
+	_, err := tr.runCommand(c, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > outputWaitDelay+2*time.Second {
+		t.Errorf("runCommand returned after %v", elapsed)
+	}
+}
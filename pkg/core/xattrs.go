@@ -0,0 +1,42 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// setXattrs gives the staged file name the extended attributes of the
+// destination, if kept, and the SELinux context of the template, if any,
+// for them to survive the destination being replaced.
+func (t *Template) setXattrs(name string) error {
+	if t.config.KeepXattrs && util.IsFileExist(t.config.Dest) {
+		if err := util.CopyXattrs(t.config.Dest, name); err != nil {
+			return fmt.Errorf("Unable to keep the extended attributes of %s: %v", t.config.Dest, err)
+		}
+	}
+	if t.config.SELinux != "" {
+		if err := util.SetSELinuxContext(name, t.config.SELinux); err != nil {
+			return fmt.Errorf("Unable to set the SELinux context of %s: %v", t.config.Dest, err)
+		}
+	}
+	return nil
+}
+
+// fixSELinuxContext sets the SELinux context of the template back on the
+// destination if it was changed, e.g. by a restorecon(8) run with another
+// policy, while its contents are in sync.
+func (t *Template) fixSELinuxContext() error {
+	if t.config.SELinux == "" {
+		return nil
+	}
+	context, err := util.SELinuxContext(t.config.Dest)
+	if err != nil || context == t.config.SELinux {
+		return err
+	}
+	t.logger.Infof("Setting the SELinux context of %s back to %s", t.config.Dest, t.config.SELinux)
+	if err := util.SetSELinuxContext(t.config.Dest, t.config.SELinux); err != nil {
+		return fmt.Errorf("Unable to set the SELinux context of %s: %v", t.config.Dest, err)
+	}
+	return nil
+}
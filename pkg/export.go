@@ -0,0 +1,247 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+const ociLayerMediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+// exportEntry is a rendered file ready to be archived.
+type exportEntry struct {
+	name     string
+	mode     os.FileMode
+	uid      int
+	gid      int
+	contents []byte
+}
+
+// ociDescriptor describes a layer blob as expected by OCI image manifests,
+// the diff id is required to append the layer to the image config rootfs.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	DiffID    string `json:"diffID"`
+}
+
+// Export renders every template against the backend data and writes the
+// results into an archive at ec.Output ("-" for stdout) instead of syncing
+// the destinations.
+func Export(gc *config.GlobalConfig, bc config.BackendConfig, ec *config.ExportConfig) {
+	configureLogging(gc)
+
+	tcs := getTemplateConfigs(gc)
+	client := newStoreClient(gc, bc)
+	defer client.Close()
+
+	datasources, err := newDatasources(gc, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verifier, err := newVerifier(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	custom, err := loadCustomFuncs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries, err := renderEntries(tcs, client, datasources, verifier, gc, custom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if ec.Output != "-" {
+		f, err := os.Create(ec.Output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch ec.Format {
+	case config.ExportFormatTar:
+		err = writeTar(w, entries, time.Now(), false)
+	case config.ExportFormatOCILayer:
+		err = writeOCILayer(w, entries, ec.Descriptor)
+	default:
+		err = fmt.Errorf("Unknown export format %q", ec.Format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// renderEntries renders every template without touching its destination.
+func renderEntries(tcs []*config.TemplateConfig, client store.Store, datasources *core.Datasources, verifier *core.SnapshotVerifier, gc *config.GlobalConfig, custom *customFuncs) ([]exportEntry, error) {
+	entries := make([]exportEntry, 0, len(tcs))
+	for _, tc := range tcs {
+		if tc.IsStdout() {
+			log.Warningf("Skipping %s, writing to stdout", tc.Src)
+			continue
+		}
+		template := core.NewTemplate(tc, false, false, true)
+		template.SetDatasources(datasources)
+		template.SetVerifier(verifier)
+		if err := setTemplateFuncs(template, gc, custom); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := core.NewOnDemandProcessor(template, client).Execute(&buf); err != nil {
+			return nil, fmt.Errorf("Unable to export %s: %v", tc.Dest, err)
+		}
+
+		fileMode, err := template.ExpectedFileMode()
+		if err != nil {
+			return nil, err
+		}
+
+		dest, err := filepath.Abs(tc.Dest)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, exportEntry{
+			name:     strings.TrimPrefix(dest, "/"),
+			mode:     fileMode.Perm(),
+			uid:      tc.Uid,
+			gid:      tc.Gid,
+			contents: buf.Bytes(),
+		})
+	}
+	return entries, nil
+}
+
+// writeTar archives entries into w. If withDirs is set, parent directories
+// are added before the files they contain.
+func writeTar(w io.Writer, entries []exportEntry, modTime time.Time, withDirs bool) error {
+	tw := tar.NewWriter(w)
+
+	if withDirs {
+		for _, dir := range parentDirs(entries) {
+			hdr := &tar.Header{
+				Name:     dir + "/",
+				Mode:     0755,
+				ModTime:  modTime,
+				Typeflag: tar.TypeDir,
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     int64(e.mode),
+			Uid:      e.uid,
+			Gid:      e.gid,
+			Size:     int64(len(e.contents)),
+			ModTime:  modTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(e.contents); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeOCILayer writes entries as a gzipped layer. Entries are sorted and
+// timestamps zeroed so that the same data always produces the same digest.
+// If descriptor is set, the layer descriptor is written there as JSON.
+func writeOCILayer(w io.Writer, entries []exportEntry, descriptor string) error {
+	sorted := make([]exportEntry, len(entries))
+	copy(sorted, entries)
+	sort.Sort(byEntryName(sorted))
+
+	blobHash := sha256.New()
+	counter := &countingWriter{}
+	zw := gzip.NewWriter(io.MultiWriter(w, blobHash, counter))
+
+	diffHash := sha256.New()
+	if err := writeTar(io.MultiWriter(zw, diffHash), sorted, time.Unix(0, 0), true); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	desc := ociDescriptor{
+		MediaType: ociLayerMediaType,
+		Digest:    fmt.Sprintf("sha256:%x", blobHash.Sum(nil)),
+		Size:      counter.n,
+		DiffID:    fmt.Sprintf("sha256:%x", diffHash.Sum(nil)),
+	}
+	log.Infof("Layer digest %s, diff id %s, size %d", desc.Digest, desc.DiffID, desc.Size)
+
+	if descriptor == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(descriptor, append(data, '\n'), 0644)
+}
+
+// parentDirs returns the sorted, unique set of directories containing the
+// entries.
+func parentDirs(entries []exportEntry) []string {
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		for dir := path.Dir(e.name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			seen[dir] = true
+		}
+	}
+
+	dirs := make([]string, 0, len(seen))
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+type byEntryName []exportEntry
+
+func (e byEntryName) Len() int           { return len(e) }
+func (e byEntryName) Less(i, j int) bool { return e[i].name < e[j].name }
+func (e byEntryName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
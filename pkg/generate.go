@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// generator is a starter template resource for a common use case, reading
+// the keys laid out as <prefix>/<service>/<instance> = <host>:<port>.
+type generator struct {
+	description string
+	dest        string
+	checkCmd    string
+	reloadCmd   string
+	// template is the template source, @PREFIX@ being replaced by the
+	// prefix of the keys
+	template string
+}
+
+var generators = map[string]generator{
+	"nginx-upstreams": {
+		description: "Generate an nginx template rendering an upstream block per service",
+		dest:        "/etc/nginx/conf.d/upstreams.conf",
+		reloadCmd:   "/usr/sbin/nginx -s reload",
+		template: `# Generated by renderizr from the keys laid out as
+# @PREFIX@/<service>/<instance> = <host>:<port>
+{{range $service := lsdir "@PREFIX@"}}
+upstream {{$service}} {
+{{- range getvs (printf "@PREFIX@/%s/*" $service)}}
+    server {{.}};
+{{- end}}
+}
+{{end}}`,
+	},
+	"haproxy-backends": {
+		description: "Generate an haproxy template rendering a backend section per service",
+		dest:        "/etc/haproxy/backends.cfg",
+		checkCmd:    "/usr/sbin/haproxy -c -f /etc/haproxy/haproxy.cfg -f {{.}}",
+		reloadCmd:   "/bin/systemctl reload haproxy",
+		template: `# Generated by renderizr from the keys laid out as
+# @PREFIX@/<service>/<instance> = <host>:<port>
+{{range $service := lsdir "@PREFIX@"}}
+backend {{$service}}
+    balance roundrobin
+{{- range gets (printf "@PREFIX@/%s/*" $service)}}
+    server {{base .Key}} {{.Value}} check
+{{- end}}
+{{end}}`,
+	},
+}
+
+// Generators returns the names of the generators along with their
+// descriptions.
+func Generators() map[string]string {
+	descriptions := make(map[string]string, len(generators))
+	for name, g := range generators {
+		descriptions[name] = g.description
+	}
+	return descriptions
+}
+
+// Generate writes the template resource of the named generator into the
+// confdir layout at gc.Output: conf.d/<name>.toml and templates/<name>.tmpl.
+func Generate(gc *config.GenerateConfig, name string) {
+	g, ok := generators[name]
+	if !ok {
+		log.Fatalf("Unknown generator %q, should be one of: %s", name, strings.Join(generatorNames(), ", "))
+	}
+	prefix := path.Clean("/" + gc.Prefix)
+	dest := gc.Dest
+	if dest == "" {
+		dest = g.dest
+	}
+
+	resource := fmt.Sprintf("[template]\nsrc = %q\ndest = %q\nmode = \"0644\"\nkeys = [\n  %q,\n]\n",
+		name+".tmpl", dest, prefix)
+	if g.checkCmd != "" {
+		resource += fmt.Sprintf("check_cmd = %q\n", g.checkCmd)
+	}
+	if g.reloadCmd != "" {
+		resource += fmt.Sprintf("reload_cmd = %q\n", g.reloadCmd)
+	}
+	template := strings.Replace(g.template, "@PREFIX@", prefix, -1)
+
+	files := []struct{ name, contents string }{
+		{filepath.Join(gc.Output, "templates", name+".tmpl"), template},
+		{filepath.Join(gc.Output, "conf.d", name+".toml"), resource},
+	}
+	for _, f := range files {
+		if err := writeGenerated(f.name, f.contents, gc.Force); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Wrote %s", f.name)
+	}
+	log.Infof("Render it with: renderizr --confdir=%s <backend>", gc.Output)
+}
+
+// generatorNames returns the sorted names of the generators.
+func generatorNames() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeGenerated writes contents to name, refusing to overwrite an
+// existing file unless forced.
+func writeGenerated(name, contents string, force bool) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", name)
+		}
+		return err
+	}
+	if _, err := f.WriteString(contents); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/core"
+)
+
+// inventory lists the files managed by a renderizr instance, for CMDB and
+// asset systems to consume.
+type inventory struct {
+	Node      string          `json:"node"`
+	Generated time.Time       `json:"generated"`
+	Files     []inventoryFile `json:"files"`
+}
+
+// inventoryFile describes a destination as found on disk after a cycle,
+// along with the template resource it comes from.
+type inventoryFile struct {
+	Path       string    `json:"path"`
+	Template   string    `json:"template"`
+	Exists     bool      `json:"exists"`
+	Mode       string    `json:"mode,omitempty"`
+	Uid        int       `json:"uid"`
+	Gid        int       `json:"gid"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256,omitempty"`
+	ModTime    time.Time `json:"mod_time"`
+	InSync     bool      `json:"in_sync"`
+	LastRender time.Time `json:"last_render"`
+	LastChange time.Time `json:"last_change"`
+}
+
+// newInventory describes the destinations of templates, those written to
+// stdout left out.
+func newInventory(node string, templates []*core.Template) (*inventory, error) {
+	inv := &inventory{Node: node, Generated: time.Now(), Files: []inventoryFile{}}
+	for _, template := range templates {
+		tc := template.Config()
+		if tc.IsStdout() {
+			continue
+		}
+		status := template.Status()
+		file := inventoryFile{
+			Path:       tc.Dest,
+			Template:   tc.Src,
+			InSync:     status.InSync,
+			LastRender: status.LastRender,
+			LastChange: status.LastChange,
+		}
+
+		data, err := ioutil.ReadFile(tc.Dest)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			fi, err := os.Stat(tc.Dest)
+			if err != nil {
+				return nil, err
+			}
+			file.Exists = true
+			file.Mode = fmt.Sprintf("%04o", fi.Mode().Perm())
+			file.Size = fi.Size()
+			file.ModTime = fi.ModTime()
+			file.SHA256 = fmt.Sprintf("%x", sha256.Sum256(data))
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				file.Uid, file.Gid = int(st.Uid), int(st.Gid)
+			}
+		}
+		inv.Files = append(inv.Files, file)
+	}
+	return inv, nil
+}
+
+// writeInventory writes the inventory of the destinations to the inventory
+// file, atomically replacing the previous one.
+func (c *Controller) writeInventory() error {
+	inv, err := newInventory(c.gc.NodeName, c.templates)
+	if err != nil {
+		return fmt.Errorf("Unable to take the inventory: %v", err)
+	}
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(c.gc.InventoryFile), "."+filepath.Base(c.gc.InventoryFile))
+	if err != nil {
+		return fmt.Errorf("Unable to write the inventory: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(append(data, '\n'))
+	if cerr := tempFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tempFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), c.gc.InventoryFile)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to write the inventory: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/backends"
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"gopkg.in/yaml.v2"
+)
+
+// KVImport writes the keys of the YAML/JSON document at kc.File ("-" for
+// stdin) into the backend under the global prefix, nested maps and lists
+// becoming path components like the fixture backend does.
+func KVImport(gc *config.GlobalConfig, bc config.BackendConfig, kc *config.KVConfig) {
+	configureLogging(gc)
+
+	data, err := readKVFile(kc.File)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		log.Fatalf("Unable to parse %s: %v", kc.File, err)
+	}
+	kvs := backends.FlattenDocument(doc)
+
+	client := newKVClient(gc, bc)
+	defer client.Close()
+
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key := path.Join("/", gc.Prefix, k)
+		if err := client.Put(backends.StoreKey(key), []byte(kvs[k]), nil); err != nil {
+			log.Fatalf("Unable to write %s: %v", key, err)
+		}
+	}
+	log.Infof("Imported %d keys under %s", len(keys), path.Join("/", gc.Prefix))
+}
+
+// KVExport writes the keys of the backend under the global prefix to
+// kc.File ("-" for stdout) as a YAML or JSON document, which KVImport
+// loads back.
+func KVExport(gc *config.GlobalConfig, bc config.BackendConfig, kc *config.KVConfig) {
+	configureLogging(gc)
+
+	client := newKVClient(gc, bc)
+	defer client.Close()
+
+	prefix := path.Join("/", gc.Prefix)
+	pairs, err := client.List(prefix)
+	if err != nil && err != store.ErrKeyNotFound {
+		log.Fatalf("Unable to list %s: %v", prefix, err)
+	}
+	doc, err := unflattenPairs(prefix, pairs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var data []byte
+	switch kc.Format {
+	case config.KVFormatYAML:
+		data, err = yaml.Marshal(doc)
+	case config.KVFormatJSON:
+		data, err = json.MarshalIndent(doc, "", "  ")
+		data = append(data, '\n')
+	default:
+		log.Fatalf("Unknown format %q, should be %s or %s", kc.Format, config.KVFormatYAML, config.KVFormatJSON)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if kc.File != "-" {
+		f, err := os.Create(kc.File)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(data); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Exported %d keys under %s", len(backends.FlattenDocument(doc)), prefix)
+}
+
+// keyRewrite renames the keys matching pattern to replacement, which may
+// refer to the groups of the pattern like $1.
+type keyRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// KVMigrate copies the keys under the global prefix from the backend from to
+// the backend to. Keys matching kc.Exclude are skipped and the others are
+// renamed by the first of kc.Rewrites, 'pattern=replacement' rules, they
+// match, an empty replacement skipping them too.
+func KVMigrate(gc *config.GlobalConfig, from, to config.BackendConfig, kc *config.KVConfig) {
+	configureLogging(gc)
+
+	rewrites := make([]keyRewrite, 0, len(kc.Rewrites))
+	for _, r := range kc.Rewrites {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("Rewrite should be provided as 'pattern=replacement': %s", r)
+		}
+		pattern, err := regexp.Compile(parts[0])
+		if err != nil {
+			log.Fatalf("Invalid rewrite pattern %q: %v", parts[0], err)
+		}
+		rewrites = append(rewrites, keyRewrite{pattern: pattern, replacement: parts[1]})
+	}
+	var exclude *regexp.Regexp
+	if kc.Exclude != "" {
+		var err error
+		if exclude, err = regexp.Compile(kc.Exclude); err != nil {
+			log.Fatalf("Invalid exclude pattern %q: %v", kc.Exclude, err)
+		}
+	}
+
+	src := newKVClient(gc, from)
+	defer src.Close()
+	// keys are written as rewritten, not into the backends mounted
+	dgc := *gc
+	dgc.BackendMounts = nil
+	dst := newKVClient(&dgc, to)
+	defer dst.Close()
+
+	prefix := path.Join("/", gc.Prefix)
+	pairs, err := src.List(prefix)
+	if err != nil && err != store.ErrKeyNotFound {
+		log.Fatalf("Unable to list %s: %v", prefix, err)
+	}
+
+	copied := 0
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") && len(pair.Value) == 0 {
+			continue
+		}
+		key := path.Join("/", pair.Key)
+		if exclude != nil && exclude.MatchString(key) {
+			log.Debugf("Skipping excluded %s", key)
+			continue
+		}
+		target := key
+		for _, r := range rewrites {
+			if r.pattern.MatchString(key) {
+				target = r.pattern.ReplaceAllString(key, r.replacement)
+				break
+			}
+		}
+		if target == "" {
+			log.Debugf("Skipping %s, rewritten to nothing", key)
+			continue
+		}
+		target = path.Join("/", target)
+
+		if kc.DryRun {
+			log.Infof("Would copy %s to %s", key, target)
+		} else if err := dst.Put(backends.StoreKey(target), pair.Value, nil); err != nil {
+			log.Fatalf("Unable to write %s: %v", target, err)
+		}
+		copied++
+	}
+	if kc.DryRun {
+		log.Infof("Would copy %d keys from %s to %s", copied, from.Type(), to.Type())
+		return
+	}
+	log.Infof("Copied %d keys from %s to %s", copied, from.Type(), to.Type())
+}
+
+// newKVClient connects to the backend, without the local overrides which
+// are not backend data.
+func newKVClient(gc *config.GlobalConfig, bc config.BackendConfig) store.Store {
+	kgc := *gc
+	kgc.OverridesFile = ""
+	return newStoreClient(&kgc, bc)
+}
+
+func readKVFile(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// unflattenPairs nests the pairs under prefix into maps, one level per path
+// component. Directory placeholders, keys ending in / without a value, are
+// left out.
+func unflattenPairs(prefix string, pairs []*store.KVPair) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") && len(pair.Value) == 0 {
+			continue
+		}
+		key := path.Join("/", pair.Key)
+		rel := strings.Trim(strings.TrimPrefix(key, prefix), "/")
+		if rel == "" || (prefix != "/" && !strings.HasPrefix(key, prefix+"/")) {
+			continue
+		}
+
+		parts := strings.Split(rel, "/")
+		m := doc
+		for i, part := range parts {
+			if i == len(parts)-1 {
+				if _, ok := m[part].(map[string]interface{}); ok {
+					return nil, fmt.Errorf("Key %s has both a value and keys under it, it cannot be exported as a document", key)
+				}
+				m[part] = string(pair.Value)
+				break
+			}
+			switch child := m[part].(type) {
+			case map[string]interface{}:
+				m = child
+			case nil:
+				next := make(map[string]interface{})
+				m[part] = next
+				m = next
+			default:
+				return nil, fmt.Errorf("Key %s has both a value and keys under it, it cannot be exported as a document",
+					path.Join(prefix, path.Join(parts[:i+1]...)))
+			}
+		}
+	}
+	return doc, nil
+}
@@ -4,7 +4,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/docker/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
 	"github.com/stretchr/testify/assert"
 )
 
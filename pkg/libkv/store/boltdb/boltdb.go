@@ -11,8 +11,8 @@ import (
 	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/docker/libkv"
-	"github.com/docker/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/libkv"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
 )
 
 var (
@@ -29,7 +29,7 @@ const (
 	filePerm os.FileMode = 0644
 )
 
-//BoltDB type implements the Store interface
+// BoltDB type implements the Store interface
 type BoltDB struct {
 	client     *bolt.DB
 	boltBucket []byte
@@ -164,7 +164,7 @@ func (b *BoltDB) Get(key string) (*store.KVPair, error) {
 	return &store.KVPair{Key: key, Value: val, LastIndex: (dbIndex)}, nil
 }
 
-//Put the key, value pair. index number metadata is prepended to the value
+// Put the key, value pair. index number metadata is prepended to the value
 func (b *BoltDB) Put(key string, value []byte, opts *store.WriteOptions) error {
 	var (
 		dbIndex uint64
@@ -200,7 +200,7 @@ func (b *BoltDB) Put(key string, value []byte, opts *store.WriteOptions) error {
 	return err
 }
 
-//Delete the value for the given key.
+// Delete the value for the given key.
 func (b *BoltDB) Delete(key string) error {
 	var (
 		db  *bolt.DB
@@ -3,13 +3,14 @@ package consul
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/docker/libkv"
-	"github.com/docker/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/libkv"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
 	api "github.com/hashicorp/consul/api"
 )
 
@@ -38,8 +39,9 @@ var (
 // Store interface
 type Consul struct {
 	sync.Mutex
-	config *api.Config
-	client *api.Client
+	config      *api.Config
+	client      *api.Client
+	consistency string
 }
 
 type consulLock struct {
@@ -64,7 +66,7 @@ func New(endpoints []string, options *store.Config) (store.Store, error) {
 	// Create Consul client
 	config := api.DefaultConfig()
 	s.config = config
-	config.HttpClient = http.DefaultClient
+	config.HttpClient = &http.Client{}
 	config.Address = endpoints[0]
 	config.Scheme = "http"
 
@@ -76,6 +78,12 @@ func New(endpoints []string, options *store.Config) (store.Store, error) {
 		if options.ConnectionTimeout != 0 {
 			s.setTimeout(options.ConnectionTimeout)
 		}
+		switch options.Consistency {
+		case "", "default", "consistent", "stale":
+			s.consistency = options.Consistency
+		default:
+			return nil, fmt.Errorf("unknown consul consistency mode %q, expected default, consistent or stale", options.Consistency)
+		}
 	}
 
 	// Creates a new client
@@ -101,6 +109,19 @@ func (s *Consul) setTimeout(time time.Duration) {
 	s.config.WaitTime = time
 }
 
+// queryOptions sets the consistency mode requested, if any, on opts
+func (s *Consul) queryOptions(opts *api.QueryOptions) *api.QueryOptions {
+	switch s.consistency {
+	case "default":
+		opts.AllowStale, opts.RequireConsistent = false, false
+	case "consistent":
+		opts.AllowStale, opts.RequireConsistent = false, true
+	case "stale":
+		opts.AllowStale, opts.RequireConsistent = true, false
+	}
+	return opts
+}
+
 // Normalize the key for usage in Consul
 func (s *Consul) normalize(key string) string {
 	key = store.Normalize(key)
@@ -161,10 +182,10 @@ func (s *Consul) getActiveSession(key string) (string, error) {
 // Get the value at "key", returns the last modified index
 // to use in conjunction to CAS calls
 func (s *Consul) Get(key string) (*store.KVPair, error) {
-	options := &api.QueryOptions{
+	options := s.queryOptions(&api.QueryOptions{
 		AllowStale:        false,
 		RequireConsistent: true,
-	}
+	})
 
 	pair, meta, err := s.client.KV().Get(s.normalize(key), options)
 	if err != nil {
@@ -176,7 +197,7 @@ func (s *Consul) Get(key string) (*store.KVPair, error) {
 		return nil, store.ErrKeyNotFound
 	}
 
-	return &store.KVPair{Key: pair.Key, Value: pair.Value, LastIndex: meta.LastIndex}, nil
+	return &store.KVPair{Key: pair.Key, Value: pair.Value, LastIndex: meta.LastIndex, Ephemeral: pair.Session != ""}, nil
 }
 
 // Put a value at "key"
@@ -229,7 +250,7 @@ func (s *Consul) Exists(key string) (bool, error) {
 
 // List child nodes of a given directory
 func (s *Consul) List(directory string) ([]*store.KVPair, error) {
-	pairs, _, err := s.client.KV().List(s.normalize(directory), nil)
+	pairs, _, err := s.client.KV().List(s.normalize(directory), s.queryOptions(&api.QueryOptions{}))
 	if err != nil {
 		return nil, err
 	}
@@ -247,6 +268,7 @@ func (s *Consul) List(directory string) ([]*store.KVPair, error) {
 			Key:       pair.Key,
 			Value:     pair.Value,
 			LastIndex: pair.ModifyIndex,
+			Ephemeral: pair.Session != "",
 		})
 	}
 
@@ -276,7 +298,7 @@ func (s *Consul) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair
 
 		// Use a wait time in order to check if we should quit
 		// from time to time.
-		opts := &api.QueryOptions{WaitTime: DefaultWatchWaitTime}
+		opts := s.queryOptions(&api.QueryOptions{WaitTime: DefaultWatchWaitTime})
 
 		for {
 			// Check if we should quit
@@ -306,6 +328,7 @@ func (s *Consul) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair
 					Key:       pair.Key,
 					Value:     pair.Value,
 					LastIndex: pair.ModifyIndex,
+					Ephemeral: pair.Session != "",
 				}
 			}
 		}
@@ -328,7 +351,7 @@ func (s *Consul) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*
 
 		// Use a wait time in order to check if we should quit
 		// from time to time.
-		opts := &api.QueryOptions{WaitTime: DefaultWatchWaitTime}
+		opts := s.queryOptions(&api.QueryOptions{WaitTime: DefaultWatchWaitTime})
 		for {
 			// Check if we should quit
 			select {
@@ -360,6 +383,7 @@ func (s *Consul) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*
 					Key:       pair.Key,
 					Value:     pair.Value,
 					LastIndex: pair.ModifyIndex,
+					Ephemeral: pair.Session != "",
 				})
 			}
 			watchCh <- kvpairs
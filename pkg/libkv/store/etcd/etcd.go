@@ -3,17 +3,19 @@ package etcd
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 
 	etcd "github.com/coreos/etcd/client"
-	"github.com/docker/libkv"
-	"github.com/docker/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/libkv"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
 )
 
 var (
@@ -27,6 +29,9 @@ var (
 // Store interface
 type Etcd struct {
 	client etcd.KeysAPI
+	// quorum makes reads go through the raft log instead of being served
+	// by the member, serializable, possibly stale
+	quorum bool
 }
 
 type etcdLock struct {
@@ -53,19 +58,25 @@ func Register() {
 // New creates a new Etcd client given a list
 // of endpoints and an optional tls config
 func New(addrs []string, options *store.Config) (store.Store, error) {
-	s := &Etcd{}
+	s := &Etcd{quorum: true}
 
 	var (
 		entries []string
 		err     error
 	)
 
-	entries = store.CreateEndpoints(addrs, "http")
+	entries, dial := createEndpoints(addrs, "http")
 	cfg := &etcd.Config{
 		Endpoints:               entries,
 		Transport:               etcd.DefaultTransport,
 		HeaderTimeoutPerRequest: 3 * time.Second,
 	}
+	if dial != nil {
+		cfg.Transport = &http.Transport{
+			Dial:                dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}
+	}
 
 	// Set options
 	if options != nil {
@@ -75,6 +86,13 @@ func New(addrs []string, options *store.Config) (store.Store, error) {
 		if options.ConnectionTimeout != 0 {
 			setTimeout(cfg, options.ConnectionTimeout)
 		}
+		switch options.Consistency {
+		case "", "quorum":
+		case "serializable":
+			s.quorum = false
+		default:
+			return nil, fmt.Errorf("unknown etcd consistency mode %q, expected quorum or serializable", options.Consistency)
+		}
 	}
 
 	c, err := etcd.New(*cfg)
@@ -86,14 +104,14 @@ func New(addrs []string, options *store.Config) (store.Store, error) {
 
 	// Periodic Cluster Sync
 	/*
-	go func() {
-		for {
-			if err := c.AutoSync(context.Background(), periodicSync); err != nil {
-				return
+		go func() {
+			for {
+				if err := c.AutoSync(context.Background(), periodicSync); err != nil {
+					return
+				}
+				println(err.Error())
 			}
-			println(err.Error())
-		}
-	}()
+		}()
 	*/
 
 	return s, nil
@@ -101,15 +119,18 @@ func New(addrs []string, options *store.Config) (store.Store, error) {
 
 // SetTLS sets the tls configuration given a tls.Config scheme
 func setTLS(cfg *etcd.Config, tls *tls.Config, addrs []string) {
-	entries := store.CreateEndpoints(addrs, "https")
+	entries, dial := createEndpoints(addrs, "https")
 	cfg.Endpoints = entries
+	if dial == nil {
+		dial = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial
+	}
 
 	// Set transport
 	t := http.Transport{
-		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).Dial,
+		Dial:                dial,
 		TLSHandshakeTimeout: 10 * time.Second,
 		TLSClientConfig:     tls,
 	}
@@ -117,6 +138,38 @@ func setTLS(cfg *etcd.Config, tls *tls.Config, addrs []string) {
 	cfg.Transport = &t
 }
 
+// createEndpoints returns the URLs of addrs. Unix domain sockets, given as
+// unix:///path, are reached through placeholder hosts by the returned dial
+// function, which is nil if there are none.
+func createEndpoints(addrs []string, scheme string) ([]string, func(network, addr string) (net.Conn, error)) {
+	sockets := make(map[string]string)
+	entries := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "unix://") {
+			host := "unix-socket-" + strconv.Itoa(len(sockets))
+			sockets[host] = strings.TrimPrefix(addr, "unix://")
+			addr = host
+		}
+		entries = append(entries, scheme+"://"+addr)
+	}
+	if len(sockets) == 0 {
+		return entries, nil
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return entries, func(network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if path, ok := sockets[host]; ok {
+				return dialer.Dial("unix", path)
+			}
+		}
+		return dialer.Dial(network, addr)
+	}
+}
+
 // setTimeout sets the timeout used for connecting to the store
 func setTimeout(cfg *etcd.Config, time time.Duration) {
 	cfg.HeaderTimeoutPerRequest = time
@@ -147,7 +200,7 @@ func keyNotFound(err error) bool {
 // index to use in conjunction to Atomic calls
 func (s *Etcd) Get(key string) (pair *store.KVPair, err error) {
 	getOpts := &etcd.GetOptions{
-		Quorum: true,
+		Quorum: s.quorum,
 	}
 
 	result, err := s.client.Get(context.Background(), s.normalize(key), getOpts)
@@ -163,6 +216,9 @@ func (s *Etcd) Get(key string) (pair *store.KVPair, err error) {
 		Value:     []byte(result.Node.Value),
 		LastIndex: result.Node.ModifiedIndex,
 	}
+	if result.Node.Expiration != nil {
+		pair.Expiration = *result.Node.Expiration
+	}
 
 	return pair, nil
 }
@@ -394,7 +450,7 @@ func (s *Etcd) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
 func (s *Etcd) List(directory string) ([]*store.KVPair, error) {
 	var walkNode func(node *etcd.Node) []*store.KVPair
 	getOpts := &etcd.GetOptions{
-		Quorum:    true,
+		Quorum:    s.quorum,
 		Recursive: true,
 		Sort:      true,
 	}
@@ -409,11 +465,15 @@ func (s *Etcd) List(directory string) ([]*store.KVPair, error) {
 	walkNode = func(node *etcd.Node) []*store.KVPair {
 		kv := []*store.KVPair{}
 		if node != resp.Node {
-			kv = append(kv, &store.KVPair{
+			pair := &store.KVPair{
 				Key:       node.Key,
 				Value:     []byte(node.Value),
 				LastIndex: node.ModifiedIndex,
-			})
+			}
+			if node.Expiration != nil {
+				pair.Expiration = *node.Expiration
+			}
+			kv = append(kv, pair)
 		}
 		for _, v := range node.Nodes {
 			kv = append(kv, walkNode(v)...)
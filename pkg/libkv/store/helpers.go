@@ -14,8 +14,7 @@ func CreateEndpoints(addrs []string, scheme string) (entries []string) {
 
 // Normalize the key for each store to the form:
 //
-//     /path/to/key
-//
+//	/path/to/key
 func Normalize(key string) string {
 	return "/" + join(SplitKey(key))
 }
@@ -23,8 +22,7 @@ func Normalize(key string) string {
 // GetDirectory gets the full directory part of
 // the key to the form:
 //
-//     /path/to/
-//
+//	/path/to/
 func GetDirectory(key string) string {
 	parts := SplitKey(key)
 	parts = parts[:len(parts)-1]
@@ -46,6 +46,9 @@ type Config struct {
 	ConnectionTimeout time.Duration
 	Bucket            string
 	PersistConnection bool
+	// Consistency is the read consistency mode of the stores supporting
+	// several, each one keeps its own default if empty
+	Consistency string
 }
 
 // ClientTLSConfig contains data for a Client TLS configuration in the form
@@ -107,6 +110,12 @@ type KVPair struct {
 	Key       string
 	Value     []byte
 	LastIndex uint64
+	// Expiration is when the key expires, zero if it does not
+	// or the store does not tell
+	Expiration time.Time
+	// Ephemeral keys are deleted when the session of their
+	// owner ends, at a time that is not known in advance
+	Ephemeral bool
 }
 
 // WriteOptions contains optional request parameters
@@ -4,8 +4,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/docker/libkv"
-	"github.com/docker/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/libkv"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
 	zk "github.com/samuel/go-zookeeper/zk"
 )
 
@@ -85,6 +85,7 @@ func (s *Zookeeper) Get(key string) (pair *store.KVPair, err error) {
 		Key:       key,
 		Value:     resp,
 		LastIndex: uint64(meta.Version),
+		Ephemeral: meta.EphemeralOwner != 0,
 	}
 
 	return pair, nil
@@ -262,6 +263,7 @@ func (s *Zookeeper) List(directory string) ([]*store.KVPair, error) {
 			Key:       key,
 			Value:     []byte(pair.Value),
 			LastIndex: uint64(stat.Version),
+			Ephemeral: pair.Ephemeral,
 		})
 	}
 
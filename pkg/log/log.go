@@ -0,0 +1,320 @@
+// Package log is the logger of renderizr. Entries are written through glog by
+// default, or as logfmt text or JSON lines carrying their context fields for
+// log aggregation pipelines.
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarningLevel
+	ErrorLevel
+	FatalLevel
+)
+
+var levelNames = []string{"debug", "info", "warning", "error", "fatal"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > FatalLevel {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named name. Numbers are taken as glog
+// verbosities for compatibility, any above zero meaning debug.
+func ParseLevel(name string) (Level, error) {
+	if v, err := strconv.Atoi(name); err == nil {
+		if v > 0 {
+			return DebugLevel, nil
+		}
+		return InfoLevel, nil
+	}
+	name = strings.ToLower(name)
+	if name == "warn" {
+		return WarningLevel, nil
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level %q", name)
+}
+
+// Supported formats.
+const (
+	FormatGlog = "glog"
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Fields are the context of a log entry, e.g. the template it is about.
+type Fields map[string]interface{}
+
+type logger struct {
+	mutex  sync.Mutex
+	out    io.Writer
+	format string
+	level  Level
+}
+
+var std = &logger{out: os.Stderr, format: FormatGlog, level: InfoLevel}
+
+// Configure sets the format entries are written in and the lowest level
+// written. Context fields are left out of the glog format, which is the
+// default.
+func Configure(format, level string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatGlog, FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("Unknown log format %q", format)
+	}
+
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+	std.format = format
+	std.level = lvl
+	return nil
+}
+
+// Enabled reports whether entries of level are written.
+func Enabled(level Level) bool {
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+	return level >= std.level
+}
+
+// Entry logs messages with context fields.
+type Entry struct {
+	fields Fields
+}
+
+var empty = &Entry{}
+
+// WithFields returns an entry logging messages with fields.
+func WithFields(fields Fields) *Entry {
+	return empty.WithFields(fields)
+}
+
+// WithFields returns an entry logging messages with the fields of e along
+// with fields, which take precedence.
+func (e *Entry) WithFields(fields Fields) *Entry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Entry{fields: merged}
+}
+
+// Fields returns the context fields of e.
+func (e *Entry) Fields() Fields {
+	return e.fields
+}
+
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	e.output(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Info(args ...interface{}) {
+	e.output(InfoLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.output(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Warning(args ...interface{}) {
+	e.output(WarningLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Warningf(format string, args ...interface{}) {
+	e.output(WarningLevel, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Error(args ...interface{}) {
+	e.output(ErrorLevel, fmt.Sprint(args...))
+}
+
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.output(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs the message and exits with status 255.
+func (e *Entry) Fatal(args ...interface{}) {
+	e.output(FatalLevel, fmt.Sprint(args...))
+}
+
+// Fatalf logs the message and exits with status 255.
+func (e *Entry) Fatalf(format string, args ...interface{}) {
+	e.output(FatalLevel, fmt.Sprintf(format, args...))
+}
+
+func Debugf(format string, args ...interface{}) {
+	empty.output(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func Info(args ...interface{}) {
+	empty.output(InfoLevel, fmt.Sprint(args...))
+}
+
+func Infof(format string, args ...interface{}) {
+	empty.output(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func Warning(args ...interface{}) {
+	empty.output(WarningLevel, fmt.Sprint(args...))
+}
+
+func Warningf(format string, args ...interface{}) {
+	empty.output(WarningLevel, fmt.Sprintf(format, args...))
+}
+
+func Error(args ...interface{}) {
+	empty.output(ErrorLevel, fmt.Sprint(args...))
+}
+
+func Errorf(format string, args ...interface{}) {
+	empty.output(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs the message and exits with status 255.
+func Fatal(args ...interface{}) {
+	empty.output(FatalLevel, fmt.Sprint(args...))
+}
+
+// Fatalf logs the message and exits with status 255.
+func Fatalf(format string, args ...interface{}) {
+	empty.output(FatalLevel, fmt.Sprintf(format, args...))
+}
+
+// glogDepth is the depth of the caller of the logging functions from the
+// glog depth functions called by output.
+const glogDepth = 2
+
+func (e *Entry) output(level Level, msg string) {
+	std.mutex.Lock()
+	if level < std.level {
+		std.mutex.Unlock()
+		return
+	}
+	format := std.format
+	std.mutex.Unlock()
+
+	if format == FormatGlog {
+		switch level {
+		case DebugLevel, InfoLevel:
+			glog.InfoDepth(glogDepth, msg)
+		case WarningLevel:
+			glog.WarningDepth(glogDepth, msg)
+		case ErrorLevel:
+			glog.ErrorDepth(glogDepth, msg)
+		case FatalLevel:
+			glog.FatalDepth(glogDepth, msg)
+		}
+		return
+	}
+
+	var line []byte
+	if format == FormatJSON {
+		line = formatJSON(time.Now(), level, msg, e.fields)
+	} else {
+		line = formatText(time.Now(), level, msg, e.fields)
+	}
+
+	std.mutex.Lock()
+	std.out.Write(line)
+	std.mutex.Unlock()
+
+	if level == FatalLevel {
+		glog.Flush()
+		os.Exit(255)
+	}
+}
+
+// formatText formats an entry as a logfmt line, with its fields sorted by
+// name after the time, level and message.
+func formatText(t time.Time, level Level, msg string, fields Fields) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("time=")
+	buf.WriteString(t.Format(time.RFC3339Nano))
+	buf.WriteString(" level=")
+	buf.WriteString(level.String())
+	buf.WriteString(" msg=")
+	buf.WriteString(quote(msg))
+	for _, k := range sortedKeys(fields) {
+		buf.WriteByte(' ')
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(quote(fmt.Sprint(fields[k])))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// quote quotes s if it is empty or holds characters breaking logfmt.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// formatJSON formats an entry as a JSON object on its own line. Fields
+// named like time, level or msg do not replace them.
+func formatJSON(t time.Time, level Level, msg string, fields Fields) []byte {
+	obj := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		obj[k] = v
+	}
+	obj["time"] = t.Format(time.RFC3339Nano)
+	obj["level"] = level.String()
+	obj["msg"] = msg
+
+	line, err := json.Marshal(obj)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{
+			"time":  obj["time"].(string),
+			"level": level.String(),
+			"msg":   msg,
+			"error": "Unable to marshal fields: " + err.Error(),
+		})
+	}
+	return append(line, '\n')
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
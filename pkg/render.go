@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"os"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// Render renders every template from the keys of the rc.Values YAML/JSON
+// documents alone, without connecting to any backend. The outputs are
+// printed to stdout, each preceded by a header naming its destination when
+// there are several, or synced to the destinations like a onetime run if
+// rc.Write is set.
+func Render(gc *config.GlobalConfig, rc *config.RenderConfig) {
+	if len(rc.Values) == 0 {
+		configureLogging(gc)
+		log.Fatalf("At least a values file is required")
+	}
+
+	fbc := config.NewFixtureBackendConfig()
+	fbc.Files = rc.Values
+	if rc.Write {
+		ogc := *gc
+		ogc.Onetime = true
+		ogc.BackendMounts = nil
+		Run(&ogc, fbc)
+		return
+	}
+
+	configureLogging(gc)
+	tcs := getTemplateConfigs(gc)
+	client := newFixtureClient(gc, fbc)
+	defer client.Close()
+
+	datasources, err := newDatasources(gc, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	custom, err := loadCustomFuncs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := 0
+	for _, tc := range tcs {
+		template := core.NewTemplate(tc, true, false, true)
+		template.SetDatasources(datasources)
+		if err := setTemplateFuncs(template, gc, custom); err != nil {
+			log.Fatal(err)
+		}
+
+		if len(tcs) > 1 {
+			name := tc.Dest
+			if tc.IsStdout() {
+				name = tc.Src
+			}
+			core.WriteOutputHeader(os.Stdout, name)
+		}
+		if err := core.NewOnDemandProcessor(template, client).Execute(os.Stdout); err != nil {
+			log.Errorf("%s: %v", tc.Dest, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d templates failed", failed, len(tcs))
+	}
+}
+
+// newFixtureClient reads the keys of the fixture backend alone, ignoring
+// the backends mounted.
+func newFixtureClient(gc *config.GlobalConfig, fbc *config.FixtureBackendConfig) store.Store {
+	fgc := *gc
+	fgc.BackendMounts = nil
+	return newKVClient(&fgc, fbc)
+}
@@ -2,32 +2,37 @@ package pkg
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/csv"
 	"encoding/pem"
-	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/docker/libkv"
-	"github.com/docker/libkv/store"
-	"github.com/docker/libkv/store/boltdb"
-	"github.com/docker/libkv/store/consul"
-	"github.com/docker/libkv/store/etcd"
-	"github.com/docker/libkv/store/zookeeper"
+	"github.com/glerchundi/renderizr/pkg/backends"
 	"github.com/glerchundi/renderizr/pkg/config"
 	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/libkv"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/libkv/store/boltdb"
+	"github.com/glerchundi/renderizr/pkg/libkv/store/consul"
+	"github.com/glerchundi/renderizr/pkg/libkv/store/etcd"
+	"github.com/glerchundi/renderizr/pkg/libkv/store/zookeeper"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"github.com/glerchundi/renderizr/pkg/spiffe"
 	"github.com/glerchundi/renderizr/pkg/util"
-	"github.com/golang/glog"
-	"github.com/spf13/pflag"
 )
 
 // Register libkv supported stores
@@ -38,15 +43,293 @@ func init() {
 	boltdb.Register()
 }
 
+// shutdownGracePeriod is how long renders in flight are waited for on
+// shutdown before their commands are killed.
+const shutdownGracePeriod = 10 * time.Second
+
+// The wait between onetime retries doubles from minOnetimeDelay up to
+// maxOnetimeDelay.
+const (
+	minOnetimeDelay = time.Second
+	maxOnetimeDelay = 30 * time.Second
+)
+
 func Run(gc *config.GlobalConfig, bc config.BackendConfig) {
-	// configure logging.
-	logLevel := pflag.Lookup("log-level")
-	flag.Set("v", logLevel.Value.String())
+	started := time.Now()
+	configureLogging(gc)
+
+	if gc.OnceOnChange && gc.Onetime {
+		log.Fatalf("Once on change and onetime modes cannot be combined")
+	}
+	var sup *supervisor
+	if gc.Exec != "" {
+		if gc.Onetime {
+			log.Fatalf("Exec and onetime modes cannot be combined")
+		}
+		var reloadSignal syscall.Signal
+		if gc.ExecSignal != "" {
+			var err error
+			if reloadSignal, err = util.ParseSignal(gc.ExecSignal); err != nil {
+				log.Fatalf("Invalid exec reload signal: %v", err)
+			}
+		}
+		killSignal, err := util.ParseSignal(gc.ExecKillSignal)
+		if err != nil {
+			log.Fatalf("Invalid exec kill signal: %v", err)
+		}
+		sup = newSupervisor(gc.Shell, gc.Exec, reloadSignal, killSignal, gc.ExecKillWait)
+	}
+
+	c, err := New(gc, bc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	// Spread the first backend access of a fleet restarted at once
+	if jitter := util.Jitter(gc.StartupJitter); jitter > 0 {
+		log.Infof("Delaying startup by %v", jitter)
+		time.Sleep(jitter)
+	}
+
+	// render onetime templates and exit, failing if any of them failed
+	if gc.Onetime {
+		if err := renderOnetime(c, gc); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// render every unit once and in order before they are run on their
+	// own, the exec command starts once every template rendered
+	var exited <-chan int
+	if sup == nil {
+		c.RenderOnce()
+	} else {
+		for {
+			err := c.RenderOnce()
+			if err == nil {
+				break
+			}
+			select {
+			case err := <-c.failures:
+				log.Fatalf("%v. Exiting...", err)
+			default:
+			}
+			log.Errorf("%v, retrying in %v before starting %q", err, execRetryDelay, gc.Exec)
+			time.Sleep(execRetryDelay)
+		}
+		select {
+		case <-c.changes:
+		default:
+		}
+		if err := sup.Start(); err != nil {
+			log.Fatalf("Unable to start %q: %v", gc.Exec, err)
+		}
+		exited = sup.Exited()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	var startErr error
+	go func() {
+		startErr = c.Start(ctx)
+		close(stopped)
+	}()
+
+	if gc.AdminListen != "" {
+		var token []byte
+		if gc.AdminTokenFile != "" {
+			var err error
+			if token, err = backends.ReadSecret(gc.AdminTokenFile); err != nil {
+				log.Fatalf("Unable to read the admin token: %v", err)
+			}
+		}
+		go newAdminServer(c.templates, c.processors, c.events, token).ListenAndServe(gc.AdminListen)
+	}
+
+	// stop lets the renders in flight finish, then stops the exec command
+	stop := func() {
+		cancel()
+		<-stopped
+		if sup != nil {
+			sup.Stop()
+		}
+	}
+
+	var maxRuntime <-chan time.Time
+	if gc.MaxRuntime > 0 {
+		maxRuntime = time.After(gc.MaxRuntime - time.Since(started))
+	}
+
+	// wait for signal
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case dest := <-c.changes:
+			if gc.OnceOnChange {
+				log.Infof("%s has been updated. Exiting...", dest)
+				if sup != nil {
+					sup.Stop()
+				}
+				os.Exit(0)
+			}
+			if err := sup.Reload(); err != nil {
+				log.Errorf("Unable to reload %q: %v", gc.Exec, err)
+			}
+		case <-stopped:
+			log.Errorf("%v. Exiting...", startErr)
+			if sup != nil {
+				sup.Stop()
+			}
+			os.Exit(1)
+		case code := <-exited:
+			log.Infof("%q exited. Exiting...", gc.Exec)
+			os.Exit(code)
+		case <-maxRuntime:
+			log.Infof("Reached the maximum runtime of %v. Exiting...", gc.MaxRuntime)
+			stop()
+			os.Exit(0)
+		case s := <-signalChan:
+			if s == syscall.SIGHUP {
+				log.Infof("Captured %v. Reloading the backend configuration...", s)
+				if err := c.Reload(); err != nil {
+					log.Errorf("Unable to reload the backend configuration: %v", err)
+				}
+				continue
+			}
+			log.Infof("Captured %v. Exiting...", s)
+			stop()
+			os.Exit(0)
+		}
+	}
+}
+
+// runOnetime runs every processor once, up to workers of them at once, and
+// returns their errors in the same order.
+func runOnetime(processors []core.Processor, workers int) []error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, len(processors))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(processors); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = processors[i].Run()
+			}
+		}()
+	}
+	for i := range processors {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
+
+// setTemplateFuncs sets the optional template functions up, the
+// user-defined ones being those of custom.
+func setTemplateFuncs(template *core.Template, gc *config.GlobalConfig, custom *customFuncs) error {
+	template.SetCustomFuncs(custom.funcs)
+	if err := template.SetShellFuncs(custom.commands); err != nil {
+		return fmt.Errorf("Unable to load template functions: %v", err)
+	}
+	template.SetSprig(gc.EnableSprig)
+	template.SetFileAllowList(gc.FileAllowList)
+	return nil
+}
+
+// customFuncs are the user-defined template functions of the --func-plugin
+// flags, and the commands of those of --shell-funcs.
+type customFuncs struct {
+	funcs    map[string]interface{}
+	commands map[string]string
+}
+
+// loadCustomFuncs loads the user-defined template functions of gc, once for
+// every template of a run.
+func loadCustomFuncs(gc *config.GlobalConfig) (*customFuncs, error) {
+	verifier, err := newConfigVerifier(gc)
+	if err != nil {
+		return nil, err
+	}
 
+	custom := &customFuncs{funcs: make(map[string]interface{})}
+	for _, path := range gc.FuncPlugins {
+		// plugins are code run by renderizr itself
+		funcs, err := core.LoadFuncPlugin(path, verifier)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load template functions: %v", err)
+		}
+		for name, fn := range funcs {
+			custom.funcs[name] = fn
+		}
+	}
+	if gc.ShellFuncs != "" {
+		if custom.commands, err = core.LoadShellFuncs(gc.ShellFuncs, verifier); err != nil {
+			return nil, fmt.Errorf("Unable to load template functions: %v", err)
+		}
+	}
+	return custom, nil
+}
+
+// newConfigVerifier returns the verifier of the signatures of configuration
+// files, or nil if they aren't verified.
+func newConfigVerifier(gc *config.GlobalConfig) (*core.FileVerifier, error) {
+	if gc.ConfigKeyFile == "" {
+		return nil, nil
+	}
+	verifier, err := core.NewFileVerifier(gc.ConfigKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load configuration public key: %v", err)
+	}
+	return verifier, nil
+}
+
+// configureLogging sets the log format and level.
+func configureLogging(gc *config.GlobalConfig) {
+	if err := log.Configure(gc.LogFormat, gc.LogLevel); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+}
+
+// getTemplateConfigs is like loadTemplateConfigs but exits on errors.
+func getTemplateConfigs(gc *config.GlobalConfig) []*config.TemplateConfig {
+	tcs, err := loadTemplateConfigs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tcs
+}
+
+// loadTemplateConfigs parses the template records provided by the user and
+// prepends the global prefix to each of them.
+func loadTemplateConfigs(gc *config.GlobalConfig) ([]*config.TemplateConfig, error) {
 	// check if templates are available
 	tcs := make([]*config.TemplateConfig, 0)
-	if len(gc.Templates) <= 0 {
-		glog.Fatalf("Provide at least one template parameters\n")
+	if len(gc.Templates) <= 0 && gc.ConfDir == "" {
+		return nil, fmt.Errorf("Provide at least one template parameters or a configuration directory")
+	}
+
+	// template resources from the configuration directory
+	if gc.ConfDir != "" {
+		verifier, err := newConfigVerifier(gc)
+		if err != nil {
+			return nil, err
+		}
+		resources, err := getTemplateConfigsFromConfDir(gc.ConfDir, verifier)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load template resources: %v", err)
+		}
+		tcs = append(tcs, resources...)
 	}
 
 	// parse and map
@@ -55,12 +338,12 @@ func Run(gc *config.GlobalConfig, bc config.BackendConfig) {
 		reader.Comma = ';'
 		record, err := reader.Read()
 		if err != nil {
-			glog.Fatalf("Unable to read template %s: %v\n", t, err)
+			return nil, fmt.Errorf("Unable to read template %s: %v", t, err)
 		}
 
 		tc, err := getTemplateConfigFromRecord(gc.Prefix, record)
 		if err != nil {
-			glog.Fatalf("Unable to parse template record %s: %v\n", t, err)
+			return nil, fmt.Errorf("Unable to parse template record %s: %v", t, err)
 		}
 
 		tcs = append(tcs, tc)
@@ -71,7 +354,49 @@ func Run(gc *config.GlobalConfig, bc config.BackendConfig) {
 	for _, tc := range tcs {
 		util.Dump(tc)
 	}
-	util.Dump(bc)
+
+	// keep the templates selected for this host
+	selected := tcs[:0]
+	for _, tc := range tcs {
+		if ok, reason := tc.Selector.Matches(gc.NodeName); !ok {
+			log.Infof("Skipping %s, not selected: %s", tc.Dest, reason)
+			continue
+		}
+		selected = append(selected, tc)
+	}
+	tcs = selected
+
+	// process templates by priority, those sharing one in the order they
+	// were defined: configuration directory files by name, then flags
+	sort.SliceStable(tcs, func(i, j int) bool {
+		return tcs[i].Priority < tcs[j].Priority
+	})
+
+	// templates without their own shell, render pacing, command timeouts or
+	// failure limit use the global ones
+	for _, tc := range tcs {
+		if tc.Shell == "" {
+			tc.Shell = gc.Shell
+		}
+		if tc.Debounce == 0 {
+			tc.Debounce = config.Duration(gc.RenderDebounce)
+		}
+		if tc.MinInterval == 0 {
+			tc.MinInterval = config.Duration(gc.MinInterval)
+		}
+		if tc.CheckTimeout == 0 {
+			tc.CheckTimeout = config.Duration(gc.CheckTimeout)
+		}
+		if tc.ReloadTimeout == 0 {
+			tc.ReloadTimeout = config.Duration(gc.ReloadTimeout)
+		}
+		if tc.MaxFailures == 0 {
+			tc.MaxFailures = gc.MaxFailures
+		}
+		if err := tc.ValidateCommands(); err != nil {
+			return nil, fmt.Errorf("Invalid commands of %s: %v", tc.Dest, err)
+		}
+	}
 
 	// prepend global prefix to template prefix (if provided)
 	if gc.Prefix != "" {
@@ -80,105 +405,492 @@ func Run(gc *config.GlobalConfig, bc config.BackendConfig) {
 		}
 	}
 
-	// Exit if watch is requested and not supported by backend
-	if gc.Watch && !bc.IsWatchSupported() {
-		glog.Fatalf("Watch is not supported for backend %s. Exiting...", bc.Type())
+	// acknowledgments of templates watching them would render them again
+	if gc.AckPrefix != "" {
+		for _, tc := range tcs {
+			if tc.Watches(gc.AckPrefix) {
+				return nil, fmt.Errorf("Acknowledgment prefix %s is watched by %s, acknowledging it would render it again", gc.AckPrefix, tc.Dest)
+			}
+		}
+	}
+
+	return tcs, nil
+}
+
+// renderOnetime renders every template once. With onetime retries failures
+// are rendered again, waiting longer each time, until every template
+// succeeded or the onetime timeout expired.
+func renderOnetime(c *Controller, gc *config.GlobalConfig) error {
+	err := c.RenderOnce()
+	if err == nil || !gc.OnetimeRetry {
+		return err
+	}
+
+	var deadline time.Time
+	if gc.OnetimeTimeout > 0 {
+		deadline = time.Now().Add(gc.OnetimeTimeout)
+	}
+	delay := minOnetimeDelay
+	for {
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return fmt.Errorf("%v, giving up after %v", err, gc.OnetimeTimeout)
+			}
+			if delay > remaining {
+				delay = remaining
+			}
+		}
+		log.Warningf("%v, retrying in %v", err, delay)
+		time.Sleep(delay)
+
+		if err = c.RenderOnce(); err == nil {
+			return nil
+		}
+		delay *= 2
+		if delay > maxOnetimeDelay {
+			delay = maxOnetimeDelay
+		}
+	}
+}
+
+// newStoreClient creates the backend client, throttled and with local
+// overrides if requested.
+func newStoreClient(gc *config.GlobalConfig, bc config.BackendConfig) store.Store {
+	client, _, err := newReloadableStoreClient(gc, bc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client
+}
+
+// newReloadableStoreClient returns the store client along with a function
+// connecting to the backends again if their configuration, once env:// and
+// file:// values are resolved anew, changed.
+func newReloadableStoreClient(gc *config.GlobalConfig, bc config.BackendConfig) (store.Store, func() error, error) {
+	// Overrides are merged before the data is verified, failing every render
+	if gc.OverridesFile != "" && gc.PublicKeyFile != "" {
+		return nil, nil, fmt.Errorf("An overrides file cannot be combined with signed data (--public-key-file)")
 	}
 
 	// Notify which backend is going to use
-	glog.Infof("Backend set to %s", bc.Type())
+	log.Infof("Backend set to %s", bc.Type())
+
+	// Obtain client certificates from the SPIFFE Workload API (if requested)
+	var svids *spiffe.Source
+	if gc.SpiffeSocket != "" {
+		var err error
+		svids, err = spiffe.NewSource(gc.SpiffeSocket, spiffeTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to obtain an SVID: %v", err)
+		}
+	}
 
-	// Create store client instance
-	client, err := getStoreFromBackendConfig(bc)
+	expanded, err := expandBackendConfigs(bc, gc.BackendMounts)
+	if err != nil {
+		return nil, nil, err
+	}
+	backend, err := newBackendStore(expanded, svids, gc.SpiffeServerID)
 	if err != nil {
-		glog.Fatal(err)
+		return nil, nil, err
 	}
+	swappable := backends.NewSwappableStore(backend)
+	var client store.Store = swappable
 
-	// loop over templates
-	stopChan := make(<-chan struct {} )
-	doneChan := make(chan bool)
-	errChan := make(chan error, 10)
+	// Throttle backend requests (if requested)
+	if gc.RateLimit > 0 {
+		client = backends.NewRateLimitedStore(client, gc.RateLimit, gc.RateBurst)
+	}
 
-	var lastErr error = nil
-	for _, tc := range tcs {
-		template := core.NewTemplate(tc, gc.NoOp, gc.KeepStageFile, true)
-		processor := core.NewOnDemandProcessor(template, client)
-		if gc.Onetime {
-			if err := processor.Run(); err != nil {
-				lastErr = err
+	// Share reads and watches between templates using the same keys (if requested)
+	if gc.ShareRequests {
+		client = backends.NewSharedStore(client)
+	}
+
+	// Merge local overrides over backend values (if requested)
+	if gc.OverridesFile != "" {
+		client = backends.NewOverrideStore(client, gc.OverridesFile)
+	}
+
+	reload := func() error {
+		next, err := expandBackendConfigs(bc, gc.BackendMounts)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(next, expanded) {
+			log.Infof("Backend configuration unchanged")
+			return nil
+		}
+		backend, err := newBackendStore(next, svids, gc.SpiffeServerID)
+		if err != nil {
+			return err
+		}
+		log.Infof("Backend configuration changed, reconnecting to %s", bc.Type())
+		swappable.Swap(backend)
+		expanded = next
+		return nil
+	}
+	return client, reload, nil
+}
+
+// expandBackendConfigs resolves the env:// and file:// values of the backend
+// configuration and of the backends mounted, the latter being keyed by their
+// prefix and the former by the empty one.
+func expandBackendConfigs(bc config.BackendConfig, mounts map[string]config.BackendConfig) (map[string]config.BackendConfig, error) {
+	expanded := make(map[string]config.BackendConfig, len(mounts)+1)
+	var err error
+	if expanded[""], err = config.ExpandBackendConfig(bc); err != nil {
+		return nil, err
+	}
+	for prefix, mbc := range mounts {
+		if expanded[prefix], err = config.ExpandBackendConfig(mbc); err != nil {
+			return nil, fmt.Errorf("Backend mounted under %s: %v", prefix, err)
+		}
+	}
+	return expanded, nil
+}
+
+// newBackendStore connects to the backends of the expanded configurations,
+// serving the mounted ones under their own prefixes.
+func newBackendStore(bcs map[string]config.BackendConfig, svids *spiffe.Source, serverID string) (store.Store, error) {
+	client, err := getStoreFromBackendConfig(bcs[""], svids, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serve other backends under their own prefixes (if requested)
+	if len(bcs) > 1 {
+		mounts := make(map[string]store.Store, len(bcs)-1)
+		for prefix, mbc := range bcs {
+			if prefix == "" {
+				continue
 			}
-		} else {
-			go func() {
-				core.NewIntervalProcessor(gc.ResyncInterval, processor, stopChan, doneChan, errChan).Run()
-			}()
-			if gc.Watch {
-				go func() {
-					core.NewWatchProcessor(template, client, stopChan, doneChan, errChan).Run()
-				}()
+			log.Infof("Backend %s mounted under %s", mbc.Type(), prefix)
+			mounts[prefix], err = getStoreFromBackendConfig(mbc, svids, serverID)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to mount %s: %v", prefix, err)
 			}
 		}
+		client = backends.NewMountStore(client, mounts)
 	}
+	return client, nil
+}
 
-	// exit prematurely if any of onetime templates failed
-	if gc.Onetime {
-		if lastErr == nil {
-			os.Exit(0)
+// newDatasources parses the 'name=uri' datasource definitions.
+func newDatasources(gc *config.GlobalConfig, client store.Store) (*core.Datasources, error) {
+	defs := make(map[string]string)
+	for _, d := range gc.Datasources {
+		parts := strings.SplitN(d, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Datasource should be provided as name=uri: %s", d)
 		}
-		glog.Errorf("%v", lastErr)
-		os.Exit(1)
+		defs[parts[0]] = parts[1]
 	}
+	datasources, err := core.NewDatasources(defs, client, gc.DatasourceTTL)
+	if err != nil {
+		return nil, err
+	}
+	datasources.SetFetchAllowList(gc.FetchAllowList)
+	return datasources, nil
+}
 
-	// wait for signal
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	for {
-		select {
-		case err := <-errChan:
-			glog.Error(err)
-		case s := <-signalChan:
-			glog.Infof("Captured %v. Exiting...", s)
-			close(doneChan)
-		case <-doneChan:
-			os.Exit(0)
+// newVerifier returns the snapshot verifier, if a public key was provided.
+func newVerifier(gc *config.GlobalConfig) (*core.SnapshotVerifier, error) {
+	if gc.PublicKeyFile == "" {
+		return nil, nil
+	}
+	return core.NewSnapshotVerifier(gc.PublicKeyFile, gc.ManifestKey)
+}
+
+// lockDestinations acquires a lock for every template destination, failing if
+// two templates share a destination or another process already manages one.
+func lockDestinations(tcs []*config.TemplateConfig) ([]*util.FileLock, error) {
+	locks := make([]*util.FileLock, 0, len(tcs))
+	unlockAll := func() {
+		for _, l := range locks {
+			l.Unlock()
 		}
 	}
+
+	seen := make(map[string]string)
+	for _, tc := range tcs {
+		if tc.IsStdout() {
+			continue
+		}
+		dest, err := filepath.Abs(tc.Dest)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		if src, ok := seen[dest]; ok {
+			unlockAll()
+			return nil, fmt.Errorf("Templates %s and %s share the same destination %s", src, tc.Src, dest)
+		}
+		seen[dest] = tc.Src
+
+		l, err := util.LockFile(util.LockFileName(dest))
+		if err != nil {
+			unlockAll()
+			return nil, fmt.Errorf("Unable to manage %s: %v", dest, err)
+		}
+		locks = append(locks, l)
+	}
+
+	return locks, nil
 }
 
-func getStoreFromBackendConfig(bc config.BackendConfig) (s store.Store, err error) {
+// spiffeTimeout is how long the first SVID is waited for.
+const spiffeTimeout = 30 * time.Second
+
+// defaultPorts are the ports of the backends whose endpoints are given as
+// host:port.
+var defaultPorts = map[store.Backend]string{
+	store.CONSUL:  "8500",
+	store.ETCD:    "2379",
+	store.ZK:      "2181",
+	backends.MQTT: "1883",
+}
+
+// getStoreFromBackendConfig creates the client of a backend. If svids is not
+// nil the backends supporting TLS use mutual TLS with the SPIFFE SVIDs of the
+// workload instead of certificate files, expecting the server to present
+// serverID if not empty.
+func getStoreFromBackendConfig(bc config.BackendConfig, svids *spiffe.Source, serverID string) (s store.Store, err error) {
 	var endpoints []string
 	var tlsConfig *store.ClientTLSConfig
+	var consistency string
 
 	switch bc.Type() {
+	case backends.GCP:
+		gbc, _ := bc.(*config.GCPBackendConfig)
+		versions, err := backends.ParseSecretVersions(gbc.SecretVersions)
+		if err != nil {
+			return nil, err
+		}
+		return backends.NewGCP(backends.GCPOptions{
+			Project:        gbc.Project,
+			Secrets:        gbc.Secrets,
+			Versions:       versions,
+			SecretsPrefix:  gbc.SecretsPrefix,
+			MetadataPrefix: gbc.MetadataPrefix,
+		})
+	case backends.AZURE:
+		abc, _ := bc.(*config.AzureBackendConfig)
+		return backends.NewAzure(backends.AzureOptions{
+			AppConfigEndpoint: abc.AppConfigEndpoint,
+			Label:             abc.Label,
+			KeySeparator:      abc.KeySeparator,
+			KeyVault:          abc.KeyVault,
+			SecretsPrefix:     abc.SecretsPrefix,
+			ClientID:          abc.ClientID,
+		})
+	case backends.SQL:
+		sbc, _ := bc.(*config.SQLBackendConfig)
+		return backends.NewSQL(backends.SQLOptions{
+			Driver:        sbc.Driver,
+			DSN:           sbc.DSN,
+			Table:         sbc.Table,
+			KeyColumn:     sbc.KeyColumn,
+			ValueColumn:   sbc.ValueColumn,
+			PollInterval:  sbc.PollInterval,
+			NotifyChannel: sbc.NotifyChannel,
+		})
+	case backends.PUSH:
+		pbc, _ := bc.(*config.PushBackendConfig)
+		secret, err := backends.ReadSecret(pbc.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		options := backends.PushOptions{
+			Listen:    pbc.Listen,
+			Secret:    secret,
+			StateFile: pbc.StateFile,
+		}
+		if pbc.CertFile != "" || pbc.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(pbc.CertFile, pbc.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to load the push receiver certificate: %v", err)
+			}
+			options.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+		return backends.NewPushReceiver(options)
 	case store.CONSUL:
 		cbc, _ := bc.(*config.ConsulBackendConfig)
 		endpoints = cbc.Endpoints
-		tlsConfig = &store.ClientTLSConfig{cbc.CertFile, cbc.KeyFile, cbc.CAFile}
+		tlsConfig = &store.ClientTLSConfig{CertFile: cbc.CertFile, KeyFile: cbc.KeyFile, CACertFile: cbc.CAFile}
+		consistency = cbc.Consistency
 		break
 	case store.ETCD:
 		ebc, _ := bc.(*config.EtcdBackendConfig)
 		endpoints = ebc.Endpoints
-		tlsConfig = &store.ClientTLSConfig{ebc.CertFile, ebc.KeyFile, ebc.CAFile}
+		tlsConfig = &store.ClientTLSConfig{CertFile: ebc.CertFile, KeyFile: ebc.KeyFile, CACertFile: ebc.CAFile}
+		consistency = ebc.Consistency
 		break
 	case store.ZK:
 		zbc, _ := bc.(*config.ZookeeperBackendConfig)
 		endpoints = zbc.Endpoints
 		break
+	case backends.FIXTURE:
+		fbc, _ := bc.(*config.FixtureBackendConfig)
+		endpoints = fbc.Files
+		break
+	case backends.MQTT:
+		mbc, _ := bc.(*config.MQTTBackendConfig)
+		endpoints = mbc.Endpoints
+		tlsConfig = &store.ClientTLSConfig{CertFile: mbc.CertFile, KeyFile: mbc.KeyFile, CACertFile: mbc.CAFile}
+		break
+	case backends.HTTP:
+		hbc, _ := bc.(*config.HTTPBackendConfig)
+		endpoints = []string{hbc.URL}
+		tlsConfig = &store.ClientTLSConfig{CertFile: hbc.CertFile, KeyFile: hbc.KeyFile, CACertFile: hbc.CAFile}
+		break
+	case backends.VAULT:
+		vbc, _ := bc.(*config.VaultBackendConfig)
+		endpoints = []string{vbc.Address}
+		tlsConfig = &store.ClientTLSConfig{CertFile: vbc.CertFile, KeyFile: vbc.KeyFile, CACertFile: vbc.CAFile}
+		break
+	}
+
+	// IPv6 literals need brackets to carry a port, unix sockets skip TLS
+	if port, ok := defaultPorts[bc.Type()]; ok {
+		if endpoints, err = util.NormalizeEndpoints(endpoints, port); err != nil {
+			return nil, err
+		}
+		for _, endpoint := range endpoints {
+			if !util.IsUnixEndpoint(endpoint) {
+				continue
+			}
+			if bc.Type() == store.ZK {
+				return nil, fmt.Errorf("Unix socket endpoints are not supported by %s", bc.Type())
+			}
+			if tlsConfig != nil && tlsConfig.CertFile != "" && bc.Type() != backends.MQTT {
+				log.Warningf("TLS is not used with unix socket endpoint %s", endpoint)
+				tlsConfig = nil
+			}
+		}
 	}
 
 	var tls *tls.Config = nil
-	if tlsConfig != nil {
+	if tlsConfig != nil && svids != nil {
+		tls = svids.TLSConfig(serverID)
+	} else if tlsConfig != nil {
 		tls, err = newTLS(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.CACertFile)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	switch bc.Type() {
+	case backends.MQTT:
+		mbc, _ := bc.(*config.MQTTBackendConfig)
+		options := backends.MQTTOptions{
+			Topic:    mbc.Topic,
+			ClientID: mbc.ClientID,
+			Username: mbc.Username,
+			TLS:      tls,
+		}
+		if mbc.PasswordFile != "" {
+			password, err := backends.ReadSecret(mbc.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+			options.Password = string(password)
+		}
+		return backends.NewMQTT(endpoints, options)
+	case backends.HTTP:
+		hbc, _ := bc.(*config.HTTPBackendConfig)
+		headers := make(map[string]string)
+		for _, h := range hbc.Headers {
+			parts := strings.SplitN(h, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Header should be provided as 'Name: value'")
+			}
+			headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		return backends.NewHTTP(backends.HTTPOptions{
+			URL:     hbc.URL,
+			Headers: headers,
+			Timeout: hbc.Timeout,
+			TLS:     tls,
+		})
+	case backends.VAULT:
+		vbc, _ := bc.(*config.VaultBackendConfig)
+		options := backends.VaultOptions{
+			Address:      vbc.Address,
+			Namespace:    vbc.Namespace,
+			Token:        os.Getenv("VAULT_TOKEN"),
+			RoleID:       vbc.RoleID,
+			AuthMount:    vbc.AuthMount,
+			KVMount:      vbc.KVMount,
+			KVVersion:    vbc.KVVersion,
+			Paths:        vbc.Paths,
+			PollInterval: vbc.PollInterval,
+			TLS:          tls,
+		}
+		if vbc.TokenFile != "" {
+			token, err := backends.ReadSecret(vbc.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			options.Token = string(token)
+		}
+		if vbc.SecretIDFile != "" {
+			secretID, err := backends.ReadSecret(vbc.SecretIDFile)
+			if err != nil {
+				return nil, err
+			}
+			options.SecretID = string(secretID)
+		}
+		return backends.NewVault(options)
+	case backends.K8S:
+		kbc, _ := bc.(*config.K8sBackendConfig)
+		options := backends.K8sOptions{
+			Server:        kbc.Server,
+			TokenFile:     kbc.TokenFile,
+			Namespaces:    kbc.Namespaces,
+			LabelSelector: kbc.LabelSelector,
+			Resources:     kbc.Resources,
+		}
+		if options.Server == "" {
+			host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+			if host == "" || port == "" {
+				return nil, fmt.Errorf("Not running in a cluster, the API server address is required")
+			}
+			options.Server = "https://" + net.JoinHostPort(host, port)
+		}
+		if options.TokenFile != "" && !util.IsFileExist(options.TokenFile) {
+			options.TokenFile = ""
+		}
+		options.TLS, err = newK8sTLS(kbc)
+		if err != nil {
+			return nil, err
+		}
+		return backends.NewK8s(options)
+	case backends.ENV:
+		ebc, _ := bc.(*config.EnvBackendConfig)
+		return backends.NewEnv(backends.EnvOptions{
+			Prefix:      ebc.Prefix,
+			StripPrefix: ebc.StripPrefix,
+			Separator:   ebc.Separator,
+		})
+	case backends.FS:
+		fbc, _ := bc.(*config.FsBackendConfig)
+		if fbc.RootPath == "" {
+			return nil, fmt.Errorf("A root path is required")
+		}
+		return backends.NewFs(backends.FsOptions{
+			RootPath:    fbc.RootPath,
+			MaxFileSize: fbc.MaxFileSize,
+		})
+	}
+
 	return libkv.NewStore(
 		bc.Type(),
 		endpoints,
 		&store.Config{
-			TLS: tls,
-			ConnectionTimeout: 10*time.Second,
+			TLS:               tls,
+			ConnectionTimeout: 10 * time.Second,
+			Consistency:       consistency,
 		},
 	)
 }
@@ -219,6 +931,30 @@ func newTLS(certFile, keyFile, caCertFile string) (*tls.Config, error) {
 	}, nil
 }
 
+// newK8sTLS returns the TLS configuration to reach the API server, the CA
+// bundle alone is enough as clients usually authenticate with a token.
+func newK8sTLS(kbc *config.K8sBackendConfig) (*tls.Config, error) {
+	config := &tls.Config{}
+	if kbc.CertFile != "" && kbc.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(kbc.CertFile, kbc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if kbc.CAFile != "" && util.IsFileExist(kbc.CAFile) {
+		pemByte, err := ioutil.ReadFile(kbc.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pemByte) {
+			return nil, fmt.Errorf("No certificates found in %s", kbc.CAFile)
+		}
+	}
+	return config, nil
+}
+
 // For example:
 // "/etc/nginx.conf.tmpl;/etc/nginx.conf;;0600;/usr/sbin/nginx -t -c {{ .src }};/usr/sbin/nginx -s reload"
 // 0: *src       = /etc/nginx.conf.tmpl
@@ -238,7 +974,7 @@ func getTemplateConfigFromRecord(prefix string, record []string) (*config.Templa
 	tc.Dest = record[1]
 
 	if recordLength < 3 {
-		return tc, nil
+		return tc, tc.Validate()
 	}
 
 	if record[2] != "" {
@@ -262,22 +998,141 @@ func getTemplateConfigFromRecord(prefix string, record []string) (*config.Templa
 	}
 
 	if recordLength < 4 {
-		return tc, nil
+		return tc, tc.Validate()
 	}
 
 	tc.Mode = record[3]
 
 	if recordLength < 5 {
-		return tc, nil
+		return tc, tc.Validate()
 	}
 
 	tc.CheckCmd = record[4]
 
 	if recordLength < 6 {
-		return tc, nil
+		return tc, tc.Validate()
 	}
 
 	tc.ReloadCmd = record[5]
 
-	return tc, nil
-}
\ No newline at end of file
+	// the remaining elements are name=value options
+	for _, option := range record[6:] {
+		if err := setTemplateOption(tc, option); err != nil {
+			return nil, err
+		}
+	}
+
+	return tc, tc.Validate()
+}
+
+// setTemplateOption sets a name=value template option, values holding a
+// list are separated by spaces.
+func setTemplateOption(tc *config.TemplateConfig, option string) error {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Template option %q should be provided as name=value", option)
+	}
+
+	name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	switch name {
+	case "keys":
+		tc.Keys = strings.Fields(value)
+	case "triggers":
+		tc.Triggers = strings.Fields(value)
+	case "normalize":
+		tc.Normalize = strings.Fields(value)
+	case "encoding":
+		tc.Encoding = value
+	case "line-endings":
+		tc.LineEndings = value
+	case "shell":
+		tc.Shell = value
+	case "group":
+		tc.Group = value
+	case "priority":
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid priority %q", value)
+		}
+		tc.Priority = priority
+	case "on-success":
+		tc.OnSuccess = value
+	case "on-failure":
+		tc.OnFailure = value
+	case "reload-signal":
+		tc.ReloadSignal = value
+	case "reload-process":
+		tc.ReloadProcess = value
+	case "reload-window":
+		tc.ReloadWindow = value
+	case "max-consecutive-failures":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid maximum consecutive failures %q", value)
+		}
+		tc.MaxFailures = n
+	case "left-delim":
+		tc.LeftDelim = value
+	case "right-delim":
+		tc.RightDelim = value
+	case "backups":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid number of backups %q", value)
+		}
+		tc.Backups = n
+	case "backup-suffix":
+		tc.BackupSuffix = value
+	case "auto-rollback":
+		autoRollback, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid auto rollback %q", value)
+		}
+		tc.AutoRollback = autoRollback
+	case "keep-xattrs":
+		keepXattrs, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid keep xattrs %q", value)
+		}
+		tc.KeepXattrs = keepXattrs
+	case "selinux-context":
+		tc.SELinux = value
+	case "allow-empty":
+		allowEmpty, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid allow empty %q", value)
+		}
+		tc.AllowEmpty = allowEmpty
+	case "advisory":
+		advisory, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid advisory %q", value)
+		}
+		tc.Advisory = advisory
+	case "render-debounce":
+		if err := tc.Debounce.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("Invalid render debounce %q", value)
+		}
+	case "min-render-interval":
+		if err := tc.MinInterval.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("Invalid minimum render interval %q", value)
+		}
+	case "check-timeout":
+		if err := tc.CheckTimeout.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("Invalid check timeout %q", value)
+		}
+	case "reload-timeout":
+		if err := tc.ReloadTimeout.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("Invalid reload timeout %q", value)
+		}
+	case "hosts":
+		tc.Selector.Hosts = strings.Fields(value)
+	case "env":
+		tc.Selector.Env = strings.Fields(value)
+	case "files":
+		tc.Selector.Files = strings.Fields(value)
+	default:
+		return fmt.Errorf("Unknown template option %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package renderizrtest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+)
+
+// Harness renders template resources against a Store the way renderizr
+// does, with sources and destinations in a scratch directory.
+type Harness struct {
+	Store *Store
+	Dir   string
+	// Verifier, if set, is the verifier data must pass before being
+	// rendered, as with --public-key-file.
+	Verifier *core.SnapshotVerifier
+}
+
+// NewHarness returns a Harness with an empty Store and a new scratch
+// directory, remove it with Close.
+func NewHarness() (*Harness, error) {
+	dir, err := ioutil.TempDir("", "renderizrtest")
+	if err != nil {
+		return nil, err
+	}
+	return &Harness{Store: NewStore(), Dir: dir}, nil
+}
+
+// Close removes the scratch directory.
+func (h *Harness) Close() error {
+	return os.RemoveAll(h.Dir)
+}
+
+// Resource writes the template tmpl to the scratch directory and returns
+// the configuration of a template resource rendering it to a destination
+// named name, also in the scratch directory. The destination is owned by
+// the current user so that renders don't need to run as root.
+func (h *Harness) Resource(name, tmpl string) (*config.TemplateConfig, error) {
+	tc := config.NewTemplateConfig()
+	tc.Src = filepath.Join(h.Dir, "templates", name+".tmpl")
+	tc.Dest = filepath.Join(h.Dir, name)
+	tc.Uid = os.Getuid()
+	tc.Gid = os.Getgid()
+
+	if err := os.MkdirAll(filepath.Dir(tc.Src), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(tc.Src, []byte(tmpl), 0644); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Render renders tc once, as 'renderizr --onetime' does, syncing its
+// destination and running its check and reload commands, and returns the
+// contents of the destination.
+func (h *Harness) Render(tc *config.TemplateConfig) (string, error) {
+	if err := tc.Validate(); err != nil {
+		return "", err
+	}
+	template := h.template(tc)
+	if err := core.NewOnDemandProcessor(template, h.Store).Run(); err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(tc.Dest)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Execute returns the output of tc with the current keys of the Store,
+// leaving its destination untouched and without running any command.
+func (h *Harness) Execute(tc *config.TemplateConfig) (string, error) {
+	if err := tc.Validate(); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	template := h.template(tc)
+	if err := core.NewOnDemandProcessor(template, h.Store).Execute(&buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (h *Harness) template(tc *config.TemplateConfig) *core.Template {
+	template := core.NewTemplate(tc, false, false, false)
+	if h.Verifier != nil {
+		template.SetVerifier(h.Verifier)
+	}
+	return template
+}
@@ -0,0 +1,257 @@
+package renderizrtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/glerchundi/renderizr/pkg/core"
+)
+
+func newTestHarness(t *testing.T) *Harness {
+	h, err := NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func readDest(t *testing.T, dest string) string {
+	data, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRender(t *testing.T) {
+	h := newTestHarness(t)
+	defer h.Close()
+
+	h.Store.SetAll(map[string]string{
+		"/upstreams/app/1": "10.0.0.1:8080",
+		"/upstreams/app/2": "10.0.0.2:8080",
+	})
+	tc, err := h.Resource("nginx.conf", `{{range getvs "/upstreams/app/*"}}server {{.}};
+{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := h.Execute(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "server 10.0.0.1:8080;\nserver 10.0.0.2:8080;\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+	if _, err := ioutil.ReadFile(tc.Dest); err == nil {
+		t.Errorf("Expected Execute to leave %s untouched", tc.Dest)
+	}
+
+	h.Store.Set("/upstreams/app/3", "10.0.0.3:8080")
+	out, err = h.Render(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "server 10.0.0.1:8080;\nserver 10.0.0.2:8080;\nserver 10.0.0.3:8080;\n"
+	if out != expected {
+		t.Errorf("Unexpected output %q", out)
+	}
+	if dest := readDest(t, tc.Dest); dest != expected {
+		t.Errorf("Expected %s to be synced, got %q", tc.Dest, dest)
+	}
+}
+
+func TestRenderRollsBackFailedReloads(t *testing.T) {
+	h := newTestHarness(t)
+	defer h.Close()
+
+	tc, err := h.Resource("app.conf", `listen {{getv "/app/listen"}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.AutoRollback = true
+	// the service refuses to reload a configuration listening on port 0
+	tc.ReloadCmd = "! grep -q ':0$' " + tc.Dest
+
+	h.Store.Set("/app/listen", ":8080")
+	if _, err := h.Render(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Store.Set("/app/listen", ":0")
+	if _, err := h.Render(tc); err == nil {
+		t.Errorf("Expected the failed reload to be reported")
+	}
+	if dest := readDest(t, tc.Dest); dest != "listen :8080\n" {
+		t.Errorf("Expected %s to be rolled back, got %q", tc.Dest, dest)
+	}
+
+	// without rolling back the failed configuration is left in place
+	tc.AutoRollback = false
+	if _, err := h.Render(tc); err == nil {
+		t.Errorf("Expected the failed reload to be reported")
+	}
+	if dest := readDest(t, tc.Dest); dest != "listen :0\n" {
+		t.Errorf("Expected %s to be updated, got %q", tc.Dest, dest)
+	}
+}
+
+func TestRenderRefusesBlankOutput(t *testing.T) {
+	h := newTestHarness(t)
+	defer h.Close()
+
+	tc, err := h.Resource("hosts", `{{range gets "/hosts/*"}}{{.Value}} {{base .Key}}
+{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blank output is fine for destinations not written yet
+	h.Store.Set("/zone", "example.org")
+	if out, err := h.Render(tc); err != nil || out != "" {
+		t.Fatalf("Expected an empty destination, got %q: %v", out, err)
+	}
+
+	h.Store.Set("/hosts/db", "10.0.0.1")
+	if _, err := h.Render(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Store.DeleteTree("/hosts"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Render(tc); err == nil {
+		t.Errorf("Expected blank output not to replace %s", tc.Dest)
+	}
+	if dest := readDest(t, tc.Dest); dest != "10.0.0.1 db\n" {
+		t.Errorf("Expected %s to be left untouched, got %q", tc.Dest, dest)
+	}
+
+	tc.AllowEmpty = true
+	if out, err := h.Render(tc); err != nil || out != "" {
+		t.Errorf("Expected allow_empty to let blank output replace %s, got %q: %v", tc.Dest, out, err)
+	}
+}
+
+// signManifest stores the manifest serial of kvs, under the root prefix,
+// signed with key at the default manifest key, along with kvs.
+func signManifest(t *testing.T, s *Store, key *ecdsa.PrivateKey, serial uint64, kvs map[string]string) {
+	hashes := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		sum := sha256.Sum256([]byte(v))
+		hashes[k] = hex.EncodeToString(sum[:])
+	}
+	manifest, err := json.Marshal(core.Manifest{Prefix: "/", Serial: serial, Keys: hashes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(manifest)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := json.Marshal(core.SignedManifest{
+		Manifest:  string(manifest),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := map[string]string{"/.manifest": string(signed)}
+	for k, v := range kvs {
+		all[k] = v
+	}
+	s.SetAll(all)
+}
+
+func TestRenderVerifiesSignatures(t *testing.T) {
+	h := newTestHarness(t)
+	defer h.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(h.Dir, "publisher.pem")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.Verifier, err = core.NewSnapshotVerifier(keyFile, ".manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := h.Resource("app.conf", `db {{getv "/app/db"}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signManifest(t, h.Store, key, 2, map[string]string{"/app/db": "db1"})
+	if out, err := h.Render(tc); err != nil || out != "db db1\n" {
+		t.Fatalf("Expected the signed data to be rendered, got %q: %v", out, err)
+	}
+
+	tests := []struct {
+		desc string
+		kvs  map[string]string
+	}{
+		{"modified key", map[string]string{"/app/db": "evil"}},
+		{"unsigned key", map[string]string{"/app/cache": "cache1"}},
+	}
+	for _, tt := range tests {
+		signManifest(t, h.Store, key, 2, map[string]string{"/app/db": "db1"})
+		h.Store.SetAll(tt.kvs)
+		if _, err := h.Render(tc); err == nil {
+			t.Errorf("%s: expected the data to be refused", tt.desc)
+		}
+		if dest := readDest(t, tc.Dest); dest != "db db1\n" {
+			t.Errorf("%s: expected %s to be left untouched, got %q", tt.desc, tc.Dest, dest)
+		}
+		h.Store.Delete("/app/cache")
+	}
+
+	// data signed by someone else is refused too
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signManifest(t, h.Store, other, 3, map[string]string{"/app/db": "db2"})
+	if _, err := h.Render(tc); err == nil {
+		t.Errorf("Expected data signed with another key to be refused")
+	}
+
+	// and so is the replay of an older manifest with its data
+	signManifest(t, h.Store, key, 3, map[string]string{"/app/db": "db3"})
+	if out, err := h.Render(tc); err != nil || out != "db db3\n" {
+		t.Fatalf("Expected the signed data to be rendered, got %q: %v", out, err)
+	}
+	signManifest(t, h.Store, key, 2, map[string]string{"/app/db": "db1"})
+	if _, err := h.Render(tc); err == nil {
+		t.Errorf("Expected an older manifest to be refused")
+	}
+
+	if err := h.Store.Delete("/.manifest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Render(tc); err == nil {
+		t.Errorf("Expected data without a manifest to be refused")
+	}
+}
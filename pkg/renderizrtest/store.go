@@ -0,0 +1,260 @@
+// Package renderizrtest provides test doubles for programs embedding
+// renderizr: an in-memory store and a harness rendering template resources
+// against it, so that they can be unit tested without running a backend.
+package renderizrtest
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+)
+
+// Store is an in-memory store.Store. Every write bumps the index of the
+// pairs written and notifies the watches, like real backends do.
+type Store struct {
+	mutex    sync.Mutex
+	pairs    map[string]*store.KVPair
+	index    uint64
+	watchers map[chan struct{}]bool
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		pairs:    make(map[string]*store.KVPair),
+		watchers: make(map[chan struct{}]bool),
+	}
+}
+
+// NewStoreWith returns a Store holding kvs.
+func NewStoreWith(kvs map[string]string) *Store {
+	s := NewStore()
+	s.SetAll(kvs)
+	return s
+}
+
+// Set sets the value of key.
+func (s *Store) Set(key, value string) {
+	s.SetAll(map[string]string{key: value})
+}
+
+// SetAll sets every key of kvs at once, notifying the watches only once.
+func (s *Store) SetAll(kvs map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.index++
+	for key, value := range kvs {
+		key = normalizeKey(key)
+		s.pairs[key] = &store.KVPair{Key: key, Value: []byte(value), LastIndex: s.index}
+	}
+	s.notify()
+}
+
+func normalizeKey(key string) string {
+	return path.Join("/", key)
+}
+
+// notify wakes up every watch, the mutex must be held.
+func (s *Store) notify() {
+	for changed := range s.watchers {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Store) subscribe() chan struct{} {
+	changed := make(chan struct{}, 1)
+	changed <- struct{}{}
+	s.mutex.Lock()
+	s.watchers[changed] = true
+	s.mutex.Unlock()
+	return changed
+}
+
+func (s *Store) unsubscribe(changed chan struct{}) {
+	s.mutex.Lock()
+	delete(s.watchers, changed)
+	s.mutex.Unlock()
+}
+
+func (s *Store) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.Set(key, string(value))
+	return nil
+}
+
+func (s *Store) Get(key string) (*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pair, ok := s.pairs[normalizeKey(key)]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	copied := *pair
+	return &copied, nil
+}
+
+func (s *Store) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key = normalizeKey(key)
+	if _, ok := s.pairs[key]; !ok {
+		return store.ErrKeyNotFound
+	}
+	s.index++
+	delete(s.pairs, key)
+	s.notify()
+	return nil
+}
+
+func (s *Store) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Watch sends the pair of key, once it exists, whenever it changes until
+// stopCh is closed.
+func (s *Store) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	changed := s.subscribe()
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		defer s.unsubscribe(changed)
+		var last uint64
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-changed:
+			}
+			pair, err := s.Get(key)
+			if err != nil || pair.LastIndex == last {
+				continue
+			}
+			last = pair.LastIndex
+			select {
+			case out <- pair:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree sends the pairs under directory right away and whenever the
+// store changes until stopCh is closed.
+func (s *Store) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	changed := s.subscribe()
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		defer s.unsubscribe(changed)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-changed:
+			}
+			pairs, err := s.List(directory)
+			if err != nil {
+				pairs = []*store.KVPair{}
+			}
+			select {
+			case out <- pairs:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *Store) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// List returns the pairs under directory sorted by key, or
+// store.ErrKeyNotFound if there are none.
+func (s *Store) List(directory string) ([]*store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	directory = normalizeKey(directory)
+	pairs := make([]*store.KVPair, 0)
+	for key, pair := range s.pairs {
+		if directory == "/" || key == directory || strings.HasPrefix(key, directory+"/") {
+			copied := *pair
+			pairs = append(pairs, &copied)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, nil
+}
+
+func (s *Store) DeleteTree(directory string) error {
+	pairs, err := s.List(directory)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.index++
+	for _, pair := range pairs {
+		delete(s.pairs, pair.Key)
+	}
+	s.notify()
+	return nil
+}
+
+func (s *Store) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key = normalizeKey(key)
+	current, ok := s.pairs[key]
+	switch {
+	case previous == nil && ok:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && !ok:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && current.LastIndex != previous.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+	s.index++
+	pair := &store.KVPair{Key: key, Value: value, LastIndex: s.index}
+	s.pairs[key] = pair
+	s.notify()
+	copied := *pair
+	return true, &copied, nil
+}
+
+func (s *Store) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key = normalizeKey(key)
+	current, ok := s.pairs[key]
+	switch {
+	case !ok:
+		return false, store.ErrKeyNotFound
+	case previous == nil:
+		return false, store.ErrPreviousNotSpecified
+	case current.LastIndex != previous.LastIndex:
+		return false, store.ErrKeyModified
+	}
+	s.index++
+	delete(s.pairs, key)
+	s.notify()
+	return true, nil
+}
+
+func (s *Store) Close() {
+}
@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+const replHelp = `Enter a template expression, e.g. getv "/app/name" or a full template
+text like {{range gets "/app/*"}}{{.Key}}{{end}}. End a line with \ to
+continue it on the next one.
+
+  :keys [prefix]  list the keys of the snapshot
+  :reload         take a new snapshot from the backend
+  :help           show this help
+  :quit           exit
+`
+
+// Repl evaluates template expressions read from stdin against a snapshot of
+// the backend data, for template authors to try out functions and keys.
+func Repl(gc *config.GlobalConfig, bc config.BackendConfig) {
+	configureLogging(gc)
+
+	client := newStoreClient(gc, bc)
+	defer client.Close()
+
+	tc := config.NewTemplateConfig()
+	tc.Prefix = gc.Prefix
+	template := core.NewTemplate(tc, true, false, true)
+	datasources, err := newDatasources(gc, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verifier, err := newVerifier(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	custom, err := loadCustomFuncs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	template.SetDatasources(datasources)
+	template.SetVerifier(verifier)
+	if err := setTemplateFuncs(template, gc, custom); err != nil {
+		log.Fatal(err)
+	}
+
+	session := core.NewSession(template, client)
+	if err := session.Load(); err != nil {
+		log.Fatalf("Unable to load snapshot: %v", err)
+	}
+
+	runRepl(session, os.Stdin, os.Stdout)
+}
+
+func runRepl(session *core.Session, r io.Reader, w io.Writer) {
+	fmt.Fprintf(w, "Loaded %d keys, type :help for help\n", len(session.Keys("/")))
+
+	scanner := bufio.NewScanner(r)
+	prompt := "> "
+	var input string
+	for fmt.Fprint(w, prompt); scanner.Scan(); fmt.Fprint(w, prompt) {
+		line := scanner.Text()
+		if strings.HasSuffix(line, "\\") {
+			input += strings.TrimSuffix(line, "\\") + "\n"
+			prompt = ". "
+			continue
+		}
+		input += line
+		prompt = "> "
+
+		text := strings.TrimSpace(input)
+		input = ""
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		switch fields[0] {
+		case ":quit", ":q":
+			return
+		case ":help":
+			fmt.Fprint(w, replHelp)
+		case ":keys":
+			prefix := "/"
+			if len(fields) > 1 {
+				prefix = fields[1]
+			}
+			for _, key := range session.Keys(prefix) {
+				fmt.Fprintln(w, key)
+			}
+		case ":reload":
+			if err := session.Load(); err != nil {
+				fmt.Fprintf(w, "error: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "Loaded %d keys\n", len(session.Keys("/")))
+		default:
+			if err := session.Eval(w, text); err != nil {
+				fmt.Fprintf(w, "error: %v\n", err)
+				continue
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"path/filepath"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// Rollback restores the latest backup of the destinations of rc.Dests, or
+// of every template keeping backups if empty, and reloads them.
+func Rollback(gc *config.GlobalConfig, rc *config.RollbackConfig) {
+	configureLogging(gc)
+
+	tcs := getTemplateConfigs(gc)
+	selected := make([]*config.TemplateConfig, 0, len(tcs))
+	if len(rc.Dests) == 0 {
+		for _, tc := range tcs {
+			if tc.Backups > 0 {
+				selected = append(selected, tc)
+			}
+		}
+	} else {
+		byDest := make(map[string]*config.TemplateConfig, len(tcs))
+		for _, tc := range tcs {
+			if dest, err := filepath.Abs(tc.Dest); err == nil {
+				byDest[dest] = tc
+			}
+		}
+		for _, d := range rc.Dests {
+			dest, err := filepath.Abs(d)
+			if err != nil {
+				log.Fatal(err)
+			}
+			tc, ok := byDest[dest]
+			if !ok {
+				log.Fatalf("No template renders %s", d)
+			}
+			selected = append(selected, tc)
+		}
+	}
+	if len(selected) == 0 {
+		log.Fatalf("No template keeps backups")
+	}
+
+	failed := 0
+	for _, tc := range selected {
+		template := core.NewTemplate(tc, gc.NoOp, false, true)
+		template.SetReloadRetry(gc.ReloadRetries, gc.ReloadDelay, gc.ReloadMaxDelay)
+		template.SetRenderTimeout(gc.RenderTimeout)
+		if err := template.Rollback(); err != nil {
+			log.Error(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d destinations failed to roll back", failed, len(selected))
+	}
+}
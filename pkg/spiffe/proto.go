@@ -0,0 +1,129 @@
+package spiffe
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+)
+
+// Protocol buffers wire types used by the Workload API messages.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// fields calls fn with the number and value of every length-delimited field
+// of the protocol buffers message msg, skipping the others.
+func fields(msg []byte, fn func(num int, value []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("Malformed message")
+		}
+		msg = msg[n:]
+
+		num, wire := int(key>>3), key&7
+		switch wire {
+		case wireVarint:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return fmt.Errorf("Malformed message")
+			}
+			msg = msg[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wire == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return fmt.Errorf("Malformed message")
+			}
+			msg = msg[size:]
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return fmt.Errorf("Malformed message")
+			}
+			value := msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+			if err := fn(num, value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unsupported wire type %d", wire)
+		}
+	}
+	return nil
+}
+
+// parseX509SVIDResponse returns the first SVID of an X509SVIDResponse:
+//
+//	message X509SVIDResponse {
+//	    repeated X509SVID svids = 1;
+//	    ...
+//	}
+func parseX509SVIDResponse(msg []byte) (*SVID, error) {
+	var svid *SVID
+	err := fields(msg, func(num int, value []byte) error {
+		if num != 1 || svid != nil {
+			return nil
+		}
+		var err error
+		svid, err = parseX509SVID(value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if svid == nil {
+		return nil, fmt.Errorf("No SVID received")
+	}
+	return svid, nil
+}
+
+// parseX509SVID parses an X509SVID, whose certificates and bundle are
+// concatenated ASN.1 DER and whose key is PKCS#8:
+//
+//	message X509SVID {
+//	    string spiffe_id = 1;
+//	    bytes x509_svid = 2;
+//	    bytes x509_svid_key = 3;
+//	    bytes bundle = 4;
+//	}
+func parseX509SVID(msg []byte) (*SVID, error) {
+	svid := &SVID{}
+	var key []byte
+	err := fields(msg, func(num int, value []byte) error {
+		var err error
+		switch num {
+		case 1:
+			svid.ID = string(value)
+		case 2:
+			svid.Certificates, err = x509.ParseCertificates(value)
+		case 3:
+			key = value
+		case 4:
+			svid.Bundle, err = x509.ParseCertificates(value)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(svid.Certificates) == 0 {
+		return nil, fmt.Errorf("SVID %s has no certificates", svid.ID)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the key of SVID %s: %v", svid.ID, err)
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported key type of SVID %s", svid.ID)
+	}
+	svid.PrivateKey = signer
+	return svid, nil
+}
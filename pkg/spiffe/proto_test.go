@@ -0,0 +1,137 @@
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate along with its key, issued by parent or
+// self-signed if it has none.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, parent *testCert, id string, ca bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: id},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		uri, err := url.Parse(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = []*url.URL{uri}
+	}
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// appendField appends a length-delimited protocol buffers field to msg.
+func appendField(msg []byte, num int, value []byte) []byte {
+	msg = binary.AppendUvarint(msg, uint64(num)<<3|wireBytes)
+	msg = binary.AppendUvarint(msg, uint64(len(value)))
+	return append(msg, value...)
+}
+
+func encodeX509SVIDResponse(t *testing.T, id string, svid, ca *testCert) []byte {
+	key, err := x509.MarshalPKCS8PrivateKey(svid.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg []byte
+	msg = appendField(msg, 1, []byte(id))
+	msg = appendField(msg, 2, svid.cert.Raw)
+	msg = appendField(msg, 3, key)
+	msg = appendField(msg, 4, ca.cert.Raw)
+	return appendField(nil, 1, msg)
+}
+
+func TestParseX509SVIDResponse(t *testing.T) {
+	const id = "spiffe://example.org/renderizr"
+	ca := newTestCert(t, nil, "ca", true)
+	svid := newTestCert(t, ca, id, false)
+	msg := encodeX509SVIDResponse(t, id, svid, ca)
+
+	// fields unknown to renderizr are skipped
+	unknown := binary.AppendUvarint(nil, 2<<3|wireVarint)
+	unknown = binary.AppendUvarint(unknown, 300)
+	unknown = binary.AppendUvarint(unknown, 3<<3|wireFixed32)
+	unknown = append(unknown, 0, 0, 0, 0)
+	msg = append(unknown, msg...)
+
+	parsed, err := parseX509SVIDResponse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ID != id {
+		t.Errorf("Expected SVID %s, got %s", id, parsed.ID)
+	}
+	if len(parsed.Certificates) != 1 || !parsed.Certificates[0].Equal(svid.cert) {
+		t.Errorf("Expected the SVID certificate, got %v", parsed.Certificates)
+	}
+	if len(parsed.Bundle) != 1 || !parsed.Bundle[0].Equal(ca.cert) {
+		t.Errorf("Expected the bundle certificate, got %v", parsed.Bundle)
+	}
+	if !svid.key.PublicKey.Equal(parsed.PrivateKey.Public()) {
+		t.Errorf("Expected the SVID key")
+	}
+}
+
+func TestParseX509SVIDResponseErrors(t *testing.T) {
+	ca := newTestCert(t, nil, "ca", true)
+	svid := newTestCert(t, ca, "spiffe://example.org/renderizr", false)
+	valid := encodeX509SVIDResponse(t, "spiffe://example.org/renderizr", svid, ca)
+
+	tests := []struct {
+		desc string
+		msg  []byte
+	}{
+		{"empty response", nil},
+		{"truncated response", valid[:len(valid)-1]},
+		{"truncated key", []byte{0x80}},
+		{"unsupported wire type", []byte{1<<3 | 3}},
+		{"no certificates", appendField(nil, 1, appendField(nil, 1, []byte("spiffe://example.org/renderizr")))},
+		{"no key", appendField(nil, 1, appendField(nil, 2, svid.cert.Raw))},
+		{"invalid certificates", appendField(nil, 1, appendField(nil, 2, []byte("certificate")))},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseX509SVIDResponse(tt.msg); err == nil {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+	}
+}
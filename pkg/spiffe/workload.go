@@ -0,0 +1,281 @@
+// Package spiffe obtains X.509 SVIDs from the SPIFFE Workload API of a local
+// agent, like SPIRE's, keeping them rotated as the agent pushes new ones.
+package spiffe
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+const (
+	fetchX509SVIDPath = "/SpiffeWorkloadAPI/FetchX509SVID"
+	// maxMessageSize bounds the responses read from the agent.
+	maxMessageSize = 4 << 20
+	minRetryDelay  = time.Second
+	maxRetryDelay  = 30 * time.Second
+)
+
+// SVID is an X.509 SPIFFE Verifiable Identity Document along with the
+// bundle of its trust domain.
+type SVID struct {
+	ID           string
+	Certificates []*x509.Certificate
+	PrivateKey   crypto.Signer
+	Bundle       []*x509.Certificate
+}
+
+// Source streams the X.509 SVIDs of the workload from the Workload API.
+type Source struct {
+	socket string
+	client *http.Client
+	cancel context.CancelFunc
+
+	mutex sync.RWMutex
+	svid  *SVID
+}
+
+// NewSource connects to the Workload API listening on socket, given as
+// unix:///path or a plain path, and waits up to timeout for the first SVID.
+func NewSource(socket string, timeout time.Duration) (*Source, error) {
+	path := strings.TrimPrefix(socket, "unix://")
+	if path == "" {
+		return nil, fmt.Errorf("The SPIFFE Workload API socket is required")
+	}
+
+	// the Workload API is gRPC, HTTP/2 without TLS over the socket
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+		Protocols: protocols,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		socket: socket,
+		client: &http.Client{Transport: transport},
+		cancel: cancel,
+	}
+
+	ready := make(chan error, 1)
+	go s.run(ctx, ready)
+	select {
+	case err := <-ready:
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	case <-time.After(timeout):
+		cancel()
+		return nil, fmt.Errorf("Timed out waiting for an SVID from %s", socket)
+	}
+	return s, nil
+}
+
+// run keeps the stream of SVIDs open, reconnecting when it breaks. The
+// outcome of the first attempt is reported on ready.
+func (s *Source) run(ctx context.Context, ready chan<- error) {
+	delay := minRetryDelay
+	for {
+		err := s.fetch(ctx, func(svid *SVID) {
+			s.mutex.Lock()
+			s.svid = svid
+			s.mutex.Unlock()
+			log.Infof("Received SVID %s expiring at %v", svid.ID, svid.Certificates[0].NotAfter)
+
+			delay = minRetryDelay
+			if ready != nil {
+				ready <- nil
+				ready = nil
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if ready != nil {
+			ready <- err
+			return
+		}
+
+		log.Warningf("Lost the SVID stream from %s, retrying in %v: %v", s.socket, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// fetch calls FetchX509SVID, passing every SVID received to update until
+// the stream ends.
+func (s *Source) fetch(ctx context.Context, update func(*SVID)) error {
+	// an empty X509SVIDRequest
+	req, err := http.NewRequest("POST", "http://localhost"+fetchX509SVIDPath, bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("workload.spiffe.io", "true")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected response %s", resp.Status)
+	}
+	if err := grpcError(resp.Header); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := readMessage(resp.Body)
+		if err == io.EOF {
+			if err := grpcError(resp.Trailer); err != nil {
+				return err
+			}
+			return fmt.Errorf("Stream closed")
+		}
+		if err != nil {
+			return err
+		}
+
+		svid, err := parseX509SVIDResponse(msg)
+		if err != nil {
+			return err
+		}
+		update(svid)
+	}
+}
+
+// grpcError returns the error reported in the headers or trailers of a
+// gRPC response, if any.
+func grpcError(h http.Header) error {
+	status := h.Get("Grpc-Status")
+	if status == "" || status == "0" {
+		return nil
+	}
+	return fmt.Errorf("Workload API error %s: %s", status, h.Get("Grpc-Message"))
+}
+
+// readMessage reads a length-prefixed gRPC message.
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, fmt.Errorf("Compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("Message of %d bytes exceeds %d bytes", size, maxMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// SVID returns the current SVID.
+func (s *Source) SVID() *SVID {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.svid
+}
+
+// Close stops streaming SVIDs.
+func (s *Source) Close() {
+	s.cancel()
+}
+
+// TLSConfig returns a client TLS configuration presenting the current SVID
+// and verifying servers against the current bundle, so that rotations are
+// picked up by new connections. If serverID is not empty the server must
+// present that SPIFFE ID.
+func (s *Source) TLSConfig(serverID string) *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			svid := s.SVID()
+			cert := &tls.Certificate{PrivateKey: svid.PrivateKey, Leaf: svid.Certificates[0]}
+			for _, c := range svid.Certificates {
+				cert.Certificate = append(cert.Certificate, c.Raw)
+			}
+			return cert, nil
+		},
+		// servers are verified against the SVID bundle instead, which
+		// may change over time
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return s.verify(rawCerts, serverID)
+		},
+	}
+}
+
+// verify checks the certificate chain presented by a server.
+func (s *Source) verify(rawCerts [][]byte, serverID string) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("No server certificate presented")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	roots := x509.NewCertPool()
+	for _, c := range s.SVID().Bundle {
+		roots.AddCert(c)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		return err
+	}
+
+	if serverID == "" {
+		return nil
+	}
+	for _, uri := range certs[0].URIs {
+		if uri.String() == serverID {
+			return nil
+		}
+	}
+	return fmt.Errorf("Server is not %s", serverID)
+}
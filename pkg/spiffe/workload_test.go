@@ -0,0 +1,102 @@
+package spiffe
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewSource(t *testing.T) {
+	const id = "spiffe://example.org/renderizr"
+	ca := newTestCert(t, nil, "ca", true)
+	svid := newTestCert(t, ca, id, false)
+	msg := encodeX509SVIDResponse(t, id, svid, ca)
+
+	dir, err := ioutil.TempDir("", "renderizr-spiffe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// agents refuse requests without the security header
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{
+		Protocols: protocols,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != fetchX509SVIDPath {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/grpc")
+			if r.Header.Get("workload.spiffe.io") != "true" {
+				w.Header().Set("Grpc-Status", "3")
+				w.Header().Set("Grpc-Message", "security header missing from request")
+				return
+			}
+			prefix := make([]byte, 5)
+			binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+			w.Write(append(prefix, msg...))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}),
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	s, err := NewSource("unix://"+socket, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.SVID().ID != id {
+		t.Errorf("Expected SVID %s, got %s", id, s.SVID().ID)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const serverID = "spiffe://example.org/etcd"
+	ca := newTestCert(t, nil, "ca", true)
+	intermediate := newTestCert(t, ca, "intermediate", true)
+	server := newTestCert(t, intermediate, serverID, false)
+	other := newTestCert(t, nil, "other", true)
+	untrusted := newTestCert(t, other, serverID, false)
+
+	s := &Source{svid: &SVID{Bundle: []*x509.Certificate{ca.cert}}}
+
+	tests := []struct {
+		desc     string
+		rawCerts [][]byte
+		serverID string
+		valid    bool
+	}{
+		{"any server", [][]byte{server.cert.Raw, intermediate.cert.Raw}, "", true},
+		{"expected server", [][]byte{server.cert.Raw, intermediate.cert.Raw}, serverID, true},
+		{"unexpected server", [][]byte{server.cert.Raw, intermediate.cert.Raw}, "spiffe://example.org/consul", false},
+		{"missing intermediate", [][]byte{server.cert.Raw}, "", false},
+		{"untrusted server", [][]byte{untrusted.cert.Raw, other.cert.Raw}, serverID, false},
+		{"no certificates", nil, "", false},
+		{"invalid certificate", [][]byte{[]byte("certificate")}, "", false},
+	}
+
+	for _, tt := range tests {
+		err := s.verify(tt.rawCerts, tt.serverID)
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected the server to be verified, got: %v", tt.desc, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected the server to be refused", tt.desc)
+		}
+	}
+}
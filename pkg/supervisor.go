@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// execRetryDelay is the time waited before rendering the templates again
+// when any of them failed before the exec command started.
+const execRetryDelay = 2 * time.Second
+
+// supervisor runs a child process, signaling or restarting it when the
+// templates change. The command runs through the shell with exec, or on its
+// own without one, so the signals reach the process itself.
+type supervisor struct {
+	shell        []string
+	command      string
+	reloadSignal syscall.Signal
+	killSignal   syscall.Signal
+	killTimeout  time.Duration
+
+	mutex      sync.Mutex
+	cmd        *exec.Cmd
+	done       chan struct{}
+	restarting bool
+	exited     chan int
+}
+
+// newSupervisor returns a supervisor of command. If reloadSignal is zero the
+// process is restarted on changes.
+func newSupervisor(shell, command string, reloadSignal, killSignal syscall.Signal, killTimeout time.Duration) *supervisor {
+	return &supervisor{
+		shell:        strings.Fields(shell),
+		command:      command,
+		reloadSignal: reloadSignal,
+		killSignal:   killSignal,
+		killTimeout:  killTimeout,
+		exited:       make(chan int, 1),
+	}
+}
+
+// Exited returns a channel receiving the exit code of the process when it
+// exits on its own.
+func (s *supervisor) Exited() <-chan int {
+	return s.exited
+}
+
+// Start starts the process.
+func (s *supervisor) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.start()
+}
+
+func (s *supervisor) start() error {
+	var cmd *exec.Cmd
+	if len(s.shell) == 1 && s.shell[0] == config.ShellNone {
+		args, err := util.SplitArgs(s.command)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return errors.New("Empty command")
+		}
+		cmd = exec.Command(args[0], args[1:]...)
+	} else {
+		shell := s.shell
+		if len(shell) == 0 {
+			shell = []string{"/bin/sh", "-c"}
+		}
+		cmd = exec.Command(shell[0], append(shell[1:], "exec "+s.command)...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Infof("Started %q with pid %d", s.command, cmd.Process.Pid)
+
+	done := make(chan struct{})
+	s.cmd, s.done = cmd, done
+	go func() {
+		err := cmd.Wait()
+		close(done)
+
+		s.mutex.Lock()
+		expected := s.cmd != cmd || s.restarting
+		s.mutex.Unlock()
+		if expected {
+			return
+		}
+
+		code := 0
+		if err != nil {
+			code = 1
+			if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Exited() {
+				code = status.ExitStatus()
+			}
+		}
+		log.Infof("%q exited: %v", s.command, cmd.ProcessState)
+		s.exited <- code
+	}()
+	return nil
+}
+
+// Reload signals the process, or restarts it, to pick up the changes.
+func (s *supervisor) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cmd == nil {
+		return s.start()
+	}
+	if s.reloadSignal != 0 {
+		log.Infof("Sending %v to %q", s.reloadSignal, s.command)
+		return s.cmd.Process.Signal(s.reloadSignal)
+	}
+
+	log.Infof("Restarting %q", s.command)
+	s.restarting = true
+	s.stop()
+	s.restarting = false
+	return s.start()
+}
+
+// Stop terminates the process.
+func (s *supervisor) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cmd != nil {
+		s.restarting = true
+		s.stop()
+	}
+}
+
+// stop sends the kill signal and waits for the process to exit, killing it
+// if it takes longer than the kill timeout. The mutex must be held, it is
+// released while waiting.
+func (s *supervisor) stop() {
+	cmd, done := s.cmd, s.done
+	s.mutex.Unlock()
+	defer s.mutex.Lock()
+
+	cmd.Process.Signal(s.killSignal)
+	select {
+	case <-done:
+	case <-time.After(s.killTimeout):
+		log.Warningf("%q did not exit after %v, killing it", s.command, s.killTimeout)
+		cmd.Process.Kill()
+		<-done
+	}
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitArgs splits cmd into arguments like a POSIX shell would, without
+// running one: arguments are separated by blanks, single quotes keep
+// everything they enclose, double quotes everything but backslash escaped
+// '"', '\', '$' and '`', and a backslash outside of quotes escapes any
+// character. Variables, globs, pipes and redirections are not expanded, so
+// that their characters are passed as they are.
+func SplitArgs(cmd string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range cmd {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("Command %q ends with a backslash", cmd)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("Command %q has an unterminated %c quote", cmd, quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		args []string
+		err  bool
+	}{
+		{cmd: "", args: nil},
+		{cmd: " \t\n ", args: nil},
+		{cmd: "nginx -t", args: []string{"nginx", "-t"}},
+		{cmd: "  a \t b\nc  ", args: []string{"a", "b", "c"}},
+		{cmd: `nginx -c '{{.}}'`, args: []string{"nginx", "-c", "{{.}}"}},
+		{cmd: `echo 'a "b" \n $x'`, args: []string{"echo", `a "b" \n $x`}},
+		{cmd: `echo "a \"b\" \\ \$x \` + "`" + ` \n"`, args: []string{"echo", `a "b" \ $x ` + "`" + ` \n`}},
+		{cmd: `echo x\ y \'z\'`, args: []string{"echo", "x y", "'z'"}},
+		{cmd: `a"b"'c'd`, args: []string{"abcd"}},
+		{cmd: `echo "" ''`, args: []string{"echo", "", ""}},
+		{cmd: `echo $HOME; rm -rf / | cat > out`, args: []string{"echo", "$HOME;", "rm", "-rf", "/", "|", "cat", ">", "out"}},
+		{cmd: `echo 'unterminated`, err: true},
+		{cmd: `echo "unterminated`, err: true},
+		{cmd: `echo trailing\`, err: true},
+	}
+
+	for _, tt := range tests {
+		args, err := SplitArgs(tt.cmd)
+		if tt.err {
+			if err == nil {
+				t.Errorf("SplitArgs(%q): expected an error, got %q", tt.cmd, args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitArgs(%q): %v", tt.cmd, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("SplitArgs(%q) = %q, expected %q", tt.cmd, args, tt.args)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := RequireToken([]byte("s3cr3t"), "renderizr", ok)
+
+	tests := []struct {
+		desc   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"no credentials", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t") }, http.StatusOK},
+		{"wrong bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3") }, http.StatusUnauthorized},
+		{"bare token", func(r *http.Request) { r.Header.Set("Authorization", "s3cr3t") }, http.StatusOK},
+		{"basic auth", func(r *http.Request) { r.SetBasicAuth("admin", "s3cr3t") }, http.StatusOK},
+		{"wrong basic auth", func(r *http.Request) { r.SetBasicAuth("s3cr3t", "admin") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/render", nil)
+		tt.auth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.desc, tt.status, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="renderizr"` {
+			t.Errorf("%s: expected browsers to be asked for credentials", tt.desc)
+		}
+	}
+
+	// without a token every request goes through
+	w := httptest.NewRecorder()
+	RequireToken(nil, "renderizr", ok).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected requests to go through without a token, got %d", w.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// BackupFileName returns the name of the nth most recent backup of dest, 1
+// being the latest, like nginx.conf.bak.1.
+func BackupFileName(dest, suffix string, n int) string {
+	return fmt.Sprintf("%s%s.%d", dest, suffix, n)
+}
+
+// BackupFile copies dest as its latest backup, with the same mode and
+// owner, shifting the previous ones so that count of them are kept.
+func BackupFile(dest, suffix string, count int) error {
+	if err := os.Remove(BackupFileName(dest, suffix, count)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := count - 1; n >= 1; n-- {
+		err := os.Rename(BackupFileName(dest, suffix, n), BackupFileName(dest, suffix, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return copyFile(dest, BackupFileName(dest, suffix, 1))
+}
+
+// RestoreBackup replaces dest with its latest backup, with the mode and
+// owner it had, and shifts the older ones so that the next restore goes
+// back further. It returns the name of the restored backup.
+func RestoreBackup(dest, suffix string) (string, error) {
+	latest := BackupFileName(dest, suffix, 1)
+	if err := copyFile(latest, dest); err != nil {
+		return "", err
+	}
+	if err := os.Remove(latest); err != nil {
+		return "", err
+	}
+	for n := 2; ; n++ {
+		err := os.Rename(BackupFileName(dest, suffix, n), BackupFileName(dest, suffix, n-1))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return latest, nil
+}
+
+// SnapshotFile copies path next to it, with the same mode and owner, for it
+// to be restored with RestoreSnapshot. It returns the name of the copy.
+func SnapshotFile(path string) (string, error) {
+	snapshot := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".snapshot")
+	if err := copyFile(path, snapshot); err != nil {
+		return "", err
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot moves snapshot back to path. Paths that cannot be renamed
+// over, like bind mounts, are written to instead.
+func RestoreSnapshot(snapshot, path string) error {
+	err := os.Rename(snapshot, path)
+	if err == nil || !strings.Contains(err.Error(), "device or resource busy") {
+		return err
+	}
+	fi, err := os.Stat(snapshot)
+	if err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, contents, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(path, int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+			return err
+		}
+	}
+	return os.Remove(snapshot)
+}
+
+// copyFile atomically replaces dst with a copy of src, with the same mode
+// and, if allowed, owner and extended attributes.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = io.Copy(tempFile, in)
+	if cerr := tempFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tempFile.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(tempFile.Name(), int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+			return err
+		}
+	}
+	if err := CopyXattrs(src, tempFile.Name()); err != nil && !os.IsPermission(err) {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), dst)
+}
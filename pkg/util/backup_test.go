@@ -0,0 +1,107 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, contents string, mode os.FileMode) {
+	if err := ioutil.WriteFile(name, []byte(contents), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectFile(t *testing.T, name, contents string, mode os.FileMode) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if fi.Mode().Perm() != mode {
+		t.Errorf("Expected %s to have mode %v, got %v", name, mode, fi.Mode().Perm())
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != contents {
+		t.Errorf("Expected %s to hold %q, got %q", name, contents, data)
+	}
+}
+
+func TestBackupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "nginx.conf")
+	for i := 1; i <= 4; i++ {
+		writeFile(t, dest, "v"+strconv.Itoa(i), 0640)
+		if err := BackupFile(dest, ".bak", 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, dest, "v5", 0600)
+
+	// only the 2 latest backups are kept
+	expectFile(t, BackupFileName(dest, ".bak", 1), "v4", 0640)
+	expectFile(t, BackupFileName(dest, ".bak", 2), "v3", 0640)
+	if IsFileExist(BackupFileName(dest, ".bak", 3)) {
+		t.Errorf("Expected only 2 backups to be kept")
+	}
+
+	// restoring goes back one backup at a time
+	restored, err := RestoreBackup(dest, ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != BackupFileName(dest, ".bak", 1) {
+		t.Errorf("Unexpected restored backup %s", restored)
+	}
+	expectFile(t, dest, "v4", 0640)
+	expectFile(t, BackupFileName(dest, ".bak", 1), "v3", 0640)
+
+	if _, err := RestoreBackup(dest, ".bak"); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, dest, "v3", 0640)
+	if _, err := RestoreBackup(dest, ".bak"); err == nil {
+		t.Errorf("Expected an error once no backup is left")
+	}
+	expectFile(t, dest, "v3", 0640)
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "nginx.conf")
+	writeFile(t, dest, "good", 0640)
+	snapshot, err := SnapshotFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(snapshot) != dir {
+		t.Errorf("Expected the snapshot next to %s, got %s", dest, snapshot)
+	}
+
+	writeFile(t, dest, "bad", 0644)
+	if err := RestoreSnapshot(snapshot, dest); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, dest, "good", 0640)
+	if IsFileExist(snapshot) {
+		t.Errorf("Expected the snapshot %s to be gone once restored", snapshot)
+	}
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Capabilities, see capabilities(7).
+const (
+	CapChown  = 0
+	CapFowner = 3
+)
+
+// capNames names the capabilities in error messages.
+var capNames = map[uint]string{
+	CapChown:  "CAP_CHOWN",
+	CapFowner: "CAP_FOWNER",
+}
+
+// CapabilityName returns the name of the capability c.
+func CapabilityName(c uint) string {
+	if name, ok := capNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("capability %d", c)
+}
+
+// EffectiveCapabilities returns the set of effective capabilities of this
+// process, as found in /proc/self/status.
+func EffectiveCapabilities() (uint64, error) {
+	f, err := os.Open(procDir + "/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "CapEff:") {
+			return strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("No effective capabilities found in %s/self/status", procDir)
+}
+
+// HasCapability reports whether this process has the effective capability
+// c. Root is assumed to have it where capabilities cannot be read.
+func HasCapability(c uint) bool {
+	caps, err := EffectiveCapabilities()
+	if err != nil {
+		return os.Geteuid() == 0
+	}
+	return caps&(1<<c) != 0
+}
+
+// CanChown reports whether this process may give files it creates to uid
+// and gid. Without CAP_CHOWN that is only possible for its own user and
+// groups.
+func CanChown(uid, gid int) bool {
+	if HasCapability(CapChown) {
+		return true
+	}
+	if uid != os.Geteuid() {
+		return false
+	}
+	if gid == os.Getegid() {
+		return true
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		return false
+	}
+	for _, g := range groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
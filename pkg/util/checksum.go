@@ -0,0 +1,39 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ChecksumFileName returns the name of the sidecar file holding the checksum
+// of dest.
+func ChecksumFileName(dest string) string {
+	return dest + ".sha256"
+}
+
+// WriteChecksumFile writes the sha256 digest of dest next to it using the
+// sha256sum(1) format, so it can be checked with `sha256sum -c`. The file is
+// replaced atomically.
+func WriteChecksumFile(dest, digest string) error {
+	name := ChecksumFileName(dest)
+	tempFile, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = fmt.Fprintf(tempFile, "%s  %s\n", digest, filepath.Base(dest))
+	if cerr := tempFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tempFile.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), name)
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("Expected %q to be invalid", expr)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// Monday 2024-01-15 03:30
+	monday := time.Date(2024, time.January, 15, 3, 30, 0, 0, time.UTC)
+	sunday := time.Date(2024, time.January, 14, 3, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr    string
+		t       time.Time
+		matches bool
+	}{
+		{"* * * * *", monday, true},
+		{"30 3 * * *", monday, true},
+		{"31 3 * * *", monday, false},
+		{"*/15 * * * *", monday, true},
+		{"*/20 * * * *", monday, false},
+		{"10/20 * * * *", monday, true},
+		{"0,30 2-4 * * *", monday, true},
+		{"* * * * 1-5", monday, true},
+		{"* * * * 1-5", sunday, false},
+		{"* * * * 0", sunday, true},
+		{"* * * * 7", sunday, true},
+		{"* * * 2 *", monday, false},
+		// either the day of month or the day of week, like cron
+		{"* * 1 * 1", monday, true},
+		{"* * 15 * 0", monday, true},
+		{"* * 1 * 0", monday, false},
+		{"* * 1 * *", monday, false},
+		// unless one of them starts with '*'
+		{"* * */2 * 1", monday, true},
+		{"* * */2 * 1", monday.AddDate(0, 0, 2), false},
+		{"* * */2 * 1", monday.AddDate(0, 0, 7), false},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := s.Matches(tt.t); matches != tt.matches {
+			t.Errorf("%q at %v: expected %v, got %v", tt.expr, tt.t, tt.matches, matches)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, time.January, 15, 3, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 15, 3, 31, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, time.January, 16, 2, 0, 0, 0, time.UTC)},
+		{"0 2 * * 6", time.Date(2024, time.January, 20, 2, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+		{"0 0 */2 * 1", time.Date(2024, time.January, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := s.Next(from); !next.Equal(tt.expected) {
+			t.Errorf("%q after %v: expected %v, got %v", tt.expr, from, tt.expected, next)
+		}
+	}
+
+	// minutes matching already are returned as is
+	s, _ := ParseCron("30 3 * * *")
+	at := time.Date(2024, time.January, 15, 3, 30, 0, 0, time.UTC)
+	if next := s.Next(at); !next.Equal(at) {
+		t.Errorf("Expected %v, got %v", at, next)
+	}
+}
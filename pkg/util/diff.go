@@ -0,0 +1,158 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the memory Diff uses, about 4 bytes per cell: the
+// line matching needs the product of the lengths of the parts of both
+// inputs left once their common first and last lines are put aside.
+const maxDiffCells = 1 << 20
+
+// Diff returns a unified diff, with context lines of context, turning a into
+// b. It returns an empty string if both are equal.
+func Diff(aName, bName, a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+
+	al, bl := splitLines(a), splitLines(b)
+	ops, ok := diffLines(al, bl)
+	if !ok {
+		return fmt.Sprintf("--- %s\n+++ %s\n(too large to diff: %d and %d lines)\n", aName, bName, len(al), len(bl))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// extend the hunk while changes are closer than twice the context
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*context {
+				break
+			}
+		}
+
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		to := end + context
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		aStart, bStart, aLen, bLen := ops[from].a, ops[from].b, 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", hunkStart(aStart, aLen), aLen, hunkStart(bStart, bLen), bLen)
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&buf, "%c%s\n", op.kind, op.line)
+		}
+
+		start = to
+	}
+
+	return buf.String()
+}
+
+// hunkStart returns the line number a hunk range starts at, empty ranges
+// refer to the line preceding them.
+func hunkStart(start, length int) int {
+	if length == 0 {
+		return start
+	}
+	return start + 1
+}
+
+type diffOp struct {
+	kind byte
+	line string
+	a, b int
+}
+
+// diffLines matches a and b through their longest common subsequence, or
+// returns false if that needs more than maxDiffCells. Their common first and
+// last lines are matched beforehand, so that small changes of large inputs
+// are cheap.
+func diffLines(a, b []string) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	prefix := 0
+	for prefix < n && prefix < m && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && a[n-1-suffix] == b[m-1-suffix] {
+		suffix++
+	}
+	if (n-prefix-suffix)*(m-prefix-suffix) > maxDiffCells {
+		return nil, false
+	}
+
+	// lcs is indexed from the end of the common prefix
+	lcs := make([][]int32, n-prefix-suffix+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m-prefix-suffix+1)
+	}
+	for i := n - suffix - 1; i >= prefix; i-- {
+		for j := m - suffix - 1; j >= prefix; j-- {
+			x, y := i-prefix, j-prefix
+			if a[i] == b[j] {
+				lcs[x][y] = lcs[x+1][y+1] + 1
+			} else if lcs[x+1][y] >= lcs[x][y+1] {
+				lcs[x][y] = lcs[x+1][y]
+			} else {
+				lcs[x][y] = lcs[x][y+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	i, j := 0, 0
+	for ; i < prefix; i, j = i+1, j+1 {
+		ops = append(ops, diffOp{' ', a[i], i, j})
+	}
+	for i < n-suffix || j < m-suffix {
+		switch {
+		case i < n-suffix && j < m-suffix && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j < m-suffix && (i == n-suffix || lcs[i-prefix][j-prefix+1] > lcs[i-prefix+1][j-prefix]):
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		default:
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		}
+	}
+	for ; i < n; i, j = i+1, j+1 {
+		ops = append(ops, diffOp{' ', a[i], i, j})
+	}
+	return ops, true
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
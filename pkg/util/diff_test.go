@@ -0,0 +1,55 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		desc     string
+		a, b     string
+		context  int
+		expected string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", 3, ""},
+		{"changed line", "a\nb\nc\n", "a\nx\nc\n", 3, "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"added lines", "a\n", "a\nb\nc\n", 3, "--- a\n+++ b\n@@ -1,1 +1,3 @@\n a\n+b\n+c\n"},
+		{"removed lines", "a\nb\nc\n", "c\n", 3, "--- a\n+++ b\n@@ -1,3 +1,1 @@\n-a\n-b\n c\n"},
+		{"from empty", "", "a\n", 3, "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"distant changes", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "x\n2\n3\n4\n5\n6\n7\n8\ny\n", 1,
+			"--- a\n+++ b\n@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -8,2 +8,2 @@\n 8\n-9\n+y\n"},
+	}
+
+	for _, tt := range tests {
+		if actual := Diff("a", "b", tt.a, tt.b, tt.context); actual != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.desc, tt.expected, actual)
+		}
+	}
+}
+
+func TestDiffLargeInputs(t *testing.T) {
+	lines := make([]string, 100000)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i)
+	}
+	a := strings.Join(lines, "\n") + "\n"
+
+	// small changes of large inputs are diffed
+	changed := append([]string(nil), lines...)
+	changed[50000] = "changed"
+	diff := Diff("a", "b", a, strings.Join(changed, "\n")+"\n", 0)
+	if expected := "--- a\n+++ b\n@@ -50001,1 +50001,1 @@\n-50000\n+changed\n"; diff != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, diff)
+	}
+
+	// large changes are not
+	for i := range changed {
+		changed[i] = "changed " + lines[i]
+	}
+	diff = Diff("a", "b", a, strings.Join(changed, "\n")+"\n", 0)
+	if !strings.Contains(diff, "too large to diff") {
+		t.Errorf("Expected the diff to be too large, got %d bytes", len(diff))
+	}
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// UnixScheme prefixes the unix domain socket endpoints, e.g.
+// unix:///var/run/consul.sock.
+const UnixScheme = "unix://"
+
+// IsUnixEndpoint reports whether endpoint is a unix domain socket.
+func IsUnixEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, UnixScheme)
+}
+
+// NormalizeEndpoint returns endpoint as 'host:port', with IPv6 literals in
+// brackets and defaultPort if it has none, or as 'unix:///path' for unix
+// domain sockets. Schemes like tcp:// or http:// are dropped.
+func NormalizeEndpoint(endpoint, defaultPort string) (string, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if IsUnixEndpoint(endpoint) {
+		if !strings.HasPrefix(endpoint, UnixScheme+"/") {
+			return "", fmt.Errorf("Unix socket endpoint %s should be an absolute path like unix:///var/run/service.sock", endpoint)
+		}
+		return endpoint, nil
+	}
+
+	for _, scheme := range []string{"tcp://", "http://", "https://"} {
+		endpoint = strings.TrimPrefix(endpoint, scheme)
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if endpoint == "" {
+		return "", fmt.Errorf("Empty endpoint")
+	}
+
+	if host, port, err := net.SplitHostPort(endpoint); err == nil {
+		if host == "" || port == "" {
+			return "", fmt.Errorf("Endpoint %s should be provided as host:port", endpoint)
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+
+	// a host alone, IPv6 literals possibly in brackets and with a zone
+	host := strings.TrimSuffix(strings.TrimPrefix(endpoint, "["), "]")
+	ip := host
+	if i := strings.LastIndex(ip, "%"); i >= 0 {
+		ip = ip[:i]
+	}
+	if strings.Contains(host, ":") && net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("Endpoint %s should be provided as host:port, with IPv6 addresses in brackets", endpoint)
+	}
+	return net.JoinHostPort(host, defaultPort), nil
+}
+
+// NormalizeEndpoints normalizes every endpoint, see NormalizeEndpoint.
+func NormalizeEndpoints(endpoints []string, defaultPort string) ([]string, error) {
+	normalized := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		n, err := NormalizeEndpoint(endpoint, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, n)
+	}
+	return normalized, nil
+}
+
+// DialEndpoint connects to a normalized endpoint, either over TCP or to a
+// unix domain socket.
+func DialEndpoint(dialer *net.Dialer, endpoint string) (net.Conn, error) {
+	if IsUnixEndpoint(endpoint) {
+		return dialer.Dial("unix", strings.TrimPrefix(endpoint, UnixScheme))
+	}
+	return dialer.Dial("tcp", endpoint)
+}
@@ -1,21 +1,71 @@
 package util
 
 import (
-	"crypto/md5"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
-	"github.com/golang/glog"
+	"github.com/glerchundi/renderizr/pkg/log"
 )
 
+// compareBlockSize is the size of the blocks read from each file when
+// comparing their contents.
+const compareBlockSize = 32 * 1024
+
+// destState is the state of a destination file right after it was last known
+// to hold the contents identified by digest.
+type destState struct {
+	Size    int64
+	ModTime time.Time
+	Ino     uint64
+	Digest  string
+}
+
+var destStates = struct {
+	sync.Mutex
+	m map[string]destState
+}{m: make(map[string]destState)}
+
+// RecordConfig remembers the current size and modification time of dest
+// along with the digest of the contents it holds. As long as dest keeps the
+// same size and modification time, IsSameConfig compares digests instead of
+// reading it.
+func RecordConfig(dest, digest string) {
+	stats, err := os.Stat(dest)
+	if err != nil || digest == "" {
+		forgetConfig(dest)
+		return
+	}
+
+	destStates.Lock()
+	destStates.m[dest] = destState{
+		Size:    stats.Size(),
+		ModTime: stats.ModTime(),
+		Ino:     stats.Sys().(*syscall.Stat_t).Ino,
+		Digest:  digest,
+	}
+	destStates.Unlock()
+}
+
+func forgetConfig(dest string) {
+	destStates.Lock()
+	delete(destStates.m, dest)
+	destStates.Unlock()
+}
+
 // FileInfo describes a configuration file and is returned by fileStat.
 type fileInfo struct {
-	Uid  uint32
-	Gid  uint32
-	Mode os.FileMode
-	Md5  string
+	Uid     uint32
+	Gid     uint32
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+	Ino     uint64
 }
 
 // IsFileExist reports whether path exits.
@@ -26,11 +76,45 @@ func IsFileExist(fpath string) bool {
 	return true
 }
 
+// blankBytes are the bytes of blank files: whitespace, and the byte order
+// marks and NUL bytes of UTF-8 and UTF-16 encoded whitespace.
+const blankBytes = " \t\r\n\v\f\x00\xef\xbb\xbf\xfe\xff"
+
+// IsBlankFile reports whether path is empty or holds only whitespace. It
+// stops reading at the first other byte.
+func IsBlankFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, compareBlockSize)
+	for {
+		n, err := f.Read(buf)
+		for _, b := range buf[:n] {
+			if strings.IndexByte(blankBytes, b) < 0 {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
 // IsSameConfig reports whether src and dest config files are equal.
 // Two config files are equal when they have the same file contents and
 // Unix permissions. The owner, group, and mode must match.
 // It return false in other cases.
-func IsSameConfig(src, dest string) (bool, error) {
+//
+// If digest, the digest of src contents, is given and dest still has the size
+// and modification time recorded by RecordConfig, the contents are compared
+// by digest without reading dest.
+func IsSameConfig(src, dest, digest string) (bool, error) {
 	if !IsFileExist(dest) {
 		return false, nil
 	}
@@ -43,43 +127,109 @@ func IsSameConfig(src, dest string) (bool, error) {
 		return false, err
 	}
 	if dfi.Uid != sfi.Uid {
-		glog.Infof("%s has UID %d should be %d", dest, dfi.Uid, sfi.Uid)
+		log.Infof("%s has UID %d should be %d", dest, dfi.Uid, sfi.Uid)
 	}
 	if dfi.Gid != sfi.Gid {
-		glog.Infof("%s has GID %d should be %d", dest, dfi.Gid, sfi.Gid)
+		log.Infof("%s has GID %d should be %d", dest, dfi.Gid, sfi.Gid)
 	}
 	if dfi.Mode != sfi.Mode {
-		glog.Infof("%s has mode %s should be %s", dest, os.FileMode(dfi.Mode), os.FileMode(sfi.Mode))
+		log.Infof("%s has mode %s should be %s", dest, os.FileMode(dfi.Mode), os.FileMode(sfi.Mode))
+	}
+	if dfi.Size != sfi.Size {
+		log.Infof("%s has size %d should be %d", dest, dfi.Size, sfi.Size)
+		return false, nil
+	}
+	same, err := isSameContentCached(src, dest, digest, dfi)
+	if err != nil {
+		return false, err
 	}
-	if dfi.Md5 != sfi.Md5 {
-		glog.Infof("%s has md5sum %s should be %s", dest, dfi.Md5, sfi.Md5)
+	if !same {
+		log.Infof("%s has different contents", dest)
 	}
-	if dfi.Uid != sfi.Uid || dfi.Gid != sfi.Gid || dfi.Mode != sfi.Mode || dfi.Md5 != sfi.Md5 {
+	if dfi.Uid != sfi.Uid || dfi.Gid != sfi.Gid || dfi.Mode != sfi.Mode || !same {
 		return false, nil
 	}
 	return true, nil
 }
 
-// getFileInfo returns a FileInfo describing the named file.
-func getFileInfo(name string) (fi fileInfo, err error) {
-	if !IsFileExist(name) {
-		return fi, fmt.Errorf("%s file not found", name)
+// isSameContentCached compares the digests of src and the recorded state of
+// dest if still valid, otherwise it falls back to isSameContent.
+func isSameContentCached(src, dest, digest string, dfi fileInfo) (bool, error) {
+	if digest != "" {
+		destStates.Lock()
+		state, ok := destStates.m[dest]
+		destStates.Unlock()
+		if ok && state.Size == dfi.Size && state.ModTime.Equal(dfi.ModTime) && state.Ino == dfi.Ino {
+			log.Debugf("%s unchanged since last written, comparing digests", dest)
+			return state.Digest == digest, nil
+		}
+	}
+
+	same, err := isSameContent(src, dest)
+	if err != nil {
+		return false, err
+	}
+	if same && digest != "" {
+		RecordConfig(dest, digest)
+	}
+	return same, nil
+}
+
+// isSameContent compares both files block by block and stops at the first
+// block that differs, so only as much as needed is read and at most two
+// blocks are held in memory.
+func isSameContent(src, dest string) (bool, error) {
+	sf, err := os.Open(src)
+	if err != nil {
+		return false, err
 	}
+	defer sf.Close()
 
-	f, err := os.Open(name)
+	df, err := os.Open(dest)
 	if err != nil {
+		return false, err
+	}
+	defer df.Close()
+
+	sbuf := make([]byte, compareBlockSize)
+	dbuf := make([]byte, compareBlockSize)
+	for {
+		sn, serr := io.ReadFull(sf, sbuf)
+		dn, derr := io.ReadFull(df, dbuf)
+		if !bytes.Equal(sbuf[:sn], dbuf[:dn]) {
+			return false, nil
+		}
+		if serr == io.EOF || serr == io.ErrUnexpectedEOF {
+			return derr == io.EOF || derr == io.ErrUnexpectedEOF, nil
+		}
+		if serr != nil {
+			return false, serr
+		}
+		if derr != nil {
+			if derr == io.EOF || derr == io.ErrUnexpectedEOF {
+				return false, nil
+			}
+			return false, derr
+		}
+	}
+}
+
+// getFileInfo returns a FileInfo describing the named file.
+func getFileInfo(name string) (fi fileInfo, err error) {
+	stats, err := os.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fi, fmt.Errorf("%s file not found", name)
+		}
 		return fi, err
 	}
-	defer f.Close()
 
-	stats, _ := f.Stat()
 	fi.Uid = stats.Sys().(*syscall.Stat_t).Uid
 	fi.Gid = stats.Sys().(*syscall.Stat_t).Gid
 	fi.Mode = stats.Mode()
-
-	h := md5.New()
-	io.Copy(h, f)
-	fi.Md5 = fmt.Sprintf("%x", h.Sum(nil))
+	fi.Size = stats.Size()
+	fi.ModTime = stats.ModTime()
+	fi.Ino = stats.Sys().(*syscall.Stat_t).Ino
 
 	return fi, nil
-}
\ No newline at end of file
+}
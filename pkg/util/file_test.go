@@ -0,0 +1,145 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsSameConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	block := strings.Repeat("x", compareBlockSize)
+	tests := []struct {
+		desc      string
+		src, dest string
+		srcMode   os.FileMode
+		same      bool
+	}{
+		{"same contents", "a\nb\n", "a\nb\n", 0644, true},
+		{"empty", "", "", 0644, true},
+		{"different size", "a\n", "a\nb\n", 0644, false},
+		{"different contents", "a\nb\n", "a\nc\n", 0644, false},
+		{"different mode", "a\n", "a\n", 0600, false},
+		{"same blocks", block + block + "a", block + block + "a", 0644, true},
+		{"different last block", block + block + "a", block + block + "b", 0644, false},
+		{"different first block", "b" + block + block, "a" + block + block, 0644, false},
+	}
+
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	for _, tt := range tests {
+		writeFile(t, src, tt.src, tt.srcMode)
+		writeFile(t, dest, tt.dest, 0644)
+		same, err := IsSameConfig(src, dest, "")
+		if err != nil {
+			t.Errorf("%s: %v", tt.desc, err)
+			continue
+		}
+		if same != tt.same {
+			t.Errorf("%s: expected same %v, got %v", tt.desc, tt.same, same)
+		}
+	}
+
+	if same, err := IsSameConfig(src, filepath.Join(dir, "missing"), ""); err != nil || same {
+		t.Errorf("Expected a missing destination to differ, got %v: %v", same, err)
+	}
+}
+
+func TestIsSameConfigDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	writeFile(t, src, "a\n", 0644)
+	writeFile(t, dest, "a\n", 0644)
+	RecordConfig(dest, "digest-a")
+	defer forgetConfig(dest)
+
+	// dest is not read while it keeps its recorded size and modification
+	// time, so a change behind its back that keeps both goes unnoticed
+	fi, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dest, "b\n", 0644)
+	if err := os.Chtimes(dest, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if same, err := IsSameConfig(src, dest, "digest-a"); err != nil || !same {
+		t.Errorf("Expected the recorded digest to be compared, got %v: %v", same, err)
+	}
+	if same, err := IsSameConfig(src, dest, "digest-b"); err != nil || same {
+		t.Errorf("Expected another digest to differ, got %v: %v", same, err)
+	}
+
+	// once the modification time changes the contents are compared again
+	if err := os.Chtimes(dest, fi.ModTime(), fi.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if same, err := IsSameConfig(src, dest, "digest-a"); err != nil || same {
+		t.Errorf("Expected the modified contents to differ, got %v: %v", same, err)
+	}
+
+	// and recorded again when they match
+	writeFile(t, dest, "a\n", 0644)
+	if same, err := IsSameConfig(src, dest, "digest-a"); err != nil || !same {
+		t.Errorf("Expected the same contents, got %v: %v", same, err)
+	}
+	destStates.Lock()
+	state, ok := destStates.m[dest]
+	destStates.Unlock()
+	if !ok || state.Digest != "digest-a" {
+		t.Errorf("Expected the matching contents to be recorded, got %+v", state)
+	}
+}
+
+func TestIsBlankFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		desc     string
+		contents string
+		blank    bool
+	}{
+		{"empty", "", true},
+		{"whitespace", " \t\r\n\v\f", true},
+		{"UTF-8 byte order mark", "\xef\xbb\xbf\n", true},
+		{"UTF-16 whitespace", "\xff\xfe \x00\n\x00", true},
+		{"contents", "\n\n  x\n", false},
+		{"contents after a block", strings.Repeat(" ", 3*compareBlockSize) + "x", false},
+		{"whitespace over blocks", strings.Repeat("\n", 3*compareBlockSize), true},
+	}
+
+	for i, tt := range tests {
+		name := filepath.Join(dir, string(rune('a'+i)))
+		if err := ioutil.WriteFile(name, []byte(tt.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		blank, err := IsBlankFile(name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.desc, err)
+			continue
+		}
+		if blank != tt.blank {
+			t.Errorf("%s: expected blank %v, got %v", tt.desc, tt.blank, blank)
+		}
+	}
+
+	if _, err := IsBlankFile(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
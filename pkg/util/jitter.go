@@ -0,0 +1,23 @@
+package util
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	jitterMutex sync.Mutex
+	jitterRand  = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Jitter returns a random duration in the [0, max) range, or zero if max is
+// not positive.
+func Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	jitterMutex.Lock()
+	defer jitterMutex.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
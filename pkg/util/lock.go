@@ -0,0 +1,67 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// FileLock is an exclusive advisory lock held on a file. The lock is bound
+// to the open file description, so it is released automatically by the
+// kernel when the process dies.
+type FileLock struct {
+	path string
+	file *os.File
+}
+
+// LockFileName returns the name of the lock file guarding dest.
+func LockFileName(dest string) string {
+	return filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".renderizr.lock")
+}
+
+// LockFile acquires an exclusive lock on path without blocking. If another
+// process already holds the lock an error including its pid is returned.
+func LockFile(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is locked by another renderizr instance (pid %s)", path, lockOwner(path))
+		}
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &FileLock{path: path, file: f}, nil
+}
+
+// Unlock releases the lock. The lock file itself is left in place, removing
+// it would allow two processes to hold a lock on different inodes.
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
+
+func lockOwner(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := LockFileName(filepath.Join(dir, "nginx.conf"))
+	if name != filepath.Join(dir, ".nginx.conf.renderizr.lock") {
+		t.Errorf("Unexpected lock file name %s", name)
+	}
+
+	lock, err := LockFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// locks are held by open files, so a second one conflicts even within
+	// the same process
+	_, err = LockFile(name)
+	if err == nil {
+		t.Fatalf("Expected %s to be locked already", name)
+	}
+	if pid := strconv.Itoa(os.Getpid()); !strings.Contains(err.Error(), "pid "+pid) {
+		t.Errorf("Expected the error to name the owner %s, got: %v", pid, err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("Expected the lock file to be left in place: %v", err)
+	}
+	lock, err = LockFile(name)
+	if err != nil {
+		t.Fatalf("Expected %s to be unlocked: %v", name, err)
+	}
+	lock.Unlock()
+}
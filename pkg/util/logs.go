@@ -17,10 +17,10 @@ limitations under the License.
 package util
 
 import (
-	"fmt"
-	"runtime"
 	"flag"
+	"fmt"
 	"log"
+	"runtime"
 	"time"
 
 	"github.com/golang/glog"
@@ -99,7 +99,6 @@ func Until(f func(), period time.Duration, stopCh <-chan struct{}) {
 
 var (
 	logFlushFreq = pflag.Duration("log-flush-frequency", 5*time.Second, "Maximum number of seconds between log flushes")
-	_ = pflag.Int("log-level", 0, "Enable V-leveled logging at the specified level.")
 )
 
 // TODO(thockin): This is temporary until we agree on log dirs and put those into each cmd.
@@ -132,4 +131,4 @@ func FlushLogs() {
 // NewLogger creates a new log.Logger which sends logs to glog.Info.
 func NewLogger(prefix string) *log.Logger {
 	return log.New(GlogWriter{}, prefix, 0)
-}
\ No newline at end of file
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// procDir is where the processes are listed.
+const procDir = "/proc"
+
+// FindProcesses returns the pids of the processes, other than this one,
+// whose name matches pattern, e.g. those of other containers in a shared PID
+// namespace. Their name is their command, their first argument or its base
+// name, not the rest of their command line, so that editors or log readers
+// given the same name are not matched. Kernel threads, without a command
+// line, are ignored.
+func FindProcesses(pattern *regexp.Regexp) ([]int, error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+	pids := make([]int, 0)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		// processes may exit while being listed
+		data, err := ioutil.ReadFile(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		argv0 := strings.TrimSpace(strings.SplitN(string(data), "\x00", 2)[0])
+		names := []string{argv0, filepath.Base(argv0)}
+		if comm, err := ioutil.ReadFile(filepath.Join(procDir, entry.Name(), "comm")); err == nil {
+			names = append(names, strings.TrimSpace(string(comm)))
+		}
+		for _, name := range names {
+			if pattern.MatchString(name) {
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"os/exec"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func startProcess(t *testing.T, argv0 string, args ...string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Args[0] = argv0
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func containsPid(pids []int, pid int) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFindProcesses(t *testing.T) {
+	sleeper := startProcess(t, "/usr/bin/renderizr-test-sleeper", "sleep", "30")
+	defer sleeper.Process.Kill()
+	// a shell whose arguments mention the name of the sleeper
+	shell := startProcess(t, "sh", "sh", "-c", "sleep 30; : renderizr-test-sleeper")
+	defer shell.Process.Kill()
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		pattern string
+		sleeper bool
+		shell   bool
+	}{
+		{"renderizr-test-sleeper", true, false},
+		{"/usr/bin/renderizr-test-sleeper", true, false},
+		{"renderizr-test-.*", true, false},
+		{"sleep", true, false},
+		{"sh", false, true},
+		{"renderizr-test", false, false},
+		{"30", false, false},
+	}
+
+	for _, tt := range tests {
+		pids, err := FindProcesses(regexp.MustCompile("^(?:" + tt.pattern + ")$"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := containsPid(pids, sleeper.Process.Pid); found != tt.sleeper {
+			t.Errorf("Pattern %q: expected the sleeper to be found %v, got %v", tt.pattern, tt.sleeper, found)
+		}
+		if found := containsPid(pids, shell.Process.Pid); found != tt.shell {
+			t.Errorf("Pattern %q: expected the shell to be found %v, got %v", tt.pattern, tt.shell, found)
+		}
+	}
+}
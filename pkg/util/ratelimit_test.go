@@ -0,0 +1,48 @@
+package util
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(20, 3)
+
+	// the burst goes through right away
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.Wait()
+	}
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("Expected the burst not to wait, waited %v", elapsed)
+	}
+
+	// then events are spaced by 1/rate
+	start = time.Now()
+	for i := 0; i < 4; i++ {
+		l.Wait()
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("Expected 4 events at 20/s to take 200ms, took %v", elapsed)
+	}
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	l := NewRateLimiter(100, 0)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Wait()
+		}()
+	}
+	wg.Wait()
+	// a burst lower than one is one, the other 9 events wait 10ms each
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected 10 events at 100/s to take 90ms, took %v", elapsed)
+	}
+}
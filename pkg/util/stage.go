@@ -0,0 +1,61 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stageRootName is the directory, next to the destinations, holding the
+// staging areas of every resource rendered into that directory.
+const stageRootName = ".renderizr"
+
+// StageDir returns the directory the stage files of dest are created in. It
+// lives in the same directory as dest so that stage files can be renamed
+// over it.
+func StageDir(dest string) string {
+	return filepath.Join(filepath.Dir(dest), stageRootName, filepath.Base(dest))
+}
+
+// CreateStageFile creates a new stage file for dest in its staging area.
+func CreateStageFile(dest string) (*os.File, error) {
+	dir := StageDir(dest)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, filepath.Base(dest))
+}
+
+// CleanStageDir removes the stage files of dest last modified before
+// olderThan, and then the staging directories if they were left empty. It
+// returns the names of the removed files.
+func CleanStageDir(dest string, olderThan time.Time) ([]string, error) {
+	dir := StageDir(dest)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	removed := make([]string, 0, len(files))
+	for _, fi := range files {
+		if fi.IsDir() || !fi.ModTime().Before(olderThan) {
+			continue
+		}
+		name := filepath.Join(dir, fi.Name())
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+
+	// both fail unless empty, which is exactly what we want
+	if os.Remove(dir) == nil {
+		os.Remove(filepath.Dir(dir))
+	}
+
+	return removed, nil
+}
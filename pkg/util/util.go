@@ -3,7 +3,7 @@ package util
 import (
 	"reflect"
 
-	"github.com/golang/glog"
+	"github.com/glerchundi/renderizr/pkg/log"
 )
 
 // Dump object
@@ -14,9 +14,9 @@ func Dump(v interface{}) {
 	s := reflect.ValueOf(v).Elem()
 	typeOfT := s.Type()
 
-	glog.V(1).Infof(typeOfT.String())
+	log.Debugf("%s", typeOfT.String())
 	for i := 0; i < s.NumField(); i++ {
 		f := s.Field(i)
-		glog.V(1).Infof("%d: %s %s = '%v'", i, typeOfT.Field(i).Name, f.Type(), f.Interface())
+		log.Debugf("%d: %s %s = '%v'", i, typeOfT.Field(i).Name, f.Type(), f.Interface())
 	}
-}
\ No newline at end of file
+}
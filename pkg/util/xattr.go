@@ -0,0 +1,5 @@
+package util
+
+// SELinuxXattr is the extended attribute holding the SELinux context of a
+// file.
+const SELinuxXattr = "security.selinux"
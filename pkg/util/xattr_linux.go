@@ -0,0 +1,96 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// ListXattrs returns the names of the extended attributes of path, none if
+// its filesystem doesn't support them.
+func ListXattrs(path string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		if err != nil || size == 0 {
+			return nil, os.NewSyscallError("listxattr", err)
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Listxattr(path, buf)
+		if err == syscall.ERANGE {
+			// attributes were added in between
+			continue
+		}
+		if err != nil {
+			return nil, os.NewSyscallError("listxattr", err)
+		}
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// GetXattr returns the value of the extended attribute name of path.
+func GetXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, os.NewSyscallError("getxattr", err)
+		}
+		buf := make([]byte, size)
+		n, err := syscall.Getxattr(path, name, buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, os.NewSyscallError("getxattr", err)
+		}
+		return buf[:n], nil
+	}
+}
+
+// CopyXattrs sets the extended attributes of src on dst: its SELinux
+// context, POSIX ACLs and any other one.
+func CopyXattrs(src, dst string) error {
+	names, err := ListXattrs(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		value, err := GetXattr(src, name)
+		if err != nil {
+			return &os.PathError{Op: "getxattr " + name, Path: src, Err: err.(*os.SyscallError).Err}
+		}
+		if err := syscall.Setxattr(dst, name, value, 0); err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: dst, Err: err}
+		}
+	}
+	return nil
+}
+
+// SELinuxContext returns the SELinux context of path, "" if it has none.
+func SELinuxContext(path string) (string, error) {
+	value, err := GetXattr(path, SELinuxXattr)
+	if err != nil {
+		if serr, ok := err.(*os.SyscallError); ok && (serr.Err == syscall.ENODATA || serr.Err == syscall.ENOTSUP) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(value), "\x00"), nil
+}
+
+// SetSELinuxContext sets the SELinux context of path, like chcon(1).
+func SetSELinuxContext(path, context string) error {
+	if err := syscall.Setxattr(path, SELinuxXattr, append([]byte(context), 0), 0); err != nil {
+		return os.NewSyscallError("setxattr", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renderizr-xattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, name := range []string{src, dst} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syscall.Setxattr(src, "user.renderizr", []byte("value"), 0); err != nil {
+		t.Skipf("Extended attributes not supported: %v", err)
+	}
+
+	if err := CopyXattrs(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	value, err := GetXattr(dst, "user.renderizr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "value" {
+		t.Errorf("Expected the copied attribute to be %q, got %q", "value", value)
+	}
+
+	// backups keep the attributes of the destination
+	if err := BackupFile(src, ".bak", 1); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ListXattrs(BackupFileName(src, ".bak", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, name := range names {
+		found = found || name == "user.renderizr"
+	}
+	if !found {
+		t.Errorf("Expected the backup to keep user.renderizr, got %v", names)
+	}
+
+	if _, err := SELinuxContext(dst); err != nil {
+		t.Errorf("Expected reading the SELinux context of %s not to fail: %v", dst, err)
+	}
+}
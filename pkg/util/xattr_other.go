@@ -0,0 +1,37 @@
+//go:build !linux
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// Extended attributes are only supported on Linux, files elsewhere are
+// treated as having none and setting them fails.
+
+// ListXattrs returns no names, as for filesystems without extended
+// attributes.
+func ListXattrs(path string) ([]string, error) {
+	return nil, nil
+}
+
+// GetXattr fails with ENOTSUP.
+func GetXattr(path, name string) ([]byte, error) {
+	return nil, os.NewSyscallError("getxattr", syscall.ENOTSUP)
+}
+
+// CopyXattrs does nothing, src has no extended attributes to copy.
+func CopyXattrs(src, dst string) error {
+	return nil
+}
+
+// SELinuxContext returns "", files have no SELinux context.
+func SELinuxContext(path string) (string, error) {
+	return "", nil
+}
+
+// SetSELinuxContext fails with ENOTSUP.
+func SetSELinuxContext(path, context string) error {
+	return os.NewSyscallError("setxattr", syscall.ENOTSUP)
+}
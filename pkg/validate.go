@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+)
+
+// Validate parses the source of every template, failing on syntax errors
+// and unknown functions, and renders them against the keys of the
+// vc.Values YAML/JSON documents if any, without touching their
+// destinations. It exits non-zero if any of them is invalid.
+func Validate(gc *config.GlobalConfig, vc *config.ValidateConfig) {
+	configureLogging(gc)
+
+	tcs, err := loadTemplateConfigs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	custom, err := loadCustomFuncs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var client store.Store
+	var datasources *core.Datasources
+	if len(vc.Values) > 0 {
+		fbc := config.NewFixtureBackendConfig()
+		fbc.Files = vc.Values
+		client = newFixtureClient(gc, fbc)
+		defer client.Close()
+
+		if datasources, err = newDatasources(gc, client); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	failed := 0
+	for _, tc := range tcs {
+		if err := validateTemplate(tc, client, datasources, gc, custom); err != nil {
+			log.Errorf("%s: %v", tc.Dest, err)
+			failed++
+			continue
+		}
+		log.Infof("%s: OK", tc.Dest)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d templates are invalid", failed, len(tcs))
+	}
+}
+
+// validateTemplate parses the source of the template and, if client is
+// set, renders it with the keys of client.
+func validateTemplate(tc *config.TemplateConfig, client store.Store, datasources *core.Datasources, gc *config.GlobalConfig, custom *customFuncs) error {
+	template := core.NewTemplate(tc, true, false, true)
+	template.SetDatasources(datasources)
+	if err := setTemplateFuncs(template, gc, custom); err != nil {
+		return err
+	}
+	if err := template.Compile(); err != nil {
+		return err
+	}
+	if client == nil {
+		return nil
+	}
+
+	if err := core.NewOnDemandProcessor(template, client).Execute(ioutil.Discard); err != nil {
+		return fmt.Errorf("Unable to render with the fixture values: %v", err)
+	}
+	return nil
+}
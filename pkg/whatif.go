@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/glerchundi/renderizr/pkg/backends"
+	"github.com/glerchundi/renderizr/pkg/config"
+	"github.com/glerchundi/renderizr/pkg/core"
+	"github.com/glerchundi/renderizr/pkg/libkv/store"
+	"github.com/glerchundi/renderizr/pkg/log"
+	"github.com/glerchundi/renderizr/pkg/util"
+)
+
+// WhatIf renders every template against the backend data as it is and as it
+// would be with the wc.Set keys, '/key=value', written and the wc.Delete
+// keys deleted, printing the differences to stdout. Neither the backend nor
+// the destinations are modified.
+func WhatIf(gc *config.GlobalConfig, bc config.BackendConfig, wc *config.WhatIfConfig) {
+	configureLogging(gc)
+
+	set := make(map[string]string, len(wc.Set))
+	for _, s := range wc.Set {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			log.Fatalf("Key should be provided as '/key=value': %s", s)
+		}
+		set[parts[0]] = parts[1]
+	}
+	if len(set) == 0 && len(wc.Delete) == 0 {
+		log.Fatalf("No key to set or delete given")
+	}
+
+	tcs := getTemplateConfigs(gc)
+	client := newStoreClient(gc, bc)
+	defer client.Close()
+	overlay := backends.NewOverlayStore(client, set, wc.Delete)
+
+	datasources, err := newDatasources(gc, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	custom, err := loadCustomFuncs(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	changed, failed := 0, 0
+	for _, tc := range tcs {
+		current, err := executeTemplate(tc, client, datasources, gc, custom)
+		if err != nil {
+			log.Errorf("%s: unable to render with the current data: %v", tc.Dest, err)
+			failed++
+			continue
+		}
+		proposed, err := executeTemplate(tc, overlay, datasources, gc, custom)
+		if err != nil {
+			log.Errorf("%s would fail to render: %v", tc.Dest, err)
+			failed++
+			continue
+		}
+		if current == proposed {
+			log.Debugf("%s would not change", tc.Dest)
+			continue
+		}
+		changed++
+		fmt.Fprint(os.Stdout, util.Diff(tc.Dest, tc.Dest+" (what-if)", current, proposed, 3))
+	}
+
+	log.Infof("%d of %d templates would change", changed, len(tcs))
+	if failed > 0 {
+		log.Fatalf("%d of %d templates failed", failed, len(tcs))
+	}
+}
+
+// executeTemplate returns the output of the template rendered with the data
+// of client.
+func executeTemplate(tc *config.TemplateConfig, client store.Store, datasources *core.Datasources, gc *config.GlobalConfig, custom *customFuncs) (string, error) {
+	template := core.NewTemplate(tc, true, false, true)
+	template.SetDatasources(datasources)
+	if err := setTemplateFuncs(template, gc, custom); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := core.NewOnDemandProcessor(template, client).Execute(&buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}